@@ -3,20 +3,31 @@
 package abi // import "github.com/karmarun/karma.link/abi"
 
 import (
+	"encoding/hex"
 	"encoding/json"
 	"github.com/karmarun/karma.link/config"
 	"github.com/karmarun/karma.link/types"
-	"log"
 	"math/big"
+	"strconv"
+	"strings"
 )
 
 // Code represents a Solidity ABI-encoded payload.
 type Code []byte
 
-var logger = log.New(config.LogWriter, `abi`, config.LogFlags)
+var logger = config.NewLogger(`abi`)
 
 const addressType = types.Elementary(`address`)
 
+// functionValue is the JSON representation of an external function type,
+// which the ABI encodes like bytes24 (address followed by selector).
+type functionValue struct {
+	Address  string `json:"address"`
+	Selector string `json:"selector"`
+}
+
+// width returns the size of typ's encoding in the head of its frame. All elementary types, including
+// fixed<M>x<N> and ufixed<M>x<N>, occupy one 32-byte word, so arrays and structs of them need no special casing.
 func width(typ types.Type) int {
 	switch t := typ.(type) {
 
@@ -38,6 +49,9 @@ func width(typ types.Type) int {
 		return w
 
 	case types.Struct:
+		if isDynamic(t) {
+			return 32 // = pointer into tail
+		}
 		w := 0
 		for _, typ := range t.Types {
 			w += width(typ)
@@ -45,7 +59,7 @@ func width(typ types.Type) int {
 		return w
 
 	case types.Array:
-		if t.Length == types.DynamicArrayLength {
+		if isDynamic(t) {
 			return 32 // = pointer into tail
 		}
 		return width(t.Type) * t.Length
@@ -55,6 +69,65 @@ func width(typ types.Type) int {
 	return 0 // shut up compiler
 }
 
+// isDynamic reports whether values of typ are encoded in the tail of their frame and referenced by offset.
+// Unlike types.Array.IsDynamic, this includes fixed-size arrays and structs containing dynamic values.
+func isDynamic(typ types.Type) bool {
+	switch t := typ.(type) {
+
+	case types.Named:
+		return isDynamic(t.Type)
+
+	case types.Tuple:
+		for _, typ := range t {
+			if isDynamic(typ) {
+				return true
+			}
+		}
+		return false
+
+	case types.Struct:
+		for _, typ := range t.Types {
+			if isDynamic(typ) {
+				return true
+			}
+		}
+		return false
+
+	case types.Array:
+		return t.IsDynamic() || isDynamic(t.Type)
+
+	case types.Elementary:
+		return normalizeElementaryTypeName(t) == `bytes`
+
+	}
+	return false
+}
+
+// omitMappings returns t without its mapping members, which are never part of encoded data,
+// e.g. of structs returned member by member by getters.
+func omitMappings(t types.Struct) types.Struct {
+	out := types.Struct{Keys: make([]string, 0, len(t.Keys)), Types: make([]types.Type, 0, len(t.Types))}
+	for i, typ := range t.Types {
+		member := typ
+		if named, ok := member.(types.Named); ok {
+			member = named.Type
+		}
+		if _, ok := member.(types.Mapping); ok {
+			continue
+		}
+		out.Keys, out.Types = append(out.Keys, t.Keys[i]), append(out.Types, typ)
+	}
+	return out
+}
+
+func repeatType(typ types.Type, n int) types.Tuple {
+	tuple := make(types.Tuple, n, n)
+	for i := range tuple {
+		tuple[i] = typ
+	}
+	return tuple
+}
+
 func peekNonWhitespaceByte(json json.RawMessage) byte {
 	for len(json) > 0 && (json[0] == '\t' || json[0] == '\n' || json[0] == '\r' || json[0] == ' ') {
 		json = json[1:]
@@ -65,6 +138,18 @@ func peekNonWhitespaceByte(json json.RawMessage) byte {
 	return json[0]
 }
 
+// decodeHex decodes a "0x"-prefixed hex string.
+func decodeHex(s string) ([]byte, bool) {
+	if !strings.HasPrefix(s, `0x`) {
+		return nil, false
+	}
+	bs, e := hex.DecodeString(s[2:])
+	if e != nil {
+		return nil, false
+	}
+	return bs, true
+}
+
 func maxInt(a, b int) int {
 	if a > b {
 		return a
@@ -86,6 +171,23 @@ func encodeInt256(i *big.Int) []byte {
 	return manualTwosComplement(cs)
 }
 
+// fixedBits returns M and N of a normalized fixed<M>x<N> or ufixed<M>x<N> type name.
+func fixedBits(id string) (int, int) {
+	mn := strings.SplitN(strings.TrimPrefix(strings.TrimPrefix(id, `u`), `fixed`), `x`, 2)
+	if len(mn) != 2 {
+		logger.Panicln("precondition violation: not a fixed type:", id)
+	}
+	bits, e := strconv.Atoi(mn[0])
+	if e != nil {
+		logger.Panicln(e)
+	}
+	decimals, e := strconv.Atoi(mn[1])
+	if e != nil {
+		logger.Panicln(e)
+	}
+	return bits, decimals
+}
+
 func normalizeElementaryTypeName(id types.Elementary) types.Elementary {
 	switch id { // alias mapping, synonyms
 	case `byte`:
@@ -109,19 +211,11 @@ func normalizeElementaryTypeName(id types.Elementary) types.Elementary {
 // bs[0] MSB ... bs[len(bs)-1] LSB
 func manualTwosComplement(bs []byte) []byte {
 	cs := make([]byte, len(bs), len(bs))
-	copy(cs, bs)
-	// manual two's-complement
-	for i := 0; i < 32; i += 8 {
-		cs[i+0] = ^cs[i+0]
-		cs[i+1] = ^cs[i+1]
-		cs[i+2] = ^cs[i+2]
-		cs[i+3] = ^cs[i+3]
-		cs[i+4] = ^cs[i+4]
-		cs[i+5] = ^cs[i+5]
-		cs[i+6] = ^cs[i+6]
-		cs[i+7] = ^cs[i+7]
-	}
-	for i := 31; i > 0; i-- {
+	// manual two's-complement: invert, then add one, carrying from the least significant byte
+	for i, b := range bs {
+		cs[i] = ^b
+	}
+	for i := len(cs) - 1; i >= 0; i-- {
 		cs[i]++
 		if cs[i] != 0 {
 			break
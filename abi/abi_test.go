@@ -0,0 +1,781 @@
+// Copyright 2018 karma.run AG. All rights reserved.
+
+package abi
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/karmarun/karma.link/types"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// mustParse parses a canonical type string, failing t on errors.
+func mustParse(t testing.TB, s string) types.Type {
+	typ, e := types.ParseType(s)
+	if e != nil {
+		t.Fatal(e)
+	}
+	return typ
+}
+
+// mustHex decodes hex ignoring whitespace, failing t on errors.
+func mustHex(t testing.TB, s string) []byte {
+	bs, e := hex.DecodeString(strings.Join(strings.Fields(s), ``))
+	if e != nil {
+		t.Fatal(e)
+	}
+	return bs
+}
+
+// jsonEqual reports whether a and b hold equal JSON values.
+func jsonEqual(a, b []byte) bool {
+	x, y := interface{}(nil), interface{}(nil)
+	if json.Unmarshal(a, &x) != nil || json.Unmarshal(b, &y) != nil {
+		return false
+	}
+	return reflect.DeepEqual(x, y)
+}
+
+// checkRoundTrip encodes value as typ, compares the encoding with hexCode and decodes it back to value.
+func checkRoundTrip(t *testing.T, typ types.Type, value, hexCode string) {
+	t.Helper()
+	want := mustHex(t, hexCode)
+	code, e := Encode(typ, json.RawMessage(value))
+	if e != nil {
+		t.Fatalf(`encoding %s: %s`, value, e)
+	}
+	if !bytes.Equal(code, want) {
+		t.Fatalf("encoding %s:\n have %x\n want %x", value, []byte(code), want)
+	}
+	decoded, e := Decode(typ, want)
+	if e != nil {
+		t.Fatalf(`decoding %x: %s`, want, e)
+	}
+	if !jsonEqual(decoded, []byte(value)) {
+		t.Fatalf(`decoded %s, want %s`, decoded, value)
+	}
+}
+
+func TestFunctionType(t *testing.T) {
+	checkRoundTrip(t, mustParse(t, `(uint256,function)`),
+		`[7, {"address": "0x52908400098527886e0f7030069857d2e4169ee7", "selector": "0xa9059cbb"}]`, `
+		0000000000000000000000000000000000000000000000000000000000000007
+		52908400098527886e0f7030069857d2e4169ee7a9059cbb0000000000000000`)
+
+	for _, arg := range []string{`"0x1234"`, `{"address": "0x01", "selector": "0xa9059cbb"}`, `{"address": "0x52908400098527886e0f7030069857d2e4169ee7", "selector": "0xa9"}`} {
+		if _, e := Encode(types.Elementary(`function`), json.RawMessage(arg)); e == nil {
+			t.Errorf(`expected error encoding %s`, arg)
+		}
+	}
+}
+
+func TestNegativeIntegers(t *testing.T) {
+	minusOne := strings.Repeat(`ff`, 32)
+	minusFive := strings.Repeat(`ff`, 31) + `fb`
+	for _, c := range []struct{ arg, hex string }{
+		{`"-0x1"`, minusOne},
+		{`"-5"`, minusFive},
+		{`-5`, minusFive},
+		{`"-0x5"`, minusFive},
+	} {
+		code, e := Encode(types.Elementary(`int256`), json.RawMessage(c.arg))
+		if e != nil {
+			t.Fatalf(`int256 %s: %s`, c.arg, e)
+		}
+		if hex.EncodeToString(code) != c.hex {
+			t.Fatalf(`int256 %s: expected %s, have %x`, c.arg, c.hex, []byte(code))
+		}
+		if _, e := Encode(types.Elementary(`uint256`), json.RawMessage(c.arg)); e == nil {
+			t.Fatalf(`uint256 %s: expected error`, c.arg)
+		}
+	}
+	if code, e := Encode(types.Elementary(`int8`), json.RawMessage(`"-0x80"`)); e != nil || hex.EncodeToString(code) != strings.Repeat(`ff`, 31)+`80` {
+		t.Fatalf(`int8 -0x80: have %x, %v`, []byte(code), e)
+	}
+	if _, e := Encode(types.Elementary(`int8`), json.RawMessage(`"-0x81"`)); e == nil {
+		t.Fatalf(`int8 -0x81: expected error`)
+	}
+}
+
+func TestNestedDynamicTuple(t *testing.T) {
+	entry := types.Struct{Keys: []string{`name`, `amount`}, Types: []types.Type{types.Elementary(`string`), types.Elementary(`uint256`)}}
+	typ := types.Tuple{types.Array{Length: types.DynamicArrayLength, Type: entry}, types.Elementary(`bytes`)}
+	// verified against go-ethereum's accounts/abi
+	checkRoundTrip(t, typ, `[[{"name": "alice", "amount": 1}, {"name": "bob", "amount": 2}], "hi"]`, `
+		0000000000000000000000000000000000000000000000000000000000000040
+		00000000000000000000000000000000000000000000000000000000000001a0
+		0000000000000000000000000000000000000000000000000000000000000002
+		0000000000000000000000000000000000000000000000000000000000000040
+		00000000000000000000000000000000000000000000000000000000000000c0
+		0000000000000000000000000000000000000000000000000000000000000040
+		0000000000000000000000000000000000000000000000000000000000000001
+		0000000000000000000000000000000000000000000000000000000000000005
+		616c696365000000000000000000000000000000000000000000000000000000
+		0000000000000000000000000000000000000000000000000000000000000040
+		0000000000000000000000000000000000000000000000000000000000000002
+		0000000000000000000000000000000000000000000000000000000000000003
+		626f620000000000000000000000000000000000000000000000000000000000
+		0000000000000000000000000000000000000000000000000000000000000002
+		6869000000000000000000000000000000000000000000000000000000000000`)
+}
+
+func TestEmptyDynamicValues(t *testing.T) {
+	empty := `0000000000000000000000000000000000000000000000000000000000000020
+	          0000000000000000000000000000000000000000000000000000000000000000`
+	for _, typ := range []string{`uint256[]`, `string[]`, `bytes`, `string`} {
+		value := `[[]]`
+		if typ == `bytes` || typ == `string` {
+			value = `[""]`
+		}
+		t.Run(typ, func(t *testing.T) {
+			checkRoundTrip(t, mustParse(t, `(`+typ+`)`), value, empty)
+		})
+	}
+	checkRoundTrip(t, mustParse(t, `(uint256[],bytes,uint256[2][])`), `[[], "", []]`, `
+		0000000000000000000000000000000000000000000000000000000000000060
+		0000000000000000000000000000000000000000000000000000000000000080
+		00000000000000000000000000000000000000000000000000000000000000a0
+		0000000000000000000000000000000000000000000000000000000000000000
+		0000000000000000000000000000000000000000000000000000000000000000
+		0000000000000000000000000000000000000000000000000000000000000000`)
+	if code, e := Encode(types.Elementary(`bytes`), json.RawMessage(`[]`)); e != nil || len(code) != 64 {
+		t.Fatalf(`empty byte array: have %x, %v`, []byte(code), e)
+	}
+}
+
+func TestDecodeAll(t *testing.T) {
+	typs := []types.Type{types.Elementary(`uint256`), types.Elementary(`address`)}
+	code := mustHex(t, `
+		000000000000000000000000000000000000000000000000000000000000002a
+		00000000000000000000000052908400098527886e0f7030069857d2e4169ee7`)
+	values, e := DecodeAll(typs, code)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(values) != 2 || string(values[0]) != `42` || !jsonEqual(values[1], []byte(`"0x52908400098527886e0f7030069857d2e4169ee7"`)) {
+		t.Fatalf(`unexpected values: %s`, values)
+	}
+	if _, e := DecodeAll(typs, append(code, make([]byte, 32)...)); e == nil || !strings.Contains(e.Error(), `32 leftover bytes`) {
+		t.Fatalf(`expected leftover bytes error, have %v`, e)
+	}
+}
+
+func TestTruncatedData(t *testing.T) {
+	for _, vector := range []struct{ typ, value string }{
+		{`(uint256,address,bool,bytes4)`, `[1, "0x52908400098527886e0f7030069857d2e4169ee7", true, "0x01020304"]`},
+		{`(bytes,string)`, `["hi", "there"]`},
+		{`(uint256[],string[])`, `[[1, 2], ["a", "b"]]`},
+		{`((uint256,bytes)[2],uint256[2][])`, `[[[1, "x"], [2, "y"]], [[1, 2]]]`},
+	} {
+		t.Run(vector.typ, func(t *testing.T) {
+			typ := mustParse(t, vector.typ)
+			code, e := Encode(typ, json.RawMessage(vector.value))
+			if e != nil {
+				t.Fatal(e)
+			}
+			for n := 0; n < len(code); n++ {
+				// must not panic; prefixes only missing padding may decode fine
+				if _, e := Decode(typ, code[:n]); e == nil && n < 32*len(typ.(types.Tuple)) {
+					t.Fatalf(`expected error decoding %d of %d bytes`, n, len(code))
+				}
+			}
+		})
+	}
+}
+
+func TestInvalidOffsets(t *testing.T) {
+	for _, vector := range []struct{ typ, hex string }{
+		{`(bytes)`, `0000000000000000000000000000000000000000000000000000000000001000`},
+		{`(uint256[])`, `ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff`},
+		{`(string[])`, `
+			0000000000000000000000000000000000000000000000000000000000000020
+			0000000000000000000000000000000000000000000000000000000000000001
+			0000000000000000000000000000000000000000000000000000000000001000`},
+	} {
+		if _, e := Decode(mustParse(t, vector.typ), mustHex(t, vector.hex)); e == nil {
+			t.Errorf(`%s: expected error`, vector.typ)
+		}
+	}
+	if _, e := Decode(types.Enum{`A`, `B`}, mustHex(t, `0000000000000000000000000000000000000000000000000000000000000002`)); e == nil {
+		t.Error(`expected error decoding enum value out of range`)
+	}
+}
+
+func TestEncodePacked(t *testing.T) {
+	for _, vector := range []struct{ typ, arg, hex string }{
+		// from the Solidity documentation of abi.encodePacked
+		{`(int16,bytes1,uint16,string)`, `[-1, "0x42", 3, "Hello, world!"]`, `ffff 42 0003 48656c6c6f2c20776f726c6421`},
+		{`uint8`, `42`, `2a`},
+		{`int8`, `-2`, `fe`},
+		{`uint32`, `258`, `00000102`},
+		{`bool`, `true`, `01`},
+		{`address`, `"0x52908400098527886E0F7030069857D2E4169EE7"`, `52908400098527886e0f7030069857d2e4169ee7`},
+		{`bytes4`, `"0xa9059cbb"`, `a9059cbb`},
+		{`bytes`, `[0, 255]`, `00ff`},
+		{`bytes`, `"karma"`, `6b61726d61`},
+		{`string`, `""`, ``},
+		{`uint8[]`, `[1, 2]`, `
+			0000000000000000000000000000000000000000000000000000000000000001
+			0000000000000000000000000000000000000000000000000000000000000002`},
+		{`bytes2[2]`, `["0x0102", "0x0304"]`, `
+			0102000000000000000000000000000000000000000000000000000000000000
+			0304000000000000000000000000000000000000000000000000000000000000`},
+		{`(address,uint256)`, `["0x00000000000000000000000000000000000000aa", 1]`, `
+			00000000000000000000000000000000000000aa
+			0000000000000000000000000000000000000000000000000000000000000001`},
+	} {
+		code, e := EncodePacked(mustParse(t, vector.typ), json.RawMessage(vector.arg))
+		if e != nil {
+			t.Fatalf(`%s: %s`, vector.typ, e)
+		}
+		if want := mustHex(t, vector.hex); !bytes.Equal(code, want) {
+			t.Fatalf("%s: encoded\n have %x\n want %x", vector.typ, []byte(code), want)
+		}
+	}
+
+	for _, vector := range []struct{ typ, arg string }{
+		{`(uint8,(uint8,uint8))`, `[1, [2, 3]]`}, // nested tuple
+		{`uint8[2][]`, `[[1, 2]]`},
+		{`string[]`, `["a"]`},
+		{`(uint8,bool)`, `[1]`},
+		{`uint8`, `256`},
+	} {
+		if code, e := EncodePacked(mustParse(t, vector.typ), json.RawMessage(vector.arg)); e == nil {
+			t.Fatalf(`%s: expected error, have %x`, vector.typ, []byte(code))
+		}
+	}
+	strct := types.Struct{Keys: []string{`a`}, Types: []types.Type{mustParse(t, `uint8`)}}
+	if _, e := EncodePacked(strct, json.RawMessage(`{"a": 1}`)); e == nil {
+		t.Fatal(`expected error encoding a struct`)
+	}
+}
+
+func TestDecodePacked(t *testing.T) {
+	typs := []types.Type{mustParse(t, `uint8`), mustParse(t, `address`), mustParse(t, `bytes32`)}
+	hash := strings.Repeat(`ff`, 31) + `01`
+	code := mustHex(t, `2a 52908400098527886e0f7030069857d2e4169ee7`+hash)
+
+	decoded, e := DecodePacked(typs, code)
+	if e != nil {
+		t.Fatal(e)
+	}
+	have, _ := json.Marshal(decoded)
+	if want := `[42, "0x52908400098527886e0f7030069857d2e4169ee7", "0x` + hash + `"]`; !jsonEqual(have, []byte(want)) {
+		t.Fatalf(`decoded %s, want %s`, have, want)
+	}
+
+	// round trip with EncodePacked
+	encoded := Code{}
+	for i, typ := range typs {
+		bs, e := EncodePacked(typ, decoded[i])
+		if e != nil {
+			t.Fatal(e)
+		}
+		encoded = append(encoded, bs...)
+	}
+	if !bytes.Equal(encoded, code) {
+		t.Fatalf("re-encoded\n have %x\n want %x", []byte(encoded), code)
+	}
+
+	if _, e := DecodePacked(typs, code[:len(code)-1]); e == nil {
+		t.Fatal(`expected error decoding truncated data`)
+	}
+	if _, e := DecodePacked(typs, append(code, 0)); e == nil {
+		t.Fatal(`expected error on leftover bytes`)
+	}
+	if _, e := DecodePacked([]types.Type{mustParse(t, `bytes`), mustParse(t, `uint8`)}, code); e == nil || !strings.Contains(e.Error(), `ambiguous`) {
+		t.Fatalf(`expected ambiguous layout error, have %v`, e)
+	}
+}
+
+// rgbCodec is a toy custom elementary type encoding "#rrggbb" colors in the low 3 bytes of a word.
+type rgbCodec struct{}
+
+func (rgbCodec) Encode(arg json.RawMessage) ([]byte, error) {
+	s := ""
+	if e := json.Unmarshal(arg, &s); e != nil || len(s) != 7 || s[0] != '#' {
+		return nil, fmt.Errorf(`invalid color: %s`, arg)
+	}
+	bs, e := hex.DecodeString(s[1:])
+	if e != nil {
+		return nil, fmt.Errorf(`invalid color: %s`, arg)
+	}
+	return append(make([]byte, 29, 32), bs...), nil
+}
+
+func (rgbCodec) Decode(word []byte) (json.RawMessage, error) {
+	return json.Marshal(`#` + hex.EncodeToString(word[29:]))
+}
+
+func init() {
+	RegisterElementary(`rgb`, rgbCodec{})
+}
+
+func TestRegisterElementary(t *testing.T) {
+	rgb := types.Elementary(`rgb`)
+	checkRoundTrip(t, types.Tuple{rgb, mustParse(t, `uint256`), types.Array{Length: -1, Type: rgb}}, `["#ff8000", 7, ["#000001"]]`, `
+		0000000000000000000000000000000000000000000000000000000000ff8000
+		0000000000000000000000000000000000000000000000000000000000000007
+		0000000000000000000000000000000000000000000000000000000000000060
+		0000000000000000000000000000000000000000000000000000000000000001
+		0000000000000000000000000000000000000000000000000000000000000001`)
+
+	if _, e := Encode(rgb, json.RawMessage(`"red"`)); e == nil {
+		t.Fatal(`expected codec error`)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal(`expected registering rgb twice to panic`)
+		}
+	}()
+	RegisterElementary(`rgb`, rgbCodec{})
+}
+
+func TestAssembleKeyPaths(t *testing.T) {
+	meta := types.Struct{Keys: []string{`name`}, Types: []types.Type{mustParse(t, `string`)}}
+	order := types.Named{Name: `Shop.sol:Shop.Order`, Type: types.Struct{
+		Keys:  []string{`owner`, `amounts`, `meta`},
+		Types: []types.Type{mustParse(t, `address`), mustParse(t, `uint256[]`), meta},
+	}}
+	names, typs := []string{`order`, ``}, []types.Type{order, mustParse(t, `bool[2]`)}
+
+	assembled, e := AssembleKeyPaths(names, typs, map[string]string{
+		`order.owner`:      `0x52908400098527886e0f7030069857d2e4169ee7`,
+		`order.amounts[0]`: `1`,
+		`order.amounts[1]`: `2`,
+		`order.meta.name`:  `foo`,
+		`1[0]`:             `true`,
+		`1[1]`:             `false`,
+	})
+	if e != nil {
+		t.Fatal(e)
+	}
+	nested := `[{"owner": "0x52908400098527886e0f7030069857d2e4169ee7", "amounts": [1, 2], "meta": {"name": "foo"}}, [true, false]]`
+	want, e := Encode(types.Tuple(typs), json.RawMessage(nested))
+	if e != nil {
+		t.Fatal(e)
+	}
+	have, e := Encode(types.Tuple(typs), assembled)
+	if e != nil {
+		t.Fatalf(`encoding %s: %s`, assembled, e)
+	}
+	if !bytes.Equal(have, want) {
+		t.Fatalf("assembled %s encodes differently from %s", assembled, nested)
+	}
+
+	for _, values := range []map[string]string{
+		{`order.owner`: `0x01`, `order.meta.name`: ``, `1[0]`: `true`, `1[1]`: `true`, `order.amounts`: ``, `order.extra`: `1`},
+		{`order.owner`: `0x01`, `order.meta.name`: ``, `1[0]`: `true`, `1[1]`: `true`, `order.amounts[1]`: `1`}, // index gap
+		{`order.owner`: `0x01`, `order.meta.name`: ``, `order.amounts`: ``},                                     // missing argument
+	} {
+		if assembled, e := AssembleKeyPaths(names, typs, values); e == nil {
+			t.Errorf(`%v: expected error, have %s`, values, assembled)
+		}
+	}
+}
+
+func TestArraysOfArrays(t *testing.T) {
+	// a dynamic array of static arrays: elements are inline
+	checkRoundTrip(t, mustParse(t, `(uint256[3][])`), `[[[1, 2, 3], [4, 5, 6]]]`, `
+		0000000000000000000000000000000000000000000000000000000000000020
+		0000000000000000000000000000000000000000000000000000000000000002
+		0000000000000000000000000000000000000000000000000000000000000001
+		0000000000000000000000000000000000000000000000000000000000000002
+		0000000000000000000000000000000000000000000000000000000000000003
+		0000000000000000000000000000000000000000000000000000000000000004
+		0000000000000000000000000000000000000000000000000000000000000005
+		0000000000000000000000000000000000000000000000000000000000000006`)
+
+	// a static array of dynamic arrays is dynamic, its elements are referenced by offsets relative to the array
+	checkRoundTrip(t, mustParse(t, `(uint256[][3])`), `[[[1], [], [2, 3]]]`, `
+		0000000000000000000000000000000000000000000000000000000000000020
+		0000000000000000000000000000000000000000000000000000000000000060
+		00000000000000000000000000000000000000000000000000000000000000a0
+		00000000000000000000000000000000000000000000000000000000000000c0
+		0000000000000000000000000000000000000000000000000000000000000001
+		0000000000000000000000000000000000000000000000000000000000000001
+		0000000000000000000000000000000000000000000000000000000000000000
+		0000000000000000000000000000000000000000000000000000000000000002
+		0000000000000000000000000000000000000000000000000000000000000002
+		0000000000000000000000000000000000000000000000000000000000000003`)
+
+	// both following a static value, taking one head word each
+	checkRoundTrip(t, mustParse(t, `(uint8,uint256[][2],uint256[2][])`), `[7, [[1], []], [[2, 3]]]`, `
+		0000000000000000000000000000000000000000000000000000000000000007
+		0000000000000000000000000000000000000000000000000000000000000060
+		0000000000000000000000000000000000000000000000000000000000000100
+		0000000000000000000000000000000000000000000000000000000000000040
+		0000000000000000000000000000000000000000000000000000000000000080
+		0000000000000000000000000000000000000000000000000000000000000001
+		0000000000000000000000000000000000000000000000000000000000000001
+		0000000000000000000000000000000000000000000000000000000000000000
+		0000000000000000000000000000000000000000000000000000000000000001
+		0000000000000000000000000000000000000000000000000000000000000002
+		0000000000000000000000000000000000000000000000000000000000000003`)
+}
+
+func TestBytesLengthExceedingBuffer(t *testing.T) {
+	for _, vector := range []struct{ typ, hex, err string }{
+		{`(bytes)`, `
+			0000000000000000000000000000000000000000000000000000000000000020
+			0000000000000000000000000000000000000000000000000000000000000021
+			6869000000000000000000000000000000000000000000000000000000000000`, `length 33 exceeds remaining 32 bytes`},
+		{`(string)`, `
+			0000000000000000000000000000000000000000000000000000000000000020
+			ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff`, `exceeds remaining 0 bytes`},
+	} {
+		if _, e := Decode(mustParse(t, vector.typ), mustHex(t, vector.hex)); e == nil || !strings.Contains(e.Error(), vector.err) {
+			t.Errorf(`%s: expected error containing %q, have %v`, vector.typ, vector.err, e)
+		}
+	}
+	// a length exactly covering the buffer is fine
+	if _, e := Decode(mustParse(t, `(bytes)`), mustHex(t, `
+		0000000000000000000000000000000000000000000000000000000000000020
+		0000000000000000000000000000000000000000000000000000000000000020
+		ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff`)); e != nil {
+		t.Fatal(e)
+	}
+}
+
+func TestDecodeLogIndexedValues(t *testing.T) {
+	// event Signed(address indexed signer, bytes32 indexed hash, bytes4 indexed tag, string indexed note, uint256 value)
+	args := []types.Type{mustParse(t, `address`), mustParse(t, `bytes32`), mustParse(t, `bytes4`), mustParse(t, `string`), mustParse(t, `uint256`)}
+	hash := `ff` + strings.Repeat(`00`, 30) + `01`
+	noteHash := `1c8aff950685c2ed4bc3174f3472287b56d9517b9c948127319a09a7a36deac8` // keccak256("hello")
+	topics := [][]byte{
+		mustHex(t, `00000000000000000000000052908400098527886e0f7030069857d2e4169ee7`),
+		mustHex(t, hash),
+		mustHex(t, `cafebabe00000000000000000000000000000000000000000000000000000000`),
+		mustHex(t, noteHash),
+	}
+	decoded, e := DecodeLog(args, []bool{true, true, true, true, false}, topics, mustHex(t, `000000000000000000000000000000000000000000000000000000000000002a`))
+	if e != nil {
+		t.Fatal(e)
+	}
+	want := `["0x52908400098527886e0f7030069857d2e4169ee7", "0x` + hash + `", "0xcafebabe", "0x` + noteHash + `", 42]`
+	if !jsonEqual(decoded, []byte(want)) {
+		t.Fatalf(`decoded %s, want %s`, decoded, want)
+	}
+}
+
+func TestPadHex(t *testing.T) {
+	typ := mustParse(t, `(uint256,uint256,uint64,uint64,int64)`)
+	code := mustHex(t, `
+		0000000000000000000000000000000000000000000000000000000000000007
+		00000000000000000000000000000000000000000000000000000001000000ff
+		0000000000000000000000000000000000000000000000000000000000000007
+		000000000000000000000000000000000000000000000000ffffffffffffffff
+		fffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffe`)
+
+	for _, vector := range []struct {
+		options DecodeOptions
+		want    string
+	}{
+		{DecodeOptions{PadHex: true}, `["0x` + strings.Repeat(`0`, 63) + `7", "0x` + strings.Repeat(`0`, 54) + `01000000ff", "0x0000000000000007", "0xffffffffffffffff", "0xfffffffffffffffe"]`},
+		{DecodeOptions{Integers: IntegerFormatHex, PadHex: true}, `["0x` + strings.Repeat(`0`, 63) + `7", "0x` + strings.Repeat(`0`, 54) + `01000000ff", "0x0000000000000007", "0xffffffffffffffff", "0xfffffffffffffffe"]`},
+		{DecodeOptions{}, `[7, "0x1000000ff", 7, "0xffffffffffffffff", -2]`},
+		{DecodeOptions{Integers: IntegerFormatString, PadHex: true}, `["7", "4294967551", "7", "18446744073709551615", "-2"]`}, // not hex
+	} {
+		decoded, e := DecodeWithOptions(typ, code, vector.options)
+		if e != nil {
+			t.Fatal(e)
+		}
+		if !jsonEqual(decoded, []byte(vector.want)) {
+			t.Fatalf(`%+v: decoded %s, want %s`, vector.options, decoded, vector.want)
+		}
+	}
+}
+
+func TestFixedComposites(t *testing.T) {
+	for typ, expected := range map[string]int{`ufixed128x18`: 32, `fixed64x10`: 32, `ufixed128x18[3]`: 96, `fixed128x18[]`: 32} {
+		if w := width(mustParse(t, typ)); w != expected {
+			t.Fatalf(`%s: expected width %d, have %d`, typ, expected, w)
+		}
+	}
+
+	checkRoundTrip(t, mustParse(t, `(ufixed128x18[3],uint8)`), `[["1.500000000000000000", "0.000000000000000000", "0.000000000000000001"], 7]`, `
+		00000000000000000000000000000000000000000000000014d1120d7b160000
+		0000000000000000000000000000000000000000000000000000000000000000
+		0000000000000000000000000000000000000000000000000000000000000001
+		0000000000000000000000000000000000000000000000000000000000000007`)
+
+	checkRoundTrip(t, mustParse(t, `(fixed128x18[])`), `[["-1.250000000000000000", "1.500000000000000000"]]`, `
+		0000000000000000000000000000000000000000000000000000000000000020
+		0000000000000000000000000000000000000000000000000000000000000002
+		ffffffffffffffffffffffffffffffffffffffffffffffffeea71b9f6ec30000
+		00000000000000000000000000000000000000000000000014d1120d7b160000`)
+
+	// struct Quote { fixed128x18 price; string symbol; uint8 count; }
+	quote := types.Struct{Keys: []string{`price`, `symbol`, `count`}, Types: []types.Type{mustParse(t, `fixed128x18`), mustParse(t, `string`), mustParse(t, `uint8`)}}
+	if w := width(quote); w != 32 { // dynamic, so just an offset in the head
+		t.Fatalf(`expected width 32, have %d`, w)
+	}
+	checkRoundTrip(t, types.Tuple{quote, mustParse(t, `uint8`)}, `[{"price": "-1.250000000000000000", "symbol": "ETH", "count": 2}, 9]`, `
+		0000000000000000000000000000000000000000000000000000000000000040
+		0000000000000000000000000000000000000000000000000000000000000009
+		ffffffffffffffffffffffffffffffffffffffffffffffffeea71b9f6ec30000
+		0000000000000000000000000000000000000000000000000000000000000060
+		0000000000000000000000000000000000000000000000000000000000000002
+		0000000000000000000000000000000000000000000000000000000000000003
+		4554480000000000000000000000000000000000000000000000000000000000`)
+
+	// without the string the struct is static and laid out inline
+	static := types.Struct{Keys: []string{`price`, `count`}, Types: []types.Type{mustParse(t, `fixed128x18`), mustParse(t, `uint8`)}}
+	if w := width(static); w != 64 {
+		t.Fatalf(`expected width 64, have %d`, w)
+	}
+	checkRoundTrip(t, types.Tuple{static, mustParse(t, `uint8`)}, `[{"price": "0.500000000000000000", "count": 2}, 9]`, `
+		00000000000000000000000000000000000000000000000006f05b59d3b20000
+		0000000000000000000000000000000000000000000000000000000000000002
+		0000000000000000000000000000000000000000000000000000000000000009`)
+}
+
+func TestRawUnsupported(t *testing.T) {
+	typ := types.Tuple{types.Elementary(`uint8`), types.Elementary(`quux256`)}
+	code := mustHex(t, `
+		0000000000000000000000000000000000000000000000000000000000000007
+		00000000000000000000000000000000000000000000000000000000deadbeef`)
+
+	logged := &bytes.Buffer{}
+	output := logger.Writer()
+	logger.SetOutput(logged)
+	defer logger.SetOutput(output)
+	loggedUnsupported.Delete(`quux256`) // logged by an earlier run of the test
+
+	decoded, e := DecodeWithOptions(typ, code, DecodeOptions{RawUnsupported: true})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if _, e := DecodeWithOptions(typ, code, DecodeOptions{RawUnsupported: true}); e != nil {
+		t.Fatal(e)
+	}
+	if n := strings.Count(logged.String(), `unsupported type quux256`); n != 1 {
+		t.Fatalf(`expected unsupported type to be logged once, logged %d times`, n)
+	}
+	if want := `[7, {"unsupported": "quux256", "raw": "0x00000000000000000000000000000000000000000000000000000000deadbeef"}]`; !jsonEqual(decoded, []byte(want)) {
+		t.Fatalf(`decoded %s, want %s`, decoded, want)
+	}
+
+	// fewer than 32 bytes left is an error, not a panic
+	if _, e := DecodeWithOptions(typ, code[:48], DecodeOptions{RawUnsupported: true}); e == nil {
+		t.Fatalf(`expected error for truncated unsupported value`)
+	}
+	if _, e := DecodeWithOptions(types.Elementary(`quux256`), code[:0], DecodeOptions{RawUnsupported: true}); e == nil {
+		t.Fatalf(`expected error for missing unsupported value`)
+	}
+}
+
+func TestStructWithMapping(t *testing.T) {
+	// struct Info { uint256 id; mapping(uint256 => bool) seen; bool active; }, the mapping taking no space
+	info := types.Struct{
+		Keys:  []string{`id`, `seen`, `active`},
+		Types: []types.Type{types.Elementary(`uint256`), types.Mapping{Key: types.Elementary(`uint256`), Value: types.Elementary(`bool`)}, types.Elementary(`bool`)},
+	}
+	code := mustHex(t, `
+		0000000000000000000000000000000000000000000000000000000000000007
+		0000000000000000000000000000000000000000000000000000000000000001`)
+	decoded, e := Decode(types.Tuple{info}, code)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if want := `[{"id": 7, "active": true}]`; !jsonEqual(decoded, []byte(want)) {
+		t.Fatalf(`decoded %s, want %s`, decoded, want)
+	}
+}
+
+func TestManualTwosComplement(t *testing.T) {
+	for _, vector := range []struct{ in, out string }{
+		{`01`, `ff`},
+		{`ff`, `01`},
+		{`80`, `80`},
+		{`00`, `00`},
+		{`00000000000000000000000000000001`, `ffffffffffffffffffffffffffffffff`},
+		{`00000000000000000000000000000100`, `ffffffffffffffffffffffffffffff00`},
+		{`7fffffffffffffff0000000000000000`, `80000000000000010000000000000000`}, // carry stops mid-way
+		{`000000000000000000000000000000000000000000000000000000000000002a`, `ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffd6`},
+		{`8000000000000000000000000000000000000000000000000000000000000000`, `8000000000000000000000000000000000000000000000000000000000000000`},
+		{``, ``},
+	} {
+		in := mustHex(t, vector.in)
+		out := manualTwosComplement(in)
+		if hex.EncodeToString(out) != vector.out {
+			t.Fatalf(`%s: expected %s, have %x`, vector.in, vector.out, out)
+		}
+		if hex.EncodeToString(in) != vector.in {
+			t.Fatalf(`%s: input modified to %x`, vector.in, in)
+		}
+		// 2^(8n) - x, modulo 2^(8n)
+		modulus := new(big.Int).Lsh(big.NewInt(1), uint(8*len(in)))
+		expected := new(big.Int).Mod(new(big.Int).Sub(modulus, new(big.Int).SetBytes(in)), modulus)
+		if new(big.Int).SetBytes(out).Cmp(expected) != 0 {
+			t.Fatalf(`%s: expected %x, have %x`, vector.in, expected, out)
+		}
+	}
+}
+
+func TestEncodePartial(t *testing.T) {
+	full, e := Encode(mustParse(t, `(uint256,address,string,bool)`), json.RawMessage(`[7, "0x00000000000000000000000000000000000000aa", "hello", true]`))
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	// a static prefix is a prefix of the full encoding
+	static, e := EncodePartial([]types.Type{types.Elementary(`uint256`), types.Elementary(`address`)}, []json.RawMessage{json.RawMessage(`7`), json.RawMessage(`"0x00000000000000000000000000000000000000aa"`)})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if !bytes.Equal(static, full[:64]) {
+		t.Fatalf("expected the full encoding's first two words:\n%x\n%x", static, full[:64])
+	}
+
+	// a dynamic prefix has the same tail, its offsets relative to its own shorter head
+	dynamic, e := EncodePartial([]types.Type{types.Elementary(`uint256`), types.Elementary(`string`)}, []json.RawMessage{json.RawMessage(`7`), json.RawMessage(`"hello"`)})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(dynamic) != 4*32 {
+		t.Fatalf(`expected 2 head and 2 tail words, have %x`, dynamic)
+	}
+	if !bytes.Equal(dynamic[:32], full[:32]) {
+		t.Fatalf(`expected equal first words, have %x and %x`, dynamic[:32], full[:32])
+	}
+	if !bytes.Equal(dynamic[64:], full[4*32:]) {
+		t.Fatalf("expected equal tails:\n%x\n%x", dynamic[64:], full[4*32:])
+	}
+	if offset, expected := hex.EncodeToString(dynamic[32:64]), fmt.Sprintf(`%064x`, 64); offset != expected {
+		t.Fatalf(`expected offset %s, have %s`, expected, offset)
+	}
+	if offset, expected := hex.EncodeToString(full[64:96]), fmt.Sprintf(`%064x`, 128); offset != expected {
+		t.Fatalf(`expected offset %s in the full encoding, have %s`, expected, offset)
+	}
+
+	if _, e := EncodePartial([]types.Type{types.Elementary(`uint256`)}, nil); e == nil {
+		t.Fatalf(`expected error for missing argument`)
+	}
+}
+
+func TestHugeArrayLength(t *testing.T) {
+	for _, vector := range []struct{ typ, length, err string }{
+		{`(uint256[])`, `ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff`, `exceeds remaining 64 bytes`},
+		{`(uint256[])`, `000000000000000000000000000000000000000000000000000000003b9aca00`, `length 1000000000 exceeds remaining 64 bytes`},
+		{`(string[])`, `0000000000000000000000000000000000000000000000000000000000000003`, `length 3 exceeds remaining 64 bytes`},
+		{`((uint256,bool)[])`, `8000000000000000000000000000000000000000000000000000000000000000`, `exceeds remaining 64 bytes`},
+	} {
+		code := mustHex(t, `0000000000000000000000000000000000000000000000000000000000000020`+vector.length+`
+			0000000000000000000000000000000000000000000000000000000000000001
+			0000000000000000000000000000000000000000000000000000000000000002`)
+		if _, e := Decode(mustParse(t, vector.typ), code); e == nil || !strings.Contains(e.Error(), vector.err) {
+			t.Errorf(`%s with length %s: expected error containing %q, have %v`, vector.typ, vector.length, vector.err, e)
+		}
+	}
+	// the largest length fitting the data decodes
+	decoded, e := Decode(mustParse(t, `(uint256[])`), mustHex(t, `
+		0000000000000000000000000000000000000000000000000000000000000020
+		0000000000000000000000000000000000000000000000000000000000000002
+		0000000000000000000000000000000000000000000000000000000000000001
+		0000000000000000000000000000000000000000000000000000000000000002`))
+	if e != nil {
+		t.Fatal(e)
+	}
+	if !jsonEqual(decoded, []byte(`[[1, 2]]`)) {
+		t.Fatalf(`expected [[1, 2]], have %s`, decoded)
+	}
+}
+
+func TestLenientBooleans(t *testing.T) {
+	typ := mustParse(t, `(uint8,uint256,bool)`)
+	code, e := EncodeWithOptions(typ, json.RawMessage(`[true, false, true]`), EncodeOptions{LenientBooleans: true})
+	if e != nil {
+		t.Fatal(e)
+	}
+	expected := mustHex(t, `
+		0000000000000000000000000000000000000000000000000000000000000001
+		0000000000000000000000000000000000000000000000000000000000000000
+		0000000000000000000000000000000000000000000000000000000000000001`)
+	if !bytes.Equal(code, expected) {
+		t.Fatalf("expected true, false as 1, 0:\n%x\n%x", code, expected)
+	}
+	// like integers
+	if numbers, e := Encode(typ, json.RawMessage(`[1, 0, true]`)); e != nil || !bytes.Equal(numbers, code) {
+		t.Fatalf(`expected the encoding of [1, 0, true], have %x (%v)`, numbers, e)
+	}
+
+	for _, arg := range []string{`[true, 0, true]`, `[0, false, true]`} {
+		if code, e := Encode(typ, json.RawMessage(arg)); e == nil {
+			t.Fatalf(`%s: expected booleans to be rejected without the flag, have %x`, arg, code)
+		}
+	}
+}
+
+func TestDecodeBytesNAsHex(t *testing.T) {
+	typ := mustParse(t, `(bytes32,bytes4,bytes4,bytes1)`)
+	code := mustHex(t, `
+		c5d2460186f7233c927e7db2dcc703c0e500b653ca82273b7bfad8045d85a470
+		a9059cbb00000000000000000000000000000000000000000000000000000000
+		6b61726d00000000000000000000000000000000000000000000000000000000
+		0000000000000000000000000000000000000000000000000000000000000000`)
+	decoded, e := Decode(typ, code)
+	if e != nil {
+		t.Fatal(e)
+	}
+	// a hash, a selector, valid UTF-8 ("karm") and a zero byte, all as hex strings of exactly N bytes
+	want := `["0xc5d2460186f7233c927e7db2dcc703c0e500b653ca82273b7bfad8045d85a470", "0xa9059cbb", "0x6b61726d", "0x00"]`
+	if !jsonEqual(decoded, []byte(want)) {
+		t.Fatalf(`decoded %s, want %s`, decoded, want)
+	}
+
+	// dynamic bytes are unaffected
+	decoded, e = Decode(mustParse(t, `(bytes)`), mustHex(t, `
+		0000000000000000000000000000000000000000000000000000000000000020
+		0000000000000000000000000000000000000000000000000000000000000004
+		6b61726d00000000000000000000000000000000000000000000000000000000`))
+	if e != nil {
+		t.Fatal(e)
+	}
+	if want := `["karm"]`; !jsonEqual(decoded, []byte(want)) {
+		t.Fatalf(`decoded %s, want %s`, decoded, want)
+	}
+}
+
+func TestEncodeBytesNFromHex(t *testing.T) {
+	typ := mustParse(t, `(bytes4,bytes32,bytes4,bytes2)`)
+	code, e := Encode(typ, json.RawMessage(`["0xa9059cbb", "0xC5D2460186F7233C927E7DB2DCC703C0E500B653CA82273B7BFAD8045D85A470", "0x1g", "hi"]`))
+	if e != nil {
+		t.Fatal(e)
+	}
+	// hex of exactly N bytes is left-aligned, strings other than hex are taken as plain bytes
+	want := mustHex(t, `
+		a9059cbb00000000000000000000000000000000000000000000000000000000
+		c5d2460186f7233c927e7db2dcc703c0e500b653ca82273b7bfad8045d85a470
+		3078316700000000000000000000000000000000000000000000000000000000
+		6869000000000000000000000000000000000000000000000000000000000000`)
+	if !bytes.Equal(code, want) {
+		t.Fatalf("encoded\n%x\nwant\n%x", []byte(code), want)
+	}
+
+	// decoded values encode back to the same words
+	decoded, e := Decode(typ, code)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if again, e := Encode(typ, decoded); e != nil || !bytes.Equal(again, code) {
+		t.Fatalf("expected %s to encode to\n%x\nhave\n%x (%v)", decoded, []byte(code), []byte(again), e)
+	}
+
+	for _, invalid := range []struct{ typ, arg string }{
+		{`(bytes4)`, `["0x` + strings.Repeat(`ab`, 5) + `"]`},   // 5 bytes of hex
+		{`(bytes32)`, `["0x` + strings.Repeat(`ab`, 33) + `"]`}, // 33 bytes of hex
+		{`(bytes32)`, `["0xabcd"]`},                             // 2 bytes of hex, rather than a 6-byte string
+		{`(bytes2)`, `["0x"]`},
+	} {
+		if code, e := Encode(mustParse(t, invalid.typ), json.RawMessage(invalid.arg)); e == nil {
+			t.Fatalf(`%s: expected error, have %x`, invalid.arg, code)
+		}
+	}
+}
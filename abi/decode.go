@@ -3,51 +3,124 @@
 package abi // import "github.com/karmarun/karma.link/abi"
 
 import (
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"github.com/karmarun/karma.link/types"
 	"math/big"
 	"strconv"
 	"strings"
+	"sync"
 	"unicode/utf8"
 )
 
+// IntegerFormat determines how decoded integers are rendered in JSON.
+type IntegerFormat string
+
+const (
+	// IntegerFormatHex renders integers of up to 32 bits as JSON numbers and larger ones as "0x"-prefixed hex strings.
+	// Negative integers beyond 32 bits are rendered in their 256-bit two's complement. This is the default.
+	IntegerFormatHex IntegerFormat = `hex`
+	// IntegerFormatString renders all integers as decimal strings, e.g. "-42".
+	IntegerFormatString IntegerFormat = `string`
+	// IntegerFormatNumber renders integers as JSON numbers if they are exactly representable
+	// as IEEE 754 doubles (|n| <= 2^53-1) and as decimal strings otherwise.
+	IntegerFormatNumber IntegerFormat = `number`
+)
+
+// maxSafeInteger is the largest integer n such that n and n+1 are exactly representable as IEEE 754 doubles.
+var maxSafeInteger = big.NewInt(1<<53 - 1)
+
+// DecodeOptions configures DecodeWithOptions. The zero value is the behaviour of Decode.
+type DecodeOptions struct {
+	Integers IntegerFormat // IntegerFormatHex if empty
+	PadHex   bool          // renders all integers as hex zero-padded to the width of their type, e.g. 64 digits for uint256; hex format only
+
+	// RawUnsupported renders values of types decode doesn't support as {"unsupported": type, "raw": "0x..."}
+	// holding their 32-byte word, instead of panicking.
+	RawUnsupported bool
+}
+
+// ParseIntegerFormat parses an integer format as given in requests, returning IntegerFormatHex if s is empty.
+func ParseIntegerFormat(s string) (IntegerFormat, error) {
+	switch f := IntegerFormat(s); f {
+	case ``:
+		return IntegerFormatHex, nil
+	case IntegerFormatHex, IntegerFormatString, IntegerFormatNumber:
+		return f, nil
+	}
+	return ``, fmt.Errorf(`invalid integer format %s, available: hex, string, number`, s)
+}
+
 // Decode translates Solidity ABI-encoded code into JSON, using typ as reference.
 // typ is usually of type types.Tuple representing a Solidity function return type list.
 func Decode(typ types.Type, code Code) (json.RawMessage, error) {
-	value, _, e := decode(typ, code, 0)
+	return DecodeWithOptions(typ, code, DecodeOptions{})
+}
+
+// DecodeWithOptions is like Decode, rendering values as configured by options.
+func DecodeWithOptions(typ types.Type, code Code, options DecodeOptions) (json.RawMessage, error) {
+	value, _, e := decode(typ, code, 0, options)
 	if e != nil {
 		return nil, e
 	}
 	return value, nil
 }
 
-// offset = offset from head start
+// DecodeAll decodes a sequence of values laid out like a tuple, returning each value separately.
+// Leftover bytes are reported as an error if all of typs are static. Otherwise, they may belong to the
+// tails of dynamic values and are not checked.
+func DecodeAll(typs []types.Type, code Code) ([]json.RawMessage, error) {
+	out, offset, remainder := make([]json.RawMessage, len(typs), len(typs)), 0, code
+	for i, typ := range typs {
+		p, c, e := decode(typ, remainder, offset, DecodeOptions{})
+		if e != nil {
+			return nil, fmt.Errorf(`[%d] %s`, i, e)
+		}
+		offset += len(remainder) - len(c)
+		out[i], remainder = p, c
+	}
+	if len(remainder) > 0 && !isDynamic(types.Tuple(typs)) {
+		return nil, fmt.Errorf(`%d leftover bytes after decoding %d values`, len(remainder), len(typs))
+	}
+	return out, nil
+}
+
+// decode is the inverse of encode. code starts at the value to decode and extends to the end of the
+// enclosing frame (see encode), offset is the position of code within that frame. Offsets read from
+// head are relative to the start of the frame, so the referenced data begins at code[ref-offset:].
+// Every dereference enters a new frame, decoded with offset 0.
 // parsed, remainder, error
-func decode(typ types.Type, code Code, offset int) (json.RawMessage, Code, error) {
+func decode(typ types.Type, code Code, offset int, options DecodeOptions) (json.RawMessage, Code, error) {
 	switch t := typ.(type) {
 
 	case types.Named:
-		return decode(t.Type, code, offset)
+		return decode(t.Type, code, offset, options)
 
 	case types.ContractAddress:
-		return decode(addressType, code, offset)
+		return decode(addressType, code, offset, options)
 
 	case types.InterfaceAddress:
-		return decode(addressType, code, offset)
+		return decode(addressType, code, offset, options)
 
 	case types.LibraryAddress:
-		return decode(addressType, code, offset)
+		return decode(addressType, code, offset, options)
 
 	case types.Enum:
-		idx := new(big.Int).SetBytes(code[:32]).Int64()
-		bs, _ := json.Marshal(t[idx])
+		if e := checkWord(code); e != nil {
+			return nil, nil, e
+		}
+		idx := new(big.Int).SetBytes(code[:32])
+		if idx.Cmp(big.NewInt(int64(len(t)))) >= 0 {
+			return nil, nil, fmt.Errorf(`enum value %s out of range, have %d members`, idx, len(t))
+		}
+		bs, _ := json.Marshal(t[idx.Int64()])
 		return bs, code[32:], nil
 
 	case types.Tuple:
 		out := make([]json.RawMessage, len(t), len(t))
 		for i, typ := range t {
-			p, c, e := decode(typ, code, offset)
+			p, c, e := decode(typ, code, offset, options)
 			if e != nil {
 				return nil, nil, e
 			}
@@ -58,30 +131,32 @@ func decode(typ types.Type, code Code, offset int) (json.RawMessage, Code, error
 		return bs, code, nil
 
 	case types.Struct:
-		out := make(map[string]json.RawMessage, len(t.Keys))
-		for i, key := range t.Keys {
-			typ := t.Types[i]
-			p, c, e := decode(typ, code, offset)
+		t = omitMappings(t)
+		if isDynamic(t) {
+			tail, e := dereference(code, offset)
 			if e != nil {
 				return nil, nil, e
 			}
-			offset += len(code) - len(c)
-			out[key], code = p, c
+			val, _, e := decodeStruct(t, tail, 0, options) // NOTE: new frame
+			if e != nil {
+				return nil, nil, e
+			}
+			return val, code[32:], nil
 		}
-		bs, _ := json.Marshal(out)
-		return bs, code, nil
+		return decodeStruct(t, code, offset, options)
 
 	case types.Array:
 
 		if t.IsDynamic() {
-			ref := int(new(big.Int).SetBytes(code[:32]).Int64())
-			tail := code[ref-offset:]
-			lng := int(new(big.Int).SetBytes(tail[:32]).Int64())
-			tuple := make(types.Tuple, lng, lng)
-			for i := 0; i < lng; i++ {
-				tuple[i] = t.Type
-			}
-			val, _, e := decode(tuple, tail[32:], 0) // NOTE: reset offset (multi-dimensional case)
+			tail, e := dereference(code, offset)
+			if e != nil {
+				return nil, nil, e
+			}
+			lng, e := readLength(tail, 32) // every element takes at least one word
+			if e != nil {
+				return nil, nil, e
+			}
+			val, _, e := decode(repeatType(t.Type, lng), tail[32:], 0, options) // NOTE: new frame (multi-dimensional case)
 			if e != nil {
 				return nil, nil, e
 			}
@@ -92,9 +167,21 @@ func decode(typ types.Type, code Code, offset int) (json.RawMessage, Code, error
 			return json.RawMessage(`[]`), code, nil
 		}
 
+		if isDynamic(t.Type) {
+			tail, e := dereference(code, offset)
+			if e != nil {
+				return nil, nil, e
+			}
+			val, _, e := decode(repeatType(t.Type, t.Length), tail, 0, options) // NOTE: new frame
+			if e != nil {
+				return nil, nil, e
+			}
+			return val, code[32:], nil
+		}
+
 		out := make([]json.RawMessage, t.Length, t.Length)
 		for i := 0; i < t.Length; i++ {
-			p, c, e := decode(t.Type, code, offset)
+			p, c, e := decode(t.Type, code, offset, options)
 			if e != nil {
 				return nil, nil, e
 			}
@@ -105,37 +192,77 @@ func decode(typ types.Type, code Code, offset int) (json.RawMessage, Code, error
 		return bs, code, nil
 
 	case types.Elementary:
+		if e := checkWord(code); e != nil {
+			return nil, nil, e
+		}
 		id := string(normalizeElementaryTypeName(t))
+		if codec, ok := elementaryCodec(id); ok {
+			val, e := codec.Decode(code[:32])
+			if e != nil {
+				return nil, nil, e
+			}
+			return val, code[32:], nil
+		}
+		if t == addressType || strings.HasPrefix(string(t), `address `) { // right-aligned in the low 20 bytes
+			val, _ := json.Marshal(`0x` + hex.EncodeToString(code[12:32]))
+			return val, code[32:], nil
+		}
 		if strings.HasPrefix(id, `fixed`) || strings.HasPrefix(id, `ufixed`) {
-			// TODO: support fixed<M>x<N> and ufixed<M>x<N>
-			return nil, nil, fmt.Errorf(`fixed/ufixed types not supported yet`)
+			_, decimals := fixedBits(id)
+			val := new(big.Int).SetBytes(code[:32])
+			if id[0] == 'f' && val.Bit(255) == 1 {
+				val.SetBytes(manualTwosComplement(code[:32]))
+				val.Neg(val)
+			}
+			// NOTE: exact since the denominator is a power of ten, so there's nothing to round.
+			// Always N fractional digits (none for N = 0), e.g. "1.250000000000000000" for ufixed128x18.
+			scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil)
+			bs, _ := json.Marshal(new(big.Rat).SetFrac(val, scale).FloatString(decimals))
+			return bs, code[32:], nil
+		}
+		if id == `bool` {
+			for _, b := range code[:31] {
+				if b != 0 {
+					return nil, nil, fmt.Errorf(`invalid bool word`)
+				}
+			}
+			switch code[31] {
+			case 0:
+				return json.RawMessage(`false`), code[32:], nil
+			case 1:
+				return json.RawMessage(`true`), code[32:], nil
+			}
+			return nil, nil, fmt.Errorf(`invalid bool word`)
+		}
+		if id == `function` {
+			bs, _ := json.Marshal(functionValue{
+				Address:  `0x` + hex.EncodeToString(code[:20]),
+				Selector: `0x` + hex.EncodeToString(code[20:24]),
+			})
+			return bs, code[32:], nil
 		}
 		if strings.HasPrefix(id, `uint`) {
 			val := new(big.Int).SetBytes(code[:32])
-			if val.BitLen() <= 32 {
-				return json.RawMessage(val.Text(10)), code[32:], nil
-			}
-			return json.RawMessage(`"0x` + val.Text(16) + `"`), code[32:], nil
+			return options.formatInteger(val, val, integerBits(id)), code[32:], nil
 		}
 		if strings.HasPrefix(id, `int`) {
 			uval := new(big.Int).SetBytes(code[:32])
 			if uval.Bit(255) == 0 {
-				if uval.BitLen() <= 32 {
-					return json.RawMessage(uval.Text(10)), code[32:], nil
-				}
-				return json.RawMessage(`"0x` + uval.Text(16) + `"`), code[32:], nil
+				return options.formatInteger(uval, uval, integerBits(id)), code[32:], nil
 			}
 			sval := new(big.Int).SetBytes(manualTwosComplement(code[:32]))
 			sval = sval.Neg(sval)
-			if sval.BitLen() > 32 {
-				return json.RawMessage(`"0x` + uval.Text(16) + `"`), code[32:], nil
-			}
-			return json.RawMessage(sval.Text(10)), code[32:], nil
+			return options.formatInteger(sval, uval, integerBits(id)), code[32:], nil
 		}
 		if id == `bytes` {
-			ref := int(new(big.Int).SetBytes(code[:32]).Int64())
-			tail := code[ref-offset:]
-			lng := int(new(big.Int).SetBytes(tail[:32]).Int64())
+			tail, e := dereference(code, offset)
+			if e != nil {
+				return nil, nil, e
+			}
+			lng, e := readLength(tail, 1)
+			if e != nil {
+				return nil, nil, e
+			}
 			bs := tail[32 : 32+lng]
 			if utf8.Valid(bs) {
 				val, _ := json.Marshal(string(bs))
@@ -149,18 +276,119 @@ func decode(typ types.Type, code Code, offset int) (json.RawMessage, Code, error
 			if e != nil || n < 0 || n > 32 {
 				logger.Panicln(n, e)
 			}
-			bs := code[:32]
-			code = code[32:]
-			bs = bs[:n]
-			if utf8.Valid(bs) {
-				val, _ := json.Marshal(string(bs))
-				return val, code, nil
-			}
-			val, _ := json.Marshal(bs)
-			return val, code, nil
+			// NOTE: always hex, like hashes and selectors are conventionally rendered, regardless of whether the bytes are valid UTF-8
+			val, _ := json.Marshal(`0x` + hex.EncodeToString(code[:n]))
+			return val, code[32:], nil
 		}
 
 	}
+	if options.RawUnsupported {
+		return rawUnsupported(typ, code)
+	}
 	logger.Panicf("unexpected type in abi.Decode: %#v\n", typ)
 	return nil, nil, nil // shut up compiler
 }
+
+// unsupportedValue is the rendering of a value whose type decode doesn't support, see DecodeOptions.RawUnsupported.
+type unsupportedValue struct {
+	Unsupported string `json:"unsupported"` // the type's signature
+	Raw         string `json:"raw"`
+}
+
+// loggedUnsupported holds the signatures of unsupported types already logged by rawUnsupported.
+var loggedUnsupported = &sync.Map{}
+
+// rawUnsupported renders the word at the start of code as an unsupportedValue of type typ.
+// Each unsupported type is logged once, not per value.
+func rawUnsupported(typ types.Type, code Code) (json.RawMessage, Code, error) {
+	signature := strings.TrimSpace(string(typ.SoliditySignature()))
+	if e := checkWord(code); e != nil {
+		return nil, nil, fmt.Errorf(`unsupported type %s: %s`, signature, e)
+	}
+	if _, logged := loggedUnsupported.LoadOrStore(signature, true); !logged {
+		logger.Printf("decoding unsupported type %s (%T) as raw bytes\n", signature, typ)
+	}
+	bs, _ := json.Marshal(unsupportedValue{Unsupported: signature, Raw: `0x` + hex.EncodeToString(code[:32])})
+	return bs, code[32:], nil
+}
+
+// decodeStruct decodes the members of a struct laid out inline, starting at code.
+func decodeStruct(t types.Struct, code Code, offset int, options DecodeOptions) (json.RawMessage, Code, error) {
+	out := make(map[string]json.RawMessage, len(t.Keys))
+	for i, key := range t.Keys {
+		typ := t.Types[i]
+		p, c, e := decode(typ, code, offset, options)
+		if e != nil {
+			return nil, nil, e
+		}
+		offset += len(code) - len(c)
+		out[key], code = p, c
+	}
+	bs, _ := json.Marshal(out)
+	return bs, code, nil
+}
+
+// formatInteger renders the integer val of a bits wide type, whose 256-bit two's complement is word, according to options.
+func (options DecodeOptions) formatInteger(val, word *big.Int, bits int) json.RawMessage {
+	switch options.Integers {
+	case IntegerFormatString:
+		return json.RawMessage(`"` + val.Text(10) + `"`)
+	case IntegerFormatNumber:
+		if new(big.Int).Abs(val).Cmp(maxSafeInteger) > 0 {
+			return json.RawMessage(`"` + val.Text(10) + `"`)
+		}
+		return json.RawMessage(val.Text(10))
+	}
+	if options.PadHex {
+		// negative values are rendered in the two's complement of the type's width
+		word = new(big.Int).And(word, new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), uint(bits)), big.NewInt(1)))
+		return json.RawMessage(fmt.Sprintf(`"0x%0*s"`, bits/4, word.Text(16)))
+	}
+	if val.BitLen() > 32 {
+		return json.RawMessage(`"0x` + word.Text(16) + `"`)
+	}
+	return json.RawMessage(val.Text(10))
+}
+
+// integerBits returns the width of the (normalized) integer type id, e.g. 64 for uint64.
+func integerBits(id string) int {
+	bits, e := strconv.Atoi(strings.TrimPrefix(strings.TrimPrefix(id, `u`), `int`))
+	if e != nil {
+		logger.Panicln(e)
+	}
+	return bits
+}
+
+// checkWord reports an error if code ends before a full word.
+func checkWord(code Code) error {
+	if len(code) < 32 {
+		return fmt.Errorf(`unexpected end of data, need 32 bytes, have %d`, len(code))
+	}
+	return nil
+}
+
+// dereference follows the offset stored in the head word at the start of code,
+// checking that it points into the frame.
+func dereference(code Code, offset int) (Code, error) {
+	if e := checkWord(code); e != nil {
+		return nil, e
+	}
+	ref := new(big.Int).SetBytes(code[:32])
+	if ref.Cmp(big.NewInt(int64(offset+len(code)))) > 0 || ref.Int64() < int64(offset) {
+		return nil, fmt.Errorf(`offset %s points outside of the data`, ref)
+	}
+	return code[int(ref.Int64())-offset:], nil
+}
+
+// readLength reads the length word at the start of tail, checking that the following elementSize-byte
+// elements fit into the rest of tail. Adversarial lengths would otherwise cause huge allocations or panics.
+func readLength(tail Code, elementSize int) (int, error) {
+	if len(tail) < 32 {
+		return 0, fmt.Errorf(`missing length word`)
+	}
+	lng := new(big.Int).SetBytes(tail[:32])
+	if lng.Cmp(big.NewInt(int64((len(tail)-32)/elementSize))) > 0 {
+		return 0, fmt.Errorf(`length %s exceeds remaining %d bytes`, lng, len(tail)-32)
+	}
+	return int(lng.Int64()), nil
+}
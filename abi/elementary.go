@@ -0,0 +1,42 @@
+// Copyright 2018 karma.run AG. All rights reserved.
+
+package abi // import "github.com/karmarun/karma.link/abi"
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// ElementaryCodec encodes and decodes values of a custom elementary type.
+// Custom elementary types are static and occupy a single 32-byte word.
+type ElementaryCodec interface {
+
+	// Encode translates a JSON value into its 32-byte ABI word.
+	Encode(arg json.RawMessage) ([]byte, error)
+
+	// Decode translates a 32-byte ABI word into JSON.
+	Decode(word []byte) (json.RawMessage, error)
+}
+
+var elementaryCodecs = &sync.Map{}
+
+// RegisterElementary registers a codec for the elementary type with the given normalized name, e.g. "uint256".
+// Registered codecs take precedence over built-in ones.
+// It panics if there already is a codec registered with the same name, or if name is the dynamic "bytes".
+func RegisterElementary(name string, codec ElementaryCodec) {
+	if name == `bytes` {
+		logger.Panicln(`can't register elementary codec for dynamic type:`, name)
+	}
+	if _, loaded := elementaryCodecs.LoadOrStore(name, codec); loaded {
+		logger.Panicln(`already registered elementary codec with name:`, name)
+	}
+}
+
+// elementaryCodec returns the codec registered for the normalized type name id, if any.
+func elementaryCodec(id string) (ElementaryCodec, bool) {
+	codec, ok := elementaryCodecs.Load(id)
+	if !ok {
+		return nil, false
+	}
+	return codec.(ElementaryCodec), true
+}
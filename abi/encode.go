@@ -11,31 +11,62 @@ import (
 	"strings"
 )
 
+// EncodeOptions configures EncodeWithOptions. The zero value is the behaviour of Encode.
+type EncodeOptions struct {
+	// LenientBooleans accepts JSON booleans for integer types, encoding true as 1 and false as 0,
+	// e.g. for contracts using uint8 flags. Encode rejects them.
+	LenientBooleans bool
+}
+
 // Encode translates JSON into  Solidity ABI-encoded code, using typ as reference.
 // typ is usually of type types.Tuple representing a Solidity function parameter list.
 func Encode(typ types.Type, arg json.RawMessage) (Code, error) {
-	head, tail, e := encode(typ, arg, 0, make([]byte, 0, 1024), make([]byte, 0, 1024))
+	return EncodeWithOptions(typ, arg, EncodeOptions{})
+}
+
+// EncodeWithOptions is like Encode, accepting values as configured by options.
+func EncodeWithOptions(typ types.Type, arg json.RawMessage, options EncodeOptions) (Code, error) {
+	head, tail, e := encode(typ, arg, 0, make([]byte, 0, 1024), make([]byte, 0, 1024), options)
 	if e != nil {
 		return nil, e
 	}
 	return append(head, tail...), nil
 }
 
-func encode(typ types.Type, arg json.RawMessage, tailOffset int, head, tail []byte) ([]byte, []byte, error) {
+// EncodePartial encodes args as a tuple of typs, independent of any function, e.g. a prefix of a function's arguments
+// to be concatenated with further data. Offsets of dynamic values are relative to the start of the returned code,
+// so it only equals a prefix of the full encoding if all of typs are static.
+func EncodePartial(typs []types.Type, args []json.RawMessage) (Code, error) {
+	if len(typs) != len(args) {
+		return nil, fmt.Errorf(`%d types but %d arguments`, len(typs), len(args))
+	}
+	return encodeFrame(typs, args, indexPath, EncodeOptions{})
+}
+
+// encode appends the encoding of arg to head and tail, the two halves of the enclosing frame.
+// A frame is the encoding of a tuple-like sequence of values: the root argument list, the members
+// of a dynamic struct or the elements of an array containing dynamic values. Static values are
+// written into head. Dynamic values are written into tail and referenced from head by an offset
+// relative to the start of the frame; tailOffset is the width of the frame's head.
+//
+// Nested arrays follow from these rules: uint256[3][] is a dynamic array of static uint256[3] elements,
+// laid out inline after its length. uint256[][3] is a fixed array of dynamic elements and therefore dynamic
+// itself: a pointer to a frame of three pointers to the inner arrays.
+func encode(typ types.Type, arg json.RawMessage, tailOffset int, head, tail []byte, options EncodeOptions) ([]byte, []byte, error) {
 
 	switch t := typ.(type) {
 
 	case types.Named:
-		return encode(t.Type, arg, tailOffset, head, tail)
+		return encode(t.Type, arg, tailOffset, head, tail, options)
 
 	case types.ContractAddress:
-		return encode(addressType, arg, tailOffset, head, tail)
+		return encode(addressType, arg, tailOffset, head, tail, options)
 
 	case types.InterfaceAddress:
-		return encode(addressType, arg, tailOffset, head, tail)
+		return encode(addressType, arg, tailOffset, head, tail, options)
 
 	case types.LibraryAddress:
-		return encode(addressType, arg, tailOffset, head, tail)
+		return encode(addressType, arg, tailOffset, head, tail, options)
 
 	case types.Tuple:
 		temp := make([]json.RawMessage, 0, len(t))
@@ -45,10 +76,10 @@ func encode(typ types.Type, arg json.RawMessage, tailOffset int, head, tail []by
 		if len(temp) != len(t) {
 			return nil, nil, fmt.Errorf(`expected array of %d elements, have %d`, len(t), len(temp)) // TODO: pathed errors
 		}
-		// tuples are function argument lists, they determine the tail offset
+		// tuples are function argument lists, i.e. the root frame, they determine the tail offset
 		tailOffset += width(t)
 		for i, typ := range t {
-			h, t, e := encode(typ, temp[i], tailOffset, head, tail)
+			h, t, e := encode(typ, temp[i], tailOffset, head, tail, options)
 			if e != nil {
 				return nil, nil, fmt.Errorf(`[%d] %s`, i, e)
 			}
@@ -81,12 +112,26 @@ func encode(typ types.Type, arg json.RawMessage, tailOffset int, head, tail []by
 		if len(temp) != len(t.Keys) {
 			return nil, nil, fmt.Errorf(`too many or too few keys in object: %d, expected keys: %s`, len(temp), strings.Join(t.Keys, ", "))
 		}
+		args := make([]json.RawMessage, len(t.Keys), len(t.Keys))
 		for i, key := range t.Keys {
-			if _, ok := temp[key]; !ok {
+			arg, ok := temp[key]
+			if !ok {
 				return nil, nil, fmt.Errorf(`missing key in object: %s`, key)
 			}
+			args[i] = arg
+		}
+		if isDynamic(t) {
+			// offset -> members
+			frame, e := encodeFrame(t.Types, args, func(i int) string { return `["` + t.Keys[i] + `"]` }, options)
+			if e != nil {
+				return nil, nil, e
+			}
+			head = append(head, encodeInt256(big.NewInt(int64(tailOffset+len(tail))))...)
+			return head, append(tail, frame...), nil
+		}
+		for i, key := range t.Keys {
 			typ := t.Types[i]
-			h, t, e := encode(typ, temp[key], tailOffset, head, tail)
+			h, t, e := encode(typ, args[i], tailOffset, head, tail, options)
 			if e != nil {
 				return nil, nil, fmt.Errorf(`["%s"] %s`, key, e)
 			}
@@ -102,36 +147,31 @@ func encode(typ types.Type, arg json.RawMessage, tailOffset int, head, tail []by
 		}
 		if t.IsDynamic() {
 
-			// offset -> length, args...
+			// offset -> length, elements...
+			frame, e := encodeFrame(repeatType(t.Type, len(temp)), temp, indexPath, options)
+			if e != nil {
+				return nil, nil, e
+			}
 			head = append(head, encodeInt256(big.NewInt(int64(tailOffset+len(tail))))...)
 			tail = append(tail, encodeInt256(big.NewInt(int64(len(temp))))...)
-
-			itemWidth := width(t.Type)
-			subHead := make([]byte, 0, itemWidth*len(temp))
-			subTailOffset := itemWidth * len(temp) // offsets are relative, mirroring remix.ethereum.org
-			subTail := make([]byte, 0, 1024)
-
-			for i, arg := range temp {
-				h, t, e := encode(t.Type, arg, subTailOffset, subHead, subTail)
-				if e != nil {
-					return nil, nil, fmt.Errorf(`[%d] %s`, i, e)
-				}
-				subHead, subTail = h, t
-			}
-
-			if len(subHead) != cap(subHead) {
-				logger.Panicln(len(subHead), cap(subHead))
-			}
-
-			return head, append(tail, append(subHead, subTail...)...), nil
+			return head, append(tail, frame...), nil
 
 		}
 		// fixed-size case
 		if t.Length != len(temp) {
 			return nil, nil, fmt.Errorf(`expected array of length %d, have %d elements`, t.Length, len(temp))
 		}
+		if isDynamic(t.Type) {
+			// offset -> elements...
+			frame, e := encodeFrame(repeatType(t.Type, len(temp)), temp, indexPath, options)
+			if e != nil {
+				return nil, nil, e
+			}
+			head = append(head, encodeInt256(big.NewInt(int64(tailOffset+len(tail))))...)
+			return head, append(tail, frame...), nil
+		}
 		for i, arg := range temp {
-			h, t, e := encode(t.Type, arg, tailOffset, head, tail)
+			h, t, e := encode(t.Type, arg, tailOffset, head, tail, options)
 			if e != nil {
 				return nil, nil, fmt.Errorf(`[%d] %s`, i, e)
 			}
@@ -141,16 +181,85 @@ func encode(typ types.Type, arg json.RawMessage, tailOffset int, head, tail []by
 
 	case types.Elementary:
 		id := string(normalizeElementaryTypeName(t))
+		if codec, ok := elementaryCodec(id); ok {
+			word, e := codec.Encode(arg)
+			if e != nil {
+				return nil, nil, e
+			}
+			if len(word) != 32 {
+				return nil, nil, fmt.Errorf(`codec for %s returned %d bytes, expected 32`, id, len(word))
+			}
+			return append(head, word...), tail, nil
+		}
 		if strings.HasPrefix(id, `fixed`) || strings.HasPrefix(id, `ufixed`) {
-			// TODO: support fixed<M>x<N> and ufixed<M>x<N>
-			return nil, nil, fmt.Errorf(`fixed/ufixed types not supported yet`)
+			signed := id[0] == 'f'
+			bits, decimals := fixedBits(id)
+			str := ""
+			// either JSON number or string holding a (possibly negative) decimal number
+			switch peekNonWhitespaceByte(arg) {
+			case '"':
+				temp := ""
+				if e := json.Unmarshal(arg, &temp); e != nil {
+					return nil, nil, fmt.Errorf(`invalid JSON string`)
+				}
+				str = temp
+
+			case '-', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+				temp := json.Number("")
+				if e := json.Unmarshal(arg, &temp); e != nil {
+					return nil, nil, fmt.Errorf(`invalid JSON number`)
+				}
+				str = string(temp)
+
+			default:
+				return nil, nil, fmt.Errorf(`expected JSON string or number`)
+			}
+			val, e := parseFixed(str, decimals)
+			if e != nil {
+				return nil, nil, fmt.Errorf(`invalid number for type %s: %s`, typ, e)
+			}
+			if !signed && val.Sign() < 0 {
+				return nil, nil, fmt.Errorf(`negative value for unsigned type %s: %s`, typ, str)
+			}
+			if !fitsInteger(val, bits, signed) {
+				return nil, nil, fmt.Errorf(`value out of range for type %s: %s`, typ, str)
+			}
+			return append(head, encodeInt256(val)...), tail, nil
+		}
+		if id == `bool` {
+			temp := false
+			if e := json.Unmarshal(arg, &temp); e != nil {
+				return nil, nil, fmt.Errorf(`expected JSON boolean`)
+			}
+			out := make([]byte, 32, 32)
+			if temp {
+				out[31] = 1
+			}
+			return append(head, out...), tail, nil
+		}
+		if id == `function` {
+			temp := functionValue{}
+			if e := json.Unmarshal(arg, &temp); e != nil {
+				return nil, nil, fmt.Errorf(`expected object with keys: address, selector`)
+			}
+			address, ok := decodeHex(temp.Address)
+			if !ok || len(address) != 20 {
+				return nil, nil, fmt.Errorf(`invalid address in function value: %s`, temp.Address)
+			}
+			selector, ok := decodeHex(temp.Selector)
+			if !ok || len(selector) != 4 {
+				return nil, nil, fmt.Errorf(`invalid selector in function value: %s`, temp.Selector)
+			}
+			out := make([]byte, 32, 32)
+			copy(out, address)
+			copy(out[20:], selector)
+			return append(head, out...), tail, nil
 		}
-		// TODO: suspected bug in large integers; differentiate between int and uint
 		if strings.HasPrefix(id, `int`) || strings.HasPrefix(id, `uint`) {
-			bits := 0
+			signed, bits := id[0] == 'i', 0
 			{
 				prefixLength := 3
-				if id[0] == 'u' {
+				if !signed {
 					prefixLength++
 				}
 				n, e := strconv.Atoi(id[prefixLength:])
@@ -162,18 +271,15 @@ func encode(typ types.Type, arg json.RawMessage, tailOffset int, head, tail []by
 				}
 				bits = n
 			}
-			str, base, val := "", 0, big.NewInt(0)
-			// either JSON number or "0x..." string
+			str := ""
+			// either JSON number or string holding a (possibly negative) decimal or "0x..." hex number
 			switch peekNonWhitespaceByte(arg) {
 			case '"':
 				temp := ""
 				if e := json.Unmarshal(arg, &temp); e != nil {
 					return nil, nil, fmt.Errorf(`invalid JSON string`)
 				}
-				if !strings.HasPrefix(temp, `0x`) {
-					return nil, nil, fmt.Errorf(`expected "0x" prefix on %s string.`, typ)
-				}
-				str, base = string(temp), 0
+				str = temp
 
 			case '-', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
 				temp := json.Number("")
@@ -183,16 +289,30 @@ func encode(typ types.Type, arg json.RawMessage, tailOffset int, head, tail []by
 				if strings.ContainsAny(string(temp), `eE.`) {
 					return nil, nil, fmt.Errorf(`unexpected exponent or decimal separator in number: %s`, temp)
 				}
-				str, base = string(temp), 10
+				str = string(temp)
+
+			case 't', 'f':
+				temp := false
+				if !options.LenientBooleans || json.Unmarshal(arg, &temp) != nil {
+					return nil, nil, fmt.Errorf(`expected JSON string or number`)
+				}
+				str = `0`
+				if temp {
+					str = `1`
+				}
 
 			default:
 				return nil, nil, fmt.Errorf(`expected JSON string or number`)
 			}
-			if _, ok := val.SetString(str, base); !ok {
-				return nil, nil, fmt.Errorf(`invalid hex number for type %s: %s`, typ, str)
+			val, ok := parseInteger(str)
+			if !ok {
+				return nil, nil, fmt.Errorf(`invalid number for type %s: %s`, typ, str)
+			}
+			if !signed && val.Sign() < 0 {
+				return nil, nil, fmt.Errorf(`negative value for unsigned type %s: %s`, typ, str)
 			}
-			if bs := val.Bytes(); len(bs) > (bits / 8) {
-				return nil, nil, fmt.Errorf(`value too large for type %s: %s`, typ, str)
+			if !fitsInteger(val, bits, signed) {
+				return nil, nil, fmt.Errorf(`value out of range for type %s: %s`, typ, str)
 			}
 			return append(head, encodeInt256(val)...), tail, nil
 
@@ -220,7 +340,8 @@ func encode(typ types.Type, arg json.RawMessage, tailOffset int, head, tail []by
 			}
 
 			length := big.NewInt(int64(len(bytes)))
-			padded := append(bytes, make([]byte, 32-len(bytes)%32, 32-len(bytes)%32)...)
+			padding := (32 - len(bytes)%32) % 32 // no padding for empty or 32-byte aligned values
+			padded := append(bytes, make([]byte, padding, padding)...)
 			offset := big.NewInt(int64(tailOffset + len(tail)))
 
 			tail = append(tail, encodeInt256(length)...)
@@ -235,7 +356,7 @@ func encode(typ types.Type, arg json.RawMessage, tailOffset int, head, tail []by
 			if e != nil || n < 0 || n > 32 {
 				logger.Panicln(n, e)
 			}
-			// arg is either array of numbers or string
+			// arg is either array of numbers, 0x-hex string, which must be of exactly n bytes, or plain string, left-aligned in the word
 			switch peekNonWhitespaceByte(arg) {
 			case '[':
 				temp := make([]byte, 0, 32)
@@ -245,7 +366,9 @@ func encode(typ types.Type, arg json.RawMessage, tailOffset int, head, tail []by
 				if len(temp) != n {
 					return nil, nil, fmt.Errorf(`expected array of length %d, got %d elements`, n, len(temp))
 				}
-				return append(head, temp[:32]...), tail, nil
+				out := make([]byte, 32, 32)
+				copy(out, temp)
+				return append(head, out...), tail, nil
 
 			case '"':
 				temp := ""
@@ -253,6 +376,12 @@ func encode(typ types.Type, arg json.RawMessage, tailOffset int, head, tail []by
 					return nil, nil, fmt.Errorf(`invalid JSON string`)
 				}
 				bytes := []byte(temp)
+				if decoded, ok := decodeHex(temp); ok {
+					if len(decoded) != n {
+						return nil, nil, fmt.Errorf(`expected %d bytes of hex for %s, got %d`, n, typ, len(decoded))
+					}
+					bytes = decoded
+				}
 				if len(bytes) > n {
 					return nil, nil, fmt.Errorf(`string too long for %s`, typ)
 				}
@@ -269,3 +398,80 @@ func encode(typ types.Type, arg json.RawMessage, tailOffset int, head, tail []by
 	logger.Panicf("unexpected type in abi.Encode: %T\n", typ)
 	return nil, nil, nil // shut up compiler
 }
+
+// encodeFrame encodes args as a new frame whose offsets are relative to its own start.
+// path names the i-th value in error messages.
+func encodeFrame(typs []types.Type, args []json.RawMessage, path func(i int) string, options EncodeOptions) (Code, error) {
+	headWidth := 0
+	for _, typ := range typs {
+		headWidth += width(typ)
+	}
+	head, tail := make([]byte, 0, headWidth), make([]byte, 0, 1024)
+	for i, typ := range typs {
+		h, t, e := encode(typ, args[i], headWidth, head, tail, options)
+		if e != nil {
+			return nil, fmt.Errorf(`%s %s`, path(i), e)
+		}
+		head, tail = h, t
+	}
+	if len(head) != headWidth {
+		logger.Panicln(len(head), headWidth)
+	}
+	return append(head, tail...), nil
+}
+
+func indexPath(i int) string {
+	return `[` + strconv.Itoa(i) + `]`
+}
+
+// parseInteger parses a decimal or "0x"-prefixed hex integer, either of which may be negative.
+func parseInteger(s string) (*big.Int, bool) {
+	digits, negative := s, false
+	if strings.HasPrefix(digits, `-`) {
+		digits, negative = digits[1:], true
+	}
+	base := 10
+	if strings.HasPrefix(digits, `0x`) {
+		digits, base = digits[2:], 16
+	}
+	if digits == "" || strings.HasPrefix(digits, `-`) || strings.HasPrefix(digits, `+`) {
+		return nil, false
+	}
+	val, ok := new(big.Int).SetString(digits, base)
+	if !ok {
+		return nil, false
+	}
+	if negative {
+		val.Neg(val)
+	}
+	return val, true
+}
+
+// parseFixed parses a decimal number like "-1.25" (or "125e-2") into the integer value of a fixed-point
+// number with the given number of decimals, i.e. scaled by 10^decimals. Values with more decimal places are rejected.
+func parseFixed(s string, decimals int) (*big.Int, error) {
+	if s == "" || strings.ContainsAny(s, `/+`) || strings.HasPrefix(s, `0x`) || strings.HasPrefix(s, `-0x`) {
+		return nil, fmt.Errorf(`expected decimal number, have %s`, s)
+	}
+	val, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return nil, fmt.Errorf(`expected decimal number, have %s`, s)
+	}
+	val.Mul(val, new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil)))
+	if !val.IsInt() {
+		return nil, fmt.Errorf(`%s has more than %d decimal places`, s, decimals)
+	}
+	return new(big.Int).Set(val.Num()), nil
+}
+
+// fitsInteger reports whether val is representable as a (u)int<bits>.
+func fitsInteger(val *big.Int, bits int, signed bool) bool {
+	if !signed {
+		return val.Sign() >= 0 && val.BitLen() <= bits
+	}
+	if val.Sign() >= 0 {
+		return val.BitLen() < bits
+	}
+	// -2^(bits-1) is the smallest representable value
+	return new(big.Int).Add(val, big.NewInt(1)).BitLen() < bits
+}
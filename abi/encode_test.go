@@ -0,0 +1,113 @@
+// Copyright 2018 karma.run AG. All rights reserved.
+
+package abi
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/karmarun/karma.link/types"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// vector is a golden ABI round-trip vector from testdata/vectors.json: a single function argument and its
+// encoding as a one-element argument list, like in calldata (without selector). The encodings have been
+// verified to round-trip through go-ethereum's accounts/abi.
+type vector struct {
+	Name       string          `json:"name"`
+	Type       string          `json:"type"`
+	Components []component     `json:"components"` // members of tuple types, like in ABI JSON
+	Value      json.RawMessage `json:"value"`      // as rendered by Decode
+	Input      json.RawMessage `json:"input"`      // encoded instead of Value if set, for values Encode doesn't accept in their decoded form
+	Hex        []string        `json:"hex"`        // 32-byte words
+}
+
+type component struct {
+	Name       string      `json:"name"`
+	Type       string      `json:"type"`
+	Components []component `json:"components"`
+}
+
+// vectorType returns the type of an ABI JSON parameter. Tuples with components are structs, like they are
+// extracted from ABI JSON. Other types are parsed by types.ParseType, which reads "(...)" as types.Tuple.
+func vectorType(typ string, components []component) (types.Type, error) {
+	if !strings.HasPrefix(typ, `tuple`) {
+		return types.ParseType(typ)
+	}
+	strct := types.Struct{Keys: make([]string, len(components)), Types: make([]types.Type, len(components))}
+	for i, c := range components {
+		member, e := vectorType(c.Type, c.Components)
+		if e != nil {
+			return nil, e
+		}
+		strct.Keys[i], strct.Types[i] = c.Name, member
+	}
+	out, suffixes := types.Type(strct), strings.TrimPrefix(typ, `tuple`)
+	for suffixes != "" {
+		end := strings.IndexByte(suffixes, ']')
+		if !strings.HasPrefix(suffixes, `[`) || end == -1 {
+			return nil, fmt.Errorf(`invalid tuple type %s`, typ)
+		}
+		length := types.DynamicArrayLength
+		if n := suffixes[1:end]; n != "" {
+			l, e := strconv.Atoi(n)
+			if e != nil {
+				return nil, fmt.Errorf(`invalid tuple type %s`, typ)
+			}
+			length = l
+		}
+		out, suffixes = types.Array{Length: length, Type: out}, suffixes[end+1:]
+	}
+	return out, nil
+}
+
+func TestVectors(t *testing.T) {
+	bs, e := ioutil.ReadFile(`testdata/vectors.json`)
+	if e != nil {
+		t.Fatal(e)
+	}
+	vectors := []vector{}
+	if e := json.Unmarshal(bs, &vectors); e != nil {
+		t.Fatal(e)
+	}
+	if len(vectors) < 30 {
+		t.Fatalf(`expected at least 30 vectors, have %d`, len(vectors))
+	}
+
+	// NOTE: nested types.Tuple values are laid out inline, which is only correct for static tuples,
+	// so dynamic tuples are covered by structs
+	for _, v := range vectors {
+		t.Run(v.Name, func(t *testing.T) {
+			typ, e := vectorType(v.Type, v.Components)
+			if e != nil {
+				t.Fatal(e)
+			}
+			want, e := hex.DecodeString(strings.Join(v.Hex, ``))
+			if e != nil {
+				t.Fatal(e)
+			}
+			input := v.Value
+			if v.Input != nil {
+				input = v.Input
+			}
+			code, e := Encode(types.Tuple{typ}, json.RawMessage(`[`+string(input)+`]`))
+			if e != nil {
+				t.Fatalf(`encoding failed: %s`, e)
+			}
+			if !bytes.Equal(code, want) {
+				t.Fatalf("encoded\n%x\nwant\n%x", []byte(code), want)
+			}
+			decoded, e := Decode(types.Tuple{typ}, want)
+			if e != nil {
+				t.Fatalf(`decoding failed: %s`, e)
+			}
+			if expected := `[` + string(v.Value) + `]`; !jsonEqual(decoded, []byte(expected)) {
+				t.Fatalf(`decoded %s, want %s`, decoded, expected)
+			}
+		})
+	}
+}
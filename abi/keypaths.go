@@ -0,0 +1,204 @@
+// Copyright 2018 karma.run AG. All rights reserved.
+
+package abi // import "github.com/karmarun/karma.link/abi"
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/karmarun/karma.link/types"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// AssembleKeyPaths assembles the JSON argument list expected by Encode(types.Tuple(typs), ...)
+// from flat key paths as used in HTTP forms and query strings, e.g.
+//
+//	owner=0x...  amounts[0]=1  amounts[1]=2  meta.name=foo
+//
+// A path starts with a parameter name from names, or the parameter's index for unnamed parameters.
+// It continues with [index] for array elements and .key for struct members. Values are passed to Encode as JSON strings,
+// except for bools, which must be "true" or "false".
+// An empty value without sub-paths denotes an empty dynamic array.
+func AssembleKeyPaths(names []string, typs []types.Type, values map[string]string) (json.RawMessage, error) {
+	if len(names) != len(typs) {
+		return nil, fmt.Errorf(`expected %d names, have %d`, len(typs), len(names))
+	}
+	root := &keyPathNode{}
+	for path, value := range values {
+		segments, e := parseKeyPath(path)
+		if e != nil {
+			return nil, e
+		}
+		if e := root.insert(segments, value); e != nil {
+			return nil, fmt.Errorf(`%s: %s`, path, e)
+		}
+	}
+	args := make([]json.RawMessage, len(typs), len(typs))
+	for i, typ := range typs {
+		name := names[i]
+		if name == "" {
+			name = strconv.Itoa(i)
+		}
+		node, ok := root.children[name]
+		if !ok {
+			return nil, fmt.Errorf(`missing argument: %s`, name)
+		}
+		delete(root.children, name)
+		arg, e := node.assemble(typ, name)
+		if e != nil {
+			return nil, e
+		}
+		args[i] = arg
+	}
+	for name := range root.children {
+		return nil, fmt.Errorf(`unexpected argument: %s`, name)
+	}
+	bs, _ := json.Marshal(args)
+	return bs, nil
+}
+
+type keyPathNode struct {
+	value    *string
+	children map[string]*keyPathNode // array indices in decimal or struct keys
+}
+
+func (n *keyPathNode) insert(segments []string, value string) error {
+	if len(segments) == 0 {
+		if n.value != nil || len(n.children) > 0 {
+			return fmt.Errorf(`conflicting paths`)
+		}
+		n.value = &value
+		return nil
+	}
+	if n.value != nil {
+		return fmt.Errorf(`conflicting paths`)
+	}
+	if n.children == nil {
+		n.children = make(map[string]*keyPathNode, 4)
+	}
+	child, ok := n.children[segments[0]]
+	if !ok {
+		child = &keyPathNode{}
+		n.children[segments[0]] = child
+	}
+	return child.insert(segments[1:], value)
+}
+
+// assemble builds the JSON value of type typ from n. path locates n in error messages.
+func (n *keyPathNode) assemble(typ types.Type, path string) (json.RawMessage, error) {
+	switch t := typ.(type) {
+
+	case types.Named:
+		return n.assemble(t.Type, path)
+
+	case types.Struct:
+		if n.value != nil {
+			return nil, fmt.Errorf(`%s: expected struct members, have value`, path)
+		}
+		out := make(map[string]json.RawMessage, len(t.Keys))
+		for i, key := range t.Keys {
+			child, ok := n.children[key]
+			if !ok {
+				return nil, fmt.Errorf(`%s: missing struct member: %s`, path, key)
+			}
+			member, e := child.assemble(t.Types[i], path+`.`+key)
+			if e != nil {
+				return nil, e
+			}
+			out[key] = member
+		}
+		if len(out) != len(n.children) {
+			return nil, fmt.Errorf(`%s: unexpected struct members, expected: %s`, path, strings.Join(t.Keys, `, `))
+		}
+		bs, _ := json.Marshal(out)
+		return bs, nil
+
+	case types.Array:
+		if n.value != nil {
+			if *n.value == "" && t.IsDynamic() {
+				return json.RawMessage(`[]`), nil
+			}
+			return nil, fmt.Errorf(`%s: expected array elements, have value`, path)
+		}
+		indices := make([]int, 0, len(n.children))
+		for key := range n.children {
+			index, e := strconv.Atoi(key)
+			if e != nil {
+				return nil, fmt.Errorf(`%s: invalid array index: %s`, path, key)
+			}
+			indices = append(indices, index)
+		}
+		sort.Ints(indices)
+		for i, index := range indices {
+			if i != index {
+				return nil, fmt.Errorf(`%s: missing array element: %d`, path, i)
+			}
+		}
+		if !t.IsDynamic() && len(indices) != t.Length {
+			return nil, fmt.Errorf(`%s: expected %d array elements, have %d`, path, t.Length, len(indices))
+		}
+		out := make([]json.RawMessage, len(indices), len(indices))
+		for i := range out {
+			element, e := n.children[strconv.Itoa(i)].assemble(t.Type, path+indexPath(i))
+			if e != nil {
+				return nil, e
+			}
+			out[i] = element
+		}
+		bs, _ := json.Marshal(out)
+		return bs, nil
+
+	}
+	if n.value == nil {
+		return nil, fmt.Errorf(`%s: expected value of type %s, have sub-paths`, path, typ.SoliditySignature())
+	}
+	if typ == types.Elementary(`bool`) { // Encode takes JSON booleans only
+		switch *n.value {
+		case `true`, `false`:
+			return json.RawMessage(*n.value), nil
+		}
+		return nil, fmt.Errorf(`%s: expected true or false, have %s`, path, *n.value)
+	}
+	bs, _ := json.Marshal(*n.value)
+	return bs, nil
+}
+
+// parseKeyPath splits a path like "a[0].b" into "a", "0", "b".
+func parseKeyPath(path string) ([]string, error) {
+	segments, rest := make([]string, 0, 4), path
+	i := strings.IndexAny(rest, `[.`)
+	if i == -1 {
+		i = len(rest)
+	}
+	if i == 0 {
+		return nil, fmt.Errorf(`invalid key path: %s`, path)
+	}
+	segments, rest = append(segments, rest[:i]), rest[i:]
+	for rest != "" {
+		switch rest[0] {
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end == -1 {
+				return nil, fmt.Errorf(`invalid key path, missing ]: %s`, path)
+			}
+			index, e := strconv.ParseUint(rest[1:end], 10, 31)
+			if e != nil {
+				return nil, fmt.Errorf(`invalid array index in key path: %s`, path)
+			}
+			segments, rest = append(segments, strconv.FormatUint(index, 10)), rest[end+1:]
+		case '.':
+			end := strings.IndexAny(rest[1:], `[.`) + 1
+			if end == 0 {
+				end = len(rest)
+			}
+			if end == 1 {
+				return nil, fmt.Errorf(`invalid key path, empty key: %s`, path)
+			}
+			segments, rest = append(segments, rest[1:end]), rest[end:]
+		default:
+			return nil, fmt.Errorf(`invalid key path: %s`, path)
+		}
+	}
+	return segments, nil
+}
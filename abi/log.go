@@ -0,0 +1,79 @@
+// Copyright 2018 karma.run AG. All rights reserved.
+
+package abi // import "github.com/karmarun/karma.link/abi"
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/karmarun/karma.link/types"
+)
+
+// DecodeLog translates the topics and data of an event log into a JSON array of the event's arguments.
+// indexed[i] tells whether args[i] was stored in a topic. topics holds those topics in order,
+// without the event signature topic of non-anonymous events.
+// Indexed arguments of value type are stored in their topic like in ABI encoding (addresses in the low 20 bytes,
+// bytesN in the high N bytes) and are decoded as usual. Indexed arguments of reference type (strings, bytes,
+// arrays and structs) are stored as their keccak256 hash and are returned as such, hex-encoded.
+func DecodeLog(args []types.Type, indexed []bool, topics [][]byte, data Code) (json.RawMessage, error) {
+
+	if len(indexed) != len(args) {
+		return nil, fmt.Errorf(`expected %d indexed flags, have %d`, len(args), len(indexed))
+	}
+
+	unindexed := make([]types.Type, 0, len(args))
+	for i, typ := range args {
+		if !indexed[i] {
+			unindexed = append(unindexed, typ)
+		}
+	}
+
+	// NOTE: checked before decoding data, which may be too short for a differently indexed event
+	if n := len(args) - len(unindexed); len(topics) != n {
+		return nil, fmt.Errorf(`expected %d topics, have %d`, n, len(topics))
+	}
+
+	values, e := DecodeAll(unindexed, data)
+	if e != nil {
+		return nil, fmt.Errorf(`log data: %s`, e)
+	}
+
+	out := make([]json.RawMessage, 0, len(args))
+	for i, typ := range args {
+		if !indexed[i] {
+			out, values = append(out, values[0]), values[1:]
+			continue
+		}
+		if len(topics) == 0 {
+			return nil, fmt.Errorf(`missing topic for indexed argument %d`, i)
+		}
+		topic := topics[0]
+		topics = topics[1:]
+		if len(topic) != 32 {
+			return nil, fmt.Errorf(`invalid topic length for indexed argument %d: %d`, i, len(topic))
+		}
+		if isHashedInTopic(typ) {
+			bs, _ := json.Marshal(`0x` + hex.EncodeToString(topic))
+			out = append(out, bs)
+			continue
+		}
+		value, _, e := decode(typ, topic, 0, DecodeOptions{})
+		if e != nil {
+			return nil, fmt.Errorf(`[%d] %s`, i, e)
+		}
+		out = append(out, value)
+	}
+
+	bs, _ := json.Marshal(out)
+	return bs, nil
+}
+
+func isHashedInTopic(typ types.Type) bool {
+	switch t := typ.(type) {
+	case types.Named:
+		return isHashedInTopic(t.Type)
+	case types.Struct, types.Array, types.Tuple:
+		return true
+	}
+	return isDynamic(typ)
+}
@@ -0,0 +1,256 @@
+// Copyright 2018 karma.run AG. All rights reserved.
+
+package abi // import "github.com/karmarun/karma.link/abi"
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/karmarun/karma.link/types"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// EncodePacked produces the non-standard packed encoding of abi.encodePacked, the inverse of DecodePacked.
+// Static values take only their own width (1 byte for uint8 and bool, N bytes for bytesN, 20 for addresses),
+// bytes and strings are their raw contents without length and arrays are their elements padded to 32 bytes.
+// If typ is a Tuple, arg holds a value for each of its members, which are concatenated. Values are accepted like by Encode.
+// Structs, nested tuples and nested arrays have no packed encoding.
+func EncodePacked(typ types.Type, arg json.RawMessage) (Code, error) {
+	tuple, ok := typ.(types.Tuple)
+	if !ok {
+		return encodePacked(typ, arg)
+	}
+	temp := make([]json.RawMessage, 0, len(tuple))
+	if e := json.Unmarshal(arg, &temp); e != nil {
+		return nil, fmt.Errorf(`expected array of %d elements`, len(tuple))
+	}
+	if len(temp) != len(tuple) {
+		return nil, fmt.Errorf(`expected array of %d elements, have %d`, len(tuple), len(temp))
+	}
+	out := make(Code, 0, 32*len(tuple))
+	for i, typ := range tuple {
+		code, e := encodePacked(typ, temp[i])
+		if e != nil {
+			return nil, fmt.Errorf(`[%d] %s`, i, e)
+		}
+		out = append(out, code...)
+	}
+	return out, nil
+}
+
+// encodePacked encodes a single packed value. It's cut out of the value's standard encoding,
+// which holds static values in head and the length and contents of dynamic ones in tail.
+func encodePacked(typ types.Type, arg json.RawMessage) (Code, error) {
+	switch t := typ.(type) {
+
+	case types.Named:
+		return encodePacked(t.Type, arg)
+
+	case types.Struct, types.Tuple:
+		return nil, fmt.Errorf(`no packed encoding for %s`, typ.SoliditySignature())
+
+	case types.Array:
+		if _, ok := packedWidth(t.Type); !ok || isArray(t.Type) {
+			return nil, fmt.Errorf(`no packed encoding for arrays of %s`, t.Type.SoliditySignature())
+		}
+		head, tail, e := encode(t, arg, width(t), nil, nil, EncodeOptions{})
+		if e != nil {
+			return nil, e
+		}
+		if t.IsDynamic() {
+			return tail[32:], nil // NOTE: elements are static, i.e. inline after the length
+		}
+		return head, nil
+
+	case types.Elementary:
+		if normalizeElementaryTypeName(t) == `bytes` {
+			_, tail, e := encode(t, arg, width(t), nil, nil, EncodeOptions{})
+			if e != nil {
+				return nil, e
+			}
+			n, e := readLength(tail, 1)
+			if e != nil {
+				logger.Panicln(e)
+			}
+			return tail[32 : 32+n], nil
+		}
+
+	}
+
+	n, ok := packedWidth(typ)
+	if !ok {
+		return nil, fmt.Errorf(`no packed encoding for %s`, typ.SoliditySignature())
+	}
+	head, _, e := encode(typ, arg, width(typ), nil, nil, EncodeOptions{})
+	if e != nil {
+		return nil, e
+	}
+	if isRightAligned(typ) {
+		return head[:n], nil
+	}
+	return head[32-n:], nil
+}
+
+// DecodePacked decodes the non-standard packed encoding produced by abi.encodePacked, using typs as the expected sequence.
+// Packed values carry no offsets or lengths, so only the last of typs may be dynamic (bytes, string or a dynamic array);
+// it consumes the rest of code. Elements of arrays are padded to 32 bytes, as in abi.encodePacked.
+// Structs and nested arrays have no packed encoding.
+func DecodePacked(typs []types.Type, code Code) ([]json.RawMessage, error) {
+	out := make([]json.RawMessage, len(typs), len(typs))
+	for i, typ := range typs {
+		n, ok := packedWidth(typ)
+		if !ok {
+			if i != len(typs)-1 {
+				return nil, fmt.Errorf(`[%d] ambiguous packed layout: dynamic value must be last`, i)
+			}
+			n = len(code)
+		}
+		if n > len(code) {
+			return nil, fmt.Errorf(`[%d] expected %d bytes, have %d`, i, n, len(code))
+		}
+		val, e := decodePacked(typ, code[:n])
+		if e != nil {
+			return nil, fmt.Errorf(`[%d] %s`, i, e)
+		}
+		out[i], code = val, code[n:]
+	}
+	if len(code) > 0 {
+		return nil, fmt.Errorf(`%d leftover bytes after decoding %d values`, len(code), len(typs))
+	}
+	return out, nil
+}
+
+// decodePacked decodes a single packed value taking all of code.
+func decodePacked(typ types.Type, code Code) (json.RawMessage, error) {
+	switch t := typ.(type) {
+
+	case types.Named:
+		return decodePacked(t.Type, code)
+
+	case types.Array:
+		if _, ok := packedWidth(t.Type); !ok || isArray(t.Type) {
+			return nil, fmt.Errorf(`no packed encoding for arrays of %s`, t.Type.SoliditySignature())
+		}
+		if len(code)%32 != 0 {
+			return nil, fmt.Errorf(`packed array length must be a multiple of 32, have %d`, len(code))
+		}
+		out := make([]json.RawMessage, 0, len(code)/32)
+		for ; len(code) > 0; code = code[32:] {
+			val, _, e := decode(t.Type, code[:32], 0, DecodeOptions{})
+			if e != nil {
+				return nil, e
+			}
+			out = append(out, val)
+		}
+		bs, _ := json.Marshal(out)
+		return bs, nil
+
+	case types.Elementary:
+		if normalizeElementaryTypeName(t) == `bytes` {
+			if utf8.Valid(code) {
+				val, _ := json.Marshal(string(code))
+				return val, nil
+			}
+			val, _ := json.Marshal([]byte(code))
+			return val, nil
+		}
+
+	}
+
+	// static values are decoded by padding them to a full word
+	padded := make(Code, 32, 32)
+	if isRightAligned(typ) {
+		copy(padded, code)
+	} else {
+		if isSigned(typ) && len(code) > 0 && code[0]&0x80 != 0 {
+			for i := range padded {
+				padded[i] = 0xff
+			}
+		}
+		copy(padded[32-len(code):], code)
+	}
+	val, _, e := decode(typ, padded, 0, DecodeOptions{})
+	return val, e
+}
+
+// packedWidth returns the number of bytes typ takes in packed encoding, or false if it's dynamic or unsupported.
+func packedWidth(typ types.Type) (int, bool) {
+	switch t := typ.(type) {
+
+	case types.Named:
+		return packedWidth(t.Type)
+
+	case types.ContractAddress, types.InterfaceAddress, types.LibraryAddress:
+		return 20, true
+
+	case types.Enum:
+		return 1, true
+
+	case types.Array:
+		if t.IsDynamic() || isArray(t.Type) {
+			return 0, false
+		}
+		if _, ok := packedWidth(t.Type); !ok {
+			return 0, false
+		}
+		return 32 * t.Length, true
+
+	case types.Elementary:
+		id := string(t)
+		switch {
+		case id == `address` || strings.HasPrefix(id, `address `):
+			return 20, true
+		case id == `bool`:
+			return 1, true
+		case id == `function`:
+			return 24, true
+		}
+		id = string(normalizeElementaryTypeName(t))
+		for _, prefix := range []string{`uint`, `int`, `bytes`} {
+			if !strings.HasPrefix(id, prefix) || id == `bytes` {
+				continue
+			}
+			n, e := strconv.Atoi(id[len(prefix):])
+			if e != nil {
+				return 0, false
+			}
+			if prefix == `bytes` {
+				return n, true
+			}
+			return n / 8, true
+		}
+
+	}
+	return 0, false
+}
+
+func isArray(typ types.Type) bool {
+	if named, ok := typ.(types.Named); ok {
+		return isArray(named.Type)
+	}
+	_, ok := typ.(types.Array)
+	return ok
+}
+
+// isRightAligned reports whether values of typ are padded on the right (higher-order alignment) in the ABI.
+func isRightAligned(typ types.Type) bool {
+	switch t := typ.(type) {
+	case types.Named:
+		return isRightAligned(t.Type)
+	case types.Elementary:
+		id := string(normalizeElementaryTypeName(t))
+		return id == `function` || (strings.HasPrefix(id, `bytes`) && id != `bytes`)
+	}
+	return false
+}
+
+func isSigned(typ types.Type) bool {
+	switch t := typ.(type) {
+	case types.Named:
+		return isSigned(t.Type)
+	case types.Elementary:
+		return strings.HasPrefix(string(normalizeElementaryTypeName(t)), `int`)
+	}
+	return false
+}
@@ -0,0 +1,37 @@
+// Copyright 2018 karma.run AG. All rights reserved.
+
+package abi // import "github.com/karmarun/karma.link/abi"
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ethereum/go-ethereum/crypto/sha3"
+	"github.com/karmarun/karma.link/types"
+	"math/big"
+)
+
+// MappingSlot returns the storage slot of the value at key in a mapping located at baseSlot, as computed by Solidity:
+// keccak256(k ++ baseSlot), where k is the ABI encoding of key for value types and the plain bytes of key for
+// bytes and string. The slot of nested mappings is found by passing the result, as big.Int, for the next key.
+func MappingSlot(baseSlot *big.Int, keyType types.Type, key json.RawMessage) ([32]byte, error) {
+	slot := [32]byte{}
+	if baseSlot.Sign() < 0 || baseSlot.BitLen() > 256 {
+		return slot, fmt.Errorf(`invalid base slot %s`, baseSlot)
+	}
+	code, e := Encode(keyType, key)
+	if e != nil {
+		return slot, e
+	}
+	if isDynamic(keyType) { // bytes and string, the only dynamic key types: unpadded content without length
+		lng, e := readLength(code[32:], 1)
+		if e != nil {
+			return slot, e
+		}
+		code = code[64 : 64+lng]
+	}
+	hash := sha3.NewKeccak256()
+	hash.Write(code)
+	hash.Write(encodeInt256(baseSlot))
+	copy(slot[:], hash.Sum(nil))
+	return slot, nil
+}
@@ -0,0 +1,80 @@
+// Copyright 2018 karma.run AG. All rights reserved.
+
+package abi // import "github.com/karmarun/karma.link/abi"
+
+import (
+	"encoding/json"
+	"github.com/karmarun/karma.link/types"
+	"strconv"
+	"strings"
+)
+
+// ZeroValue returns a JSON value of typ accepted by Encode, holding the type's zero value: 0 for numbers, false for bools,
+// "" for strings and bytes, the zero address, zeroed bytes<N> and fixed-length arrays, empty dynamic arrays,
+// the first member of enums and objects with zeroed members for structs. Tuples yield arrays of their zero values.
+// It's meant for scaffolding arguments, e.g. in forms.
+func ZeroValue(typ types.Type) json.RawMessage {
+	switch t := typ.(type) {
+
+	case types.Named:
+		return ZeroValue(t.Type)
+
+	case types.ContractAddress, types.InterfaceAddress, types.LibraryAddress:
+		return ZeroValue(addressType)
+
+	case types.Enum:
+		bs, _ := json.Marshal(t[0])
+		return bs
+
+	case types.Tuple:
+		out := make([]json.RawMessage, len(t), len(t))
+		for i, typ := range t {
+			out[i] = ZeroValue(typ)
+		}
+		bs, _ := json.Marshal(out)
+		return bs
+
+	case types.Struct:
+		out := make(map[string]json.RawMessage, len(t.Keys))
+		for i, key := range t.Keys {
+			out[key] = ZeroValue(t.Types[i])
+		}
+		bs, _ := json.Marshal(out)
+		return bs
+
+	case types.Array:
+		if t.IsDynamic() {
+			return json.RawMessage(`[]`)
+		}
+		return ZeroValue(repeatType(t.Type, t.Length))
+
+	case types.Elementary:
+		id := string(t)
+		switch {
+		case t == addressType || strings.HasPrefix(id, `address `):
+			return json.RawMessage(`"0x0000000000000000000000000000000000000000"`)
+		case id == `bool`:
+			return json.RawMessage(`false`)
+		case id == `string` || id == `bytes`:
+			return json.RawMessage(`""`)
+		case id == `function`:
+			bs, _ := json.Marshal(functionValue{
+				Address:  `0x0000000000000000000000000000000000000000`,
+				Selector: `0x00000000`,
+			})
+			return bs
+		}
+		if id = string(normalizeElementaryTypeName(t)); strings.HasPrefix(id, `bytes`) { // bytes1, bytes2, ... bytes32
+			n, e := strconv.Atoi(id[len(`bytes`):])
+			if e != nil || n < 0 || n > 32 {
+				logger.Panicln(n, e)
+			}
+			bs, _ := json.Marshal(`0x` + strings.Repeat(`00`, n))
+			return bs
+		}
+		return json.RawMessage(`0`) // (u)int<M>, (u)fixed<M>x<N>
+
+	}
+	logger.Panicf("unexpected type in abi.ZeroValue: %#v\n", typ)
+	return nil // shut up compiler
+}
@@ -4,6 +4,7 @@ package ast // import "github.com/karmarun/karma.link/ast"
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 )
 
@@ -33,6 +34,7 @@ const (
 	StateMutabilityPure       StateMutability = "pure"
 	StateMutabilityView                       = "view"
 	StateMutabilityNonpayable                 = "nonpayable"
+	StateMutabilityPayable                    = "payable"
 )
 
 // StorageLocation represents a Solidity variables's storage location.
@@ -46,7 +48,10 @@ const (
 
 // CompiledContract represents a compiled Solidity contract's binary payload in hex.
 type CompiledContract struct {
-	Binary string `json:"bin"`
+	Binary        string          `json:"bin"`
+	RuntimeBinary string          `json:"bin-runtime"`
+	UserDoc       json.RawMessage `json:"userdoc"` // JSON object, or a string holding it in older solc versions
+	DevDoc        json.RawMessage `json:"devdoc"`  // idem
 }
 
 // Combined is the top-most node in a Solidity AST.
@@ -122,6 +127,7 @@ type VariableDeclaration struct {
 	header          Header
 	children        []Node
 	Constant        bool       `json:"constant"`
+	Indexed         bool       `json:"indexed"` // event parameters only
 	Name            string     `json:"name"`
 	Scope           int        `json:"scope"`
 	StateVariable   bool       `json:"stateVariable"`
@@ -170,6 +176,15 @@ type FunctionDefinition struct {
 	// SuperFunction   json.RawMessage `json:"superFunction"` //": null,
 }
 
+// FunctionTypeName represents a function type (e.g. "function (uint256) external returns (bool)") in a Solidity AST.
+type FunctionTypeName struct {
+	header          Header
+	children        []Node
+	StateMutability StateMutability `json:"stateMutability"`
+	Type            string          `json:"type"`
+	Visibility      Visibility      `json:"visibility"`
+}
+
 // UserDefinedTypeName represents a user defined type name (e.g. enums, structs) in a Solidity AST.
 type UserDefinedTypeName struct {
 	header                Header
@@ -250,8 +265,8 @@ type Literal struct {
 	IsPure          bool   `json:"isPure"`
 	LValueRequested bool   `json:"lValueRequested"`
 	Type            string `json:"type"`
+	Subdenomination string `json:"subdenomination"` // e.g. "ether", empty if none
 	// Token           json.RawMessage `json:"token"`
-	// Subdenomination json.RawMessage `json:"subdenomination"`
 	// ArgumentTypes   json.RawMessage `json:"argumentTypes"`
 }
 
@@ -282,6 +297,7 @@ type EventDefinition struct {
 	children      []Node
 	CanonicalName string `json:"canonicalName"` // NOTE: not present in json file, added in post.
 	Name          string `json:"name"`
+	Anonymous     bool   `json:"anonymous"`
 }
 
 func (n SourceUnit) Header() Header           { return n.header }
@@ -293,6 +309,7 @@ func (n ElementaryTypeName) Header() Header   { return n.header }
 func (n ModifierDefinition) Header() Header   { return n.header }
 func (n ParameterList) Header() Header        { return n.header }
 func (n FunctionDefinition) Header() Header   { return n.header }
+func (n FunctionTypeName) Header() Header     { return n.header }
 func (n UserDefinedTypeName) Header() Header  { return n.header }
 func (n ModifierInvocation) Header() Header   { return n.header }
 func (n Identifier) Header() Header           { return n.header }
@@ -317,6 +334,7 @@ func (n ElementaryTypeName) Children() []Node   { return nil }
 func (n ModifierDefinition) Children() []Node   { return n.children }
 func (n ParameterList) Children() []Node        { return n.children }
 func (n FunctionDefinition) Children() []Node   { return n.children }
+func (n FunctionTypeName) Children() []Node     { return n.children }
 func (n UserDefinedTypeName) Children() []Node  { return n.children }
 func (n ModifierInvocation) Children() []Node   { return n.children }
 func (n Identifier) Children() []Node           { return n.children }
@@ -349,11 +367,23 @@ func PostTraverse(root Node, f func(Node)) {
 }
 
 // UnserializeJSON parses a raw JSON AST representation into a Node tree.
+// Both the legacy AST format and the compact one (with "nodeType"), which solc emits since 0.8, are accepted.
 func UnserializeJSON(raw json.RawMessage) (Node, error) {
-	header := Header{}
-	if e := json.Unmarshal(raw, &header); e != nil {
+	decoded := struct {
+		Header
+		NodeType string `json:"nodeType"`
+	}{}
+	if e := json.Unmarshal(raw, &decoded); e != nil {
 		return nil, e
 	}
+	if decoded.NodeType != "" {
+		legacy, e := fromCompact(raw)
+		if e != nil {
+			return nil, fmt.Errorf(`invalid compact AST: %s`, e)
+		}
+		return UnserializeJSON(legacy)
+	}
+	header := decoded.Header
 	switch header.Name {
 	case "SourceUnit":
 		sourceUnit := SourceUnit{header: header}
@@ -472,7 +502,7 @@ func UnserializeJSON(raw json.RawMessage) (Node, error) {
 		return parameterList, nil
 
 	case "FunctionDefinition":
-		functionDefinition := FunctionDefinition{}
+		functionDefinition := FunctionDefinition{header: header}
 		if e := json.Unmarshal(header.Attributes, &functionDefinition); e != nil {
 			return nil, e
 		}
@@ -485,6 +515,20 @@ func UnserializeJSON(raw json.RawMessage) (Node, error) {
 		}
 		return functionDefinition, nil
 
+	case "FunctionTypeName":
+		functionTypeName := FunctionTypeName{header: header}
+		if e := json.Unmarshal(header.Attributes, &functionTypeName); e != nil {
+			return nil, e
+		}
+		for _, child := range header.Children {
+			u, e := UnserializeJSON(child)
+			if e != nil {
+				return nil, e
+			}
+			functionTypeName.children = append(functionTypeName.children, u)
+		}
+		return functionTypeName, nil
+
 	case "ModifierInvocation":
 		modifierInvocation := ModifierInvocation{header: header}
 		if e := json.Unmarshal(header.Attributes, &modifierInvocation); e != nil {
@@ -0,0 +1,67 @@
+// Copyright 2018 karma.run AG. All rights reserved.
+
+package ast // import "github.com/karmarun/karma.link/ast"
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// DecodeCombined reads a solc combined.json from r. It yields the same result as json.Unmarshal into Combined,
+// but streams the input, decoding the sources one at a time, so that the file is never held in memory as a whole.
+func DecodeCombined(r io.Reader) (Combined, error) {
+	decoder := json.NewDecoder(r)
+	combined := Combined{}
+	e := decodeObject(decoder, func(key string) error {
+		switch key {
+		case `contracts`:
+			return decoder.Decode(&combined.Contracts)
+		case `sourceList`:
+			return decoder.Decode(&combined.SourceList)
+		case `version`:
+			return decoder.Decode(&combined.Version)
+		case `sources`:
+			combined.Sources = make(map[string]CombinedSource, 64)
+			return decodeObject(decoder, func(path string) error {
+				source := CombinedSource{}
+				if e := decoder.Decode(&source); e != nil {
+					return fmt.Errorf(`%s: %s`, path, e)
+				}
+				combined.Sources[path] = source
+				return nil
+			})
+		}
+		return decoder.Decode(new(json.RawMessage)) // skip unknown keys
+	})
+	if e != nil {
+		return Combined{}, fmt.Errorf(`invalid combined.json: %s`, e)
+	}
+	return combined, nil
+}
+
+// decodeObject reads a JSON object from decoder, calling member for each key to decode the associated value.
+// Like json.Unmarshal, it accepts null in place of the object.
+func decodeObject(decoder *json.Decoder, member func(key string) error) error {
+	token, e := decoder.Token()
+	if e != nil {
+		return e
+	}
+	if token == nil {
+		return nil
+	}
+	if token != json.Delim('{') {
+		return fmt.Errorf(`expected object, got %v`, token)
+	}
+	for decoder.More() {
+		token, e := decoder.Token()
+		if e != nil {
+			return e
+		}
+		if e := member(token.(string)); e != nil {
+			return e
+		}
+	}
+	_, e = decoder.Token() // }
+	return e
+}
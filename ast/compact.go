@@ -0,0 +1,216 @@
+// Copyright 2018 karma.run AG. All rights reserved.
+
+package ast // import "github.com/karmarun/karma.link/ast"
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// compactChildren lists the members of compact AST nodes holding the nodes that are children in the legacy AST,
+// in the legacy order. Other members holding nodes, e.g. the statements of a Block, are dropped.
+var compactChildren = map[string][]string{
+	`SourceUnit`:           {`nodes`},
+	`ContractDefinition`:   {`baseContracts`, `nodes`},
+	`InheritanceSpecifier`: {`baseName`, `arguments`},
+	`UsingForDirective`:    {`libraryName`, `typeName`},
+	`StructDefinition`:     {`members`},
+	`EnumDefinition`:       {`members`},
+	`EventDefinition`:      {`parameters`},
+	`ModifierDefinition`:   {`parameters`, `body`},
+	`ModifierInvocation`:   {`modifierName`, `arguments`},
+	`FunctionDefinition`:   {`parameters`, `returnParameters`, `modifiers`, `body`},
+	`ParameterList`:        {`parameters`},
+	`VariableDeclaration`:  {`typeName`, `value`},
+	`FunctionTypeName`:     {`parameterTypes`, `returnParameterTypes`},
+	`Mapping`:              {`keyType`, `valueType`},
+	`ArrayTypeName`:        {`baseType`, `length`},
+}
+
+// compactPaths maps members holding an IdentifierPath, which solc 0.8 uses for names of declarations,
+// to the legacy node taking its place.
+var compactPaths = map[string]string{
+	`InheritanceSpecifier.baseName`:   `UserDefinedTypeName`,
+	`UsingForDirective.libraryName`:   `UserDefinedTypeName`,
+	`ModifierInvocation.modifierName`: `Identifier`,
+}
+
+// legacyNode is a node in the legacy AST format, see Header.
+type legacyNode struct {
+	Id         int                        `json:"id"`
+	Name       string                     `json:"name"`
+	Source     string                     `json:"src"`
+	Attributes map[string]json.RawMessage `json:"attributes"`
+	Children   []legacyNode               `json:"children"`
+}
+
+// compactNode is a node in the compact AST format, members other than the header included.
+type compactNode map[string]json.RawMessage
+
+// fromCompact converts a compact AST, which solc emits since 0.8 (and optionally before), to the legacy format.
+func fromCompact(raw json.RawMessage) (json.RawMessage, error) {
+	node, e := legacyFromCompact(raw, "")
+	if e != nil {
+		return nil, e
+	}
+	return json.Marshal(node)
+}
+
+// legacyFromCompact converts a compact node and its children. IdentifierPath nodes are converted to a node named as.
+func legacyFromCompact(raw json.RawMessage, as string) (legacyNode, error) {
+	compact := compactNode{}
+	if e := json.Unmarshal(raw, &compact); e != nil {
+		return legacyNode{}, e
+	}
+	node := legacyNode{Attributes: make(map[string]json.RawMessage, len(compact))}
+	nodeType := ""
+	if e := json.Unmarshal(compact[`nodeType`], &nodeType); e != nil || nodeType == "" {
+		return legacyNode{}, fmt.Errorf(`compact AST node without nodeType`)
+	}
+	if e := json.Unmarshal(compact[`id`], &node.Id); e != nil {
+		return legacyNode{}, fmt.Errorf(`compact AST node %s without id`, nodeType)
+	}
+	json.Unmarshal(compact[`src`], &node.Source)
+	node.Name = nodeType
+	if nodeType == `IdentifierPath` && as != "" {
+		node.Name = as
+	}
+
+	children := compactChildren[nodeType]
+	for key, value := range compact {
+		switch key {
+		case `id`, `src`, `nodeType`, `typeDescriptions`, `documentation`:
+			continue
+		}
+		if isChild(children, key) || holdsNodes(value) {
+			continue
+		}
+		node.Attributes[key] = value
+	}
+	if e := legacyAttributes(nodeType, compact, node.Attributes); e != nil {
+		return legacyNode{}, fmt.Errorf(`%s %d: %s`, nodeType, node.Id, e)
+	}
+
+	for _, key := range children {
+		value := compact[key]
+		if len(value) == 0 || string(value) == `null` {
+			continue
+		}
+		elements := []json.RawMessage{value}
+		if value[0] == '[' {
+			elements = elements[:0]
+			if e := json.Unmarshal(value, &elements); e != nil {
+				return legacyNode{}, e
+			}
+		}
+		for _, element := range elements {
+			child, e := legacyFromCompact(element, compactPaths[nodeType+`.`+key])
+			if e != nil {
+				return legacyNode{}, e
+			}
+			node.Children = append(node.Children, child)
+		}
+	}
+	return node, nil
+}
+
+// legacyAttributes adds the attributes of a legacy node that are spelled differently in compact nodes.
+func legacyAttributes(nodeType string, compact compactNode, attributes map[string]json.RawMessage) error {
+	marshal := func(key string, value interface{}) {
+		attributes[key], _ = json.Marshal(value)
+	}
+
+	if raw, ok := compact[`typeDescriptions`]; ok {
+		descriptions := struct {
+			TypeString *string `json:"typeString"`
+		}{}
+		if e := json.Unmarshal(raw, &descriptions); e != nil {
+			return fmt.Errorf(`invalid typeDescriptions: %s`, e)
+		}
+		if descriptions.TypeString != nil {
+			marshal(`type`, *descriptions.TypeString)
+		}
+	}
+
+	if raw, ok := compact[`documentation`]; ok && len(raw) > 0 {
+		switch raw[0] {
+		case '"': // before solc 0.6
+			attributes[`documentation`] = raw
+		case '{': // StructuredDocumentation
+			documentation := struct {
+				Text string `json:"text"`
+			}{}
+			if e := json.Unmarshal(raw, &documentation); e != nil {
+				return fmt.Errorf(`invalid documentation: %s`, e)
+			}
+			marshal(`documentation`, documentation.Text)
+		}
+	}
+
+	switch nodeType {
+
+	case `Identifier`, `IdentifierPath`:
+		attributes[`value`] = compact[`name`]
+
+	case `UserDefinedTypeName`:
+		if _, ok := compact[`name`]; !ok { // since solc 0.8, the name is in pathNode
+			path := struct {
+				Name string `json:"name"`
+			}{}
+			if e := json.Unmarshal(compact[`pathNode`], &path); e != nil {
+				return fmt.Errorf(`invalid pathNode: %s`, e)
+			}
+			marshal(`name`, path.Name)
+		}
+
+	case `FunctionDefinition`:
+		kind, mutability := "", StateMutability("")
+		json.Unmarshal(compact[`kind`], &kind) // since solc 0.5, isConstructor before
+		json.Unmarshal(compact[`stateMutability`], &mutability)
+		if kind != "" {
+			marshal(`isConstructor`, kind == `constructor`)
+		}
+		if _, ok := compact[`constant`]; !ok {
+			marshal(`constant`, mutability == StateMutabilityView || mutability == StateMutabilityPure)
+		}
+		if _, ok := compact[`payable`]; !ok {
+			marshal(`payable`, mutability == StateMutabilityPayable)
+		}
+
+	case `VariableDeclaration`:
+		mutability := ""
+		json.Unmarshal(compact[`mutability`], &mutability) // since solc 0.6.5
+		if mutability == `constant` {
+			marshal(`constant`, true)
+		}
+
+	}
+	return nil
+}
+
+func isChild(children []string, key string) bool {
+	for _, child := range children {
+		if child == key {
+			return true
+		}
+	}
+	return false
+}
+
+// holdsNodes reports whether a member of a compact node holds a node or an array of nodes.
+func holdsNodes(value json.RawMessage) bool {
+	if len(value) == 0 || (value[0] != '{' && value[0] != '[') {
+		return false
+	}
+	typed := struct {
+		NodeType string `json:"nodeType"`
+	}{}
+	if value[0] == '{' {
+		return json.Unmarshal(value, &typed) == nil && typed.NodeType != ""
+	}
+	elements := []json.RawMessage{}
+	if json.Unmarshal(value, &elements) != nil || len(elements) == 0 || len(elements[0]) == 0 || elements[0][0] != '{' {
+		return false
+	}
+	return json.Unmarshal(elements[0], &typed) == nil && typed.NodeType != ""
+}
@@ -0,0 +1,186 @@
+// Copyright 2018 karma.run AG. All rights reserved.
+
+package extract // import "github.com/karmarun/karma.link/ast/extract"
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ethereum/go-ethereum/crypto/sha3"
+	"github.com/karmarun/karma.link/ast"
+	"github.com/karmarun/karma.link/types"
+	"strings"
+)
+
+// abiEntry is an element of a standard contract ABI JSON array, as produced by solc --abi or block explorers.
+type abiEntry struct {
+	Type            string              `json:"type"` // "function" if empty
+	Name            string              `json:"name"`
+	Inputs          []abiParameter      `json:"inputs"`
+	Outputs         []abiParameter      `json:"outputs"`
+	StateMutability ast.StateMutability `json:"stateMutability"`
+	Constant        bool                `json:"constant"` // pre-0.4.16 ABIs lack stateMutability
+	Payable         bool                `json:"payable"`
+	Anonymous       bool                `json:"anonymous"`
+}
+
+type abiParameter struct {
+	Name       string         `json:"name"`
+	Type       string         `json:"type"`
+	Components []abiParameter `json:"components"` // members of tuple types
+	Indexed    bool           `json:"indexed"`    // event parameters only
+}
+
+// ABI extracts a contract from a plain ABI JSON array, for contracts whose sources aren't available.
+// file and name are used as the contract's (synthetic) location. The contract has no parents, variables or code.
+// Tuples become unnamed structs, events are added to the contract's types.
+func ABI(file, name string, data []byte) (*types.Contract, error) {
+
+	entries := make([]abiEntry, 0, 32)
+	if e := json.Unmarshal(data, &entries); e != nil {
+		return nil, &Error{File: file, Contract: name, Message: fmt.Sprintf(`invalid ABI JSON: %s`, e)}
+	}
+
+	contract := &types.Contract{
+		File:    file,
+		Name:    name,
+		Parents: []*types.Contract{},
+		Bases:   []*types.Contract{},
+		Types:   make(map[string]types.Type, 8),
+		Kind:    ast.ContractKindContract,
+		API:     make(map[string]types.Function, len(entries)),
+	}
+
+	for _, entry := range entries {
+		switch entry.Type {
+
+		case `function`, ``, `constructor`, `fallback`:
+			function, e := abiFunction(entry)
+			if e != nil {
+				return nil, inContext(e, file, name, entry.Name)
+			}
+			if entry.Type == `constructor` {
+				contract.Constructor = &function
+				continue
+			}
+			contract.API[string(function.SoliditySignature())] = function
+
+		case `event`:
+			args, indexed, names, e := abiParameters(entry.Inputs)
+			if e != nil {
+				return nil, inContext(e, file, name, "")
+			}
+			contract.Types[entry.Name] = types.Named{
+				Name: types.Key{File: file, Contract: name, Member: entry.Name}.String(),
+				Type: types.Event{Name: entry.Name, Args: args, Indexed: indexed, ArgNames: names, Anonymous: entry.Anonymous},
+			}
+
+		case `receive`, `error`:
+			continue // unsupported by this version of Solidity
+
+		default:
+			return nil, &Error{File: file, Contract: name, Message: fmt.Sprintf(`unexpected ABI entry type: %s`, entry.Type)}
+		}
+	}
+
+	contract.EventTopics = EventTopics(contract)
+
+	return contract, nil
+}
+
+// SignatureFromABI returns the canonical signature and selector of a function given as a standard ABI JSON object,
+// e.g. {"type": "function", "name": "transfer", "inputs": [...]}. Tuple inputs are rendered from their components.
+func SignatureFromABI(fragment json.RawMessage) (string, [4]byte, error) {
+	selector, entry := [4]byte{}, abiEntry{}
+	if e := json.Unmarshal(fragment, &entry); e != nil {
+		return "", selector, fmt.Errorf(`invalid ABI JSON: %s`, e)
+	}
+	if entry.Type != `function` && entry.Type != `` {
+		return "", selector, fmt.Errorf(`expected function ABI entry, have %s`, entry.Type)
+	}
+	if entry.Name == "" {
+		return "", selector, fmt.Errorf(`missing function name`)
+	}
+	function, e := abiFunction(entry)
+	if e != nil {
+		return "", selector, e
+	}
+	signature := function.SoliditySignature()
+	hash := sha3.NewKeccak256()
+	hash.Write(signature)
+	copy(selector[:], hash.Sum(nil))
+	return string(signature), selector, nil
+}
+
+func abiFunction(entry abiEntry) (types.Function, error) {
+	inputs, _, inputNames, e := abiParameters(entry.Inputs)
+	if e != nil {
+		return types.Function{}, e
+	}
+	outputs, _, outputNames, e := abiParameters(entry.Outputs)
+	if e != nil {
+		return types.Function{}, e
+	}
+	mutability := entry.StateMutability
+	if mutability == "" {
+		switch {
+		case entry.Constant:
+			mutability = ast.StateMutabilityView
+		case entry.Payable:
+			mutability = ast.StateMutabilityPayable
+		default:
+			mutability = ast.StateMutabilityNonpayable
+		}
+	}
+	name := entry.Name
+	if entry.Type == `fallback` || entry.Type == `constructor` {
+		name = types.FallbackFunctionName
+	}
+	return types.Function{
+		Name:            name,
+		Visibility:      ast.VisibilityExternal,
+		StateMutability: mutability,
+		Inputs:          inputs,
+		Outputs:         outputs,
+		InputNames:      inputNames,
+		OutputNames:     outputNames,
+		Modifiers:       []string{},
+	}, nil
+}
+
+// abiParameters returns the types, indexed flags and names of ABI parameters.
+func abiParameters(params []abiParameter) ([]types.Type, []bool, []string, error) {
+	typs, indexed, names := make([]types.Type, len(params)), make([]bool, len(params)), make([]string, len(params))
+	for i, param := range params {
+		typ, e := abiParameterType(param)
+		if e != nil {
+			return nil, nil, nil, e
+		}
+		typs[i], indexed[i], names[i] = typ, param.Indexed, param.Name
+	}
+	return typs, indexed, names, nil
+}
+
+func abiParameterType(param abiParameter) (types.Type, error) {
+	if !strings.HasPrefix(param.Type, `tuple`) {
+		return types.ParseType(param.Type)
+	}
+	strct := types.Struct{Keys: make([]string, len(param.Components)), Types: make([]types.Type, len(param.Components))}
+	for i, component := range param.Components {
+		typ, e := abiParameterType(component)
+		if e != nil {
+			return nil, e
+		}
+		strct.Keys[i], strct.Types[i] = component.Name, typ
+	}
+	// wrap in array suffixes like "tuple[2][]" by parsing them on a placeholder
+	suffixed, e := types.ParseType(`bool` + param.Type[len(`tuple`):])
+	if e != nil {
+		return nil, e
+	}
+	return suffixed.Map(func(t types.Type) types.Type {
+		if t == types.Type(types.Elementary(`bool`)) {
+			return strct
+		}
+		return t
+	}), nil
+}
@@ -0,0 +1,58 @@
+// Copyright 2018 karma.run AG. All rights reserved.
+
+package extract // import "github.com/karmarun/karma.link/ast/extract"
+
+import (
+	"fmt"
+	"github.com/karmarun/karma.link/ast"
+)
+
+// Error is returned when information can't be extracted from an AST.
+// It locates the offending node as precisely as known; empty fields are unknown.
+type Error struct {
+	File     string // source unit path
+	Contract string
+	Function string
+	Source   string // the node's src attribute, "offset:length:sourceIndex" in bytes
+	Message  string
+}
+
+func (e *Error) Error() string {
+	location := e.File
+	if e.Contract != "" {
+		location += ":" + e.Contract
+	}
+	if e.Function != "" {
+		location += "." + e.Function
+	}
+	if e.Source != "" {
+		location += " (src " + e.Source + ")"
+	}
+	if location == "" {
+		return e.Message
+	}
+	return location + ": " + e.Message
+}
+
+// errorAt creates an *Error located at node.
+func errorAt(node ast.Node, format string, args ...interface{}) *Error {
+	return &Error{Source: node.Header().Source, Message: fmt.Sprintf(format, args...)}
+}
+
+// inContext fills in the unknown parts of e's location, converting e to an *Error if necessary.
+func inContext(e error, file, contract, function string) *Error {
+	err, ok := e.(*Error)
+	if !ok {
+		err = &Error{Message: e.Error()}
+	}
+	if err.File == "" {
+		err.File = file
+	}
+	if err.Contract == "" {
+		err.Contract = contract
+	}
+	if err.Function == "" {
+		err.Function = function
+	}
+	return err
+}
@@ -0,0 +1,92 @@
+// Copyright 2018 karma.run AG. All rights reserved.
+
+package extract // import "github.com/karmarun/karma.link/ast/extract"
+
+import (
+	"github.com/ethereum/go-ethereum/crypto/sha3"
+	"github.com/karmarun/karma.link/types"
+	"sort"
+)
+
+// EventTopic returns the signature topic of event, i.e. the keccak256 hash of its signature.
+// Note that anonymous events don't emit it.
+func EventTopic(event types.Event) [32]byte {
+	topic := [32]byte{}
+	hash := sha3.NewKeccak256()
+	hash.Write(event.SoliditySignature())
+	copy(topic[:], hash.Sum(nil))
+	return topic
+}
+
+// EventTopics maps the signature topics of the events declared in contract and its parents to the events.
+// If a contract and one of its parents declare events with equal signatures, the most derived one is kept.
+func EventTopics(contract *types.Contract) map[[32]byte]types.Event {
+	events := make(map[[32]byte]types.Event, 16)
+	for i := len(contract.Parents); i >= 0; i-- {
+		declaring := contract
+		if i > 0 {
+			declaring = contract.Parents[i-1]
+		}
+		for _, typ := range declaring.Types {
+			named, ok := typ.(types.Named)
+			if !ok {
+				continue
+			}
+			if event, ok := named.Type.(types.Event); ok {
+				events[EventTopic(event)] = event
+			}
+		}
+	}
+	return events
+}
+
+// ProjectEventTopics maps the signature topics of the non-anonymous events declared in project's contracts to the events.
+// Events with equal signatures in different contracts are kept once per distinct layout of indexed args,
+// in order of file and contract name, so decoders can try them in turn.
+func ProjectEventTopics(project types.Project) map[[32]byte][]types.Event {
+	files := make([]string, 0, len(project.Files))
+	for file := range project.Files {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	events := make(map[[32]byte][]types.Event, 64)
+	for _, file := range files {
+		names := make([]string, 0, len(project.Files[file]))
+		for name := range project.Files[file] {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			contract := project.Files[file][name]
+			topics := contract.EventTopics
+			if topics == nil {
+				topics = EventTopics(contract)
+			}
+			for topic, event := range topics {
+				if event.Anonymous || containsEventLayout(events[topic], event) {
+					continue
+				}
+				events[topic] = append(events[topic], event)
+			}
+		}
+	}
+	return events
+}
+
+// containsEventLayout reports whether events contains an event indexing the same args as event.
+// The events are assumed to have equal signatures.
+func containsEventLayout(events []types.Event, event types.Event) bool {
+outer:
+	for _, other := range events {
+		if len(other.Indexed) != len(event.Indexed) {
+			continue
+		}
+		for i := range other.Indexed {
+			if other.Indexed[i] != event.Indexed[i] {
+				continue outer
+			}
+		}
+		return true
+	}
+	return false
+}
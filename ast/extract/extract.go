@@ -4,10 +4,13 @@ package extract // import "github.com/karmarun/karma.link/ast/extract"
 
 import (
 	"bytes"
-	"fmt"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/karmarun/karma.link/abi"
 	"github.com/karmarun/karma.link/ast"
 	"github.com/karmarun/karma.link/types"
 	"strconv"
+	"strings"
 )
 
 // ContractDefinitions extracts all ast.ContractDefinition's from an ast.SourceUnit.
@@ -42,7 +45,7 @@ func ContractAPI(contractDefinition ast.ContractDefinition, typeMap types.Map) (
 			}
 			function, e := FunctionAPI(functionDefinition, typeMap)
 			if e != nil {
-				return nil, e
+				return nil, inContext(e, "", contractDefinition.Name, functionDefinition.Name)
 			}
 			extracted = append(extracted, function)
 		}
@@ -51,6 +54,94 @@ func ContractAPI(contractDefinition ast.ContractDefinition, typeMap types.Map) (
 	return extracted, nil
 }
 
+// ContractConstructor extracts the constructor of an ast.ContractDefinition, or nil if it doesn't declare one.
+// typeMap is used to resolve type references in the process.
+func ContractConstructor(contractDefinition ast.ContractDefinition, typeMap types.Map) (*types.Function, error) {
+	for _, child := range contractDefinition.Children() {
+		if functionDefinition, ok := child.(ast.FunctionDefinition); ok && functionDefinition.IsConstructor {
+			function, e := FunctionAPI(functionDefinition, typeMap)
+			if e != nil {
+				return nil, inContext(e, "", contractDefinition.Name, "constructor")
+			}
+			return &function, nil
+		}
+	}
+	return nil, nil
+}
+
+// ContractVariables extracts all state variables from an ast.ContractDefinition in declaration order.
+// typeMap is used to resolve type references in the process.
+func ContractVariables(contractDefinition ast.ContractDefinition, typeMap types.Map) []types.Variable {
+	children := contractDefinition.Children()
+	extracted := make([]types.Variable, 0, len(children))
+	for _, child := range children {
+		if variableDeclaration, ok := child.(ast.VariableDeclaration); ok {
+			typeId := variableDeclaration.Children()[0].Header().Id
+			extracted = append(extracted, types.Variable{
+				Name:       variableDeclaration.Name,
+				Constant:   variableDeclaration.Constant,
+				Visibility: variableDeclaration.Visibility,
+				Type:       typeMap.Deref(types.Reference(typeId)),
+				Definition: variableDeclaration,
+			})
+		}
+	}
+	return extracted
+}
+
+// ContractConstants extracts the values of constant state variables initialized with a literal, rendered like the
+// return values of their getters, e.g. "100" for uint256 public constant FEE = 100. Constants initialized with
+// expressions or literals with subdenominations ("1 ether") are omitted, their values can be read by calling their getters.
+func ContractConstants(variables []types.Variable) map[string]json.RawMessage {
+	constants := make(map[string]json.RawMessage, 4)
+	for _, variable := range variables {
+		if !variable.Constant || len(variable.Definition.Children()) < 2 {
+			continue
+		}
+		literal, ok := variable.Definition.Children()[1].(ast.Literal)
+		if !ok || literal.Subdenomination != "" {
+			continue
+		}
+		if value, ok := literalValue(variable.Type, literal); ok {
+			constants[variable.Name] = value
+		}
+	}
+	return constants
+}
+
+// literalValue converts literal to JSON by ABI-encoding and decoding it as typ.
+func literalValue(typ types.Type, literal ast.Literal) (json.RawMessage, bool) {
+	if literal.Type == `bool` {
+		return json.RawMessage(literal.Value), literal.Value == `true` || literal.Value == `false`
+	}
+	arg, _ := json.Marshal([]string{literal.Value}) // integers are accepted as strings
+	if strings.HasPrefix(literal.Type, `literal_string`) {
+		// the value may not be valid UTF-8, e.g. hex"ff"
+		bs, e := hex.DecodeString(literal.Hexvalue)
+		if e != nil {
+			return nil, false
+		}
+		elements := make([]int, len(bs), len(bs)) // []byte would be marshalled as base64
+		for i, b := range bs {
+			elements[i] = int(b)
+		}
+		arg, _ = json.Marshal([][]int{elements})
+	}
+	code, e := abi.Encode(types.Tuple{typ}, arg)
+	if e != nil {
+		return nil, false
+	}
+	decoded, e := abi.Decode(types.Tuple{typ}, code)
+	if e != nil {
+		return nil, false
+	}
+	values := make([]json.RawMessage, 0, 1)
+	if e := json.Unmarshal(decoded, &values); e != nil || len(values) != 1 {
+		return nil, false
+	}
+	return values[0], true
+}
+
 // VariableAPI extracts the generated getter function that public top-level
 // contract variables get automatically in Solidity.
 func VariableAPI(variableDeclaration ast.VariableDeclaration, typeMap types.Map) types.Function {
@@ -61,19 +152,50 @@ func VariableAPI(variableDeclaration ast.VariableDeclaration, typeMap types.Map)
 	// mapping and array variables accept parameters:
 	// - arrays take index as uint256 + accessor for $valueType (recursively)
 	// - mappings take index as $keyType + accessor for $valueType (recursively)
-	// they return a single value of the last "concrete" type
+	// they return a single value of the last "concrete" type,
+	// except for structs, which are returned member by member (see getterOutputs)
 
 	inputs, output := variableAccessor(typ, typeMap, nil)
+	outputs, outputNames := getterOutputs(output)
 
 	return types.Function{
-		Name:       variableDeclaration.Name,
-		Visibility: variableDeclaration.Visibility,
-		Inputs:     inputs,
-		Outputs:    []types.Type{output},
-		Definition: variableDeclaration,
+		Name:        variableDeclaration.Name,
+		Visibility:  variableDeclaration.Visibility,
+		Inputs:      inputs,
+		Outputs:     outputs,
+		InputNames:  make([]string, len(inputs), len(inputs)),
+		OutputNames: outputNames,
+		Definition:  variableDeclaration,
 	}
 }
 
+// getterOutputs returns the return types and names of a getter returning a value of type typ.
+// Getters of structs return the struct's members as separate values, omitting mappings and arrays.
+// Byte arrays (bytes and string) are elementary types and are returned.
+func getterOutputs(typ types.Type) ([]types.Type, []string) {
+	concreteType := typ
+	if named, ok := concreteType.(types.Named); ok {
+		concreteType = named.Type
+	}
+	strct, ok := concreteType.(types.Struct)
+	if !ok {
+		return []types.Type{typ}, []string{""}
+	}
+	outputs, names := make([]types.Type, 0, len(strct.Types)), make([]string, 0, len(strct.Types))
+	for i, member := range strct.Types {
+		concreteMember := member
+		if named, ok := concreteMember.(types.Named); ok {
+			concreteMember = named.Type
+		}
+		switch concreteMember.(type) {
+		case types.Mapping, types.Array:
+			continue
+		}
+		outputs, names = append(outputs, member), append(names, strct.Keys[i])
+	}
+	return outputs, names
+}
+
 func variableAccessor(typ types.Type, typeMap types.Map, prev []types.Type) ([]types.Type, types.Type) {
 	concreteType := typ
 	if mapping, ok := concreteType.(types.Mapping); ok {
@@ -92,38 +214,44 @@ func FunctionAPI(functionDefinition ast.FunctionDefinition, typeMap types.Map) (
 
 	inParamList, ok := children[0].(ast.ParameterList)
 	if !ok {
-		return types.Function{}, fmt.Errorf(`functionDefinition's first child expected to be ParameterList`)
+		return types.Function{}, errorAt(functionDefinition, `functionDefinition's first child expected to be ParameterList`)
 	}
 
 	outParamList, ok := children[1].(ast.ParameterList)
 	if !ok {
-		return types.Function{}, fmt.Errorf(`functionDefinition's second child expected to be ParameterList`)
+		return types.Function{}, errorAt(functionDefinition, `functionDefinition's second child expected to be ParameterList`)
 	}
 
 	inParams, outParams := inParamList.Children(), outParamList.Children()
 	inputs, outputs := make([]types.Type, len(inParams), len(inParams)), make([]types.Type, len(outParams), len(outParams))
+	inputNames, outputNames := make([]string, len(inParams), len(inParams)), make([]string, len(outParams), len(outParams))
 
 	for i, child := range inParams {
 		variableDeclaration, ok := child.(ast.VariableDeclaration)
 		if !ok {
-			return types.Function{}, fmt.Errorf(`paramList's children expected to be VariableDeclarations`)
+			return types.Function{}, errorAt(child, `paramList's children expected to be VariableDeclarations`)
 		}
 		typeId := variableDeclaration.Children()[0].Header().Id
-		inputs[i] = typeMap.Deref(types.Reference(typeId))
+		inputs[i], inputNames[i] = typeMap.Deref(types.Reference(typeId)), variableDeclaration.Name
 	}
 
 	for i, child := range outParams {
 		variableDeclaration, ok := child.(ast.VariableDeclaration)
 		if !ok {
-			return types.Function{}, fmt.Errorf(`paramList's children expected to be VariableDeclarations`)
+			return types.Function{}, errorAt(child, `paramList's children expected to be VariableDeclarations`)
 		}
 		typeId := variableDeclaration.Children()[0].Header().Id
-		outputs[i] = typeMap.Deref(types.Reference(typeId))
+		outputs[i], outputNames[i] = typeMap.Deref(types.Reference(typeId)), variableDeclaration.Name
 	}
 
-	for _, input := range inputs {
-		if _, ok := input.(types.Reference); ok {
-			panic("")
+	modifiers := make([]string, 0, 4)
+	for _, child := range children[2:] {
+		if modifierInvocation, ok := child.(ast.ModifierInvocation); ok {
+			name, ok := modifierInvocation.Children()[0].(ast.Identifier)
+			if !ok {
+				return types.Function{}, errorAt(modifierInvocation, `modifierInvocation's first child expected to be Identifier`)
+			}
+			modifiers = append(modifiers, name.Value)
 		}
 	}
 
@@ -134,6 +262,9 @@ func FunctionAPI(functionDefinition ast.FunctionDefinition, typeMap types.Map) (
 		NatSpec:         functionDefinition.Documentation,
 		Inputs:          inputs,
 		Outputs:         outputs,
+		InputNames:      inputNames,
+		OutputNames:     outputNames,
+		Modifiers:       modifiers,
 		Definition:      functionDefinition,
 	}, nil
 }
@@ -141,6 +272,12 @@ func FunctionAPI(functionDefinition ast.FunctionDefinition, typeMap types.Map) (
 // Types extracts all type definitions and references from an ast.SourceUnit.
 func Types(path string, root ast.SourceUnit) (types.Map, error) {
 	extracted := make(types.Map, 64)
+	constants := make(map[int]ast.VariableDeclaration, 16)
+	ast.PreTraverse(root, func(node ast.Node) {
+		if node, ok := node.(ast.VariableDeclaration); ok && node.Constant {
+			constants[node.Header().Id] = node
+		}
+	})
 	contractName := "" // NOTE: we make delicate use of pre-order traversal to fix EventDefinition's canonicalName
 	err := (error)(nil)
 	ast.PreTraverse(root, func(node ast.Node) {
@@ -166,7 +303,7 @@ func Types(path string, root ast.SourceUnit) (types.Map, error) {
 			extracted[ref] = t
 		}
 		if node, ok := node.(ast.ArrayTypeName); ok {
-			t, e := Type(path, node)
+			t, e := arrayType(path, node, constants)
 			if e != nil {
 				err = e
 				return
@@ -214,9 +351,17 @@ func Types(path string, root ast.SourceUnit) (types.Map, error) {
 			}
 			extracted[ref] = t
 		}
+		if node, ok := node.(ast.FunctionTypeName); ok {
+			t, e := Type(path, node)
+			if e != nil {
+				err = e
+				return
+			}
+			extracted[ref] = t
+		}
 	})
 	if err != nil {
-		return nil, err
+		return nil, inContext(err, path, contractName, "")
 	}
 	return extracted, nil
 }
@@ -229,30 +374,31 @@ func Types(path string, root ast.SourceUnit) (types.Map, error) {
 // ast.EnumDefinition,
 // ast.StructDefinition,
 // ast.EventDefinition,
-// ast.Mapping.
+// ast.Mapping,
+// ast.FunctionTypeName.
 // It returns an error for everything else.
 func Type(path string, node ast.Node) (types.Type, error) {
 	if node, ok := node.(ast.ContractDefinition); ok {
 		switch node.ContractKind {
 
 		case ast.ContractKindContract:
-			return types.ContractAddress(path + ":" + node.Name), nil
+			return types.ContractAddress(types.Key{File: path, Contract: node.Name}.String()), nil
 
 		case ast.ContractKindInterface:
-			return types.InterfaceAddress(path + ":" + node.Name), nil
+			return types.InterfaceAddress(types.Key{File: path, Contract: node.Name}.String()), nil
 
 		case ast.ContractKindLibrary:
-			return types.LibraryAddress(path + ":" + node.Name), nil
+			return types.LibraryAddress(types.Key{File: path, Contract: node.Name}.String()), nil
 
 		default:
-			return nil, fmt.Errorf(`unexpected contract kind: %s`, node.ContractKind)
+			return nil, errorAt(node, `unexpected contract kind: %s`, node.ContractKind)
 		}
 	}
 	if node, ok := node.(ast.UserDefinedTypeName); ok {
 		return types.Reference(node.ReferencedDeclaration), nil
 	}
 	if node, ok := node.(ast.ElementaryTypeName); ok {
-		return types.Elementary(node.Type), nil
+		return ElementaryType(node), nil
 	}
 	if node, ok := node.(ast.ArrayTypeName); ok {
 		return ArrayType(path, node)
@@ -269,7 +415,37 @@ func Type(path string, node ast.Node) (types.Type, error) {
 	if node, ok := node.(ast.Mapping); ok {
 		return MappingType(path, node)
 	}
-	return nil, fmt.Errorf(`unexpected ast.Node type in extract.Type: %T`, node)
+	if _, ok := node.(ast.FunctionTypeName); ok {
+		// NOTE: the ABI encodes (external) function types as address + selector, regardless of their parameters.
+		return types.Elementary(`function`), nil
+	}
+	return nil, errorAt(node, `unexpected ast.Node type in extract.Type: %T`, node)
+}
+
+// ElementaryType extracts a canonical types.Elementary from an ast.ElementaryTypeName.
+// Depending on the compiler version, the AST spells e.g. uint256 as "uint" or "uint256" and
+// may append qualifiers such as "payable". Both are normalized so that signatures match solc's.
+func ElementaryType(elementaryTypeName ast.ElementaryTypeName) types.Elementary {
+	name := elementaryTypeName.Type
+	if name == "" {
+		name = elementaryTypeName.Name
+	}
+	if i := strings.IndexByte(name, ' '); i != -1 { // e.g. "address payable"
+		name = name[:i]
+	}
+	switch name { // aliases
+	case `int`:
+		return `int256`
+	case `uint`:
+		return `uint256`
+	case `byte`:
+		return `bytes1`
+	case `fixed`:
+		return `fixed128x18`
+	case `ufixed`:
+		return `ufixed128x18`
+	}
+	return types.Elementary(name)
 }
 
 // EventType extracts a named types.Event from an ast.EventDefinition.
@@ -277,43 +453,56 @@ func EventType(path string, eventDefinition ast.EventDefinition) (types.Named, e
 
 	children := eventDefinition.Children()
 	if len(children) != 1 {
-		return types.Named{}, fmt.Errorf(`expected eventDefinition to have exactly one child`)
+		return types.Named{}, errorAt(eventDefinition, `expected eventDefinition to have exactly one child`)
 	}
 
 	paramList, ok := children[0].(ast.ParameterList)
 	if !ok {
-		return types.Named{}, fmt.Errorf(`eventDefinition's child expected to be ParameterList`)
+		return types.Named{}, errorAt(eventDefinition, `eventDefinition's child expected to be ParameterList`)
 	}
 
 	params := paramList.Children()
-	args := make([]types.Type, len(params), len(params))
+	args, indexed := make([]types.Type, len(params), len(params)), make([]bool, len(params), len(params))
+	names := make([]string, len(params), len(params))
 
 	for i, param := range params {
 		variableDeclaration, ok := param.(ast.VariableDeclaration)
 		if !ok {
-			return types.Named{}, fmt.Errorf(`eventDefinition's ParameterList's children expected to be VariableDeclarations`)
+			return types.Named{}, errorAt(param, `eventDefinition's ParameterList's children expected to be VariableDeclarations`)
 		}
 		varChildren := variableDeclaration.Children()
 		if len(varChildren) != 1 {
-			return types.Named{}, fmt.Errorf(`variableDeclaration expected to have 1 child`)
+			return types.Named{}, errorAt(variableDeclaration, `variableDeclaration expected to have 1 child`)
 		}
 		t, e := Type(path, varChildren[0])
 		if e != nil {
 			return types.Named{}, e
 		}
-		args[i] = t
+		args[i], indexed[i], names[i] = t, variableDeclaration.Indexed, variableDeclaration.Name
 	}
 
 	return types.Named{
-		Name: path + ":" + eventDefinition.CanonicalName,
+		Name: canonicalKey(path, eventDefinition.CanonicalName).String(),
 		Type: types.Event{
-			Name: eventDefinition.Name,
-			Args: args,
+			Name:      eventDefinition.Name,
+			Args:      args,
+			Indexed:   indexed,
+			ArgNames:  names,
+			Anonymous: eventDefinition.Anonymous,
 		},
 	}, nil
 
 }
 
+// canonicalKey returns the key of a type declared in a contract, given its canonical name "Contract.Member".
+func canonicalKey(path, canonicalName string) types.Key {
+	key := types.Key{File: path, Contract: canonicalName}
+	if i := strings.IndexByte(canonicalName, '.'); i != -1 {
+		key.Contract, key.Member = canonicalName[:i], canonicalName[i+1:]
+	}
+	return key
+}
+
 // StructType extracts a named types.Struct from an ast.StructDefinition.
 func StructType(path string, structDefinition ast.StructDefinition) (types.Named, error) {
 	children := structDefinition.Children()
@@ -324,11 +513,11 @@ func StructType(path string, structDefinition ast.StructDefinition) (types.Named
 	for i, child := range children {
 		variableDeclaration, ok := child.(ast.VariableDeclaration)
 		if !ok {
-			return types.Named{}, fmt.Errorf(`structDefinition's children expected to be VariableDeclarations`)
+			return types.Named{}, errorAt(child, `structDefinition's children expected to be VariableDeclarations`)
 		}
 		varChildren := variableDeclaration.Children()
 		if len(varChildren) != 1 {
-			return types.Named{}, fmt.Errorf(`variableDeclaration expected to have 1 child`)
+			return types.Named{}, errorAt(variableDeclaration, `variableDeclaration expected to have 1 child`)
 		}
 		strct.Keys[i] = variableDeclaration.Name
 		t, e := Type(path, varChildren[0])
@@ -338,7 +527,7 @@ func StructType(path string, structDefinition ast.StructDefinition) (types.Named
 		strct.Types[i] = t
 	}
 	return types.Named{
-		Name: path + ":" + structDefinition.CanonicalName,
+		Name: canonicalKey(path, structDefinition.CanonicalName).String(),
 		Type: strct,
 	}, nil
 }
@@ -350,55 +539,103 @@ func EnumType(path string, enumDefinition ast.EnumDefinition) (types.Named, erro
 	for i, child := range children {
 		enumValue, ok := child.(ast.EnumValue)
 		if !ok {
-			return types.Named{}, fmt.Errorf(`enumDefinition's children expected to be EnumValues`)
+			return types.Named{}, errorAt(child, `enumDefinition's children expected to be EnumValues`)
 		}
 		enum[i] = enumValue.Name
 	}
 	return types.Named{
-		Name: path + ":" + enumDefinition.CanonicalName,
+		Name: canonicalKey(path, enumDefinition.CanonicalName).String(),
 		Type: enum,
 	}, nil
 }
 
-// EnumType extracts a types.Array from an ast.ArrayTypeName.
+// ArrayType extracts a types.Array from an ast.ArrayTypeName.
 func ArrayType(path string, arrayTypeName ast.ArrayTypeName) (types.Array, error) {
+	return arrayType(path, arrayTypeName, nil)
+}
+
+// arrayType extracts a types.Array from an ast.ArrayTypeName.
+// constants maps node ids to constant declarations, used to evaluate lengths the type name doesn't spell out.
+func arrayType(path string, arrayTypeName ast.ArrayTypeName, constants map[int]ast.VariableDeclaration) (types.Array, error) {
 	children := arrayTypeName.Children()
+	if len(children) != 1 && len(children) != 2 {
+		return types.Array{}, errorAt(arrayTypeName, `arrayTypeName expected to have 1 or 2 children`)
+	}
+	t, e := (types.Type)(nil), (error)(nil)
+	if element, ok := children[0].(ast.ArrayTypeName); ok { // multi-dimensional
+		t, e = arrayType(path, element, constants)
+	} else {
+		t, e = Type(path, children[0])
+	}
+	if e != nil {
+		return types.Array{}, e
+	}
 	if len(children) == 1 {
-		t, e := Type(path, children[0])
-		if e != nil {
-			return types.Array{}, e
-		}
 		return types.Array{
 			Length: types.DynamicArrayLength,
 			Type:   t,
 		}, nil
 	}
-	if len(children) == 2 {
-		// second child is literal of fixed array length, which can be a static expression.
-		// we parse the length out of the type name instead of evaluating arbitrary expressions here.
-		nameBytes := ([]byte)(arrayTypeName.Type)
-		lenBytes := nameBytes[bytes.LastIndex(nameBytes, []byte("["))+1 : len(nameBytes)-1]
-		length, e := strconv.ParseInt(string(lenBytes), 10, 64)
-		if e != nil {
-			return types.Array{}, e
+	// second child is the array length, which can be a static expression.
+	// we parse the length out of the type name instead of evaluating arbitrary expressions here,
+	// falling back to literals and constants.
+	length, ok := typeNameArrayLength(arrayTypeName.Type)
+	if !ok {
+		length, ok = constantValue(children[1], constants)
+	}
+	if !ok {
+		return types.Array{}, errorAt(arrayTypeName, `can't determine array length of type %s`, arrayTypeName.Type)
+	}
+	return types.Array{
+		Length: int(length),
+		Type:   t,
+	}, nil
+}
+
+// typeNameArrayLength parses the outermost length of an array type name, e.g. 3 in "uint256[2][3] storage ref".
+func typeNameArrayLength(typeName string) (int64, bool) {
+	nameBytes := []byte(typeName)
+	if i := bytes.IndexByte(nameBytes, ' '); i != -1 {
+		nameBytes = nameBytes[:i]
+	}
+	i := bytes.LastIndexByte(nameBytes, '[')
+	if i == -1 || nameBytes[len(nameBytes)-1] != ']' {
+		return 0, false
+	}
+	length, e := strconv.ParseInt(string(nameBytes[i+1:len(nameBytes)-1]), 10, 64)
+	if e != nil || length < 0 {
+		return 0, false
+	}
+	return length, true
+}
+
+// constantValue evaluates an integer literal or an identifier referencing a constant declared as one.
+func constantValue(node ast.Node, constants map[int]ast.VariableDeclaration) (int64, bool) {
+	switch node := node.(type) {
+
+	case ast.Literal:
+		value, e := strconv.ParseInt(node.Value, 0, 64)
+		if e != nil || value < 0 {
+			return 0, false
 		}
-		t, e := Type(path, children[0])
-		if e != nil {
-			return types.Array{}, e
+		return value, true
+
+	case ast.Identifier:
+		declaration, ok := constants[node.ReferencedDeclaration]
+		if !ok || len(declaration.Children()) != 2 {
+			return 0, false
 		}
-		return types.Array{
-			Length: int(length),
-			Type:   t,
-		}, nil
+		return constantValue(declaration.Children()[1], constants)
+
 	}
-	return types.Array{}, fmt.Errorf(`arrayTypeName expected to have 1 or 2 children`)
+	return 0, false
 }
 
 // MappingType extracts a types.Mapping from an ast.Mapping.
 func MappingType(path string, mapping ast.Mapping) (types.Mapping, error) {
 	children := mapping.Children()
 	if len(children) != 2 {
-		return types.Mapping{}, fmt.Errorf(`ast.Mapping expected to have exactly two children`)
+		return types.Mapping{}, errorAt(mapping, `ast.Mapping expected to have exactly two children`)
 	}
 	t1, e := Type(path, children[0])
 	if e != nil {
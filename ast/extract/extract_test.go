@@ -0,0 +1,800 @@
+// Copyright 2018 karma.run AG. All rights reserved.
+
+package extract
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/karmarun/karma.link/abi"
+	"github.com/karmarun/karma.link/ast"
+	"github.com/karmarun/karma.link/types"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// node is a legacy (non-compact) solc AST node as found in combined.json.
+type node struct {
+	Id         int                    `json:"id"`
+	Name       string                 `json:"name"`
+	Src        string                 `json:"src"`
+	Attributes map[string]interface{} `json:"attributes"`
+	Children   []node                 `json:"children"`
+}
+
+// astBuilder hands out node ids for handwritten ASTs, there being no solc in the test environment.
+type astBuilder struct {
+	ids int
+}
+
+func (b *astBuilder) node(name string, attributes map[string]interface{}, children ...node) node {
+	b.ids++
+	if attributes == nil {
+		attributes = map[string]interface{}{}
+	}
+	return node{Id: b.ids, Name: name, Src: "0:0:0", Attributes: attributes, Children: children}
+}
+
+func (b *astBuilder) sourceUnit(path string, children ...node) node {
+	return b.node(`SourceUnit`, map[string]interface{}{`absolutePath`: path}, children...)
+}
+
+// contract defines a contract without bases, see contractWithBases.
+func (b *astBuilder) contract(name string, children ...node) node {
+	return b.contractWithBases(name, nil, children...)
+}
+
+// contractWithBases defines a contract whose linearized bases, most derived first, are bases.
+func (b *astBuilder) contractWithBases(name string, bases []int, children ...node) node {
+	contract := b.node(`ContractDefinition`, nil, children...)
+	contract.Attributes = map[string]interface{}{
+		`name`:                    name,
+		`contractKind`:            `contract`,
+		`fullyImplemented`:        true,
+		`linearizedBaseContracts`: append([]int{contract.Id}, bases...),
+	}
+	return contract
+}
+
+func (b *astBuilder) function(name string, inputs, outputs []node, children ...node) node {
+	params := []node{
+		b.node(`ParameterList`, nil, inputs...),
+		b.node(`ParameterList`, nil, outputs...),
+	}
+	return b.node(`FunctionDefinition`, map[string]interface{}{
+		`name`:            name,
+		`visibility`:      `public`,
+		`stateMutability`: `nonpayable`,
+		`implemented`:     true,
+	}, append(append(params, children...), b.node(`Block`, nil))...)
+}
+
+// param declares a parameter or event field with type name typ, e.g. elementary(`uint`).
+func (b *astBuilder) param(name string, typ node) node {
+	return b.node(`VariableDeclaration`, map[string]interface{}{
+		`name`:            name,
+		`type`:            typ.Attributes[`type`],
+		`storageLocation`: `default`,
+		`visibility`:      `internal`,
+	}, typ)
+}
+
+// elementary is an ElementaryTypeName as written in the source, e.g. "uint" or "uint256".
+func (b *astBuilder) elementary(name string) node {
+	return b.node(`ElementaryTypeName`, map[string]interface{}{`name`: name, `type`: name})
+}
+
+// array is an ArrayTypeName with element type element and length, or a dynamic one if length is nil.
+func (b *astBuilder) array(element node, typ string, length *node) node {
+	attributes := map[string]interface{}{`type`: typ}
+	if length == nil {
+		return b.node(`ArrayTypeName`, attributes, element)
+	}
+	return b.node(`ArrayTypeName`, attributes, element, *length)
+}
+
+func (b *astBuilder) literal(value string) node {
+	return b.node(`Literal`, map[string]interface{}{`value`: value, `type`: `int_const ` + value, `token`: `number`})
+}
+
+// extractProject runs Project on a combined.json holding the given source units, keyed by path.
+func extractProject(t *testing.T, units map[string]node) types.Project {
+	t.Helper()
+	project, e := extractProjectError(units)
+	if e != nil {
+		t.Fatal(e)
+	}
+	return project
+}
+
+func extractProjectError(units map[string]node) (types.Project, error) {
+	return extractCombined(units, map[string]interface{}{})
+}
+
+// extractCombined runs Project on a combined.json holding the given source units and compiled contracts,
+// the latter keyed by "path:Name".
+func extractCombined(units map[string]node, contracts map[string]interface{}) (types.Project, error) {
+	decoded, e := legacyCombined(units, contracts)
+	if e != nil {
+		return types.Project{}, e
+	}
+	return Project(decoded)
+}
+
+// legacyCombined decodes a combined.json of solc 0.4.24 holding the given source units and compiled contracts.
+func legacyCombined(units map[string]node, contracts map[string]interface{}) (ast.Combined, error) {
+	combined := map[string]interface{}{
+		`version`:   `0.4.24+commit.e67f0147.Linux.g++`,
+		`contracts`: contracts,
+	}
+	sourceList, sources := []string{}, map[string]interface{}{}
+	for path, unit := range units {
+		sourceList = append(sourceList, path)
+		sources[path] = map[string]interface{}{`AST`: unit}
+	}
+	combined[`sourceList`], combined[`sources`] = sourceList, sources
+	bs, e := json.Marshal(combined)
+	if e != nil {
+		return ast.Combined{}, e
+	}
+	return ast.DecodeCombined(bytes.NewReader(bs))
+}
+
+func selector(signature string) string {
+	return hex.EncodeToString(crypto.Keccak256([]byte(signature))[:4])
+}
+
+func TestElementaryTypeAliases(t *testing.T) {
+
+	b := &astBuilder{}
+
+	// function f(uint a, uint[] b, uint[2] c, int d, byte e) written with aliases, and again with canonical type names
+	declare := func(contract, uint, int, byte string) node {
+		two := b.literal(`2`)
+		return b.sourceUnit(contract+`.sol`, b.contract(contract, b.function(`f`, []node{
+			b.param(`a`, b.elementary(uint)),
+			b.param(`b`, b.array(b.elementary(uint), uint+`[] memory`, nil)),
+			b.param(`c`, b.array(b.elementary(uint), uint+`[2] memory`, &two)),
+			b.param(`d`, b.elementary(int)),
+			b.param(`e`, b.elementary(byte)),
+		}, nil)))
+	}
+
+	project := extractProject(t, map[string]node{
+		`lib/Aliased.sol`:   declare(`Aliased`, `uint`, `int`, `byte`),
+		`lib/Canonical.sol`: declare(`Canonical`, `uint256`, `int256`, `bytes1`),
+	})
+
+	const signature, expectedSelector = `f(uint256,uint256[],uint256[2],int256,bytes1)`, `c51269ff`
+
+	for _, name := range []string{`Aliased`, `Canonical`} {
+		contract := project.Files[name+`.sol`][name]
+		if contract == nil {
+			t.Fatalf("contract %s not extracted", name)
+		}
+		function, ok := contract.API[signature]
+		if !ok {
+			t.Fatalf("%s: expected function %s, got %v", name, signature, contract.API)
+		}
+		if got := selector(string(function.SoliditySignature())); got != expectedSelector {
+			t.Errorf("%s: expected selector %s, got %s", name, expectedSelector, got)
+		}
+	}
+}
+
+// modifier invokes the modifier named name with arguments, as applied to a function definition.
+func (b *astBuilder) modifier(name string, arguments ...node) node {
+	identifier := b.node(`Identifier`, map[string]interface{}{`value`: name, `type`: `modifier ()`})
+	return b.node(`ModifierInvocation`, nil, append([]node{identifier}, arguments...)...)
+}
+
+func TestFunctionModifiers(t *testing.T) {
+
+	b := &astBuilder{}
+
+	owned := b.contract(`Owned`,
+		b.node(`ModifierDefinition`, map[string]interface{}{`name`: `onlyOwner`, `visibility`: `internal`}, b.node(`ParameterList`, nil), b.node(`Block`, nil)),
+		b.node(`ModifierDefinition`, map[string]interface{}{`name`: `costs`, `visibility`: `internal`},
+			b.node(`ParameterList`, nil, b.param(`price`, b.elementary(`uint256`))), b.node(`Block`, nil)),
+		b.function(`withdraw`, nil, nil, b.modifier(`onlyOwner`), b.modifier(`costs`, b.literal(`5`))),
+		b.function(`deposit`, nil, nil),
+	)
+
+	project := extractProject(t, map[string]node{`Owned.sol`: b.sourceUnit(`Owned.sol`, owned)})
+	api := project.Files[`Owned.sol`][`Owned`].API
+
+	if modifiers := api[`withdraw()`].Modifiers; !reflect.DeepEqual(modifiers, []string{`onlyOwner`, `costs`}) {
+		t.Fatalf(`expected modifiers [onlyOwner costs], have %v`, modifiers)
+	}
+	if modifiers := api[`deposit()`].Modifiers; len(modifiers) != 0 {
+		t.Fatalf(`expected no modifiers, have %v`, modifiers)
+	}
+}
+
+func TestErrorLocation(t *testing.T) {
+
+	b := &astBuilder{}
+
+	broken := b.function(`f`, nil, nil)
+	broken.Children[0], broken.Children[2] = broken.Children[2], broken.Children[0] // Block before ParameterList
+	broken.Src = `40:25:0`
+
+	_, e := extractProjectError(map[string]node{`Bad.sol`: b.sourceUnit(`Bad.sol`, b.contract(`Broken`, broken))})
+	err, ok := e.(*Error)
+	if !ok {
+		t.Fatalf(`expected *Error, have %#v`, e)
+	}
+	if err.File != `Bad.sol` || err.Contract != `Broken` || err.Function != `f` || err.Source != `40:25:0` {
+		t.Fatalf(`unexpected location: %+v`, err)
+	}
+	if expected := `Bad.sol:Broken.f (src 40:25:0): functionDefinition's first child expected to be ParameterList`; err.Error() != expected {
+		t.Fatalf(`expected %q, have %q`, expected, err.Error())
+	}
+}
+
+func TestConstantArrayLength(t *testing.T) {
+
+	b := &astBuilder{}
+
+	// uint256 constant N = 3; function f(uint256[N] a, uint256[N][2] b)
+	n := b.node(`VariableDeclaration`, map[string]interface{}{
+		`name`:          `N`,
+		`type`:          `uint256`,
+		`constant`:      true,
+		`stateVariable`: true,
+		`visibility`:    `internal`,
+	}, b.elementary(`uint256`), b.literal(`3`))
+	length := func() *node {
+		identifier := b.node(`Identifier`, map[string]interface{}{`value`: `N`, `referencedDeclaration`: n.Id, `type`: `uint256`})
+		return &identifier
+	}
+	two := b.literal(`2`)
+	f := b.function(`f`, []node{
+		b.param(`a`, b.array(b.elementary(`uint256`), `uint256[N] memory`, length())),
+		b.param(`b`, b.array(b.array(b.elementary(`uint256`), `uint256[N]`, length()), `uint256[N][2] memory`, &two)),
+	}, nil)
+
+	project := extractProject(t, map[string]node{`Sized.sol`: b.sourceUnit(`Sized.sol`, b.contract(`Sized`, n, f))})
+
+	if _, ok := project.Files[`Sized.sol`][`Sized`].API[`f(uint256[3],uint256[3][2])`]; !ok {
+		t.Fatalf(`expected f(uint256[3],uint256[3][2]), have %v`, project.Files[`Sized.sol`][`Sized`].API)
+	}
+}
+
+func TestRuntimeBinary(t *testing.T) {
+
+	b := &astBuilder{}
+
+	units := map[string]node{`Stored.sol`: b.sourceUnit(`Stored.sol`, b.contract(`Stored`, b.function(`get`, nil, nil)))}
+	contracts := map[string]interface{}{
+		`Stored.sol:Stored`: map[string]string{`bin`: `6080604052348015600f57600080fd5b50`, `bin-runtime`: `6080604052600080fd00`},
+	}
+	project, e := extractCombined(units, contracts)
+	if e != nil {
+		t.Fatal(e)
+	}
+	contract := project.Files[`Stored.sol`][`Stored`]
+	if hex.EncodeToString(contract.Binary) != `6080604052348015600f57600080fd5b50` {
+		t.Fatalf(`unexpected creation code %x`, contract.Binary)
+	}
+	if hex.EncodeToString(contract.RuntimeBinary) != `6080604052600080fd00` {
+		t.Fatalf(`unexpected runtime code %x`, contract.RuntimeBinary)
+	}
+}
+
+func TestUnresolvedOutputReference(t *testing.T) {
+
+	b := &astBuilder{}
+
+	// function get() returns (Missing m), Missing being declared nowhere
+	missing := b.node(`UserDefinedTypeName`, map[string]interface{}{`name`: `Missing`, `referencedDeclaration`: 9999, `type`: `struct Missing memory`})
+	get := b.function(`get`, nil, []node{b.param(`m`, missing)})
+	get.Src = `10:20:0`
+
+	_, e := extractProjectError(map[string]node{`Dangling.sol`: b.sourceUnit(`Dangling.sol`, b.contract(`Dangling`, get))})
+	err, ok := e.(*Error)
+	if !ok {
+		t.Fatalf(`expected *Error, have %#v`, e)
+	}
+	if expected := `Dangling.sol:Dangling.get (src 10:20:0): unresolved type reference in output 0 (m)`; err.Error() != expected {
+		t.Fatalf(`expected %q, have %q`, expected, err.Error())
+	}
+}
+
+func TestStructGetter(t *testing.T) {
+
+	b := &astBuilder{}
+
+	// struct Info { uint256 id; address owner; uint256[] list; mapping(uint256 => bool) seen; string name; bool active; }
+	info := b.node(`StructDefinition`, map[string]interface{}{`name`: `Info`, `canonicalName`: `Store.Info`, `visibility`: `public`},
+		b.param(`id`, b.elementary(`uint256`)),
+		b.param(`owner`, b.elementary(`address`)),
+		b.param(`list`, b.array(b.elementary(`uint256`), `uint256[] storage pointer`, nil)),
+		b.param(`seen`, b.node(`Mapping`, map[string]interface{}{`type`: `mapping(uint256 => bool)`}, b.elementary(`uint256`), b.elementary(`bool`))),
+		b.param(`name`, b.elementary(`string`)),
+		b.param(`active`, b.elementary(`bool`)),
+	)
+	infoType := func() node {
+		return b.node(`UserDefinedTypeName`, map[string]interface{}{`name`: `Info`, `referencedDeclaration`: info.Id, `type`: `struct Store.Info storage pointer`})
+	}
+	state := func(name string, typ node) node {
+		return b.node(`VariableDeclaration`, map[string]interface{}{
+			`name`:          name,
+			`type`:          typ.Attributes[`type`],
+			`constant`:      false,
+			`stateVariable`: true,
+			`visibility`:    `public`,
+		}, typ)
+	}
+	current := state(`current`, infoType())
+	infos := state(`infos`, b.node(`Mapping`, map[string]interface{}{`type`: `mapping(uint256 => struct Store.Info)`}, b.elementary(`uint256`), infoType()))
+
+	project := extractProject(t, map[string]node{`Store.sol`: b.sourceUnit(`Store.sol`, b.contract(`Store`, info, current, infos))})
+	api := project.Files[`Store.sol`][`Store`].API
+
+	for _, signature := range []string{`current()`, `infos(uint256)`} {
+		getter, ok := api[signature]
+		if !ok {
+			t.Fatalf(`expected getter %s, have %v`, signature, api)
+		}
+		outputs := []string{}
+		for _, output := range getter.Outputs {
+			outputs = append(outputs, string(output.SoliditySignature()))
+		}
+		if expected := []string{`uint256`, `address`, `string`, `bool`}; !reflect.DeepEqual(outputs, expected) {
+			t.Fatalf(`%s: expected outputs %v, have %v`, signature, expected, outputs)
+		}
+		if expected := []string{`id`, `owner`, `name`, `active`}; !reflect.DeepEqual(getter.OutputNames, expected) {
+			t.Fatalf(`%s: expected output names %v, have %v`, signature, expected, getter.OutputNames)
+		}
+	}
+
+	// what current() returns decodes without the mapping
+	code, _ := hex.DecodeString(`` +
+		`0000000000000000000000000000000000000000000000000000000000000007` +
+		`00000000000000000000000000000000000000000000000000000000000000aa` +
+		`0000000000000000000000000000000000000000000000000000000000000080` +
+		`0000000000000000000000000000000000000000000000000000000000000001` +
+		`0000000000000000000000000000000000000000000000000000000000000001` +
+		`7800000000000000000000000000000000000000000000000000000000000000`)
+	values, e := abi.DecodeAll(api[`current()`].Outputs, code)
+	if e != nil {
+		t.Fatal(e)
+	}
+	decoded, _ := json.Marshal(values)
+	if expected := `[7,"0x00000000000000000000000000000000000000aa","x",true]`; string(decoded) != expected {
+		t.Fatalf(`expected %s, have %s`, expected, decoded)
+	}
+}
+
+func TestConstants(t *testing.T) {
+
+	b := &astBuilder{}
+
+	constant := func(name, typ string, value node) node {
+		return b.node(`VariableDeclaration`, map[string]interface{}{
+			`name`:          name,
+			`type`:          typ,
+			`constant`:      true,
+			`stateVariable`: true,
+			`visibility`:    `public`,
+		}, b.elementary(typ), value)
+	}
+	ether := b.literal(`1`)
+	ether.Attributes[`subdenomination`] = `ether`
+	version := b.node(`Literal`, map[string]interface{}{`value`: `1.0`, `hexvalue`: `312e30`, `type`: `literal_string "1.0"`, `token`: `string`})
+	sum := b.node(`BinaryOperation`, map[string]interface{}{`operator`: `+`, `type`: `uint256`}, b.literal(`1`), b.literal(`2`))
+
+	project := extractProject(t, map[string]node{`Fees.sol`: b.sourceUnit(`Fees.sol`, b.contract(`Fees`,
+		constant(`FEE`, `uint256`, b.literal(`100`)),
+		constant(`VERSION`, `string`, version),
+		constant(`PAUSED`, `bool`, b.node(`Literal`, map[string]interface{}{`value`: `false`, `type`: `bool`, `token`: `false`})),
+		constant(`PRICE`, `uint256`, ether),
+		constant(`SUM`, `uint256`, sum),
+	))})
+	contract := project.Files[`Fees.sol`][`Fees`]
+
+	constants, e := json.Marshal(contract.Constants)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if expected := `{"FEE":100,"PAUSED":false,"VERSION":"1.0"}`; string(constants) != expected {
+		t.Fatalf(`expected constants %s, have %s`, expected, constants)
+	}
+	// non-literal constants are left to their getters
+	for _, getter := range []string{`FEE()`, `PRICE()`, `SUM()`} {
+		if _, ok := contract.API[getter]; !ok {
+			t.Fatalf(`expected getter %s, have %v`, getter, contract.API)
+		}
+	}
+}
+
+// largeCombined returns a combined.json of n source units with a contract of 20 functions each.
+func largeCombined(n int) []byte {
+	b := &astBuilder{}
+	sourceList, sources, contracts := []string{}, map[string]interface{}{}, map[string]interface{}{}
+	for i := 0; i < n; i++ {
+		path, name := fmt.Sprintf(`lib/C%d.sol`, i), fmt.Sprintf(`C%d`, i)
+		functions := []node{}
+		for j := 0; j < 20; j++ {
+			functions = append(functions, b.function(fmt.Sprintf(`f%d`, j), []node{b.param(`a`, b.elementary(`uint256`))}, nil))
+		}
+		sourceList = append(sourceList, path)
+		sources[path] = map[string]interface{}{`AST`: b.sourceUnit(path, b.contract(name, functions...))}
+		contracts[path+`:`+name] = map[string]string{`bin`: `6080`, `bin-runtime`: `6080`}
+	}
+	bs, _ := json.Marshal(map[string]interface{}{
+		`version`:    `0.4.24+commit.e67f0147.Linux.g++`,
+		`sourceList`: sourceList,
+		`sources`:    sources,
+		`contracts`:  contracts,
+		`unknown`:    map[string]interface{}{`skipped`: []int{1, 2}},
+	})
+	return bs
+}
+
+func TestStreamedCombined(t *testing.T) {
+	bs := largeCombined(10)
+
+	streamed, e := ast.DecodeCombined(bytes.NewReader(bs))
+	if e != nil {
+		t.Fatal(e)
+	}
+	buffered := ast.Combined{}
+	if e := json.Unmarshal(bs, &buffered); e != nil {
+		t.Fatal(e)
+	}
+	if !reflect.DeepEqual(streamed, buffered) {
+		t.Fatal(`streamed combined.json differs from buffered one`)
+	}
+
+	fromStreamed, e := Project(streamed)
+	if e != nil {
+		t.Fatal(e)
+	}
+	fromBuffered, e := Project(buffered)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if !reflect.DeepEqual(fromStreamed, fromBuffered) {
+		t.Fatal(`project of streamed combined.json differs from buffered one`)
+	}
+
+	if _, e := ast.DecodeCombined(bytes.NewReader(bs[:len(bs)/2])); e == nil {
+		t.Fatal(`expected error decoding truncated combined.json`)
+	}
+}
+
+func BenchmarkDecodeCombined(b *testing.B) {
+	bs := largeCombined(200)
+	b.Run(`streamed`, func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, e := ast.DecodeCombined(bytes.NewReader(bs)); e != nil {
+				b.Fatal(e)
+			}
+		}
+	})
+	b.Run(`buffered`, func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			read, _ := ioutil.ReadAll(bytes.NewReader(bs))
+			combined := ast.Combined{}
+			if e := json.Unmarshal(read, &combined); e != nil {
+				b.Fatal(e)
+			}
+		}
+	})
+}
+
+func TestSourceRanges(t *testing.T) {
+
+	b := &astBuilder{}
+
+	point := b.node(`StructDefinition`, map[string]interface{}{`name`: `Point`, `canonicalName`: `Shapes.Point`, `visibility`: `public`},
+		b.param(`x`, b.elementary(`uint256`)),
+		b.param(`y`, b.elementary(`uint256`)),
+	)
+	point.Src = `52:48:1`
+	move := b.function(`move`, []node{b.param(`dx`, b.elementary(`uint256`))}, nil)
+	move.Src = `106:71:1`
+	shapes := b.contract(`Shapes`, point, move)
+	shapes.Src = `25:160:1`
+
+	project := extractProject(t, map[string]node{`Shapes.sol`: b.sourceUnit(`Shapes.sol`, shapes)})
+	contract := project.Files[`Shapes.sol`][`Shapes`]
+
+	if source := contract.API[`move(uint256)`].Source(); source == nil || *source != (ast.SourceRange{Start: 106, Length: 71, FileIndex: 1}) {
+		t.Fatalf(`unexpected function source %+v`, source)
+	}
+	if source := contract.Source(); source == nil || *source != (ast.SourceRange{Start: 25, Length: 160, FileIndex: 1}) {
+		t.Fatalf(`unexpected contract source %+v`, source)
+	}
+	sources := contract.TypeSources()
+	if source := sources[`Point`]; len(sources) != 1 || source == nil || *source != (ast.SourceRange{Start: 52, Length: 48, FileIndex: 1}) {
+		t.Fatalf(`unexpected type sources %+v`, sources)
+	}
+}
+
+func TestColonPaths(t *testing.T) {
+
+	b := &astBuilder{}
+
+	shapes := func(member string) node {
+		point := b.node(`StructDefinition`, map[string]interface{}{`name`: `Point`, `canonicalName`: `Shapes.Point`, `visibility`: `public`},
+			b.param(member, b.elementary(`uint256`)),
+		)
+		return b.contract(`Shapes`, point, b.function(`get`, nil, nil))
+	}
+	units := map[string]node{
+		`C:/one/Shapes.sol`: b.sourceUnit(`C:/one/Shapes.sol`, shapes(`x`)),
+		`D:/two/Shapes.sol`: b.sourceUnit(`D:/two/Shapes.sol`, shapes(`y`)),
+	}
+	contracts := map[string]interface{}{
+		`C:/one/Shapes.sol:Shapes`: map[string]string{`bin`: `01`, `bin-runtime`: `11`},
+		`D:/two/Shapes.sol:Shapes`: map[string]string{`bin`: `02`, `bin-runtime`: `22`},
+	}
+	project, e := extractCombined(units, contracts)
+	if e != nil {
+		t.Fatal(e)
+	}
+	for path, expected := range map[string]struct{ bin, member string }{`C:/one/Shapes.sol`: {`01`, `x`}, `D:/two/Shapes.sol`: {`02`, `y`}} {
+		contract, ok := project.Files[path][`Shapes`]
+		if !ok {
+			t.Fatalf(`missing %s:Shapes in %v`, path, project.Files)
+		}
+		if hex.EncodeToString(contract.Binary) != expected.bin {
+			t.Fatalf(`%s: expected binary %s, have %x`, path, expected.bin, contract.Binary)
+		}
+		point, ok := contract.Types[`Point`].(types.Named)
+		if !ok || point.Name != path+`:Shapes.Point` {
+			t.Fatalf(`%s: unexpected struct %#v`, path, contract.Types[`Point`])
+		}
+		if strct, ok := point.Type.(types.Struct); !ok || !reflect.DeepEqual(strct.Keys, []string{expected.member}) {
+			t.Fatalf(`%s: unexpected struct %#v`, path, point.Type)
+		}
+	}
+}
+
+func TestUserDocDevDoc(t *testing.T) {
+
+	b := &astBuilder{}
+
+	transfer := b.function(`transfer`, []node{b.param(`to`, b.elementary(`address`)), b.param(`amount`, b.elementary(`uint256`))}, nil)
+	transfer.Attributes[`documentation`] = `@notice raw documentation, superseded`
+	units := map[string]node{`Token.sol`: b.sourceUnit(`Token.sol`, b.contract(`Token`, transfer, b.function(`burn`, nil, nil)))}
+
+	userdoc := `{"methods": {"transfer(address,uint256)": {"notice": "Sends amount tokens to to."}}, "notice": "A token."}`
+	devdoc := `{"author": "karma", "title": "Token", "custom:security-contact": "security@example.com",
+		"methods": {"transfer(address,uint256)": {"details": "Reverts if the balance is too low.", "params": {"to": "recipient", "amount": "in wei"}, "return": "success"}}}`
+
+	for name, docs := range map[string][2]interface{}{
+		`objects`: {json.RawMessage(userdoc), json.RawMessage(devdoc)},
+		`strings`: {userdoc, devdoc}, // as emitted by older solc versions
+	} {
+		project, e := extractCombined(units, map[string]interface{}{
+			`Token.sol:Token`: map[string]interface{}{`bin`: ``, `bin-runtime`: ``, `userdoc`: docs[0], `devdoc`: docs[1]},
+		})
+		if e != nil {
+			t.Fatalf(`%s: %s`, name, e)
+		}
+		contract := project.Files[`Token.sol`][`Token`]
+
+		expected := types.NatSpec{
+			Notice:  `Sends amount tokens to to.`,
+			Details: `Reverts if the balance is too low.`,
+			Params:  map[string]string{`to`: `recipient`, `amount`: `in wei`},
+			Return:  `success`,
+			Custom:  map[string]string{},
+		}
+		if docs := contract.API[`transfer(address,uint256)`].Docs; docs == nil || !reflect.DeepEqual(*docs, expected) {
+			t.Fatalf(`%s: expected %+v, have %+v`, name, expected, docs)
+		}
+		if tags := contract.API[`transfer(address,uint256)`].NatSpecTags(); !reflect.DeepEqual(tags, expected) {
+			t.Fatalf(`%s: expected docs to be preferred over the raw documentation, have %+v`, name, tags)
+		}
+		if docs := contract.API[`burn()`].Docs; docs != nil {
+			t.Fatalf(`%s: expected no docs for burn(), have %+v`, name, docs)
+		}
+
+		expected = types.NatSpec{
+			Title:  `Token`,
+			Author: `karma`,
+			Notice: `A token.`,
+			Params: map[string]string{},
+			Custom: map[string]string{`security-contact`: `security@example.com`},
+		}
+		if docs := contract.Docs; docs == nil || !reflect.DeepEqual(*docs, expected) {
+			t.Fatalf(`%s: expected %+v, have %+v`, name, expected, docs)
+		}
+	}
+}
+
+// inherits is an InheritanceSpecifier naming base, as in "contract X is base".
+func (b *astBuilder) inherits(base node) node {
+	name := base.Attributes[`name`].(string)
+	return b.node(`InheritanceSpecifier`, nil,
+		b.node(`UserDefinedTypeName`, map[string]interface{}{`name`: name, `referencedDeclaration`: base.Id, `type`: `contract ` + name}),
+	)
+}
+
+func TestDiamondInheritance(t *testing.T) {
+
+	// contract A {}, contract B is A {}, contract C is A {}, contract D is B, C {}
+	b := &astBuilder{}
+	a := b.contract(`A`, b.function(`a`, nil, nil))
+	bb := b.contractWithBases(`B`, []int{a.Id}, b.inherits(a))
+	c := b.contractWithBases(`C`, []int{a.Id}, b.inherits(a))
+	d := b.contractWithBases(`D`, []int{c.Id, bb.Id, a.Id}, b.inherits(bb), b.inherits(c))
+	project := extractProject(t, map[string]node{`Diamond.sol`: b.sourceUnit(`Diamond.sol`, a, bb, c, d)})
+
+	names := func(contracts []*types.Contract) []string {
+		out := make([]string, len(contracts))
+		for i, contract := range contracts {
+			out[i] = contract.Name
+		}
+		return out
+	}
+	for name, expected := range map[string]struct{ bases, parents []string }{
+		`A`: {[]string{}, []string{}},
+		`B`: {[]string{`A`}, []string{`A`}},
+		`C`: {[]string{`A`}, []string{`A`}},
+		`D`: {[]string{`B`, `C`}, []string{`C`, `B`, `A`}}, // declaration order vs linearization
+	} {
+		contract := project.Files[`Diamond.sol`][name]
+		if contract == nil {
+			t.Fatalf(`missing contract %s`, name)
+		}
+		if bases := names(contract.Bases); !reflect.DeepEqual(bases, expected.bases) {
+			t.Fatalf(`%s: expected bases %v, have %v`, name, expected.bases, bases)
+		}
+		if parents := names(contract.Parents); !reflect.DeepEqual(parents, expected.parents) {
+			t.Fatalf(`%s: expected parents %v, have %v`, name, expected.parents, parents)
+		}
+	}
+}
+
+func TestSignatureFromABI(t *testing.T) {
+	for _, vector := range []struct{ fragment, signature, selector string }{
+		{
+			`{"constant":true,"inputs":[{"components":[{"name":"owner","type":"address"},{"name":"amounts","type":"uint256[]"}],"name":"orders","type":"tuple[2]"},{"name":"hash","type":"bytes32"}],"name":"fill","outputs":[{"name":"","type":"uint256"}],"payable":false,"stateMutability":"view","type":"function"}`,
+			`fill((address,uint256[])[2],bytes32)`, `76ea17b0`,
+		},
+		{
+			`{"type":"function","name":"submit","inputs":[{"name":"batch","type":"tuple","components":[{"name":"nonce","type":"uint256"},{"name":"calls","type":"tuple[]","components":[{"name":"to","type":"address"},{"name":"data","type":"bytes"}]}]},{"name":"strict","type":"bool"}],"outputs":[]}`,
+			`submit((uint256,(address,bytes)[]),bool)`, `7e4c983e`,
+		},
+		{
+			`{"name":"transfer","inputs":[{"name":"to","type":"address"},{"name":"value","type":"uint256"}]}`, // type defaults to function
+			`transfer(address,uint256)`, `a9059cbb`,
+		},
+	} {
+		signature, sel, e := SignatureFromABI(json.RawMessage(vector.fragment))
+		if e != nil {
+			t.Fatalf(`%s: %s`, vector.signature, e)
+		}
+		if signature != vector.signature {
+			t.Fatalf(`expected %s, have %s`, vector.signature, signature)
+		}
+		if hex.EncodeToString(sel[:]) != vector.selector || vector.selector != selector(signature) {
+			t.Fatalf(`%s: expected selector %s, have %x`, signature, vector.selector, sel)
+		}
+	}
+	for _, invalid := range []string{`{"type":"event","name":"Transfer","inputs":[]}`, `{"type":"function","inputs":[]}`, `[]`} {
+		if _, _, e := SignatureFromABI(json.RawMessage(invalid)); e == nil {
+			t.Fatalf(`%s: expected error`, invalid)
+		}
+	}
+}
+
+func TestCompilerVersion(t *testing.T) {
+	b := &astBuilder{}
+	project := extractProject(t, map[string]node{`Token.sol`: b.sourceUnit(`Token.sol`, b.contract(`Token`))})
+	if version := project.Files[`Token.sol`][`Token`].CompilerVersion; version != `0.4.24+commit.e67f0147.Linux.g++` {
+		t.Fatalf(`expected the version reported in combined.json, have %q`, version)
+	}
+}
+
+func TestLegacyAndCompactASTs(t *testing.T) {
+
+	b := &astBuilder{}
+	token := b.contract(`Token`, b.function(`transfer`, []node{b.param(`to`, b.elementary(`address`)), b.param(`value`, b.elementary(`uint256`))}, nil))
+	legacy, e := legacyCombined(map[string]node{`contracts/Token.sol`: b.sourceUnit(`contracts/Token.sol`, token)}, map[string]interface{}{})
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	// handwritten after solc 0.8.19 --combined-json ast,bin
+	bs, e := ioutil.ReadFile(`testdata/compact.json`)
+	if e != nil {
+		t.Fatal(e)
+	}
+	compact, e := ast.DecodeCombined(bytes.NewReader(bs))
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	project, e := Projects(legacy, compact)
+	if e != nil {
+		t.Fatal(e)
+	}
+	for file, versions := range map[string]map[string]string{
+		`Token.sol`: {`Token`: `0.4.24+commit.e67f0147.Linux.g++`},
+		`Vault.sol`: {`Ownable`: `0.8.19+commit.7dd6d404.Linux.g++`, `Vault`: `0.8.19+commit.7dd6d404.Linux.g++`},
+	} {
+		for name, version := range versions {
+			contract := project.Files[file][name]
+			if contract == nil {
+				t.Fatalf(`missing contract %s in %s, have %v`, name, file, project.Files)
+			}
+			if contract.CompilerVersion != version {
+				t.Fatalf(`%s: expected version %s, have %s`, name, version, contract.CompilerVersion)
+			}
+		}
+	}
+	if _, ok := project.Files[`Token.sol`][`Token`].API[`transfer(address,uint256)`]; !ok {
+		t.Fatalf(`expected transfer(address,uint256), have %v`, project.Files[`Token.sol`][`Token`].API)
+	}
+	if _, ok := project.Files[`Vault.sol`][`Ownable`].API[`owner()`]; !ok {
+		t.Fatalf(`expected owner(), have %v`, project.Files[`Vault.sol`][`Ownable`].API)
+	}
+
+	vault := project.Files[`Vault.sol`][`Vault`]
+	signatures := []string{}
+	for signature := range vault.API {
+		signatures = append(signatures, signature)
+	}
+	sort.Strings(signatures)
+	if expected := []string{`FEE()`, `balances(address)`, `deposit(uint256)`, `hook(function)`, `recent(uint256)`, `state()`}; !reflect.DeepEqual(signatures, expected) {
+		t.Fatalf(`expected API %v, have %v`, expected, signatures)
+	}
+
+	deposit := vault.API[`deposit(uint256)`]
+	if deposit.StateMutability != ast.StateMutabilityPayable || !reflect.DeepEqual(deposit.Modifiers, []string{`onlyOwner`}) {
+		t.Fatalf(`expected payable deposit with onlyOwner, have %s %v`, deposit.StateMutability, deposit.Modifiers)
+	}
+	if !reflect.DeepEqual(deposit.InputNames, []string{`amount`}) || !reflect.DeepEqual(deposit.OutputNames, []string{`ok`}) {
+		t.Fatalf(`expected deposit(amount) returns (ok), have %v %v`, deposit.InputNames, deposit.OutputNames)
+	}
+	if !strings.Contains(deposit.NatSpec, `@notice Deposits amount.`) || !strings.Contains(vault.NatSpec, `@title Vault`) {
+		t.Fatalf(`expected NatSpec from StructuredDocumentation, have %q and %q`, deposit.NatSpec, vault.NatSpec)
+	}
+	if vault.Constructor == nil || len(vault.Constructor.Inputs) != 1 || string(vault.Constructor.Inputs[0].SoliditySignature()) != `uint256` {
+		t.Fatalf(`expected constructor(uint256), have %v`, vault.Constructor)
+	}
+
+	recent := vault.API[`recent(uint256)`]
+	if expected := []string{`owner`, `amount`}; !reflect.DeepEqual(recent.OutputNames, expected) {
+		t.Fatalf(`recent: expected outputs %v, have %v`, expected, recent.OutputNames)
+	}
+	if state := vault.API[`state()`]; len(state.Outputs) != 1 || string(state.Outputs[0].SoliditySignature()) != `uint8` {
+		t.Fatalf(`state: expected an enum output, have %v`, state.Outputs)
+	}
+	for _, name := range []string{`Deposit`, `State`, `Deposited`} {
+		if _, ok := vault.Types[name]; !ok {
+			t.Fatalf(`missing type %s, have %v`, name, vault.Types)
+		}
+	}
+
+	constants, _ := json.Marshal(vault.Constants)
+	if string(constants) != `{"FEE":100}` {
+		t.Fatalf(`expected constants {"FEE":100}, have %s`, constants)
+	}
+	if len(vault.Parents) != 1 || vault.Parents[0].Name != `Ownable` || len(vault.Bases) != 1 || vault.Bases[0].Name != `Ownable` {
+		t.Fatalf(`expected Ownable as parent and base, have %v %v`, vault.Parents, vault.Bases)
+	}
+	if hex.EncodeToString(vault.Binary) != `60806040` {
+		t.Fatalf(`expected binary 60806040, have %x`, vault.Binary)
+	}
+
+	topic := [32]byte{}
+	copy(topic[:], crypto.Keccak256([]byte(`Deposited(address,uint256)`)))
+	if events := project.EventTopics[topic]; len(events) != 1 || !reflect.DeepEqual(events[0].Indexed, []bool{true, false}) {
+		t.Fatalf(`expected Deposited(address indexed, uint256), have %v`, events)
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2018 karma.run AG. All rights reserved.
+
+package extract // import "github.com/karmarun/karma.link/ast/extract"
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/karmarun/karma.link/types"
+	"strings"
+)
+
+// natSpecDoc is the union of the fields of solc's userdoc and devdoc outputs, at contract or method level.
+type natSpecDoc struct {
+	Title   string                     `json:"title"`
+	Author  string                     `json:"author"`
+	Notice  string                     `json:"notice"`
+	Details string                     `json:"details"`
+	Params  map[string]string          `json:"params"`
+	Return  string                     `json:"return"`
+	Methods map[string]json.RawMessage `json:"methods"` // contract level only, signature or "constructor" -> natSpecDoc
+}
+
+// Docs merges solc's userdoc and devdoc outputs of a contract (either may be empty) into structured NatSpec
+// for the contract and its methods, keyed by signature or "constructor". Custom tags are read from "custom:<name>" keys.
+func Docs(userdoc, devdoc json.RawMessage) (*types.NatSpec, map[string]*types.NatSpec, error) {
+	if isEmptyDoc(userdoc) && isEmptyDoc(devdoc) {
+		return nil, nil, nil
+	}
+	contract, methods := newNatSpec(), make(map[string]*types.NatSpec, 16)
+	for _, doc := range []json.RawMessage{userdoc, devdoc} {
+		if isEmptyDoc(doc) {
+			continue
+		}
+		raw, e := unquoteDoc(doc)
+		if e != nil {
+			return nil, nil, e
+		}
+		parsed := natSpecDoc{}
+		if e := mergeDoc(contract, raw, &parsed); e != nil {
+			return nil, nil, e
+		}
+		for signature, method := range parsed.Methods {
+			if methods[signature] == nil {
+				methods[signature] = newNatSpec()
+			}
+			if e := mergeDoc(methods[signature], method, &natSpecDoc{}); e != nil {
+				return nil, nil, fmt.Errorf(`%s: %s`, signature, e)
+			}
+		}
+	}
+	return contract, methods, nil
+}
+
+func newNatSpec() *types.NatSpec {
+	return &types.NatSpec{Params: map[string]string{}, Custom: map[string]string{}}
+}
+
+// mergeDoc decodes raw into doc and copies its non-empty fields into spec.
+func mergeDoc(spec *types.NatSpec, raw json.RawMessage, doc *natSpecDoc) error {
+	if e := json.Unmarshal(raw, doc); e != nil {
+		return fmt.Errorf(`invalid userdoc/devdoc: %s`, e)
+	}
+	for _, field := range []struct {
+		target *string
+		value  string
+	}{
+		{&spec.Title, doc.Title},
+		{&spec.Author, doc.Author},
+		{&spec.Notice, doc.Notice},
+		{&spec.Details, doc.Details},
+		{&spec.Return, doc.Return},
+	} {
+		if field.value != "" {
+			*field.target = field.value
+		}
+	}
+	for name, description := range doc.Params {
+		spec.Params[name] = description
+	}
+	members := make(map[string]json.RawMessage, 8)
+	json.Unmarshal(raw, &members) // can't fail after the above
+	for key, value := range members {
+		if !strings.HasPrefix(key, `custom:`) {
+			continue
+		}
+		text := ""
+		if e := json.Unmarshal(value, &text); e != nil {
+			return fmt.Errorf(`invalid %s: %s`, key, e)
+		}
+		spec.Custom[key[len(`custom:`):]] = text
+	}
+	return nil
+}
+
+// unquoteDoc returns doc as a JSON object. Older solc versions emit it as a string holding the JSON.
+func unquoteDoc(doc json.RawMessage) (json.RawMessage, error) {
+	if !strings.HasPrefix(strings.TrimSpace(string(doc)), `"`) {
+		return doc, nil
+	}
+	s := ""
+	if e := json.Unmarshal(doc, &s); e != nil {
+		return nil, fmt.Errorf(`invalid userdoc/devdoc: %s`, e)
+	}
+	return json.RawMessage(s), nil
+}
+
+func isEmptyDoc(doc json.RawMessage) bool {
+	s := strings.TrimSpace(string(doc))
+	return s == "" || s == `null` || s == `""`
+}
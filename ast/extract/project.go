@@ -7,7 +7,6 @@ import (
 	"fmt"
 	"github.com/karmarun/karma.link/ast"
 	"github.com/karmarun/karma.link/types"
-	"strings"
 )
 
 // Project extracts an entire smart contract project's combined type information and structure.
@@ -15,34 +14,72 @@ import (
 // E.g. files "a/x/b.sol" and "a/c.sol" will be referenced as "x/b.sol" and "c.sol", respectively.
 // TODO: Windows support: normalize paths to forward slashes without drive letters, etc.
 func Project(combined ast.Combined) (types.Project, error) {
+	return Projects(combined)
+}
+
+// Projects extracts a single project from several combined.json's, e.g. of contracts compiled with different solc
+// versions, whose ASTs may be in the legacy or the compact format. Paths are relative to the longest path prefix
+// shared by all of their source units. A file found in several of them (e.g. a common dependency) is taken from the first.
+func Projects(combined ...ast.Combined) (types.Project, error) {
 
 	lpp := longestPathPrefix{}
-	for _, path := range combined.SourceList {
-		lpp.Observe(path)
+	for _, c := range combined {
+		for _, path := range c.SourceList {
+			lpp.Observe(path)
+		}
+	}
+
+	project := types.Project{Files: make(map[string]map[string]*types.Contract, 16)}
+	project.Path, _ = lpp.Prefix()
+	for _, c := range combined {
+		files, e := projectFiles(c, &lpp)
+		if e != nil {
+			return types.Project{}, e
+		}
+		for path, contracts := range files {
+			if _, ok := project.Files[path]; !ok {
+				project.Files[path] = contracts
+			}
+		}
 	}
+	project.EventTopics = ProjectEventTopics(project)
+
+	return project, nil
+
+}
+
+// projectFiles extracts the contracts of a single combined.json by file, with paths relative to lpp's prefix.
+// NOTE: node ids, and thereby type references, are only unique within a single compilation.
+func projectFiles(combined ast.Combined, lpp *longestPathPrefix) (map[string]map[string]*types.Contract, error) {
 
 	typeMap, sourceUnits := make(types.Map, 128), make(map[string]ast.SourceUnit, len(combined.Sources))
 	for path, source := range combined.Sources {
 		path = lpp.RemovePrefix(path)
 		unserialized, e := ast.UnserializeJSON(source.AST)
 		if e != nil {
-			return types.Project{}, e
+			return nil, inContext(e, path, "", "")
+		}
+		sourceUnit, ok := unserialized.(ast.SourceUnit)
+		if !ok {
+			return nil, inContext(fmt.Errorf(`expected SourceUnit, have %s`, unserialized.Header().Name), path, "", "")
 		}
-		sourceUnit := unserialized.(ast.SourceUnit)
 		sourceUnits[path] = sourceUnit
 		ts, e := Types(path, sourceUnit)
 		if e != nil {
-			return types.Project{}, e
+			return nil, inContext(e, path, "", "")
 		}
 		for id, typ := range ts {
 			typeMap[id] = typ
 		}
 	}
 
-	{ // resolve all type references
+	{ // resolve all type references, leaving dangling ones for validateContract to report
 		var resolve func(t types.Type) types.Type
 		resolve = func(t types.Type) types.Type {
 			if ref, ok := t.(types.Reference); ok {
+				if _, ok := typeMap[ref]; !ok {
+					return t
+				}
 				return typeMap.Deref(ref).Map(resolve)
 			}
 			return t
@@ -52,6 +89,15 @@ func Project(combined ast.Combined) (types.Project, error) {
 		}
 	}
 
+	compiledContracts := make(map[types.Key]ast.CompiledContract, len(combined.Contracts))
+	for id, compiled := range combined.Contracts {
+		key, e := types.ParseKey(id) // "path:Name" as reported by solc
+		if e != nil {
+			return nil, &Error{Message: fmt.Sprintf(`invalid contract in combined.json: %s`, e)}
+		}
+		compiledContracts[key] = compiled
+	}
+
 	contractMap := make(map[int]*types.Contract, len(sourceUnits)*2)
 
 	for path, sourceUnit := range sourceUnits {
@@ -61,66 +107,113 @@ func Project(combined ast.Combined) (types.Project, error) {
 		for _, contractDefinition := range contractDefinitions {
 			functions, e := ContractAPI(contractDefinition, typeMap)
 			if e != nil {
-				return types.Project{}, e
+				return nil, inContext(e, path, contractDefinition.Name, "")
 			}
+			constructor, e := ContractConstructor(contractDefinition, typeMap)
+			if e != nil {
+				return nil, inContext(e, path, contractDefinition.Name, "")
+			}
+			variables := ContractVariables(contractDefinition, typeMap)
 			api := make(map[string]types.Function, len(functions))
 			for _, function := range functions {
 				api[string(function.SoliditySignature())] = function
 			}
-			bin := []byte(nil)
-			if compiled, ok := combined.Contracts[lpp.PrependPrefix(path)+`:`+contractDefinition.Name]; ok {
+			bin, binRuntime, docs := []byte(nil), []byte(nil), (*types.NatSpec)(nil)
+			if compiled, ok := compiledContracts[types.Key{File: lpp.PrependPrefix(path), Contract: contractDefinition.Name}]; ok {
 				bs, e := hex.DecodeString(compiled.Binary)
 				if e != nil {
-					return types.Project{}, fmt.Errorf(`invalid binary in contract: %s`, path)
+					return nil, &Error{File: path, Contract: contractDefinition.Name, Message: `invalid binary`}
 				}
 				bin = bs
+				bs, e = hex.DecodeString(compiled.RuntimeBinary)
+				if e != nil {
+					return nil, &Error{File: path, Contract: contractDefinition.Name, Message: `invalid runtime binary`}
+				}
+				binRuntime = bs
+				contractDocs, methodDocs, e := Docs(compiled.UserDoc, compiled.DevDoc)
+				if e != nil {
+					return nil, &Error{File: path, Contract: contractDefinition.Name, Message: e.Error()}
+				}
+				docs = contractDocs
+				for signature, function := range api {
+					function.Docs = methodDocs[signature]
+					api[signature] = function
+				}
+				if constructor != nil {
+					constructor.Docs = methodDocs[`constructor`]
+				}
 			}
 			contractMap[contractDefinition.Header().Id] = &types.Contract{
-				File:       path,
-				Name:       contractDefinition.Name,
-				Parents:    make([]*types.Contract, 0, len(contractDefinition.LinearizedBaseContracts)-1), // NOTE: filled below
-				Types:      make(map[string]types.Type, 16),                                               // idem
-				NatSpec:    contractDefinition.Documentation,
-				Kind:       contractDefinition.ContractKind,
-				API:        api,
-				Definition: contractDefinition,
-				Binary:     bin,
+				File:            path,
+				Name:            contractDefinition.Name,
+				Parents:         make([]*types.Contract, 0, len(contractDefinition.LinearizedBaseContracts)-1), // NOTE: filled below
+				Bases:           make([]*types.Contract, 0, 4),                                                 // idem
+				Types:           make(map[string]types.Type, 16),                                               // idem
+				NatSpec:         contractDefinition.Documentation,
+				Docs:            docs,
+				Kind:            contractDefinition.ContractKind,
+				API:             api,
+				Constructor:     constructor,
+				Variables:       variables,
+				Constants:       ContractConstants(variables),
+				Definition:      contractDefinition,
+				Binary:          bin,
+				RuntimeBinary:   binRuntime,
+				CompilerVersion: combined.Version,
 			}
 		}
 
 	}
 
-	project := types.Project{
-		Path:  "",
-		Files: make(map[string]map[string]*types.Contract, len(contractMap)),
-	}
-
-	project.Path, _ = lpp.Prefix()
+	files := make(map[string]map[string]*types.Contract, len(contractMap))
 
 	for _, contract := range contractMap {
 		// NOTE: first element of contract.Definition.LinearizedBaseContracts is own ID
 		for _, parentId := range contract.Definition.LinearizedBaseContracts[1:] {
 			parent, ok := contractMap[parentId]
 			if !ok {
-				return types.Project{}, fmt.Errorf(`missing contract parent definition`)
+				return nil, &Error{File: contract.File, Contract: contract.Name, Message: fmt.Sprintf(`missing contract parent definition: %d`, parentId)}
 			}
 			contract.Parents = append(contract.Parents, parent)
 		}
-		for _, typ := range typeMap {
-			if named, ok := typ.(types.Named); ok && strings.HasPrefix(named.Name, (contract.File+":"+contract.Name)) {
-				typeName := named.Name[strings.LastIndex(named.Name, `.`)+1:]
-				contract.Types[typeName] = named
+		for _, child := range contract.Definition.Children() {
+			switch definition := child.(type) {
+			case ast.InheritanceSpecifier:
+				// NOTE: first child is the base's name, followed by constructor arguments, if any
+				name, ok := definition.Children()[0].(ast.UserDefinedTypeName)
+				if !ok {
+					return nil, &Error{File: contract.File, Contract: contract.Name, Message: fmt.Sprintf(`unexpected inheritance specifier: %T`, definition.Children()[0])}
+				}
+				base, ok := contractMap[name.ReferencedDeclaration]
+				if !ok {
+					return nil, &Error{File: contract.File, Contract: contract.Name, Message: fmt.Sprintf(`missing base contract definition: %d`, name.ReferencedDeclaration)}
+				}
+				contract.Bases = append(contract.Bases, base)
+			case ast.StructDefinition:
+				contract.Types[definition.Name] = typeMap.Deref(types.Reference(definition.Header().Id))
+			case ast.EnumDefinition:
+				contract.Types[definition.Name] = typeMap.Deref(types.Reference(definition.Header().Id))
+			case ast.EventDefinition:
+				contract.Types[definition.Name] = typeMap.Deref(types.Reference(definition.Header().Id))
 			}
 		}
-		contracts := project.Files[contract.File]
+		if e := validateContract(contract); e != nil {
+			return nil, inContext(e, contract.File, contract.Name, "")
+		}
+		contracts := files[contract.File]
 		if contracts == nil {
 			contracts = make(map[string]*types.Contract, 8)
 		}
 		contracts[contract.Name] = contract
-		project.Files[contract.File] = contracts
+		files[contract.File] = contracts
 	}
 
-	return project, nil
+	// NOTE: needs the types of all parents, so can't be done above
+	for _, contract := range contractMap {
+		contract.EventTopics = EventTopics(contract)
+	}
+
+	return files, nil
 
 }
 
@@ -0,0 +1,101 @@
+// Copyright 2018 karma.run AG. All rights reserved.
+
+package extract // import "github.com/karmarun/karma.link/ast/extract"
+
+import (
+	"fmt"
+	"github.com/karmarun/karma.link/ast"
+	"github.com/karmarun/karma.link/types"
+	"sort"
+)
+
+// validateContract checks that no types.Reference survived resolution in a contract's
+// functions, constructor, state variables and declared types.
+func validateContract(contract *types.Contract) error {
+	signatures := make([]string, 0, len(contract.API))
+	for signature := range contract.API {
+		signatures = append(signatures, signature)
+	}
+	sort.Strings(signatures)
+	for _, signature := range signatures {
+		if e := validateFunction(contract.API[signature]); e != nil {
+			return e
+		}
+	}
+	if contract.Constructor != nil {
+		if e := validateFunction(*contract.Constructor); e != nil {
+			return e
+		}
+	}
+	for _, variable := range contract.Variables {
+		if hasReference(variable.Type) {
+			return &Error{Source: variable.Definition.Header().Source, Message: fmt.Sprintf(`unresolved type reference in state variable %s`, variable.Name)}
+		}
+	}
+	names := make([]string, 0, len(contract.Types))
+	for name := range contract.Types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if hasReference(contract.Types[name]) {
+			return &Error{Message: fmt.Sprintf(`unresolved type reference in type %s`, name)}
+		}
+	}
+	return nil
+}
+
+func validateFunction(function types.Function) error {
+	for _, params := range []struct {
+		kind  string
+		index int
+		types []types.Type
+	}{{`input`, 0, function.Inputs}, {`output`, 1, function.Outputs}} {
+		for i, typ := range params.types {
+			if !hasReference(typ) {
+				continue
+			}
+			e := &Error{Function: function.Name, Message: fmt.Sprintf(`unresolved type reference in %s %d`, params.kind, i)}
+			if function.Definition != nil {
+				e.Source = function.Definition.Header().Source
+				if name := parameterName(function.Definition, params.index, i); name != "" {
+					e.Message += ` (` + name + `)`
+				}
+			}
+			return e
+		}
+	}
+	return nil
+}
+
+// parameterName returns the name of the i-th parameter in a function definition's list-th parameter list, if known.
+func parameterName(definition ast.Node, list, i int) string {
+	if _, ok := definition.(ast.FunctionDefinition); !ok {
+		return "" // e.g. state variable getters
+	}
+	children := definition.Children()
+	if list >= len(children) {
+		return ""
+	}
+	paramList, ok := children[list].(ast.ParameterList)
+	if !ok || i >= len(paramList.Children()) {
+		return ""
+	}
+	variableDeclaration, ok := paramList.Children()[i].(ast.VariableDeclaration)
+	if !ok {
+		return ""
+	}
+	return variableDeclaration.Name
+}
+
+// hasReference reports whether typ contains a types.Reference.
+func hasReference(typ types.Type) bool {
+	found := false
+	typ.Map(func(t types.Type) types.Type {
+		if _, ok := t.(types.Reference); ok {
+			found = true
+		}
+		return t
+	})
+	return found
+}
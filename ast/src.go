@@ -0,0 +1,42 @@
+// Copyright 2018 karma.run AG. All rights reserved.
+
+package ast // import "github.com/karmarun/karma.link/ast"
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SourceRange locates a node in the source files of a compilation, as given by its src attribute.
+type SourceRange struct {
+	Start     int `json:"start"`     // byte offset
+	Length    int `json:"length"`    // in bytes
+	FileIndex int `json:"fileIndex"` // index into Combined.SourceList, -1 for compiler-generated code
+}
+
+// ParseSrc parses a src attribute of the form "start:length:fileIndex".
+func ParseSrc(src string) (start, length, fileIndex int, err error) {
+	parts := strings.Split(src, `:`)
+	if len(parts) != 3 {
+		return 0, 0, 0, fmt.Errorf(`invalid src %q, expected start:length:fileIndex`, src)
+	}
+	ns := [3]int{}
+	for i, part := range parts {
+		n, e := strconv.Atoi(part)
+		if e != nil || n < -1 || (n == -1 && i != 2) {
+			return 0, 0, 0, fmt.Errorf(`invalid src %q, expected start:length:fileIndex`, src)
+		}
+		ns[i] = n
+	}
+	return ns[0], ns[1], ns[2], nil
+}
+
+// Range returns the parsed src attribute of a node, or nil if it's missing or malformed.
+func (h Header) Range() *SourceRange {
+	start, length, fileIndex, e := ParseSrc(h.Source)
+	if e != nil {
+		return nil
+	}
+	return &SourceRange{Start: start, Length: length, FileIndex: fileIndex}
+}
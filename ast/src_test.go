@@ -0,0 +1,38 @@
+// Copyright 2018 karma.run AG. All rights reserved.
+
+package ast
+
+import (
+	"testing"
+)
+
+func TestParseSrc(t *testing.T) {
+	for src, expected := range map[string][3]int{
+		`0:0:0`:        {0, 0, 0},
+		`1024:356:2`:   {1024, 356, 2},
+		`120:14:-1`:    {120, 14, -1},
+		`98765:4321:0`: {98765, 4321, 0},
+	} {
+		start, length, fileIndex, e := ParseSrc(src)
+		if e != nil {
+			t.Fatalf(`%s: %s`, src, e)
+		}
+		if have := [3]int{start, length, fileIndex}; have != expected {
+			t.Fatalf(`%s: expected %v, have %v`, src, expected, have)
+		}
+	}
+	for _, src := range []string{``, `1:2`, `1:2:3:4`, `a:2:3`, `-1:2:3`, `1:-1:3`, `1:2:-2`, ` 1:2:3`} {
+		if _, _, _, e := ParseSrc(src); e == nil {
+			t.Fatalf(`expected an error parsing %q`, src)
+		}
+	}
+}
+
+func TestHeaderRange(t *testing.T) {
+	if r := (Header{Source: `10:20:1`}).Range(); r == nil || *r != (SourceRange{Start: 10, Length: 20, FileIndex: 1}) {
+		t.Fatalf(`unexpected range %+v`, r)
+	}
+	if r := (Header{}).Range(); r != nil {
+		t.Fatalf(`expected nil range for a missing src, have %+v`, r)
+	}
+}
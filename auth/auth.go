@@ -10,6 +10,7 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
 	"math/big"
+	"sort"
 	"sync"
 	"time"
 )
@@ -48,6 +49,17 @@ type Authenticator interface {
 	ExchangeToken(token json.RawMessage) (*Key, error)
 }
 
+// CredentialSchemer is an optional interface implemented by authentication providers
+// that describe the credentials they accept.
+type CredentialSchemer interface {
+
+	// CredentialSchema returns a JSON Schema describing the credentials structure expected by Authenticate.
+	CredentialSchema() json.RawMessage
+}
+
+// emptySchema is the JSON Schema that accepts anything.
+var emptySchema = json.RawMessage(`{}`)
+
 var registered = &sync.Map{}
 
 // RegisterAuthenticator registers an authenticator under the given name.
@@ -58,6 +70,17 @@ func RegisterAuthenticator(name string, implementation Authenticator) {
 	}
 }
 
+// RegisteredNames returns the names of all registered authenticators in lexical order.
+func RegisteredNames() []string {
+	names := make([]string, 0, 4)
+	registered.Range(func(name, _ interface{}) bool {
+		names = append(names, name.(string))
+		return true
+	})
+	sort.Strings(names)
+	return names
+}
+
 // Authenticate uses the Authenticator registered as name to authenticate credentials.
 // It panics if there is no Authenticator registered under name.
 func Authenticate(name string, credentials json.RawMessage) (json.RawMessage, error) {
@@ -68,6 +91,19 @@ func Authenticate(name string, credentials json.RawMessage) (json.RawMessage, er
 	return authenticator.(Authenticator).Authenticate(credentials)
 }
 
+// Schema returns the credentials JSON Schema of the Authenticator registered as name.
+// Authenticators that don't implement CredentialSchemer yield the empty schema.
+func Schema(name string) (json.RawMessage, error) {
+	authenticator, ok := registered.Load(name)
+	if !ok {
+		return nil, fmt.Errorf(`no authenticator registered with name: %s`, name)
+	}
+	if schemer, ok := authenticator.(CredentialSchemer); ok {
+		return schemer.CredentialSchema(), nil
+	}
+	return emptySchema, nil
+}
+
 // ExchangeToken uses the Authenticator registered as name to exchange token for a key.
 // It panics if there is no Authenticator registered under name.
 func ExchangeToken(name string, token json.RawMessage) (*Key, error) {
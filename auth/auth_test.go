@@ -0,0 +1,54 @@
+// Copyright 2018 karma.run AG. All rights reserved.
+
+package auth
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+type nopAuthenticator struct{}
+
+func (nopAuthenticator) Authenticate(json.RawMessage) (json.RawMessage, error) { return nil, nil }
+func (nopAuthenticator) RenewToken(json.RawMessage) (json.RawMessage, error)   { return nil, nil }
+func (nopAuthenticator) ExchangeToken(json.RawMessage) (*Key, error)           { return nil, nil }
+
+func init() {
+	RegisterAuthenticator(`test-b`, nopAuthenticator{})
+	RegisterAuthenticator(`test-a`, nopAuthenticator{})
+	RegisterAuthenticator(`test-schemaless`, nopAuthenticator{})
+}
+
+func TestRegisteredNames(t *testing.T) {
+	names, listed := RegisteredNames(), 0
+	for i, name := range names {
+		if name == `test-a` || name == `test-b` {
+			listed++
+		}
+		if i > 0 && names[i-1] >= name {
+			t.Fatalf(`names not in lexical order: %v`, names)
+		}
+	}
+	if listed != 2 {
+		t.Fatalf(`expected test-a and test-b in %v`, names)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatalf(`expected duplicate registration to panic`)
+		}
+	}()
+	RegisterAuthenticator(`test-a`, nopAuthenticator{})
+}
+
+func TestSchema(t *testing.T) {
+	schema, e := Schema(`test-schemaless`)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if string(schema) != `{}` {
+		t.Fatalf(`expected empty schema, have %s`, schema)
+	}
+	if _, e := Schema(`test-unregistered`); e == nil {
+		t.Fatalf(`expected error for unregistered authenticator`)
+	}
+}
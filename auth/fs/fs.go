@@ -3,19 +3,24 @@
 package fs // import "github.com/karmarun/karma.link/auth/fs"
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/karmarun/karma.link/auth"
 	"github.com/karmarun/karma.link/config"
 	"io/ioutil"
-	"log"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 )
 
-var logger = log.New(config.LogWriter, `auth/fs`, config.LogFlags)
+var logger = config.NewLogger(`auth/fs`)
 
 const (
 	tokenExpiration = (15 * time.Minute)
@@ -25,7 +30,8 @@ const (
 type Folder string
 
 var (
-	_ auth.Authenticator = Folder("")
+	_ auth.Authenticator     = Folder("")
+	_ auth.CredentialSchemer = Folder("")
 )
 
 // Credentials is the authentication JSON structure used in Folder.Authenticate
@@ -34,6 +40,16 @@ type Credentials struct {
 	Passphrase string   `json:"passphrase"`
 }
 
+// credentialSchema is the JSON Schema of Credentials.
+const credentialSchema = `{
+	"type": "object",
+	"properties": {
+		"filepath": {"type": "array", "items": {"type": "string"}},
+		"passphrase": {"type": "string"}
+	},
+	"required": ["filepath", "passphrase"]
+}`
+
 // Token represents the carrier token structure returned by Folder.Authenticate
 type Token struct {
 	Secret  []byte    `json:"secret"`
@@ -100,6 +116,85 @@ func (f Folder) Authenticate(credentials json.RawMessage) (json.RawMessage, erro
 	return bs, nil
 }
 
+// ScryptParams are the parameters of the scrypt key derivation protecting keystore files created by CreateAccount.
+// Higher values are more secure, but take more time and memory to unlock, see keystore.StandardScryptN.
+type ScryptParams struct {
+	N int
+	P int
+}
+
+var (
+	StandardScrypt = ScryptParams{N: keystore.StandardScryptN, P: keystore.StandardScryptP}
+	LightScrypt    = ScryptParams{N: keystore.LightScryptN, P: keystore.LightScryptP}
+)
+
+// ParseScryptParams parses "standard", "light" or custom "N,p" parameters, returning StandardScrypt if s is empty.
+func ParseScryptParams(s string) (ScryptParams, error) {
+	switch s {
+	case ``, `standard`:
+		return StandardScrypt, nil
+	case `light`:
+		return LightScrypt, nil
+	}
+	np := strings.Split(s, `,`)
+	if len(np) != 2 {
+		return ScryptParams{}, fmt.Errorf(`invalid scrypt parameters %s, expected standard, light or N,p`, s)
+	}
+	n, e1 := strconv.Atoi(strings.TrimSpace(np[0]))
+	p, e2 := strconv.Atoi(strings.TrimSpace(np[1]))
+	if e1 != nil || e2 != nil || n < 2 || n&(n-1) != 0 || p < 1 {
+		return ScryptParams{}, fmt.Errorf(`invalid scrypt parameters %s, N must be a power of two > 1 and p positive`, s)
+	}
+	return ScryptParams{N: n, P: p}, nil
+}
+
+// CreateAccount generates a new key and writes it to a keystore file in f, encrypted with passphrase using params.
+// It returns the new account's address and the file's path relative to f, as expected in Credentials.
+// The key is erased from memory afterwards.
+func (f Folder) CreateAccount(passphrase string, params ScryptParams) (common.Address, []string, error) {
+	privateKey, e := crypto.GenerateKey()
+	if e != nil {
+		return common.Address{}, nil, e
+	}
+	defer auth.DestroyEcdsaPrivateKey(privateKey)
+	id := make([]byte, 16, 16)
+	if _, e := rand.Read(id); e != nil {
+		return common.Address{}, nil, e
+	}
+	id[6], id[8] = (id[6]&0x0f)|0x40, (id[8]&0x3f)|0x80 // version 4 UUID
+	key := &keystore.Key{Id: id, Address: crypto.PubkeyToAddress(privateKey.PublicKey), PrivateKey: privateKey}
+	bs, e := keystore.EncryptKey(key, passphrase, params.N, params.P)
+	if e != nil {
+		return common.Address{}, nil, e
+	}
+	// geth's naming convention, UTC--<created at>--<address>
+	name := fmt.Sprintf(`UTC--%s--%s`, time.Now().UTC().Format(`2006-01-02T15-04-05.000000000Z`), hex.EncodeToString(key.Address[:]))
+	temp, e := ioutil.TempFile(string(f), `.`+name+`.tmp`) // mode 0600
+	if e != nil {
+		return common.Address{}, nil, e
+	}
+	if _, e := temp.Write(bs); e != nil {
+		temp.Close()
+		os.Remove(temp.Name())
+		return common.Address{}, nil, e
+	}
+	if e := temp.Close(); e != nil {
+		os.Remove(temp.Name())
+		return common.Address{}, nil, e
+	}
+	if e := os.Rename(temp.Name(), filepath.Join(string(f), name)); e != nil {
+		os.Remove(temp.Name())
+		return common.Address{}, nil, e
+	}
+	return key.Address, []string{name}, nil
+}
+
+// CredentialSchema returns the JSON Schema of Credentials.
+// It implements auth.CredentialSchemer.
+func (f Folder) CredentialSchema() json.RawMessage {
+	return json.RawMessage(credentialSchema)
+}
+
 func (f Folder) RenewToken(oldToken json.RawMessage) (json.RawMessage, error) {
 	tok, e := parseToken(oldToken)
 	if e != nil {
@@ -0,0 +1,95 @@
+// Copyright 2018 karma.run AG. All rights reserved.
+
+package fs
+
+import (
+	"encoding/json"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/karmarun/karma.link/auth"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func init() {
+	auth.RegisterAuthenticator(`fs-schema-test`, Folder(``)) // only asked for its schema
+}
+
+func TestCredentialSchema(t *testing.T) {
+	bs, e := auth.Schema(`fs-schema-test`)
+	if e != nil {
+		t.Fatal(e)
+	}
+	schema := struct {
+		Type       string                     `json:"type"`
+		Properties map[string]json.RawMessage `json:"properties"`
+		Required   []string                   `json:"required"`
+	}{}
+	if e := json.Unmarshal(bs, &schema); e != nil {
+		t.Fatalf(`invalid schema JSON: %s`, e)
+	}
+	if schema.Type != `object` || !reflect.DeepEqual(schema.Required, []string{`filepath`, `passphrase`}) {
+		t.Fatalf(`unexpected schema: %s`, bs)
+	}
+	// every property must be a field of Credentials
+	credentials := map[string]interface{}{}
+	example, _ := json.Marshal(Credentials{})
+	json.Unmarshal(example, &credentials)
+	for property := range schema.Properties {
+		if _, ok := credentials[property]; !ok {
+			t.Errorf(`schema property %s is not a field of Credentials`, property)
+		}
+	}
+}
+
+func TestCreateAccountLightScrypt(t *testing.T) {
+	dir := t.TempDir()
+	address, path, e := Folder(dir).CreateAccount(`secret`, LightScrypt)
+	if e != nil {
+		t.Fatal(e)
+	}
+	bs, e := ioutil.ReadFile(filepath.Join(append([]string{dir}, path...)...))
+	if e != nil {
+		t.Fatal(e)
+	}
+	encrypted := struct {
+		Crypto struct {
+			KDF       string `json:"kdf"`
+			KDFParams struct {
+				N int `json:"n"`
+				P int `json:"p"`
+			} `json:"kdfparams"`
+		} `json:"crypto"`
+	}{}
+	if e := json.Unmarshal(bs, &encrypted); e != nil {
+		t.Fatal(e)
+	}
+	if kdf := encrypted.Crypto; kdf.KDF != `scrypt` || kdf.KDFParams.N != keystore.LightScryptN || kdf.KDFParams.P != keystore.LightScryptP {
+		t.Fatalf(`expected light scrypt parameters, have %+v`, kdf)
+	}
+	key, e := keystore.DecryptKey(bs, `secret`)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if key.Address != address {
+		t.Fatalf(`decrypted key of %x, expected %x`, key.Address, address)
+	}
+	if _, e := keystore.DecryptKey(bs, `wrong`); e == nil {
+		t.Fatalf(`expected wrong passphrase to fail`)
+	}
+}
+
+func TestParseScryptParams(t *testing.T) {
+	for s, expected := range map[string]ScryptParams{``: StandardScrypt, `standard`: StandardScrypt, `light`: LightScrypt, `1024, 2`: {N: 1024, P: 2}} {
+		params, e := ParseScryptParams(s)
+		if e != nil || params != expected {
+			t.Fatalf(`%q: expected %+v, have %+v (%v)`, s, expected, params, e)
+		}
+	}
+	for _, invalid := range []string{`heavy`, `1000,1`, `1024`, `1024,0`, `1,1`} {
+		if _, e := ParseScryptParams(invalid); e == nil {
+			t.Fatalf(`%q: expected error`, invalid)
+		}
+	}
+}
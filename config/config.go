@@ -4,20 +4,37 @@ package config // import "github.com/karmarun/karma.link/config"
 
 import (
 	"flag"
+	"io"
 	"log"
 	"os"
 )
 
 var (
 	HttpBind         string
+	AdminBind        string
+	WSBind           string
 	GethRPCURL       string
 	CombinedJSONPath string
 	FSAuthDirectory  string
+	FSAuthScrypt     string
+	CreateAccounts   bool
+	ReadOnly         bool
+	DeploymentsPath  string
+	ABIPaths         string
+	Validate         bool
+	RequestTimeout   string
+	GethHTTPTimeout  string
+	GethCAFile       string
+	GethHeaders      string
+	MethodTimeouts   string
+	ChainID          string
+	LogOutput        string
+	LogFormat        string
 )
 
 var (
-	LogWriter = os.Stderr
-	LogFlags  = (log.Ldate | log.Ltime | log.Lshortfile)
+	LogWriter io.Writer = os.Stderr // see ConfigureLogging
+	LogFlags            = (log.Ldate | log.Ltime | log.Lshortfile)
 )
 
 func init() {
@@ -27,17 +44,47 @@ func init() {
 		getenv("KARMA_HTTP_BIND", ":8080"),
 		`HTTP interface and port number to bind and serve`,
 	)
+	flag.StringVar(
+		&AdminBind,
+		`admin-bind`,
+		getenv("KARMA_ADMIN_BIND", ""),
+		`HTTP interface and port number to serve health, readiness and metrics on (disabled if empty)`,
+	)
+	flag.StringVar(
+		&WSBind,
+		`ws-bind`,
+		getenv("KARMA_WS_BIND", ""),
+		`Interface and port number to serve the JSON-RPC API over WebSocket on, which also supports subscriptions (disabled if empty)`,
+	)
 	flag.StringVar(
 		&GethRPCURL,
 		`geth-rpc`,
 		getenv("KARMA_GETH_RPC", ""),
-		`URL or path to a running geth RPC API (local IPC pipe, WebSocket or HTTP)`,
+		`URL or path to a running geth RPC API (local IPC pipe, WebSocket or HTTP); separate multiple with commas for failover`,
+	)
+	flag.StringVar(
+		&GethHTTPTimeout,
+		`geth-http-timeout`,
+		getenv("KARMA_GETH_HTTP_TIMEOUT", "60s"),
+		`Timeout of requests to HTTP(S) geth endpoints`,
+	)
+	flag.StringVar(
+		&GethCAFile,
+		`geth-ca`,
+		getenv("KARMA_GETH_CA", ""),
+		`Path to PEM certificates to trust for HTTPS geth endpoints, instead of the system's (proxies are taken from HTTPS_PROXY etc.)`,
+	)
+	flag.StringVar(
+		&GethHeaders,
+		`geth-headers`,
+		getenv("KARMA_GETH_HEADERS", ""),
+		`Comma-separated Name=value HTTP headers sent to HTTP(S) geth endpoints, e.g. "Authorization=Bearer ..."; credentials in endpoint URLs are sent as basic auth`,
 	)
 	flag.StringVar(
 		&CombinedJSONPath,
 		`combined-json`,
 		getenv("KARMA_COMBINED_JSON", ""),
-		`Path to combined.json file produced with solc --combined-json 'ast,bin'`,
+		`Comma-separated paths to combined.json files produced with solc --combined-json 'ast,bin' (optionally adding bin-runtime, userdoc and devdoc), e.g. one per compiler version`,
 	)
 	flag.StringVar(
 		&FSAuthDirectory,
@@ -45,6 +92,72 @@ func init() {
 		getenv("KARMA_FS_AUTH_DIR", ""),
 		`Path to auth/fs's private key directory`,
 	)
+	flag.StringVar(
+		&FSAuthScrypt,
+		`fs-auth-scrypt`,
+		getenv("KARMA_FS_AUTH_SCRYPT", "standard"),
+		`Scrypt parameters of keystore files created in --fs-auth-dir: "standard", "light" (faster to unlock, less secure) or "N,p"`,
+	)
+	flag.BoolVar(
+		&CreateAccounts,
+		`create-accounts`,
+		getenv("KARMA_CREATE_ACCOUNTS", "") == "true",
+		`Enable the CreateAccount method, which lets any client write new keystore files to --fs-auth-dir`,
+	)
+	flag.BoolVar(
+		&ReadOnly,
+		`read-only`,
+		getenv("KARMA_READ_ONLY", "") == "true",
+		`Refuse to sign or broadcast transactions; calls, encoding and decoding keep working`,
+	)
+	flag.StringVar(
+		&ABIPaths,
+		`abi`,
+		getenv("KARMA_ABI", ""),
+		`Comma-separated Name=path pairs of plain ABI JSON files to load as contracts in addition to (or instead of) --combined-json, e.g. ERC20=erc20.json`,
+	)
+	flag.BoolVar(
+		&Validate,
+		`validate`,
+		getenv("KARMA_VALIDATE", "") == "true",
+		`Check the consistency of the extracted project at startup and refuse to start on violations`,
+	)
+	flag.StringVar(
+		&RequestTimeout,
+		`request-timeout`,
+		getenv("KARMA_REQUEST_TIMEOUT", "30s"),
+		`Time after which RPC calls are answered with a timeout error, unless overridden per method`,
+	)
+	flag.StringVar(
+		&MethodTimeouts,
+		`method-timeouts`,
+		getenv("KARMA_METHOD_TIMEOUTS", ""),
+		`Comma-separated Method=duration pairs overriding --request-timeout, e.g. GetLogs=2m (transacting methods default to 5m)`,
+	)
+	flag.StringVar(
+		&DeploymentsPath,
+		`deployments`,
+		getenv("KARMA_DEPLOYMENTS", ""),
+		`Path to a JSON object mapping "file:name" contracts to deployed addresses; their on-chain code is checked periodically against bin-runtime`,
+	)
+	flag.StringVar(
+		&ChainID,
+		`chain-id`,
+		getenv("KARMA_CHAIN_ID", ""),
+		`Chain ID for EIP-155 replay-protected transaction signatures (decimal or 0x-hex); transactions are signed without replay protection if empty, unless a request's signatureType is "eip155"`,
+	)
+	flag.StringVar(
+		&LogOutput,
+		`log-output`,
+		getenv("KARMA_LOG_OUTPUT", "stderr"),
+		`Destination of log output: "stderr", "stdout" or the path of a file to append to`,
+	)
+	flag.StringVar(
+		&LogFormat,
+		`log-format`,
+		getenv("KARMA_LOG_FORMAT", LogFormatText),
+		`Format of log output: "text" or "json" (one object per line)`,
+	)
 }
 
 func getenv(key, deflt string) string {
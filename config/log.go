@@ -0,0 +1,103 @@
+// Copyright 2018 karma.run AG. All rights reserved.
+
+package config // import "github.com/karmarun/karma.link/config"
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	LogFormatText = `text`
+	LogFormatJSON = `json` // one object per line: {"time": "...", "component": "abi", "caller": "decode.go:278", "message": "..."}
+)
+
+// loggers are the loggers created with NewLogger, with their components.
+var loggers = make(map[*log.Logger]string, 4)
+
+// NewLogger returns a logger writing to LogWriter with LogFlags, prefixed with component.
+// Call it during package initialization, the logger is reconfigured by ConfigureLogging.
+func NewLogger(component string) *log.Logger {
+	logger := log.New(LogWriter, component, LogFlags)
+	loggers[logger] = component
+	return logger
+}
+
+// ConfigureLogging applies LogOutput and LogFormat to the loggers created with NewLogger and the standard logger.
+// It is meant to be called once, after parsing flags and before logging anything.
+func ConfigureLogging() error {
+	switch LogOutput {
+	case `stderr`:
+		LogWriter = os.Stderr
+	case `stdout`:
+		LogWriter = os.Stdout
+	default:
+		file, e := os.OpenFile(LogOutput, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if e != nil {
+			return e
+		}
+		LogWriter = file // NOTE: open for the lifetime of the process
+	}
+	switch LogFormat {
+	case LogFormatText:
+		for logger := range loggers {
+			logger.SetOutput(LogWriter)
+		}
+		log.SetOutput(LogWriter)
+	case LogFormatJSON:
+		// NOTE: the time is added by jsonLogWriter, the caller (if logged) is moved into its own field
+		for logger, component := range loggers {
+			logger.SetOutput(jsonLogWriter{out: LogWriter, component: component, caller: LogFlags&log.Lshortfile != 0})
+			logger.SetPrefix("")
+			logger.SetFlags(LogFlags & log.Lshortfile)
+		}
+		log.SetOutput(jsonLogWriter{out: LogWriter})
+		log.SetPrefix("")
+		log.SetFlags(0)
+	default:
+		return fmt.Errorf(`invalid log format %s, available: text, json`, LogFormat)
+	}
+	return nil
+}
+
+type jsonLogLine struct {
+	Time      string `json:"time"`
+	Component string `json:"component,omitempty"`
+	Caller    string `json:"caller,omitempty"`
+	Message   string `json:"message"`
+}
+
+// jsonLogWriter writes the lines of a logger as JSON objects to out.
+// caller tells whether lines start with "file.go:line: ", as logged with log.Lshortfile.
+type jsonLogWriter struct {
+	out       io.Writer
+	component string
+	caller    bool
+}
+
+func (w jsonLogWriter) Write(p []byte) (int, error) {
+	line := jsonLogLine{
+		Time:      time.Now().UTC().Format(time.RFC3339Nano),
+		Component: w.component,
+		Message:   strings.TrimSuffix(string(p), "\n"),
+	}
+	if w.caller {
+		if i := strings.Index(line.Message, `: `); i > 0 {
+			line.Caller, line.Message = line.Message[:i], line.Message[i+2:]
+		}
+	}
+	bs, e := json.Marshal(line)
+	if e != nil {
+		return 0, e
+	}
+	// NOTE: a single write per line, so lines of loggers sharing out don't interleave
+	if _, e := w.out.Write(append(bs, '\n')); e != nil {
+		return 0, e
+	}
+	return len(p), nil
+}
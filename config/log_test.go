@@ -0,0 +1,67 @@
+// Copyright 2018 karma.run AG. All rights reserved.
+
+package config
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJSONLogging(t *testing.T) {
+	defer func(output, format string, writer io.Writer) {
+		LogOutput, LogFormat, LogWriter = output, format, writer
+		log.SetOutput(os.Stderr)
+		log.SetFlags(log.LstdFlags)
+	}(LogOutput, LogFormat, LogWriter)
+
+	path := filepath.Join(t.TempDir(), `link.log`)
+	LogOutput, LogFormat = path, LogFormatJSON
+	logger := NewLogger(`test`)
+	defer delete(loggers, logger)
+	if e := ConfigureLogging(); e != nil {
+		t.Fatal(e)
+	}
+	logFile := LogWriter.(*os.File)
+	defer logFile.Close()
+	logger.Println(`decoding: unsupported type`) // a colon in the message stays in the message
+	log.Println(`standard logger`)
+
+	file, e := os.Open(path)
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer file.Close()
+	lines := []jsonLogLine{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := jsonLogLine{}
+		if e := json.Unmarshal(scanner.Bytes(), &line); e != nil {
+			t.Fatalf(`invalid JSON log line %s: %s`, scanner.Text(), e)
+		}
+		if _, e := time.Parse(time.RFC3339Nano, line.Time); e != nil {
+			t.Fatalf(`invalid time in %s: %s`, scanner.Text(), e)
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) != 2 {
+		t.Fatalf(`expected 2 lines, have %d`, len(lines))
+	}
+	if line := lines[0]; line.Component != `test` || !strings.HasPrefix(line.Caller, `log_test.go:`) || line.Message != `decoding: unsupported type` {
+		t.Fatalf(`unexpected line %+v`, line)
+	}
+	if line := lines[1]; line.Component != `` || line.Caller != `` || line.Message != `standard logger` {
+		t.Fatalf(`unexpected line %+v`, line)
+	}
+
+	LogOutput, LogFormat = `stderr`, `xml`
+	if e := ConfigureLogging(); e == nil {
+		t.Fatalf(`expected error for unknown format`)
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright 2018 karma.run AG. All rights reserved.
+
+package main // import "github.com/karmarun/karma.link/link"
+
+import (
+	"fmt"
+	"github.com/karmarun/karma.link/ast"
+	"github.com/karmarun/karma.link/types"
+	"log"
+	"sort"
+	"strings"
+)
+
+// ABIEntry is an element of a standard contract ABI JSON array, in the format produced by solc --abi.
+type ABIEntry struct {
+	Type            string              `json:"type"`
+	Name            string              `json:"name,omitempty"`
+	Inputs          []ABIParameter      `json:"inputs"`
+	Outputs         []ABIParameter      `json:"outputs,omitempty"`
+	StateMutability ast.StateMutability `json:"stateMutability,omitempty"`
+	Constant        *bool               `json:"constant,omitempty"`  // legacy, superseded by stateMutability; nil for events
+	Payable         *bool               `json:"payable,omitempty"`   // idem
+	Anonymous       *bool               `json:"anonymous,omitempty"` // events only
+}
+
+type ABIParameter struct {
+	Name       string         `json:"name"`
+	Type       string         `json:"type"`
+	Components []ABIParameter `json:"components,omitempty"` // members of tuple types
+	Indexed    *bool          `json:"indexed,omitempty"`    // event parameters only
+}
+
+func (h RpcHandler) GetFunctionABI(req GetFunctionRequest, res *ABIEntry) error {
+	function, e := h.functionBySignature(req.File, req.Contract, req.Signature)
+	if e != nil {
+		return e
+	}
+	*res = FunctionABI(function)
+	return nil
+}
+
+func (h RpcHandler) GetContractABI(req GetContractRequest, res *[]ABIEntry) error {
+	file, ok := h.project.Files[req.File]
+	if !ok {
+		return fmt.Errorf(`file not found: %s`, req.File)
+	}
+	contract, ok := file[req.Contract]
+	if !ok {
+		return fmt.Errorf(`contract not found: %s`, req.Contract)
+	}
+	*res = ContractABI(contract)
+	return nil
+}
+
+// ContractABI returns the standard ABI of a contract: its constructor followed by its public and external
+// functions and its events, including inherited ones, each in lexical order of their signatures.
+func ContractABI(contract *types.Contract) []ABIEntry {
+	entries := make([]ABIEntry, 0, len(contract.API)+1)
+	if contract.Constructor != nil {
+		entry := FunctionABI(*contract.Constructor)
+		entry.Type, entry.Name, entry.Outputs = `constructor`, "", nil
+		entries = append(entries, entry)
+	}
+	functions := make(map[string]types.Function, len(contract.API))
+	for _, contract := range append([]*types.Contract{contract}, contract.Parents...) {
+		for signature, function := range contract.API {
+			if function.Visibility != ast.VisibilityPublic && function.Visibility != ast.VisibilityExternal {
+				continue
+			}
+			if _, overridden := functions[signature]; !overridden {
+				functions[signature] = function
+			}
+		}
+	}
+	signatures := make([]string, 0, len(functions))
+	for signature := range functions {
+		signatures = append(signatures, signature)
+	}
+	sort.Strings(signatures)
+	for _, signature := range signatures {
+		entries = append(entries, FunctionABI(functions[signature]))
+	}
+	events := make(map[string]types.Event, 8)
+	for _, event := range contractEvents(contract) {
+		events[string(event.SoliditySignature())] = event
+	}
+	signatures = signatures[:0]
+	for signature := range events {
+		signatures = append(signatures, signature)
+	}
+	sort.Strings(signatures)
+	for _, signature := range signatures {
+		entries = append(entries, EventABI(events[signature]))
+	}
+	return entries
+}
+
+// EventABI returns the standard ABI entry of an event.
+func EventABI(event types.Event) ABIEntry {
+	inputs := abiParameters(event.Args, parameterNames(event.ArgNames, len(event.Args)))
+	for i := range inputs {
+		indexed := len(event.Indexed) == len(inputs) && event.Indexed[i]
+		inputs[i].Indexed = &indexed
+	}
+	anonymous := event.Anonymous
+	return ABIEntry{Type: `event`, Name: event.Name, Inputs: inputs, Anonymous: &anonymous}
+}
+
+// FunctionABI returns the standard ABI entry of a function.
+func FunctionABI(function types.Function) ABIEntry {
+	mutability := function.StateMutability
+	if mutability == "" {
+		mutability = ast.StateMutabilityNonpayable
+	}
+	if _, ok := function.Definition.(ast.VariableDeclaration); ok {
+		mutability = ast.StateMutabilityView // getters
+	}
+	constant := mutability == ast.StateMutabilityView || mutability == ast.StateMutabilityPure
+	payable := mutability == ast.StateMutabilityPayable
+	entry := ABIEntry{
+		Type:            `function`,
+		Name:            function.Name,
+		Inputs:          abiParameters(function.Inputs, parameterNames(function.InputNames, len(function.Inputs))),
+		Outputs:         abiParameters(function.Outputs, parameterNames(function.OutputNames, len(function.Outputs))),
+		StateMutability: mutability,
+		Constant:        &constant,
+		Payable:         &payable,
+	}
+	if function.IsFallback() {
+		entry.Type, entry.Inputs, entry.Outputs = `fallback`, nil, nil
+	}
+	if entry.Inputs == nil {
+		entry.Inputs = []ABIParameter{}
+	}
+	if entry.Outputs == nil && entry.Type == `function` {
+		entry.Outputs = []ABIParameter{}
+	}
+	return entry
+}
+
+func abiParameters(typs []types.Type, names []string) []ABIParameter {
+	params := make([]ABIParameter, len(typs), len(typs))
+	for i, typ := range typs {
+		params[i] = abiParameter(names[i], typ)
+	}
+	return params
+}
+
+// abiParameter maps a type to its ABI parameter. Structs become tuples with their members as components.
+func abiParameter(name string, typ types.Type) ABIParameter {
+	suffix := ""
+	for {
+		if named, ok := typ.(types.Named); ok {
+			typ = named.Type
+			continue
+		}
+		array, ok := typ.(types.Array)
+		if !ok {
+			break
+		}
+		length := ""
+		if !array.IsDynamic() {
+			length = fmt.Sprint(array.Length)
+		}
+		suffix, typ = `[`+length+`]`+suffix, array.Type
+	}
+	switch t := typ.(type) {
+	case types.Struct:
+		return ABIParameter{Name: name, Type: `tuple` + suffix, Components: abiParameters(t.Types, t.Keys)}
+	case types.Tuple:
+		return ABIParameter{Name: name, Type: `tuple` + suffix, Components: abiParameters(t, make([]string, len(t), len(t)))}
+	case types.Mapping, types.Event, types.Reference:
+		log.Panicf(`unexpected type in abiParameter: %T`, typ)
+	}
+	return ABIParameter{Name: name, Type: strings.TrimSpace(string(typ.SoliditySignature())) + suffix}
+}
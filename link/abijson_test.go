@@ -0,0 +1,61 @@
+// Copyright 2018 karma.run AG. All rights reserved.
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestGetFunctionABI(t *testing.T) {
+	// as output by solc 0.4.24, the second with pragma experimental ABIEncoderV2
+	fragments := map[string]string{
+		`transfer(address,uint256)`:            `{"constant":false,"inputs":[{"name":"_to","type":"address"},{"name":"_value","type":"uint256"}],"name":"transfer","outputs":[{"name":"","type":"bool"}],"payable":false,"stateMutability":"nonpayable","type":"function"}`,
+		`fill((address,uint256[])[2],bytes32)`: `{"constant":true,"inputs":[{"components":[{"name":"owner","type":"address"},{"name":"amounts","type":"uint256[]"}],"name":"orders","type":"tuple[2]"},{"name":"hash","type":"bytes32"}],"name":"fill","outputs":[{"name":"","type":"uint256"},{"name":"ok","type":"bool"}],"payable":false,"stateMutability":"view","type":"function"}`,
+	}
+	abiJSON := `[`
+	for _, fragment := range fragments {
+		abiJSON += fragment + `,`
+	}
+	contract := abiContract(t, `Exchange.sol`, `Exchange`, abiJSON[:len(abiJSON)-1]+`]`)
+
+	for signature, fragment := range fragments {
+		res := ABIEntry{}
+		if e := testHandler(contract).GetFunctionABI(GetFunctionRequest{`Exchange.sol`, `Exchange`, signature}, &res); e != nil {
+			t.Fatal(e)
+		}
+		bs, e := json.Marshal(res)
+		if e != nil {
+			t.Fatal(e)
+		}
+		if !jsonEqualString(bs, fragment) {
+			t.Fatalf("%s: fragment differs from solc's:\n%s\n%s", signature, bs, fragment)
+		}
+	}
+
+	if e := testHandler(contract).GetFunctionABI(GetFunctionRequest{`Exchange.sol`, `Exchange`, `fill()`}, &ABIEntry{}); e == nil {
+		t.Fatal(`expected error for unknown function`)
+	}
+}
+
+func TestEventABI(t *testing.T) {
+	// as output by solc 0.4.24
+	transfer := `{"anonymous":false,"inputs":[{"indexed":true,"name":"from","type":"address"},{"indexed":true,"name":"to","type":"address"},{"indexed":false,"name":"value","type":"uint256"}],"name":"Transfer","type":"event"}`
+	approval := `{"anonymous":false,"inputs":[{"indexed":true,"name":"owner","type":"address"},{"indexed":true,"name":"spender","type":"address"},{"indexed":false,"name":"value","type":"uint256"}],"name":"Approval","type":"event"}`
+	logged := `{"anonymous":true,"inputs":[{"indexed":true,"name":"tag","type":"bytes32"},{"indexed":false,"name":"data","type":"bytes"}],"name":"Logged","type":"event"}`
+	function := `{"constant":false,"inputs":[{"name":"_to","type":"address"},{"name":"_value","type":"uint256"}],"name":"transfer","outputs":[{"name":"","type":"bool"}],"payable":false,"stateMutability":"nonpayable","type":"function"}`
+	contract := abiContract(t, `Token.sol`, `Token`, `[`+transfer+`,`+logged+`,`+function+`,`+approval+`]`)
+
+	res := []ABIEntry(nil)
+	if e := testHandler(contract).GetContractABI(GetContractRequest{File: `Token.sol`, Contract: `Token`}, &res); e != nil {
+		t.Fatal(e)
+	}
+	bs, e := json.Marshal(res)
+	if e != nil {
+		t.Fatal(e)
+	}
+	// functions first, then events in lexical order of their signatures
+	if expected := `[` + function + `,` + approval + `,` + logged + `,` + transfer + `]`; !jsonEqualString(bs, expected) {
+		t.Fatalf("ABI differs from solc's:\n%s\n%s", bs, expected)
+	}
+}
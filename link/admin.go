@@ -0,0 +1,79 @@
+// Copyright 2018 karma.run AG. All rights reserved.
+
+package main // import "github.com/karmarun/karma.link/link"
+
+import (
+	"context"
+	"expvar"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+const shutdownTimeout = 10 * time.Second
+
+var (
+	rpcRequests = expvar.NewInt(`rpcRequests`)
+)
+
+// adminHandler serves operational endpoints, separate from the JSON-RPC API:
+//
+//	/health  liveness, always 200 while the process is serving
+//	/ready   readiness, 200 if the geth node is reachable, 503 otherwise
+//	/metrics counters in expvar's JSON format
+//	/transactions pending and recently completed transactions
+//	/deployments results of the deployed code check, 503 on mismatch (only if deployments is non-nil)
+func adminHandler(deployments *deploymentChecker) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(`/health`, func(rw http.ResponseWriter, rq *http.Request) {
+		rw.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc(`/ready`, func(rw http.ResponseWriter, rq *http.Request) {
+		version := ""
+		if e := EthClient.CallContext(rq.Context(), &version, `net_version`); e != nil {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			rw.Write([]byte("geth unreachable\n"))
+			return
+		}
+		rw.Write([]byte("ready\n"))
+	})
+	mux.Handle(`/metrics`, expvar.Handler())
+	mux.HandleFunc(`/transactions`, ListTransactions)
+	if deployments != nil {
+		mux.Handle(`/deployments`, deployments)
+	}
+	return mux
+}
+
+// serve runs all servers until one of them fails or the process receives SIGINT/SIGTERM.
+// It then shuts down all servers gracefully.
+func serve(servers ...*http.Server) error {
+	errs := make(chan error, len(servers))
+	for _, server := range servers {
+		go func(server *http.Server) {
+			errs <- server.ListenAndServe()
+		}(server)
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	err := error(nil)
+	select {
+	case err = <-errs:
+	case sig := <-signals:
+		log.Println(`received`, sig, `shutting down`)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	for _, server := range servers {
+		if e := server.Shutdown(ctx); e != nil {
+			log.Println(`error shutting down server on`, server.Addr, e)
+		}
+	}
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
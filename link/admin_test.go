@@ -0,0 +1,85 @@
+// Copyright 2018 karma.run AG. All rights reserved.
+
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/rpc"
+	"strings"
+	"testing"
+	"time"
+)
+
+// freeAddress returns a local address that was free a moment ago.
+func freeAddress(t *testing.T) string {
+	l, e := net.Listen(`tcp`, `127.0.0.1:0`)
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// post posts a JSON-RPC call to url until the server answers, returning the response status and body.
+func post(t *testing.T, url string) (int, string) {
+	for i := 0; ; i++ {
+		res, e := http.Post(url, `application/json`, strings.NewReader(`{"jsonrpc": "2.0", "id": 1, "method": "v1.GetFiles", "params": {}}`))
+		if e != nil {
+			if i == 50 {
+				t.Fatal(e)
+			}
+			time.Sleep(20 * time.Millisecond)
+			continue
+		}
+		defer res.Body.Close()
+		bs, _ := ioutil.ReadAll(res.Body)
+		return res.StatusCode, string(bs)
+	}
+}
+
+func TestAdminListener(t *testing.T) {
+	defer useEthClient(newMockEthClient())()
+
+	timeouts, e := parseMethodTimeouts(`10s`, ``)
+	if e != nil {
+		t.Fatal(e)
+	}
+	rpcServer := rpc.NewServer()
+	if e := rpcServer.RegisterName(`v1`, testHandler(abiContract(t, `Token.sol`, `Token`, `[]`))); e != nil {
+		t.Fatal(e)
+	}
+	rpcListener := &http.Server{Addr: freeAddress(t), Handler: rpcHTTPHandler(rpcServer, timeouts)}
+	admin := &http.Server{Addr: freeAddress(t), Handler: adminHandler(nil)}
+
+	served := make(chan error, 1)
+	go func() { served <- serve(rpcListener, admin) }()
+
+	if status, body := post(t, `http://`+rpcListener.Addr+`/`); status != http.StatusOK || !strings.Contains(body, `["Token.sol"]`) {
+		t.Fatalf(`unexpected RPC response %d %s`, status, body)
+	}
+	if status, body := post(t, `http://`+admin.Addr+`/health`); status != http.StatusOK || body != "ok\n" {
+		t.Fatalf(`unexpected health response %d %s`, status, body)
+	}
+	if status, _ := post(t, `http://`+admin.Addr+`/ready`); status != http.StatusServiceUnavailable {
+		t.Fatalf(`expected unready without geth, have %d`, status)
+	}
+	if status, body := post(t, `http://`+admin.Addr+`/metrics`); status != http.StatusOK || !strings.Contains(body, `"rpcRequests"`) {
+		t.Fatalf(`unexpected metrics response %d %s`, status, body)
+	}
+
+	// closing one listener shuts down the other
+	admin.Close()
+	select {
+	case e := <-served:
+		if e != nil {
+			t.Fatal(e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal(`serve didn't return`)
+	}
+	if _, e := http.Get(`http://` + rpcListener.Addr + `/`); e == nil {
+		t.Fatal(`expected RPC listener to be shut down`)
+	}
+}
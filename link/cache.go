@@ -0,0 +1,52 @@
+// Copyright 2018 karma.run AG. All rights reserved.
+
+package main // import "github.com/karmarun/karma.link/link"
+
+import (
+	"encoding/json"
+	"expvar"
+	"sync"
+)
+
+const maxEncodingCacheEntries = 4096
+
+var (
+	encodingCacheHits   = expvar.NewInt(`encodingCacheHits`)
+	encodingCacheMisses = expvar.NewInt(`encodingCacheMisses`)
+)
+
+// encodingCache holds JSON encodings of a project's contracts and types, which are immutable once extracted.
+// It belongs to the RpcHandler serving the project, so a new project starts out with an empty cache.
+// When full, it is emptied rather than evicting individual entries.
+type encodingCache struct {
+	mutex   sync.RWMutex
+	entries map[string]json.RawMessage
+}
+
+func newEncodingCache() *encodingCache {
+	return &encodingCache{entries: make(map[string]json.RawMessage, 64)}
+}
+
+// Get returns the encoding cached under key, calling encode to produce it on a miss.
+// Callers must not modify the returned value.
+func (c *encodingCache) Get(key string, encode func() ([]byte, error)) (json.RawMessage, error) {
+	c.mutex.RLock()
+	encoded, ok := c.entries[key]
+	c.mutex.RUnlock()
+	if ok {
+		encodingCacheHits.Add(1)
+		return encoded, nil
+	}
+	encodingCacheMisses.Add(1)
+	bs, e := encode()
+	if e != nil {
+		return nil, e
+	}
+	c.mutex.Lock()
+	if len(c.entries) >= maxEncodingCacheEntries {
+		c.entries = make(map[string]json.RawMessage, 64)
+	}
+	c.entries[key] = bs
+	c.mutex.Unlock()
+	return bs, nil
+}
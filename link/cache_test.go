@@ -0,0 +1,73 @@
+// Copyright 2018 karma.run AG. All rights reserved.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// manyFunctionsABI declares n functions taking increasingly wide integers.
+func manyFunctionsABI(n int) string {
+	functions := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		functions = append(functions, fmt.Sprintf(`{"type": "function", "name": "f%d", "inputs": [{"name": "x", "type": "uint%d"}], "outputs": []}`, i, 8*(i%32+1)))
+	}
+	return `[` + strings.Join(functions, `,`) + `]`
+}
+
+func TestEncodingCache(t *testing.T) {
+	token := abiContract(t, `Token.sol`, `Token`, transferABI)
+	handler := testHandler(token)
+	req := GetContractRequest{File: `Token.sol`, Contract: `Token`}
+
+	fresh, e := rpcEncoder.EncodeContract(token)
+	if e != nil {
+		t.Fatal(e)
+	}
+	hits := encodingCacheHits.Value()
+	for i := 0; i < 2; i++ {
+		res := json.RawMessage{}
+		if e := handler.GetContract(req, &res); e != nil {
+			t.Fatal(e)
+		}
+		if !bytes.Equal(res, fresh) {
+			t.Fatalf("cached encoding differs:\n%s\n%s", res, fresh)
+		}
+	}
+	if hit := encodingCacheHits.Value() - hits; hit != 1 {
+		t.Fatalf(`expected 1 cache hit, have %d`, hit)
+	}
+
+	// reloading the project serves it with a new handler, and cache
+	reloaded := abiContract(t, `Token.sol`, `Token`, manyFunctionsABI(1))
+	res := json.RawMessage{}
+	if e := testHandler(reloaded).GetContract(req, &res); e != nil {
+		t.Fatal(e)
+	}
+	if fresh, _ := rpcEncoder.EncodeContract(reloaded); !bytes.Equal(res, fresh) {
+		t.Fatalf("stale encoding after reload:\n%s\n%s", res, fresh)
+	}
+}
+
+func BenchmarkGetContract(b *testing.B) {
+	contract := abiContract(b, `Many.sol`, `Many`, manyFunctionsABI(200))
+	req := GetContractRequest{File: `Many.sol`, Contract: `Many`}
+	for _, cached := range []bool{false, true} {
+		b.Run(fmt.Sprintf(`cached=%t`, cached), func(b *testing.B) {
+			handler := testHandler(contract)
+			for i := 0; i < b.N; i++ {
+				if !cached {
+					handler.cache = newEncodingCache()
+				}
+				res := json.RawMessage{}
+				if e := handler.GetContract(req, &res); e != nil {
+					b.Fatal(e)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2018 karma.run AG. All rights reserved.
+
+package main // import "github.com/karmarun/karma.link/link"
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/karmarun/karma.link/types"
+	"strconv"
+)
+
+// tabulateOutputs renders the decoded outputs of a function returning a single array of structs as CSV:
+// a header row of the struct's keys followed by one row per element. Strings and numbers are written as is,
+// other values (nested arrays, structs, ...) as JSON.
+func tabulateOutputs(function types.Function, decoded json.RawMessage) (string, error) {
+	keys := tableKeys(function.Outputs)
+	if keys == nil {
+		return "", fmt.Errorf(`csv output requires a function returning a single array of structs`)
+	}
+	values := make([]json.RawMessage, 0, 1)
+	if e := json.Unmarshal(decoded, &values); e != nil || len(values) != 1 {
+		return "", fmt.Errorf(`unexpected outputs: %s`, decoded)
+	}
+	elements := make([]json.RawMessage, 0, 16)
+	if e := json.Unmarshal(values[0], &elements); e != nil {
+		return "", fmt.Errorf(`unexpected outputs: %s`, decoded)
+	}
+	buffer := &bytes.Buffer{}
+	writer := csv.NewWriter(buffer)
+	writer.Write(keys)
+	for _, element := range elements {
+		row, e := tableRow(keys, element)
+		if e != nil {
+			return "", e
+		}
+		writer.Write(row)
+	}
+	writer.Flush()
+	return buffer.String(), writer.Error()
+}
+
+// tableKeys returns the column names for outputs consisting of an array of structs (or tuples, named by index), nil otherwise.
+func tableKeys(outputs []types.Type) []string {
+	if len(outputs) != 1 {
+		return nil
+	}
+	array, ok := unname(outputs[0]).(types.Array)
+	if !ok {
+		return nil
+	}
+	switch t := unname(array.Type).(type) {
+	case types.Struct:
+		return t.Keys
+	case types.Tuple:
+		keys := make([]string, len(t), len(t))
+		for i := range keys {
+			keys[i] = strconv.Itoa(i)
+		}
+		return keys
+	}
+	return nil
+}
+
+func unname(typ types.Type) types.Type {
+	for {
+		named, ok := typ.(types.Named)
+		if !ok {
+			return typ
+		}
+		typ = named.Type
+	}
+}
+
+// tableRow returns the cells of a decoded struct (an object) or tuple (an array) in the order of keys.
+func tableRow(keys []string, element json.RawMessage) ([]string, error) {
+	members := make(map[string]json.RawMessage, len(keys))
+	if e := json.Unmarshal(element, &members); e != nil {
+		list := make([]json.RawMessage, 0, len(keys))
+		if e := json.Unmarshal(element, &list); e != nil || len(list) != len(keys) {
+			return nil, fmt.Errorf(`unexpected element: %s`, element)
+		}
+		for i, value := range list {
+			members[keys[i]] = value
+		}
+	}
+	row := make([]string, len(keys), len(keys))
+	for i, key := range keys {
+		value := members[key]
+		s := ""
+		if e := json.Unmarshal(value, &s); e != nil {
+			s = string(value) // numbers, booleans and nested values
+		}
+		row[i] = s
+	}
+	return row, nil
+}
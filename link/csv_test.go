@@ -0,0 +1,75 @@
+// Copyright 2018 karma.run AG. All rights reserved.
+
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"github.com/karmarun/karma.link/abi"
+	"github.com/karmarun/karma.link/types"
+	"strings"
+	"testing"
+)
+
+func TestHoldersCSV(t *testing.T) {
+	token := abiContract(t, `Token.sol`, `Token`, `[
+		{"type": "function", "name": "holders", "stateMutability": "view", "inputs": [],
+		 "outputs": [{"name": "", "type": "tuple[]", "components": [
+			{"name": "name", "type": "string"}, {"name": "balance", "type": "uint256"}, {"name": "active", "type": "bool"}, {"name": "tags", "type": "uint8[]"}]}]},
+		{"type": "function", "name": "pairs", "stateMutability": "view", "inputs": [], "outputs": [{"name": "", "type": "uint256"}]},
+		{"type": "function", "name": "totalSupply", "stateMutability": "view", "inputs": [], "outputs": [{"name": "", "type": "uint256"}]}
+	]`)
+	holders := token.API[`holders()`]
+	holders.Outputs[0] = types.Array{Length: types.DynamicArrayLength, Type: types.Named{Name: `Token.sol:Token.Holder`, Type: holders.Outputs[0].(types.Array).Type}}
+	pairs := token.API[`pairs()`] // returning (address,uint16)[], which ABI JSON can only express as structs
+	pairs.Outputs[0] = types.Array{Length: types.DynamicArrayLength, Type: types.Tuple{types.Elementary(`address`), types.Elementary(`uint16`)}}
+
+	returned := func(function types.Function, values string) string {
+		code, e := abi.Encode(types.Tuple(function.Outputs), json.RawMessage(values))
+		if e != nil {
+			t.Fatal(e)
+		}
+		return hex.EncodeToString(code)
+	}
+
+	// names needing quotes, nested arrays as JSON cells
+	result := returned(holders, `[[{"name": "alice", "balance": 100, "active": true, "tags": [1, 2]}, {"name": "bob, \"the builder\"", "balance": 0, "active": false, "tags": []}]]`)
+	res, e := callContractCSV(t, token, `holders()`, result)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if expected := "name,balance,active,tags\nalice,100,true,\"[1,2]\"\n\"bob, \"\"the builder\"\"\",0,false,[]\n"; res.CSV != expected {
+		t.Fatalf("expected\n%s\nhave\n%s", expected, res.CSV)
+	}
+	if !strings.Contains(string(res.Result), `"alice"`) {
+		t.Fatalf(`expected the JSON result along with the CSV, have %s`, res.Result)
+	}
+
+	// plain tuples are keyed by index
+	res, e = callContractCSV(t, token, `pairs()`, returned(pairs, `[[["0x00000000000000000000000000000000000000aa", 7]]]`))
+	if e != nil {
+		t.Fatal(e)
+	}
+	if expected := "0,1\n0x00000000000000000000000000000000000000aa,7\n"; res.CSV != expected {
+		t.Fatalf("expected\n%s\nhave\n%s", expected, res.CSV)
+	}
+
+	// an empty array is just the header
+	if res, e = callContractCSV(t, token, `holders()`, returned(holders, `[[]]`)); e != nil || res.CSV != "name,balance,active,tags\n" {
+		t.Fatalf(`expected a header only, have %q, %v`, res.CSV, e)
+	}
+
+	if _, e := callContractCSV(t, token, `totalSupply()`, word(1)); e == nil || !strings.Contains(e.Error(), `single array of structs`) {
+		t.Fatalf(`expected csv to be rejected, have %v`, e)
+	}
+}
+
+// callContractCSV calls a view function of contract returning result (hex), requesting CSV.
+func callContractCSV(t *testing.T, contract *types.Contract, signature, result string) (DispatchFunctionCallResponse, error) {
+	defer useEthClient(newMockEthClient().Handle(`eth_call`, func(...interface{}) (interface{}, error) { return `0x` + result, nil }))()
+	req := DispatchFunctionCallRequest{Target: `0x01`, GasPrice: `1`, Auth: testAuth, CSV: true}
+	req.File, req.Contract, req.Signature, req.Arguments = contract.File, contract.Name, signature, json.RawMessage(`[]`)
+	res := DispatchFunctionCallResponse{}
+	e := testHandler(contract).DispatchFunctionCall(req, &res)
+	return res, e
+}
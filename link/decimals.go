@@ -0,0 +1,116 @@
+// Copyright 2018 karma.run AG. All rights reserved.
+
+package main // import "github.com/karmarun/karma.link/link"
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/karmarun/karma.link/types"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+const maxDecimals = 77 // 10^77 < 2^256 < 10^78
+
+// parseDecimals parses an optional decimals request parameter, returning -1 if it's empty.
+func parseDecimals(n json.Number) (int, error) {
+	if n == "" {
+		return -1, nil
+	}
+	d, e := strconv.Atoi(string(n))
+	if e != nil || d < 0 || d > maxDecimals {
+		return 0, fmt.Errorf(`invalid decimals, expected integer between 0 and %d`, maxDecimals)
+	}
+	return d, nil
+}
+
+// scaleDecimals mirrors a decoded value of type typ, replacing unsigned integers with decimal strings of
+// their value divided by 10^decimals, like token amounts are displayed (e.g. 1500000000000000000 -> "1.5" for 18 decimals).
+// All other values are copied unchanged, as are hashes standing in for indexed arrays and structs in logs.
+func scaleDecimals(typ types.Type, value json.RawMessage, decimals int) (json.RawMessage, error) {
+	if _, ok := typ.(types.Elementary); !ok && strings.HasPrefix(string(value), `"`) {
+		return value, nil
+	}
+	switch t := typ.(type) {
+
+	case types.Named:
+		return scaleDecimals(t.Type, value, decimals)
+
+	case types.Tuple:
+		return scaleElements(value, decimals, func(i int) types.Type { return t[i] }, len(t))
+
+	case types.Array:
+		return scaleElements(value, decimals, func(int) types.Type { return t.Type }, -1)
+
+	case types.Struct:
+		members := make(map[string]json.RawMessage, len(t.Keys))
+		if e := json.Unmarshal(value, &members); e != nil {
+			return nil, e
+		}
+		for i, key := range t.Keys {
+			scaled, e := scaleDecimals(t.Types[i], members[key], decimals)
+			if e != nil {
+				return nil, e
+			}
+			members[key] = scaled
+		}
+		return json.Marshal(members)
+
+	case types.Elementary:
+		id := string(t)
+		if !strings.HasPrefix(id, `uint`) {
+			return value, nil
+		}
+		s := ""
+		if e := json.Unmarshal(value, &s); e != nil {
+			s = string(value) // small values are decoded as JSON numbers
+		}
+		n, ok := new(big.Int), false
+		if strings.HasPrefix(s, `0x`) {
+			n, ok = n.SetString(s[2:], 16)
+		} else {
+			n, ok = n.SetString(s, 10)
+		}
+		if !ok {
+			return nil, fmt.Errorf(`unexpected %s value: %s`, id, value)
+		}
+		return json.Marshal(formatDecimals(n, decimals))
+
+	}
+	return value, nil
+}
+
+func scaleElements(value json.RawMessage, decimals int, typ func(int) types.Type, length int) (json.RawMessage, error) {
+	elements := make([]json.RawMessage, 0, 8)
+	if e := json.Unmarshal(value, &elements); e != nil {
+		return nil, e
+	}
+	if length != -1 && len(elements) != length {
+		return nil, fmt.Errorf(`expected %d values, have %d`, length, len(elements))
+	}
+	for i, element := range elements {
+		scaled, e := scaleDecimals(typ(i), element, decimals)
+		if e != nil {
+			return nil, e
+		}
+		elements[i] = scaled
+	}
+	return json.Marshal(elements)
+}
+
+// formatDecimals formats n / 10^decimals without trailing zeros in the fractional part.
+func formatDecimals(n *big.Int, decimals int) string {
+	s := n.Text(10)
+	if decimals == 0 {
+		return s
+	}
+	if len(s) <= decimals {
+		s = strings.Repeat(`0`, decimals-len(s)+1) + s
+	}
+	whole, fraction := s[:len(s)-decimals], strings.TrimRight(s[len(s)-decimals:], `0`)
+	if fraction == "" {
+		return whole
+	}
+	return whole + `.` + fraction
+}
@@ -0,0 +1,173 @@
+// Copyright 2018 karma.run AG. All rights reserved.
+
+package main // import "github.com/karmarun/karma.link/link"
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/karmarun/karma.link/types"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const deploymentCheckInterval = 10 * time.Minute
+
+var (
+	deploymentMismatches = expvar.NewInt(`deploymentMismatches`)
+)
+
+// DeploymentStatus is the outcome of comparing a contract's runtime bytecode to the code deployed at its address.
+type DeploymentStatus struct {
+	Contract string    `json:"contract"` // "file:name"
+	Address  string    `json:"address"`
+	Status   string    `json:"status"` // "match", "mismatch", "missing" (no code at address) or "error"
+	Error    string    `json:"error,omitempty"`
+	Checked  time.Time `json:"checked"`
+}
+
+// deploymentChecker compares configured contracts to on-chain code.
+type deploymentChecker struct {
+	project   types.Project
+	addresses map[string]string // "file:name" -> address
+
+	mutex    sync.Mutex
+	statuses []DeploymentStatus
+}
+
+// loadDeployments reads a JSON object mapping "file:name" contract identifiers to deployed addresses.
+func loadDeployments(path string, project types.Project) (map[string]string, error) {
+	bs, e := ioutil.ReadFile(path)
+	if e != nil {
+		return nil, e
+	}
+	addresses := make(map[string]string, 16)
+	if e := json.Unmarshal(bs, &addresses); e != nil {
+		return nil, fmt.Errorf(`invalid deployments file: %s`, e)
+	}
+	for id, address := range addresses {
+		if _, e := projectContract(project, id); e != nil {
+			return nil, e
+		}
+		if !common.IsHexAddress(address) {
+			return nil, fmt.Errorf(`invalid address for %s: %s`, id, address)
+		}
+	}
+	return addresses, nil
+}
+
+func projectContract(project types.Project, id string) (*types.Contract, error) {
+	key, e := types.ParseKey(id)
+	if e != nil || key.Member != "" {
+		return nil, fmt.Errorf(`expected "file:name" contract identifier, have %s`, id)
+	}
+	contract, ok := project.Files[key.File][key.Contract]
+	if !ok {
+		return nil, fmt.Errorf(`contract not found: %s`, id)
+	}
+	return contract, nil
+}
+
+// Run checks the deployments now and then every interval, logging mismatches. It never returns.
+func (c *deploymentChecker) Run(interval time.Duration) {
+	for {
+		c.Check()
+		time.Sleep(interval)
+	}
+}
+
+// Check compares every configured deployment and records the results.
+func (c *deploymentChecker) Check() {
+	ids := make([]string, 0, len(c.addresses))
+	for id := range c.addresses {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	statuses := make([]DeploymentStatus, 0, len(ids))
+	mismatches := int64(0)
+	for _, id := range ids {
+		status := c.check(id, c.addresses[id])
+		if status.Status != `match` {
+			mismatches++
+			log.Println(`deployment check:`, id, `at`, status.Address, status.Status, status.Error)
+		}
+		statuses = append(statuses, status)
+	}
+	deploymentMismatches.Set(mismatches)
+	c.mutex.Lock()
+	c.statuses = statuses
+	c.mutex.Unlock()
+}
+
+func (c *deploymentChecker) check(id, address string) DeploymentStatus {
+	status := DeploymentStatus{Contract: id, Address: address, Checked: time.Now()}
+	contract, _ := projectContract(c.project, id) // validated in loadDeployments
+	if len(contract.RuntimeBinary) == 0 {
+		status.Status, status.Error = `error`, `no runtime bytecode, compile with bin-runtime`
+		return status
+	}
+	code := ""
+	if e := EthClient.CallContext(context.Background(), &code, `eth_getCode`, address, `latest`); e != nil {
+		status.Status, status.Error = `error`, e.Error()
+		return status
+	}
+	deployed, e := hex.DecodeString(strip0xPrefix(code))
+	if e != nil {
+		status.Status, status.Error = `error`, `invalid code returned by node`
+		return status
+	}
+	switch {
+	case len(deployed) == 0:
+		status.Status = `missing`
+	case bytes.Equal(stripMetadata(deployed), stripMetadata(contract.RuntimeBinary)):
+		status.Status = `match`
+	default:
+		status.Status = `mismatch`
+	}
+	return status
+}
+
+// Statuses returns the results of the latest check.
+func (c *deploymentChecker) Statuses() []DeploymentStatus {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return append([]DeploymentStatus{}, c.statuses...)
+}
+
+// ServeHTTP lists the results of the latest check, responding 503 if any deployment doesn't match.
+func (c *deploymentChecker) ServeHTTP(rw http.ResponseWriter, rq *http.Request) {
+	statuses := c.Statuses()
+	rw.Header().Set(http.CanonicalHeaderKey(`content-type`), `application/json; charset=UTF-8`)
+	for _, status := range statuses {
+		if status.Status != `match` {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			break
+		}
+	}
+	json.NewEncoder(rw).Encode(statuses)
+}
+
+// stripMetadata removes the CBOR-encoded metadata solc appends to runtime bytecode.
+// Its length is stored big-endian in the code's last two bytes. The metadata hash changes
+// with unrelated details such as source paths or comments, so it's excluded from comparisons.
+func stripMetadata(code []byte) []byte {
+	if len(code) < 2 {
+		return code
+	}
+	n := int(code[len(code)-2])<<8 | int(code[len(code)-1])
+	if n == 0 || n+2 > len(code) {
+		return code
+	}
+	if metadata := code[len(code)-2-n]; metadata&0xe0 != 0xa0 { // CBOR map
+		return code
+	}
+	return code[:len(code)-2-n]
+}
@@ -0,0 +1,65 @@
+// Copyright 2018 karma.run AG. All rights reserved.
+
+package main
+
+import (
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// withMetadata appends solc's bzzr0 metadata with a hash of repeated hashByte to code.
+func withMetadata(code string, hashByte string) string {
+	return code + `a165627a7a72305820` + strings.Repeat(hashByte, 32) + `0029`
+}
+
+func TestDeploymentChecker(t *testing.T) {
+	token := abiContract(t, `Token.sol`, `Token`, transferABI)
+	token.RuntimeBinary = mustDecodeHex(withMetadata(`6080604052600080fd`, `11`))
+
+	deployed := map[string]string{
+		`0x00000000000000000000000000000000000000aa`: `0x` + withMetadata(`6080604052600080fd`, `22`), // differs in metadata only
+		`0x00000000000000000000000000000000000000bb`: `0x` + withMetadata(`6080604052600180fd`, `11`),
+		`0x00000000000000000000000000000000000000cc`: `0x`,
+	}
+	mock := newMockEthClient().Handle(`eth_getCode`, func(args ...interface{}) (interface{}, error) {
+		return deployed[args[0].(string)], nil
+	})
+	defer useEthClient(mock)()
+
+	checker := &deploymentChecker{project: testHandler(token).project}
+	for address, expected := range map[string]string{
+		`0x00000000000000000000000000000000000000aa`: `match`,
+		`0x00000000000000000000000000000000000000bb`: `mismatch`,
+		`0x00000000000000000000000000000000000000cc`: `missing`,
+	} {
+		checker.addresses = map[string]string{`Token.sol:Token`: address}
+		checker.Check()
+		statuses := checker.Statuses()
+		if len(statuses) != 1 || statuses[0].Status != expected || statuses[0].Address != address {
+			t.Fatalf(`%s: expected %s, have %+v`, address, expected, statuses)
+		}
+
+		rw := httptest.NewRecorder()
+		checker.ServeHTTP(rw, httptest.NewRequest(`GET`, `/deployments`, nil))
+		if healthy := rw.Code == http.StatusOK; healthy != (expected == `match`) {
+			t.Fatalf(`%s: unexpected status code %d for %s`, address, rw.Code, expected)
+		}
+		if mismatches := deploymentMismatches.Value(); (mismatches == 0) != (expected == `match`) {
+			t.Fatalf(`%s: unexpected mismatch count %d for %s`, address, mismatches, expected)
+		}
+	}
+}
+
+func TestStripMetadata(t *testing.T) {
+	code := withMetadata(`6080604052`, `11`)
+	if stripped := hex.EncodeToString(stripMetadata(mustDecodeHex(code))); stripped != `6080604052` {
+		t.Fatalf(`unexpected stripped code %s`, stripped)
+	}
+	// code not ending in metadata is left alone
+	if stripped := hex.EncodeToString(stripMetadata(mustDecodeHex(`6080604052600080fd`))); stripped != `6080604052600080fd` {
+		t.Fatalf(`unexpected stripped code %s`, stripped)
+	}
+}
@@ -0,0 +1,240 @@
+// Copyright 2018 karma.run AG. All rights reserved.
+
+package main // import "github.com/karmarun/karma.link/link"
+
+import (
+	"context"
+	"fmt"
+	ethrpc "github.com/ethereum/go-ethereum/rpc"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const healthCheckInterval = 15 * time.Second
+
+// ethCaller is the part of *ethrpc.Client we use to talk to geth.
+// Calls made on behalf of RPC requests pass the request's context, see requestContext.
+type ethCaller interface {
+	CallContext(ctx context.Context, result interface{}, method string, args ...interface{}) error
+}
+
+// endpointClient is implemented by *ethrpc.Client.
+type endpointClient interface {
+	ethCaller
+	Close()
+}
+
+// ethSubscriber is implemented by clients able to subscribe to geth notifications, see SubscribeLogs.
+type ethSubscriber interface {
+	EthSubscribe(ctx context.Context, channel interface{}, args ...interface{}) (ethSubscription, error)
+}
+
+// ethSubscription is implemented by *ethrpc.ClientSubscription.
+type ethSubscription interface {
+	Unsubscribe()
+	Err() <-chan error
+}
+
+// failoverClient implements ethCaller on top of one or more geth endpoints.
+// Calls go to the first healthy endpoint in configuration order. Endpoints failing with
+// connection-level errors are taken out of rotation and rejoin once a health check succeeds.
+// The last healthy endpoint is never taken out, so a single endpoint behaves like a plain client.
+type failoverClient struct {
+	mutex      sync.RWMutex
+	endpoints  []*endpoint
+	done       chan struct{}
+	httpClient *http.Client // used for http(s) endpoints
+}
+
+type endpoint struct {
+	url     string
+	client  endpointClient // nil until dialed successfully
+	healthy bool
+}
+
+// dialFailover dials all comma-separated URLs in urls, using httpClient for HTTP(S) endpoints.
+// It fails only if none of them could be dialed.
+func dialFailover(urls string, httpClient *http.Client) (*failoverClient, error) {
+	c := &failoverClient{done: make(chan struct{}), httpClient: httpClient}
+	for _, url := range strings.Split(urls, `,`) {
+		url = strings.TrimSpace(url)
+		if url == "" {
+			continue
+		}
+		ep := &endpoint{url: url}
+		if client, e := c.dial(url); e != nil {
+			log.Println(`failed dialing geth endpoint`, url, e)
+		} else {
+			ep.client, ep.healthy = client, true
+		}
+		c.endpoints = append(c.endpoints, ep)
+	}
+	if len(c.endpoints) == 0 {
+		return nil, fmt.Errorf(`no geth endpoints configured`)
+	}
+	if len(c.healthyEndpoints()) == 0 {
+		return nil, fmt.Errorf(`failed dialing any geth endpoint`)
+	}
+	if len(c.endpoints) > 1 {
+		go c.checkHealth()
+	}
+	return c, nil
+}
+
+// dial connects to a geth endpoint. IPC and WebSocket endpoints are dialed as usual.
+func (c *failoverClient) dial(rawurl string) (endpointClient, error) {
+	if u, e := url.Parse(rawurl); e == nil && (u.Scheme == `http` || u.Scheme == `https`) {
+		return ethrpc.DialHTTPWithClient(rawurl, c.httpClient)
+	}
+	return ethrpc.Dial(rawurl)
+}
+
+// CallContext implements ethCaller. Calls failing because ctx is done don't fail over.
+func (c *failoverClient) CallContext(ctx context.Context, result interface{}, method string, args ...interface{}) error {
+	err := error(nil)
+	for _, ep := range c.healthyEndpoints() {
+		e := ep.client.CallContext(ctx, result, method, args...)
+		if e == nil || ctx.Err() != nil || !isConnectionError(e) {
+			return e
+		}
+		if !c.setUnhealthy(ep) {
+			return e // the last healthy endpoint
+		}
+		log.Println(`geth endpoint`, ep.url, `failed, failing over:`, e)
+		err = e
+	}
+	if err == nil {
+		return fmt.Errorf(`no healthy geth endpoint available`)
+	}
+	return err
+}
+
+// EthSubscribe implements ethSubscriber. It subscribes on the first healthy endpoint supporting notifications,
+// i.e. one dialed over WebSocket or IPC. Subscriptions don't fail over: they end with their endpoint's connection.
+func (c *failoverClient) EthSubscribe(ctx context.Context, channel interface{}, args ...interface{}) (ethSubscription, error) {
+	for _, ep := range c.healthyEndpoints() {
+		client, ok := ep.client.(*ethrpc.Client)
+		if !ok {
+			continue
+		}
+		subscription, e := client.EthSubscribe(ctx, channel, args...)
+		if e == ethrpc.ErrNotificationsUnsupported {
+			continue
+		}
+		if e != nil {
+			if isConnectionError(e) && c.setUnhealthy(ep) {
+				log.Println(`geth endpoint`, ep.url, `failed, failing over:`, e)
+				continue
+			}
+			return nil, e
+		}
+		return subscription, nil
+	}
+	return nil, fmt.Errorf(`no healthy geth endpoint supports subscriptions, --geth-rpc needs a WebSocket or IPC endpoint`)
+}
+
+// Close stops health checks and closes all connections.
+func (c *failoverClient) Close() {
+	close(c.done)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, ep := range c.endpoints {
+		if ep.client != nil {
+			ep.client.Close()
+		}
+	}
+}
+
+func (c *failoverClient) healthyEndpoints() []*endpoint {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	healthy := make([]*endpoint, 0, len(c.endpoints))
+	for _, ep := range c.endpoints {
+		if ep.healthy {
+			healthy = append(healthy, ep)
+		}
+	}
+	return healthy
+}
+
+func (c *failoverClient) setHealthy(ep *endpoint, healthy bool) {
+	c.mutex.Lock()
+	ep.healthy = healthy
+	c.mutex.Unlock()
+}
+
+// setUnhealthy takes ep out of rotation unless it is the last healthy endpoint, reporting whether it did.
+func (c *failoverClient) setUnhealthy(ep *endpoint) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, other := range c.endpoints {
+		if other != ep && other.healthy {
+			ep.healthy = false
+			return true
+		}
+	}
+	return false
+}
+
+// checkHealth periodically probes unhealthy endpoints and lets them rejoin.
+func (c *failoverClient) checkHealth() {
+	ticker := time.NewTicker(healthCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+		c.probeUnhealthy()
+	}
+}
+
+// probeUnhealthy dials unhealthy endpoints if necessary and lets those answering net_version rejoin.
+func (c *failoverClient) probeUnhealthy() {
+	c.mutex.RLock()
+	endpoints := append([]*endpoint(nil), c.endpoints...)
+	c.mutex.RUnlock()
+	for _, ep := range endpoints {
+		c.mutex.RLock()
+		healthy, client := ep.healthy, ep.client
+		c.mutex.RUnlock()
+		if healthy {
+			continue
+		}
+		if client == nil {
+			dialed, e := c.dial(ep.url)
+			if e != nil {
+				continue
+			}
+			c.mutex.Lock()
+			ep.client, client = dialed, dialed
+			c.mutex.Unlock()
+		}
+		version := ""
+		if e := client.CallContext(context.Background(), &version, `net_version`); e != nil {
+			continue
+		}
+		log.Println(`geth endpoint`, ep.url, `recovered`)
+		c.setHealthy(ep, true)
+	}
+}
+
+// isConnectionError tells transport failures (failed dials, timeouts, dropped connections)
+// apart from errors reported by a reachable node or caused by the call itself.
+func isConnectionError(e error) bool {
+	if e == context.Canceled || e == context.DeadlineExceeded { // the latter is a net.Error, too
+		return false
+	}
+	if e == io.EOF || e == io.ErrUnexpectedEOF || e == ethrpc.ErrClientQuit {
+		return true
+	}
+	_, ok := e.(net.Error) // includes *net.OpError of failed dials and *url.Error of HTTP requests
+	return ok
+}
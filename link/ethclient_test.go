@@ -0,0 +1,105 @@
+// Copyright 2018 karma.run AG. All rights reserved.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+)
+
+// flakyClient is an endpointClient failing with err while it is set.
+type flakyClient struct {
+	*mockEthClient
+	err error
+}
+
+func (c *flakyClient) CallContext(ctx context.Context, result interface{}, method string, args ...interface{}) error {
+	if c.err != nil {
+		return c.err
+	}
+	return c.mockEthClient.CallContext(ctx, result, method, args...)
+}
+
+func (c *flakyClient) Close() {}
+
+func newFlakyClient(version string) *flakyClient {
+	return &flakyClient{mockEthClient: newMockEthClient().Handle(`net_version`, func(...interface{}) (interface{}, error) { return version, nil })}
+}
+
+// rpcError is an error reported by a reachable node.
+type rpcError struct{}
+
+func (rpcError) Error() string  { return `execution reverted` }
+func (rpcError) ErrorCode() int { return -32000 }
+
+func testFailoverClient(clients ...*flakyClient) *failoverClient {
+	c := &failoverClient{done: make(chan struct{})}
+	for i, client := range clients {
+		c.endpoints = append(c.endpoints, &endpoint{url: fmt.Sprintf(`http://node%d`, i), client: client, healthy: true})
+	}
+	return c
+}
+
+func TestFailover(t *testing.T) {
+	primary, secondary := newFlakyClient(`primary`), newFlakyClient(`secondary`)
+	c := testFailoverClient(primary, secondary)
+
+	primary.err = &net.OpError{Op: `dial`, Net: `tcp`, Err: fmt.Errorf(`connection refused`)}
+	version := ""
+	if e := c.CallContext(context.Background(), &version, `net_version`); e != nil {
+		t.Fatal(e)
+	}
+	if version != `secondary` {
+		t.Fatalf(`expected call to fail over to secondary, answered by %s`, version)
+	}
+	if healthy := c.healthyEndpoints(); len(healthy) != 1 || healthy[0].client != secondary {
+		t.Fatalf(`expected primary out of rotation`)
+	}
+
+	// the last healthy endpoint stays in rotation
+	secondary.err = io.EOF
+	if e := c.CallContext(context.Background(), &version, `net_version`); e != io.EOF {
+		t.Fatalf(`expected EOF, have %v`, e)
+	}
+	if len(c.healthyEndpoints()) != 1 {
+		t.Fatalf(`expected secondary to stay in rotation`)
+	}
+
+	// the primary rejoins once it answers health checks again
+	primary.err, secondary.err = nil, nil
+	c.probeUnhealthy()
+	if e := c.CallContext(context.Background(), &version, `net_version`); e != nil || version != `primary` {
+		t.Fatalf(`expected primary to rejoin, answered by %s (%v)`, version, e)
+	}
+}
+
+func TestFailoverIgnoresNodeErrors(t *testing.T) {
+	primary, secondary := newFlakyClient(`primary`), newFlakyClient(`secondary`)
+	c := testFailoverClient(primary, secondary)
+	for _, err := range []error{rpcError{}, fmt.Errorf(`json: cannot unmarshal string into Go value of type uint64`)} {
+		primary.err = err
+		if e := c.CallContext(context.Background(), new(string), `net_version`); e != err {
+			t.Fatalf(`expected %v, have %v`, err, e)
+		}
+		if len(c.healthyEndpoints()) != 2 {
+			t.Fatalf(`expected %v not to take primary out of rotation`, err)
+		}
+	}
+}
+
+func TestSingleEndpointIsNeverEvicted(t *testing.T) {
+	only := newFlakyClient(`only`)
+	c := testFailoverClient(only)
+	only.err = io.ErrUnexpectedEOF
+	if e := c.CallContext(context.Background(), new(string), `net_version`); e != io.ErrUnexpectedEOF {
+		t.Fatalf(`expected unexpected EOF, have %v`, e)
+	}
+	only.err = nil
+	version := ""
+	if e := c.CallContext(context.Background(), &version, `net_version`); e != nil || version != `only` {
+		t.Fatalf(`expected recovered endpoint to be called, have %s (%v)`, version, e)
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2018 karma.run AG. All rights reserved.
+
+package main // import "github.com/karmarun/karma.link/link"
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// gethHTTPClient builds the http.Client used for HTTP(S) geth endpoints. timeout limits each request,
+// caFile, if not empty, holds the PEM certificates trusted instead of the system's and headers ("Name=value,...")
+// are added to every request, e.g. API keys of node providers. Proxies are configured through the environment,
+// like for http.DefaultTransport.
+func gethHTTPClient(timeout, caFile, headers string) (*http.Client, error) {
+	d, e := time.ParseDuration(timeout)
+	if e != nil || d < 0 {
+		return nil, fmt.Errorf(`invalid geth HTTP timeout: %s`, timeout)
+	}
+	tlsConfig := &tls.Config{}
+	if caFile != "" {
+		pem, e := ioutil.ReadFile(caFile)
+		if e != nil {
+			return nil, e
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf(`no certificates found in %s`, caFile)
+		}
+	}
+	header, e := parseHeaders(headers)
+	if e != nil {
+		return nil, e
+	}
+	transport := http.RoundTripper(&http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		DialContext:         (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext,
+		TLSClientConfig:     tlsConfig,
+		TLSHandshakeTimeout: 10 * time.Second,
+		MaxIdleConnsPerHost: 16, // requests are concurrent, the default of 2 causes needless reconnects
+		IdleConnTimeout:     90 * time.Second,
+	})
+	if len(header) > 0 {
+		transport = headerTransport{header, transport}
+	}
+	return &http.Client{Timeout: d, Transport: transport}, nil
+}
+
+// parseHeaders parses comma-separated Name=value pairs.
+func parseHeaders(headers string) (http.Header, error) {
+	header := make(http.Header, 2)
+	if headers == "" {
+		return header, nil
+	}
+	for _, pair := range strings.Split(headers, `,`) {
+		kv := strings.SplitN(pair, `=`, 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return nil, fmt.Errorf(`invalid geth header %s, expected Name=value`, pair)
+		}
+		header.Add(strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]))
+	}
+	return header, nil
+}
+
+// headerTransport adds headers to every request before passing it on to next.
+type headerTransport struct {
+	header http.Header
+	next   http.RoundTripper
+}
+
+func (t headerTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	clone := new(http.Request) // RoundTrippers must not modify requests
+	*clone = *r
+	clone.Header = make(http.Header, len(r.Header)+len(t.header))
+	for name, values := range r.Header {
+		clone.Header[name] = values
+	}
+	for name, values := range t.header {
+		clone.Header[name] = values
+	}
+	return t.next.RoundTrip(clone)
+}
@@ -0,0 +1,115 @@
+// Copyright 2018 karma.run AG. All rights reserved.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+// stubTransport answers every JSON-RPC request with result and records the requests it saw.
+type stubTransport struct {
+	result   string
+	mutex    sync.Mutex
+	requests []*http.Request
+}
+
+func (t *stubTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	t.mutex.Lock()
+	t.requests = append(t.requests, r)
+	t.mutex.Unlock()
+	request := struct {
+		ID json.RawMessage `json:"id"`
+	}{}
+	if body, e := ioutil.ReadAll(r.Body); e != nil {
+		return nil, e
+	} else if e := json.Unmarshal(body, &request); e != nil {
+		return nil, e
+	}
+	response, _ := json.Marshal(map[string]interface{}{`jsonrpc`: `2.0`, `id`: request.ID, `result`: t.result})
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{`Content-Type`: []string{`application/json`}},
+		Body:       ioutil.NopCloser(bytes.NewReader(response)),
+		Request:    r,
+	}, nil
+}
+
+func (t *stubTransport) seen() []*http.Request {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return append([]*http.Request(nil), t.requests...)
+}
+
+func TestGethHTTPClientIsUsed(t *testing.T) {
+	stub := &stubTransport{result: `1337`}
+	c, e := dialFailover(`http://node.invalid:8545`, &http.Client{Transport: stub})
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer c.Close()
+	version := ""
+	if e := c.CallContext(context.Background(), &version, `net_version`); e != nil {
+		t.Fatal(e)
+	}
+	if version != `1337` {
+		t.Fatalf(`expected the stub's answer, have %s`, version)
+	}
+	requests := stub.seen()
+	if len(requests) != 1 {
+		t.Fatalf(`expected 1 request through the configured transport, have %d`, len(requests))
+	}
+	if host := requests[0].URL.Host; host != `node.invalid:8545` {
+		t.Fatalf(`expected request to node.invalid:8545, have %s`, host)
+	}
+}
+
+func TestGethHTTPHeaders(t *testing.T) {
+	client, e := gethHTTPClient(`30s`, ``, `Authorization=Bearer secret, X-Api-Key=key`)
+	if e != nil {
+		t.Fatal(e)
+	}
+	stub := &stubTransport{result: `1337`}
+	transport, ok := client.Transport.(headerTransport)
+	if !ok {
+		t.Fatalf(`expected headerTransport, have %T`, client.Transport)
+	}
+	transport.next = stub
+	client.Transport = transport
+
+	c, e := dialFailover(`http://node.invalid:8545`, client)
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer c.Close()
+	for i := 0; i < 3; i++ {
+		version := ""
+		if e := c.CallContext(context.Background(), &version, `net_version`); e != nil {
+			t.Fatal(e)
+		}
+	}
+	requests := stub.seen()
+	if len(requests) != 3 {
+		t.Fatalf(`expected 3 requests, have %d`, len(requests))
+	}
+	for i, r := range requests {
+		if h := r.Header.Get(`Authorization`); h != `Bearer secret` {
+			t.Fatalf(`request %d: expected Authorization header, have %q`, i, h)
+		}
+		if h := r.Header.Get(`X-Api-Key`); h != `key` {
+			t.Fatalf(`request %d: expected X-Api-Key header, have %q`, i, h)
+		}
+		if h := r.Header.Get(`Content-Type`); h != `application/json` {
+			t.Fatalf(`request %d: expected geth's own headers to be kept, have Content-Type %q`, i, h)
+		}
+	}
+
+	if _, e := gethHTTPClient(`30s`, ``, `no-value`); e == nil {
+		t.Fatalf(`expected error for header without value`)
+	}
+}
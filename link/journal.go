@@ -0,0 +1,106 @@
+// Copyright 2018 karma.run AG. All rights reserved.
+
+package main // import "github.com/karmarun/karma.link/link"
+
+import (
+	"encoding/json"
+	"github.com/ethereum/go-ethereum/common"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const maxRecentTransactions = 256
+
+// journalStatusUnknown is the status of transactions whose receipt couldn't be fetched.
+// They may still be mined, check their hash with the node.
+const journalStatusUnknown = `unknown`
+
+// JournalEntry describes a transaction broadcast by this server.
+// It intentionally carries no calldata or signature values.
+type JournalEntry struct {
+	Hash   string    `json:"hash"`
+	From   string    `json:"from"`
+	Nonce  uint64    `json:"nonce"`
+	Target string    `json:"target,omitempty"` // empty for contract creations
+	Status string    `json:"status"`           // "pending", the receipt's status once mined, or "unknown"
+	Sent   time.Time `json:"sent"`
+	Age    string    `json:"age"`
+}
+
+// journal keeps track of in-flight and recently completed transactions for introspection.
+type journal struct {
+	mutex   sync.Mutex
+	pending map[common.Hash]*JournalEntry
+	recent  []*JournalEntry // ring of completed entries, oldest first
+}
+
+var txJournal = &journal{pending: make(map[common.Hash]*JournalEntry, 16)}
+
+// Add records a freshly broadcast transaction as pending.
+func (j *journal) Add(tx journaledTransaction, from common.Address) {
+	entry := &JournalEntry{
+		Hash:   tx.Hash().Hex(),
+		From:   from.Hex(),
+		Nonce:  tx.Nonce(),
+		Status: `pending`,
+		Sent:   time.Now(),
+	}
+	if to := tx.To(); to != nil {
+		entry.Target = to.Hex()
+	}
+	j.mutex.Lock()
+	j.pending[tx.Hash()] = entry
+	j.mutex.Unlock()
+}
+
+// Complete moves a pending transaction to the recent list with its final status.
+func (j *journal) Complete(hash common.Hash, status string) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	entry, ok := j.pending[hash]
+	if !ok {
+		return
+	}
+	delete(j.pending, hash)
+	entry.Status = status
+	if len(j.recent) == maxRecentTransactions {
+		j.recent = j.recent[1:]
+	}
+	j.recent = append(j.recent, entry)
+}
+
+// List returns copies of all pending and recent entries, newest first.
+func (j *journal) List() []JournalEntry {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	now := time.Now()
+	out := make([]JournalEntry, 0, len(j.pending)+len(j.recent))
+	for _, entry := range j.pending {
+		out = append(out, *entry)
+	}
+	for i := len(j.recent) - 1; i >= 0; i-- {
+		out = append(out, *j.recent[i])
+	}
+	for i := range out {
+		out[i].Age = now.Sub(out[i].Sent).Round(time.Second).String()
+	}
+	sort.Slice(out, func(i, k int) bool {
+		return out[i].Sent.After(out[k].Sent)
+	})
+	return out
+}
+
+// ListTransactions serves the journal as JSON on the admin listener.
+func ListTransactions(rw http.ResponseWriter, rq *http.Request) {
+	bs, e := json.Marshal(txJournal.List())
+	if e != nil {
+		log.Println("failed marshalling transaction journal", e)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set(http.CanonicalHeaderKey(`content-type`), `application/json; charset=UTF-8`)
+	rw.Write(bs)
+}
@@ -0,0 +1,66 @@
+// Copyright 2018 karma.run AG. All rights reserved.
+
+package main
+
+import (
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"testing"
+)
+
+// journaled returns the journal entry of hash, nil if there is none.
+func journaled(hash common.Hash) *JournalEntry {
+	for _, entry := range txJournal.List() {
+		if entry.Hash == hash.Hex() {
+			return &entry
+		}
+	}
+	return nil
+}
+
+func TestJournalListsDispatchedTransaction(t *testing.T) {
+	raw, e := dispatchTyped(t, TypedTransactionFields{}, SignatureTypeDefault)
+	if e != nil {
+		t.Fatal(e)
+	}
+	entry := journaled(common.BytesToHash(keccak(raw)))
+	if entry == nil {
+		t.Fatalf(`dispatched transaction not in journal`)
+	}
+	expected := JournalEntry{
+		Hash:   common.BytesToHash(keccak(raw)).Hex(),
+		From:   testAddress.Hex(),
+		Nonce:  7,
+		Target: common.HexToAddress(`0xcc`).Hex(),
+		Status: `0x1`,
+		Sent:   entry.Sent,
+		Age:    entry.Age,
+	}
+	if *entry != expected {
+		t.Fatalf(`expected %+v, have %+v`, expected, *entry)
+	}
+}
+
+func TestJournalCompletesUnknownReceipts(t *testing.T) {
+	raw := []byte(nil)
+	mock := newMockEthClient().
+		Handle(`eth_getTransactionCount`, func(...interface{}) (interface{}, error) { return `0x8`, nil }).
+		Handle(`eth_getTransactionReceipt`, func(...interface{}) (interface{}, error) { return nil, fmt.Errorf(`connection refused`) }).
+		Handle(`eth_sendRawTransaction`, func(args ...interface{}) (interface{}, error) {
+			raw = mustDecodeHex(args[0].(string))
+			return nil, nil
+		})
+	defer useEthClient(mock)()
+	store := abiContract(t, `Store.sol`, `Store`, `[
+		{"type": "function", "name": "set", "stateMutability": "nonpayable", "inputs": [{"name": "v", "type": "uint256"}], "outputs": []}
+	]`)
+	req := DispatchFunctionCallRequest{Target: `0xcc`, GasPrice: `1`, Auth: testAuth}
+	req.File, req.Contract, req.Signature, req.Arguments = `Store.sol`, `Store`, `set(uint256)`, []byte(`[2]`)
+	if e := testHandler(store).DispatchFunctionCall(req, &DispatchFunctionCallResponse{}); e == nil {
+		t.Fatal(`expected receipt error`)
+	}
+	entry := journaled(common.BytesToHash(keccak(raw)))
+	if entry == nil || entry.Status != journalStatusUnknown {
+		t.Fatalf(`expected journal entry with status unknown, have %+v`, entry)
+	}
+}
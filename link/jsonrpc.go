@@ -0,0 +1,424 @@
+// Copyright 2018 karma.run AG. All rights reserved.
+
+package main // import "github.com/karmarun/karma.link/link"
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/rpc"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JSON-RPC 2.0 error codes
+const (
+	jsonrpcParseError     = -32700
+	jsonrpcInvalidRequest = -32600
+	jsonrpcMethodNotFound = -32601
+	jsonrpcInvalidParams  = -32602
+	jsonrpcServerError    = -32000
+)
+
+// serverCodec is a net/rpc ServerCodec speaking JSON-RPC 2.0, including notifications and {code, message, data} errors.
+// Requests without a "jsonrpc" member are answered in the JSON-RPC 1.0 format of net/rpc/jsonrpc, which this codec replaces.
+// Params may be the argument itself or, like in net/rpc/jsonrpc, an array holding it as the only element.
+// Calls exceeding their method's timeout are answered with an error right away and their eventual results dropped.
+// net/rpc has no means to cancel them, so the context of requests embedding requestContext is cancelled instead.
+// Streamed results are written once their calls returned, so the timeout can't be answered anymore: their context
+// stays valid until the stream has been written, and its deadline, the method's timeout, bounds the whole stream.
+// Over persistent connections (see push), calls returning a pushedResult are answered with its ID and followed by
+// notifications {"jsonrpc":"2.0","method":"v1.Subscription","params":{"subscription":ID,"result":...}}.
+type serverCodec struct {
+	decoder  *json.Decoder
+	encoder  *json.Encoder
+	writer   io.Writer // written to directly by streamed results only
+	closer   io.Closer
+	timeouts methodTimeouts
+	push     bool // the connection is persistent and frames messages (WebSocket): notifications can be pushed, streams are buffered
+
+	writeMutex sync.Mutex // guards encoder and writer, acquired after mutex if both are needed
+
+	request serverRequest   // the request being read
+	current *pendingRequest // idem
+
+	mutex   sync.Mutex // guards everything below
+	seq     uint64
+	pending map[uint64]*pendingRequest
+	expired map[uint64]bool // timed out, answered already
+	writing int             // responses being written after their calls have been removed from pending
+	eof     bool            // all requests have been read
+	done    chan struct{}   // closed when all requests have been read and answered
+
+	subscriptions map[string]pushedResult // pushing notifications, cancelled on Close
+}
+
+type serverRequest struct {
+	Version string           `json:"jsonrpc"`
+	Method  string           `json:"method"`
+	Params  json.RawMessage  `json:"params"`
+	Id      *json.RawMessage `json:"id"`
+}
+
+type pendingRequest struct {
+	version string
+	id      *json.RawMessage
+	err     *jsonrpcError // overrides the error reported by net/rpc
+	ctx     context.Context
+	cancel  context.CancelFunc // called once the call has been answered
+	timer   *time.Timer        // expires the call, nil if it can't time out
+	expiry  string             // the timeout error expire answers with
+}
+
+// requestContext is embedded in the request types of methods calling geth. serverCodec sets it to a context
+// that is done once the call has timed out or been answered, so the method can stop waiting for geth.
+type requestContext struct {
+	ctx context.Context
+}
+
+// contextSetter is implemented by pointers to request types embedding requestContext.
+type contextSetter interface {
+	setContext(ctx context.Context)
+}
+
+func (r *requestContext) setContext(ctx context.Context) {
+	r.ctx = ctx
+}
+
+// Context returns the call's context, or context.Background() if the request wasn't read by serverCodec.
+func (r requestContext) Context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+type jsonrpcError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+type serverResponse struct {
+	Version string           `json:"jsonrpc"`
+	Id      *json.RawMessage `json:"id"`
+	Result  interface{}      `json:"result,omitempty"`
+	Error   *jsonrpcError    `json:"error,omitempty"`
+}
+
+// serverNotification is a notification pushed to a subscriber.
+type serverNotification struct {
+	Version string             `json:"jsonrpc"`
+	Method  string             `json:"method"`
+	Params  subscriptionParams `json:"params"`
+}
+
+type subscriptionParams struct {
+	Subscription string      `json:"subscription"`
+	Result       interface{} `json:"result"`
+}
+
+// serverResponse1 is a JSON-RPC 1.0 response. Result and Error are always present, one of them null.
+type serverResponse1 struct {
+	Id     *json.RawMessage `json:"id"`
+	Result interface{}      `json:"result"`
+	Error  interface{}      `json:"error"`
+}
+
+var null = json.RawMessage(`null`)
+
+// streamedResult is a result too large to be buffered, e.g. of GetLogs. WriteResponse has it write its
+// JSON directly to the connection, framed like other results.
+type streamedResult interface {
+	WriteJSON(w io.Writer) error
+}
+
+func newServerCodec(conn io.ReadWriteCloser, timeouts methodTimeouts) *serverCodec {
+	return &serverCodec{
+		decoder:  json.NewDecoder(conn),
+		encoder:  json.NewEncoder(conn),
+		writer:   conn,
+		closer:   conn,
+		timeouts: timeouts,
+		pending:  make(map[uint64]*pendingRequest, 1),
+		expired:  make(map[uint64]bool),
+		done:     make(chan struct{}),
+
+		subscriptions: make(map[string]pushedResult),
+	}
+}
+
+// Done is closed when all requests have been read and answered, including by timeout errors.
+// Unlike rpc.Server.ServeCodec, it doesn't wait for timed out calls to return.
+func (c *serverCodec) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *serverCodec) ReadRequestHeader(r *rpc.Request) error {
+	c.request = serverRequest{}
+	if e := c.decoder.Decode(&c.request); e != nil {
+		if e != io.EOF && e != io.ErrUnexpectedEOF {
+			// the stream can't be resynchronized, answer and stop reading
+			c.write(serverResponse{Version: `2.0`, Id: &null, Error: &jsonrpcError{Code: jsonrpcParseError, Message: `parse error`, Data: e.Error()}})
+			e = io.EOF
+		}
+		c.mutex.Lock()
+		c.eof = true
+		c.checkDone()
+		c.mutex.Unlock()
+		return e
+	}
+	pending := &pendingRequest{version: c.request.Version, id: c.request.Id}
+	if pending.id == nil && pending.version == "" {
+		pending.id = &null // JSON-RPC 1.0 requests are always answered
+	}
+	if c.request.Version != "" && c.request.Version != `2.0` {
+		pending.err = &jsonrpcError{Code: jsonrpcInvalidRequest, Message: `unsupported jsonrpc version: ` + c.request.Version}
+	}
+	if c.request.Method == "" {
+		pending.err = &jsonrpcError{Code: jsonrpcInvalidRequest, Message: `missing method`}
+	}
+	method, timeout := c.request.Method, c.timeouts.Of(c.request.Method)
+	if pending.err != nil {
+		method, timeout = "", 0 // an empty method makes net/rpc discard the body and respond with an error, replaced by pending.err
+	}
+	if timeout > 0 {
+		pending.ctx, pending.cancel = context.WithTimeout(context.Background(), timeout)
+	} else {
+		pending.ctx, pending.cancel = context.WithCancel(context.Background())
+	}
+	c.mutex.Lock()
+	c.seq++
+	seq := c.seq
+	c.pending[seq] = pending
+	if timeout > 0 {
+		pending.expiry = fmt.Sprintf(`%s timed out after %s`, method, timeout)
+		pending.timer = time.AfterFunc(timeout, func() { c.expire(seq) })
+	}
+	c.mutex.Unlock()
+	c.current = pending
+	r.Seq, r.ServiceMethod = seq, method
+	return nil
+}
+
+// expire answers the call seq with a timeout error if it's still pending.
+func (c *serverCodec) expire(seq uint64) {
+	c.mutex.Lock()
+	pending, ok := c.pending[seq]
+	if !ok {
+		c.mutex.Unlock()
+		return
+	}
+	delete(c.pending, seq)
+	c.expired[seq] = true
+	c.writing++
+	c.mutex.Unlock()
+	pending.cancel()
+	c.send(func() error {
+		if pending.version == "" {
+			return c.encoder.Encode(serverResponse1{Id: pending.id, Error: pending.expiry})
+		}
+		if pending.id != nil {
+			return c.encoder.Encode(serverResponse{Version: `2.0`, Id: pending.id, Error: &jsonrpcError{Code: jsonrpcServerError, Message: pending.expiry}})
+		}
+		return nil
+	})
+}
+
+// send writes a response of a call that has already been removed from pending, counted by writing.
+// It must be called without mutex held, so a slow response, e.g. a streamed result, doesn't hold up reading
+// requests and expiring calls.
+func (c *serverCodec) send(write func() error) error {
+	c.writeMutex.Lock()
+	e := write()
+	c.writeMutex.Unlock()
+	c.mutex.Lock()
+	c.writing--
+	c.checkDone()
+	c.mutex.Unlock()
+	return e
+}
+
+// checkDone closes done once all requests have been read and answered. It must be called with mutex held.
+func (c *serverCodec) checkDone() {
+	if c.eof && len(c.pending) == 0 && c.writing == 0 {
+		select {
+		case <-c.done:
+		default:
+			close(c.done)
+		}
+	}
+}
+
+func (c *serverCodec) ReadRequestBody(x interface{}) error {
+	if x == nil {
+		return nil
+	}
+	if setter, ok := x.(contextSetter); ok {
+		setter.setContext(c.current.ctx)
+	}
+	params := c.request.Params
+	if len(params) == 0 || string(params) == `null` {
+		return nil // zero value
+	}
+	e := error(nil)
+	if strings.HasPrefix(strings.TrimSpace(string(params)), `[`) {
+		wrapper := [1]interface{}{x}
+		e = json.Unmarshal(params, &wrapper)
+	} else {
+		e = json.Unmarshal(params, x)
+	}
+	if e != nil {
+		c.current.err = &jsonrpcError{Code: jsonrpcInvalidParams, Message: `invalid params`, Data: e.Error()}
+		return fmt.Errorf(`invalid params: %s`, e)
+	}
+	return nil
+}
+
+func (c *serverCodec) WriteResponse(r *rpc.Response, x interface{}) error {
+	c.mutex.Lock()
+	pushed, isPushed := x.(pushedResult)
+	isPushed = isPushed && r.Error == ""
+	if c.expired[r.Seq] {
+		delete(c.expired, r.Seq)
+		c.mutex.Unlock()
+		if isPushed {
+			pushed.Cancel() // nobody knows its ID
+		}
+		return nil // answered by expire
+	}
+	pending, ok := c.pending[r.Seq]
+	delete(c.pending, r.Seq)
+	if !ok {
+		c.checkDone()
+		c.mutex.Unlock()
+		return fmt.Errorf(`invalid sequence number in response`)
+	}
+	if pending.timer != nil && !pending.timer.Stop() {
+		// expired along with the call's context, but expire is still waiting for mutex: answer like it would
+		if isPushed {
+			pushed.Cancel()
+		}
+		r, x, isPushed = &rpc.Response{ServiceMethod: r.ServiceMethod, Seq: r.Seq, Error: pending.expiry}, nil, false
+		pending.err = nil
+	}
+	if isPushed {
+		defer c.mutex.Unlock()
+		defer c.checkDone()
+		defer pending.cancel()
+		return c.subscribe(pending, pushed)
+	}
+	c.writing++ // answered as of now, but done has to wait for the response to be written
+	c.mutex.Unlock()
+	defer pending.cancel() // streamed results still use it
+	return c.send(func() error { return c.respond(pending, r, x) })
+}
+
+// respond writes the response to a call. It must be called with writeMutex held.
+func (c *serverCodec) respond(pending *pendingRequest, r *rpc.Response, x interface{}) error {
+	if pending.version == "" {
+		if r.Error != "" {
+			return c.encoder.Encode(serverResponse1{Id: pending.id, Error: r.Error})
+		}
+		if stream, ok := x.(streamedResult); ok && !c.push {
+			return c.writeStream(pending, stream)
+		}
+		return c.encoder.Encode(serverResponse1{Id: pending.id, Result: x})
+	}
+	if pending.id == nil {
+		return nil // notification
+	}
+	if stream, ok := x.(streamedResult); ok && r.Error == "" && !c.push {
+		return c.writeStream(pending, stream)
+	}
+	if r.Error == "" {
+		if x == nil {
+			x = null
+		}
+		return c.encoder.Encode(serverResponse{Version: `2.0`, Id: pending.id, Result: x})
+	}
+	err := pending.err
+	if err == nil {
+		err = &jsonrpcError{Code: jsonrpcServerError, Message: r.Error}
+		if strings.HasPrefix(r.Error, `rpc: can't find `) {
+			err.Code = jsonrpcMethodNotFound
+		}
+	}
+	return c.encoder.Encode(serverResponse{Version: `2.0`, Id: pending.id, Error: err})
+}
+
+// subscribe answers a call with its pushed result's ID and starts pushing notifications.
+// It must be called with mutex held.
+func (c *serverCodec) subscribe(pending *pendingRequest, pushed pushedResult) error {
+	if !c.push || pending.id == nil {
+		pushed.Cancel()
+		message := `subscriptions require a WebSocket connection`
+		if pending.version == "" {
+			return c.write(serverResponse1{Id: pending.id, Error: message})
+		}
+		if pending.id == nil {
+			return nil // notification
+		}
+		return c.write(serverResponse{Version: `2.0`, Id: pending.id, Error: &jsonrpcError{Code: jsonrpcServerError, Message: message}})
+	}
+	id := pushed.ID()
+	c.subscriptions[id] = pushed
+	e := error(nil)
+	if pending.version == "" {
+		e = c.write(serverResponse1{Id: pending.id, Result: id})
+	} else {
+		e = c.write(serverResponse{Version: `2.0`, Id: pending.id, Result: id})
+	}
+	go func() { // NOTE: started after answering, notifications must not precede the ID
+		pushed.Push(func(result interface{}) error {
+			return c.write(serverNotification{Version: `2.0`, Method: subscriptionNotificationMethod, Params: subscriptionParams{Subscription: id, Result: result}})
+		})
+		c.mutex.Lock()
+		delete(c.subscriptions, id)
+		c.mutex.Unlock()
+	}()
+	return e
+}
+
+// writeStream writes a response with a streamed result. It must be called with writeMutex held, but not mutex.
+// Once the result started streaming, errors can't be answered anymore. The response is left truncated
+// (i.e. invalid JSON) instead, so it can't be mistaken for a complete one.
+func (c *serverCodec) writeStream(pending *pendingRequest, stream streamedResult) error {
+	id, _ := json.Marshal(pending.id)
+	prefix, suffix := `{"jsonrpc":"2.0","id":`+string(id)+`,"result":`, "}\n"
+	if pending.version == "" {
+		prefix, suffix = `{"id":`+string(id)+`,"result":`, `,"error":null}`+"\n"
+	}
+	if _, e := io.WriteString(c.writer, prefix); e != nil {
+		return e
+	}
+	if e := stream.WriteJSON(c.writer); e != nil {
+		log.Println(`failed streaming result:`, e)
+		return e
+	}
+	_, e := io.WriteString(c.writer, suffix)
+	return e
+}
+
+func (c *serverCodec) write(response interface{}) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	return c.encoder.Encode(response)
+}
+
+// Close cancels the connection's subscriptions and closes it.
+func (c *serverCodec) Close() error {
+	c.mutex.Lock()
+	pushed := make([]pushedResult, 0, len(c.subscriptions))
+	for _, subscription := range c.subscriptions {
+		pushed = append(pushed, subscription)
+	}
+	c.mutex.Unlock()
+	for _, subscription := range pushed {
+		subscription.Cancel() // NOTE: may wait for the upstream unsubscription, so not with mutex held
+	}
+	return c.closer.Close()
+}
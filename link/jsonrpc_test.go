@@ -0,0 +1,173 @@
+// Copyright 2018 karma.run AG. All rights reserved.
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"github.com/karmarun/karma.link/types"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/rpc"
+	"strings"
+	"testing"
+)
+
+// testRPCHTTPHandler serves contracts like the server does over HTTP. Calls time out after 10s
+// unless overridden by methodTimeouts ("Method=duration,...").
+func testRPCHTTPHandler(t *testing.T, methodTimeouts string, contracts ...*types.Contract) http.Handler {
+	rpcServer := rpc.NewServer()
+	if e := rpcServer.RegisterName(`v1`, testHandler(contracts...)); e != nil {
+		t.Fatal(e)
+	}
+	timeouts, e := parseMethodTimeouts(`10s`, methodTimeouts)
+	if e != nil {
+		t.Fatal(e)
+	}
+	return rpcHTTPHandler(rpcServer, timeouts)
+}
+
+// serveRequests posts requests to a handler for a Token contract, see postRequests.
+func serveRequests(t *testing.T, requests ...string) map[string]map[string]json.RawMessage {
+	return postRequests(t, testRPCHTTPHandler(t, ``, abiContract(t, `Token.sol`, `Token`, transferABI)), requests...)
+}
+
+// postRequests posts requests, each a JSON value, in a single HTTP body to handler and returns the responses by their raw id.
+func postRequests(t *testing.T, handler http.Handler, requests ...string) map[string]map[string]json.RawMessage {
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, httptest.NewRequest(`POST`, `/`, strings.NewReader(strings.Join(requests, "\n"))))
+
+	responses := map[string]map[string]json.RawMessage{}
+	for decoder := json.NewDecoder(rw.Body); ; {
+		response := map[string]json.RawMessage{}
+		if e := decoder.Decode(&response); e == io.EOF {
+			break
+		} else if e != nil {
+			t.Fatalf(`invalid response: %s`, e)
+		}
+		id := string(response[`id`])
+		if _, ok := responses[id]; ok {
+			t.Fatalf(`duplicate response with id %s`, id)
+		}
+		responses[id] = response
+	}
+	return responses
+}
+
+func TestJSONRPC2Responses(t *testing.T) {
+	responses := serveRequests(t,
+		`{"jsonrpc": "2.0", "id": "a", "method": "v1.GetFiles", "params": {}}`,
+		`{"jsonrpc": "2.0", "method": "v1.GetFiles", "params": {}}`,
+		`{"jsonrpc": "2.0", "id": 2, "method": "v1.Missing", "params": {}}`,
+		`{"jsonrpc": "2.0", "id": 3, "method": "v1.GetContracts", "params": {"file": 1}}`,
+		`{"jsonrpc": "2.0", "id": 4, "method": "v1.GetContracts", "params": "Missing.sol"}`,
+		`{"jsonrpc": "3.0", "id": 5, "method": "v1.GetFiles"}`,
+		`{"id": 6, "method": "v1.GetContracts", "params": ["Token.sol"]}`,
+	)
+
+	for id, expected := range map[string]map[string]string{
+		`"a"`: {`jsonrpc`: `"2.0"`, `id`: `"a"`, `result`: `["Token.sol"]`},
+		`2`:   {`jsonrpc`: `"2.0"`, `id`: `2`, `error`: `{"code": -32601, "message": "rpc: can't find method v1.Missing"}`},
+		`4`:   {`jsonrpc`: `"2.0"`, `id`: `4`, `error`: `{"code": -32000, "message": "file not found: Missing.sol"}`},
+		`5`:   {`jsonrpc`: `"2.0"`, `id`: `5`, `error`: `{"code": -32600, "message": "unsupported jsonrpc version: 3.0"}`},
+		`6`:   {`id`: `6`, `result`: `["Token"]`, `error`: `null`}, // JSON-RPC 1.0
+	} {
+		response := responses[id]
+		if len(response) != len(expected) {
+			t.Fatalf(`id %s: expected %v, have %v`, id, expected, response)
+		}
+		for key, value := range expected {
+			if !jsonEqualString(response[key], value) {
+				t.Fatalf(`id %s: expected %s %s, have %s`, id, key, value, response[key])
+			}
+		}
+	}
+
+	// invalid params carry the decoding error as data
+	invalid := responses[`3`]
+	err := jsonrpcError{}
+	if e := json.Unmarshal(invalid[`error`], &err); e != nil || err.Code != jsonrpcInvalidParams || err.Data == nil {
+		t.Fatalf(`unexpected invalid params response %v`, invalid)
+	}
+
+	// the notification isn't answered
+	if len(responses) != 6 {
+		t.Fatalf(`expected 6 responses, have %d`, len(responses))
+	}
+}
+
+func TestJSONRPC2ParseError(t *testing.T) {
+	responses := serveRequests(t, `{"jsonrpc": "2.0", "id": 1, "method": "v1.GetFiles"}`, `{"jsonrpc": ]}`)
+	if !jsonEqualString(responses[`1`][`result`], `["Token.sol"]`) {
+		t.Fatalf(`unexpected response %v`, responses[`1`])
+	}
+	err := jsonrpcError{}
+	if e := json.Unmarshal(responses[`null`][`error`], &err); e != nil || err.Code != jsonrpcParseError {
+		t.Fatalf(`expected parse error, have %v`, responses[`null`])
+	}
+}
+
+// gzipped compresses request, appending padding spaces.
+func gzipped(t *testing.T, request string, padding int) io.Reader {
+	buffer := &bytes.Buffer{}
+	gz := gzip.NewWriter(buffer)
+	if _, e := io.WriteString(gz, request); e != nil {
+		t.Fatal(e)
+	}
+	if _, e := gz.Write(bytes.Repeat([]byte{' '}, padding)); e != nil {
+		t.Fatal(e)
+	}
+	if e := gz.Close(); e != nil {
+		t.Fatal(e)
+	}
+	return buffer
+}
+
+func TestGzipRequestBody(t *testing.T) {
+	handler := testRPCHTTPHandler(t, ``, abiContract(t, `Token.sol`, `Token`, transferABI))
+
+	rq := httptest.NewRequest(`POST`, `/`, gzipped(t, `{"jsonrpc": "2.0", "id": 1, "method": "v1.GetFiles", "params": {}}`, 1<<20))
+	rq.Header.Set(`Content-Encoding`, `gzip`)
+	rq.Header.Set(`Accept-Encoding`, `gzip`)
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, rq)
+	if rw.Header().Get(`Content-Encoding`) != `gzip` {
+		t.Fatalf(`expected a gzipped response, have headers %v`, rw.Header())
+	}
+	gz, e := gzip.NewReader(rw.Body)
+	if e != nil {
+		t.Fatal(e)
+	}
+	response := map[string]json.RawMessage{}
+	if e := json.NewDecoder(gz).Decode(&response); e != nil {
+		t.Fatal(e)
+	}
+	if !jsonEqualString(response[`result`], `["Token.sol"]`) {
+		t.Fatalf(`unexpected response %v`, response)
+	}
+
+	// not gzip at all
+	rq = httptest.NewRequest(`POST`, `/`, strings.NewReader(`{"jsonrpc": "2.0", "id": 1, "method": "v1.GetFiles", "params": {}}`))
+	rq.Header.Set(`Content-Encoding`, `gzip`)
+	rw = httptest.NewRecorder()
+	handler.ServeHTTP(rw, rq)
+	if rw.Code != http.StatusBadRequest {
+		t.Fatalf(`expected status 400, have %d`, rw.Code)
+	}
+
+	// decompression bomb, the request is cut off at the limit
+	rq = httptest.NewRequest(`POST`, `/`, gzipped(t, `{"jsonrpc": "2.0", "id": 1, "method": "v1.GetFiles", "params": {}`, maxDecompressedRequestSize+1))
+	rq.Header.Set(`Content-Encoding`, `gzip`)
+	rw = httptest.NewRecorder()
+	handler.ServeHTTP(rw, rq)
+	response = map[string]json.RawMessage{}
+	if e := json.NewDecoder(rw.Body).Decode(&response); e != nil {
+		t.Fatal(e)
+	}
+	err := jsonrpcError{}
+	if e := json.Unmarshal(response[`error`], &err); e != nil || err.Code != jsonrpcParseError {
+		t.Fatalf(`expected the oversized request to be rejected, have %v`, response)
+	}
+}
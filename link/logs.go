@@ -0,0 +1,434 @@
+// Copyright 2018 karma.run AG. All rights reserved.
+
+package main // import "github.com/karmarun/karma.link/link"
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	ethrpc "github.com/ethereum/go-ethereum/rpc"
+	"github.com/karmarun/karma.link/abi"
+	"github.com/karmarun/karma.link/types"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const defaultLogsChunkSize = 5000 // blocks per eth_getLogs query
+
+type GetLogsRequest struct {
+	requestContext
+	GetContractRequest
+	Event     string      `json:"event"`     // event name, all of the contract's events if empty
+	Address   string      `json:"address"`   // emitting contract, any if empty
+	FromBlock json.Number `json:"fromBlock"` // genesis if empty
+	ToBlock   json.Number `json:"toBlock"`   // latest block if empty
+	ChunkSize json.Number `json:"chunkSize"` // blocks per query, halved whenever the node rejects a query as too large
+	Decimals  json.Number `json:"decimals"`  // adds args with unsigned integers scaled by 10^-decimals if set, see scaleDecimals
+}
+
+type DecodedLog struct {
+	TransactionReceiptLog
+	Event  string          `json:"event"` // signature
+	Args   json.RawMessage `json:"args"`
+	Scaled json.RawMessage `json:"scaled,omitempty"` // only if decimals were requested
+}
+
+type logFilter struct {
+	FromBlock string     `json:"fromBlock"`
+	ToBlock   string     `json:"toBlock"`
+	Address   string     `json:"address,omitempty"`
+	Topics    [][]string `json:"topics"`
+}
+
+// GetLogs fetches and decodes the logs of a contract's events in a range of blocks.
+// The range is queried in chunks since nodes usually limit the size of eth_getLogs results.
+// Only the first chunk is fetched before responding: the logs are streamed to the response as the following
+// chunks are fetched and decoded, see LogStream.
+func (h RpcHandler) GetLogs(req GetLogsRequest, res *LogStream) error {
+
+	ctx := req.Context()
+
+	events, topics, e := h.logEvents(req.GetContractRequest, req.Event)
+	if e != nil {
+		return e
+	}
+
+	decimals, e := parseDecimals(req.Decimals)
+	if e != nil {
+		return e
+	}
+
+	if len(topics) == 0 {
+		if req.Event != "" {
+			return fmt.Errorf(`event not found: %s`, req.Event)
+		}
+		*res = LogStream{query: &logQuery{done: true}}
+		return nil
+	}
+
+	fromBlock, toBlock, chunkSize := uint64(0), uint64(0), uint64(defaultLogsChunkSize)
+	if req.FromBlock != "" {
+		n, e := strconv.ParseUint(string(req.FromBlock), 10, 64)
+		if e != nil {
+			return fmt.Errorf(`invalid fromBlock`)
+		}
+		fromBlock = n
+	}
+	if req.ToBlock != "" {
+		n, e := strconv.ParseUint(string(req.ToBlock), 10, 64)
+		if e != nil {
+			return fmt.Errorf(`invalid toBlock`)
+		}
+		toBlock = n
+	} else {
+		latest := ""
+		if e := EthClient.CallContext(ctx, &latest, `eth_blockNumber`); e != nil {
+			return e // TODO: better error
+		}
+		toBlock, _ = strconv.ParseUint(strip0xPrefix(latest), 16, 64)
+	}
+	if req.ChunkSize != "" {
+		n, e := strconv.ParseUint(string(req.ChunkSize), 10, 64)
+		if e != nil || n == 0 {
+			return fmt.Errorf(`invalid chunkSize`)
+		}
+		chunkSize = n
+	}
+
+	query := &logQuery{
+		ctx:       ctx,
+		events:    events,
+		address:   req.Address,
+		topics:    topics,
+		decimals:  decimals,
+		from:      fromBlock,
+		to:        toBlock,
+		chunkSize: chunkSize,
+		done:      fromBlock > toBlock,
+	}
+	first, e := query.next()
+	if e != nil {
+		return e
+	}
+
+	*res = LogStream{query: query, first: first}
+	return nil
+}
+
+// logQuery fetches and decodes the logs of a range of blocks chunk by chunk.
+type logQuery struct {
+	ctx       context.Context // of the GetLogs call, its deadline bounds the whole query including streaming, see serverCodec
+	events    map[[32]byte][]types.Event
+	address   string
+	topics    []string // signature topics, any of which a log must have
+	decimals  int      // -1 if not requested
+	from      uint64   // first block of the next chunk
+	to        uint64   // last block of the range
+	chunkSize uint64
+	done      bool // all chunks have been fetched
+}
+
+// next fetches and decodes the logs of the next chunk of blocks.
+func (q *logQuery) next() ([]DecodedLog, error) {
+	for !q.done {
+
+		if e := q.ctx.Err(); e != nil {
+			return nil, fmt.Errorf(`failed fetching logs from block %d: %s`, q.from, e)
+		}
+
+		to := q.to
+		if q.to-q.from >= q.chunkSize {
+			to = q.from + q.chunkSize - 1
+		}
+
+		logs := make([]TransactionReceiptLog, 0, 64)
+		filter := logFilter{
+			FromBlock: ensure0xPrefix(strconv.FormatUint(q.from, 16)),
+			ToBlock:   ensure0xPrefix(strconv.FormatUint(to, 16)),
+			Address:   q.address,
+			Topics:    [][]string{q.topics},
+		}
+		if e := EthClient.CallContext(q.ctx, &logs, `eth_getLogs`, filter); e != nil {
+			if isLogsLimitError(e) && q.chunkSize > 1 {
+				q.chunkSize /= 2
+				continue
+			}
+			return nil, e // TODO: better error
+		}
+
+		out := make([]DecodedLog, 0, len(logs))
+		for _, log := range logs {
+			decoded, e := q.decode(log)
+			if e != nil {
+				return nil, e
+			}
+			out = append(out, decoded)
+		}
+
+		if to == q.to {
+			q.done = true // NOTE: to+1 could overflow
+		} else {
+			q.from = to + 1
+		}
+		return out, nil
+	}
+	return []DecodedLog{}, nil
+}
+
+// decode decodes a log emitted by one of the query's events, adding scaled args if decimals were requested.
+func (q *logQuery) decode(log TransactionReceiptLog) (DecodedLog, error) {
+	decoded, event, e := decodeLog(q.events, log)
+	if e == nil && q.decimals != -1 {
+		decoded.Scaled, e = scaleDecimals(types.Tuple(event.Args), decoded.Args, q.decimals)
+	}
+	if e != nil {
+		return DecodedLog{}, fmt.Errorf(`failed decoding log %s of transaction %s: %s`, log.LogIndex, log.TransactionHash, e)
+	}
+	return decoded, nil
+}
+
+// logEvents returns the events of a contract named name, or all of them if name is empty,
+// indexed by signature topic, along with the sorted hex-encoded topics.
+func (h RpcHandler) logEvents(req GetContractRequest, name string) (map[[32]byte][]types.Event, []string, error) {
+
+	file, ok := h.project.Files[req.File]
+	if !ok {
+		return nil, nil, fmt.Errorf(`file not found: %s`, req.File)
+	}
+
+	contract, ok := file[req.Contract]
+	if !ok {
+		return nil, nil, fmt.Errorf(`contract not found: %s`, req.Contract)
+	}
+
+	events := make(map[[32]byte][]types.Event, len(contract.EventTopics))
+	topics := make([]string, 0, len(contract.EventTopics))
+	for topic, event := range contract.EventTopics {
+		if name != "" && event.Name != name {
+			continue
+		}
+		events[topic] = []types.Event{event}
+		topics = append(topics, `0x`+hex.EncodeToString(topic[:]))
+	}
+	sort.Strings(topics)
+	return events, topics, nil
+}
+
+// LogStream is the result of GetLogs, a JSON array of DecodedLog's. It's written element by element
+// while fetching the remaining chunks of its query, instead of being buffered in memory.
+type LogStream struct {
+	query *logQuery
+	first []DecodedLog // of the first chunk, fetched by GetLogs
+}
+
+// WriteJSON implements streamedResult.
+func (s *LogStream) WriteJSON(w io.Writer) error {
+	if _, e := io.WriteString(w, `[`); e != nil {
+		return e
+	}
+	logs, n := s.first, 0
+	for {
+		for _, log := range logs {
+			bs, e := json.Marshal(log)
+			if e != nil {
+				return e
+			}
+			if n > 0 {
+				bs = append([]byte{','}, bs...)
+			}
+			if _, e := w.Write(bs); e != nil {
+				return e
+			}
+			n++
+		}
+		if s.query.done {
+			break
+		}
+		next, e := s.query.next()
+		if e != nil {
+			return e
+		}
+		logs = next
+	}
+	_, e := io.WriteString(w, `]`)
+	return e
+}
+
+// MarshalJSON buffers the whole stream, for uses of LogStream other than responding to GetLogs.
+func (s *LogStream) MarshalJSON() ([]byte, error) {
+	buffer := &bytes.Buffer{}
+	if e := s.WriteJSON(buffer); e != nil {
+		return nil, e
+	}
+	return buffer.Bytes(), nil
+}
+
+// contractEvents maps the hex-encoded signature topics of the events declared in contract and its parents to the events.
+func contractEvents(contract *types.Contract) map[string]types.Event {
+	events := make(map[string]types.Event, len(contract.EventTopics))
+	for topic, event := range contract.EventTopics {
+		events[`0x`+hex.EncodeToString(topic[:])] = event
+	}
+	return events
+}
+
+// decodeReceiptLogs decodes the logs of a transaction receipt on a best-effort basis:
+// logs of unknown events and logs failing to decode, e.g. because of differently indexed args, are skipped.
+func decodeReceiptLogs(events map[[32]byte][]types.Event, logs []TransactionReceiptLog) []DecodedLog {
+	out := make([]DecodedLog, 0, len(logs))
+	for _, log := range logs {
+		if decoded, _, e := decodeLog(events, log); e == nil {
+			out = append(out, decoded)
+		}
+	}
+	return out
+}
+
+// decodeLog decodes log as emitted by one of the events indexed under its signature topic, trying them in turn
+// since events with equal signatures may index different args. It returns the event log was decoded with.
+func decodeLog(events map[[32]byte][]types.Event, log TransactionReceiptLog) (DecodedLog, types.Event, error) {
+	if len(log.Topics) == 0 {
+		return DecodedLog{}, types.Event{}, fmt.Errorf(`missing signature topic`)
+	}
+	topic := [32]byte{}
+	if bs, e := hex.DecodeString(strip0xPrefix(log.Topics[0])); e != nil || len(bs) != len(topic) {
+		return DecodedLog{}, types.Event{}, fmt.Errorf(`invalid topic: %s`, log.Topics[0])
+	} else {
+		copy(topic[:], bs)
+	}
+	err := fmt.Errorf(`unknown event topic: %s`, log.Topics[0])
+	for _, event := range events[topic] {
+		if event.Anonymous {
+			continue
+		}
+		decoded, e := decodeEventArgs(event, log.Topics[1:], log)
+		if e == nil {
+			return decoded, event, nil
+		}
+		err = e
+	}
+	return DecodedLog{}, types.Event{}, err
+}
+
+// decodeEventLog decodes log as emitted by event, checking its signature topic unless event is anonymous.
+// The number and length of log's topics are validated against event's declaration before decoding.
+func decodeEventLog(event types.Event, log TransactionReceiptLog) (DecodedLog, error) {
+	expected := 0
+	if !event.Anonymous {
+		expected++
+	}
+	for _, indexed := range event.Indexed {
+		if indexed {
+			expected++
+		}
+	}
+	if len(log.Topics) != expected {
+		return DecodedLog{}, fmt.Errorf(`%s: expected %d topics, have %d`, event.SoliditySignature(), expected, len(log.Topics))
+	}
+	for _, topic := range log.Topics {
+		if bs, e := hex.DecodeString(strip0xPrefix(topic)); e != nil || len(bs) != 32 {
+			return DecodedLog{}, fmt.Errorf(`invalid topic: %s`, topic)
+		}
+	}
+	logTopics := log.Topics
+	if !event.Anonymous {
+		signatureTopic := `0x` + hex.EncodeToString(keccak(event.SoliditySignature()))
+		if !strings.EqualFold(logTopics[0], signatureTopic) {
+			return DecodedLog{}, fmt.Errorf(`topic %s doesn't match %s's signature topic %s`, logTopics[0], event.SoliditySignature(), signatureTopic)
+		}
+		logTopics = logTopics[1:]
+	}
+	return decodeEventArgs(event, logTopics, log)
+}
+
+// decodeEventArgs decodes the args of event from log's data and logTopics, which exclude the signature topic.
+func decodeEventArgs(event types.Event, logTopics []string, log TransactionReceiptLog) (DecodedLog, error) {
+	topics := make([][]byte, 0, len(logTopics))
+	for _, topic := range logTopics {
+		bs, e := hex.DecodeString(strip0xPrefix(topic))
+		if e != nil {
+			return DecodedLog{}, fmt.Errorf(`invalid topic: %s`, topic)
+		}
+		topics = append(topics, bs)
+	}
+	data, e := hex.DecodeString(strip0xPrefix(log.Data))
+	if e != nil {
+		return DecodedLog{}, fmt.Errorf(`invalid data: %s`, e)
+	}
+	args, e := abi.DecodeLog(event.Args, event.Indexed, topics, data)
+	if e != nil {
+		return DecodedLog{}, e
+	}
+	return DecodedLog{
+		TransactionReceiptLog: log,
+		Event:                 string(event.SoliditySignature()),
+		Args:                  args,
+	}, nil
+}
+
+type DecodeLogByEventRequest struct {
+	GetContractRequest
+	Event  string   `json:"event"` // name, or signature if the event is overloaded
+	Topics []string `json:"topics"`
+	Data   string   `json:"data"`
+}
+
+// DecodeLogByEvent decodes a raw log emitted by an event of a contract (or one of its parents), taking
+// the layout of indexed args from the event's declaration. The log's first topic must be the event's signature topic,
+// unless the event is anonymous.
+func (h RpcHandler) DecodeLogByEvent(req DecodeLogByEventRequest, res *DecodedLog) error {
+
+	file, ok := h.project.Files[req.File]
+	if !ok {
+		return fmt.Errorf(`file not found: %s`, req.File)
+	}
+
+	contract, ok := file[req.Contract]
+	if !ok {
+		return fmt.Errorf(`contract not found: %s`, req.Contract)
+	}
+
+	matches := make([]types.Event, 0, 1)
+	for _, event := range contractEvents(contract) {
+		if event.Name == req.Event || string(event.SoliditySignature()) == req.Event {
+			matches = append(matches, event)
+		}
+	}
+	if len(matches) == 0 {
+		return fmt.Errorf(`event not found: %s`, req.Event)
+	}
+	if len(matches) > 1 {
+		signatures := make([]string, len(matches), len(matches))
+		for i, event := range matches {
+			signatures[i] = string(event.SoliditySignature())
+		}
+		sort.Strings(signatures)
+		return fmt.Errorf(`ambiguous event %s, specify one of: %s`, req.Event, strings.Join(signatures, `, `))
+	}
+
+	decoded, e := decodeEventLog(matches[0], TransactionReceiptLog{Topics: req.Topics, Data: req.Data})
+	if e != nil {
+		return e
+	}
+	*res = decoded
+	return nil
+}
+
+// isLogsLimitError reports whether e is a node's refusal to serve an eth_getLogs query
+// because of the size of its block range or result set.
+func isLogsLimitError(e error) bool {
+	if _, ok := e.(ethrpc.Error); !ok {
+		return false
+	}
+	msg := strings.ToLower(e.Error())
+	for _, s := range []string{`more than`, `too many`, `too large`, `exceed`, `limit`} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,323 @@
+// Copyright 2018 karma.run AG. All rights reserved.
+
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// transferABI declares the ERC-20 Transfer event.
+const transferABI = `[
+	{"type": "event", "name": "Transfer", "anonymous": false, "inputs": [
+		{"name": "from", "type": "address", "indexed": true},
+		{"name": "to", "type": "address", "indexed": true},
+		{"name": "value", "type": "uint256", "indexed": false}]}
+]`
+
+var transferTopic = `0x` + hex.EncodeToString(keccak([]byte(`Transfer(address,address,uint256)`)))
+
+// transferLog returns a Transfer log of value from 0xaa to 0xbb in block.
+func transferLog(block uint64, value uint64) TransactionReceiptLog {
+	return TransactionReceiptLog{
+		BlockNumber: ensure0xPrefix(strconv.FormatUint(block, 16)),
+		Topics:      []string{transferTopic, `0x` + word(0xaa), `0x` + word(0xbb)},
+		Data:        `0x` + word(value),
+	}
+}
+
+// limitError is a node's refusal of an eth_getLogs query.
+type limitError string
+
+func (e limitError) Error() string  { return string(e) }
+func (e limitError) ErrorCode() int { return -32005 }
+
+func TestGetLogsChunks(t *testing.T) {
+	token := abiContract(t, `Token.sol`, `Token`, transferABI)
+
+	queried := [][2]uint64{}
+	mock := newMockEthClient().Handle(`eth_getLogs`, func(args ...interface{}) (interface{}, error) {
+		filter := args[0].(logFilter)
+		from, _ := strconv.ParseUint(strip0xPrefix(filter.FromBlock), 16, 64)
+		to, _ := strconv.ParseUint(strip0xPrefix(filter.ToBlock), 16, 64)
+		if to-from+1 > 100 {
+			return nil, limitError(`query exceeds max block range 100`)
+		}
+		queried = append(queried, [2]uint64{from, to})
+		return []TransactionReceiptLog{transferLog(from, 1)}, nil
+	})
+	defer useEthClient(mock)()
+
+	req := GetLogsRequest{GetContractRequest: GetContractRequest{File: `Token.sol`, Contract: `Token`}, FromBlock: `0`, ToBlock: `999`, ChunkSize: `1000`}
+	res := LogStream{}
+	if e := testHandler(token).GetLogs(req, &res); e != nil {
+		t.Fatal(e)
+	}
+	bs, e := res.MarshalJSON()
+	if e != nil {
+		t.Fatal(e)
+	}
+	logs := []DecodedLog{}
+	if e := json.Unmarshal(bs, &logs); e != nil {
+		t.Fatal(e)
+	}
+
+	// halved from 1000 to 62 blocks per query
+	next := uint64(0)
+	for _, chunk := range queried {
+		if chunk[0] != next || chunk[1]-chunk[0]+1 > 62 {
+			t.Fatalf(`unexpected chunks %v`, queried)
+		}
+		next = chunk[1] + 1
+	}
+	if next != 1000 || len(queried) != 17 {
+		t.Fatalf(`expected 17 chunks covering blocks 0 to 999, have %v`, queried)
+	}
+	args := `["0x00000000000000000000000000000000000000aa","0x00000000000000000000000000000000000000bb",1]`
+	if len(logs) != len(queried) || logs[0].Event != `Transfer(address,address,uint256)` || !jsonEqualString(logs[0].Args, args) {
+		t.Fatalf(`unexpected logs: %s`, bs)
+	}
+}
+
+func TestGetLogsStreamsManyLogs(t *testing.T) {
+	token := abiContract(t, `Token.sol`, `Token`, transferABI)
+	mock := newMockEthClient().Handle(`eth_getLogs`, func(args ...interface{}) (interface{}, error) {
+		filter := args[0].(logFilter)
+		from, _ := strconv.ParseUint(strip0xPrefix(filter.FromBlock), 16, 64)
+		logs := make([]TransactionReceiptLog, 500)
+		for i := range logs {
+			logs[i] = transferLog(from, from*500+uint64(i))
+		}
+		return logs, nil
+	})
+	defer useEthClient(mock)()
+
+	responses := postRequests(t, testRPCHTTPHandler(t, ``, token),
+		`{"jsonrpc": "2.0", "id": 1, "method": "v1.GetLogs", "params": {"file": "Token.sol", "contract": "Token", "fromBlock": "0", "toBlock": "19", "chunkSize": "1"}}`,
+		`{"jsonrpc": "2.0", "id": 2, "method": "v1.GetFiles", "params": {}}`,
+	)
+	if files := responses[`2`][`result`]; !jsonEqualString(files, `["Token.sol"]`) {
+		t.Fatalf(`expected the other request to be answered, have %v`, responses[`2`])
+	}
+	logs := []DecodedLog{}
+	if e := json.Unmarshal(responses[`1`][`result`], &logs); e != nil {
+		t.Fatalf(`invalid result: %s`, e)
+	}
+	if len(logs) != 10000 || mock.Called(`eth_getLogs`) != 20 {
+		t.Fatalf(`expected 10000 logs of 20 chunks, have %d logs of %d`, len(logs), mock.Called(`eth_getLogs`))
+	}
+	for i, log := range logs {
+		args := fmt.Sprintf(`["0x00000000000000000000000000000000000000aa","0x00000000000000000000000000000000000000bb",%d]`, i)
+		if block := ensure0xPrefix(strconv.FormatUint(uint64(i/500), 16)); log.BlockNumber != block || !jsonEqualString(log.Args, args) {
+			t.Fatalf(`log %d: expected %s in block %s, have %s in block %s`, i, args, block, log.Args, log.BlockNumber)
+		}
+	}
+}
+
+func TestGetLogsStreamDeadline(t *testing.T) {
+	token := abiContract(t, `Token.sol`, `Token`, transferABI)
+	mock := newMockEthClient().Handle(`eth_getLogs`, func(args ...interface{}) (interface{}, error) {
+		filter := args[0].(logFilter)
+		from, _ := strconv.ParseUint(strip0xPrefix(filter.FromBlock), 16, 64)
+		if from > 0 {
+			time.Sleep(100 * time.Millisecond) // the remaining chunks take 2s
+		}
+		return []TransactionReceiptLog{transferLog(from, 1)}, nil
+	})
+	defer useEthClient(mock)()
+
+	// the method timeout bounds the whole query, although the call returned with the first chunk
+	start := time.Now()
+	rw := httptest.NewRecorder()
+	request := `{"jsonrpc": "2.0", "id": 1, "method": "v1.GetLogs", "params": {"file": "Token.sol", "contract": "Token", "fromBlock": "0", "toBlock": "20", "chunkSize": "1"}}`
+	testRPCHTTPHandler(t, `GetLogs=300ms`, token).ServeHTTP(rw, httptest.NewRequest(`POST`, `/`, strings.NewReader(request)))
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf(`expected the query to be cancelled after 300ms, took %s`, elapsed)
+	}
+	body := rw.Body.String()
+	if !strings.HasPrefix(body, `{"jsonrpc":"2.0","id":1,"result":[{`) || json.Valid([]byte(body)) {
+		t.Fatalf(`expected a truncated response, have %s`, body)
+	}
+	if n := mock.Called(`eth_getLogs`); n >= 21 {
+		t.Fatalf(`expected the query to stop, have %d eth_getLogs calls`, n)
+	}
+}
+
+func TestGetLogsDecimals(t *testing.T) {
+	token := abiContract(t, `Token.sol`, `Token`, transferABI)
+	mock := newMockEthClient().Handle(`eth_getLogs`, func(...interface{}) (interface{}, error) {
+		return []TransactionReceiptLog{transferLog(1, 1500000000000000000), transferLog(1, 1)}, nil
+	})
+	defer useEthClient(mock)()
+
+	req := GetLogsRequest{GetContractRequest: GetContractRequest{File: `Token.sol`, Contract: `Token`}, Event: `Transfer`, ToBlock: `1`, Decimals: `18`}
+	res := LogStream{}
+	if e := testHandler(token).GetLogs(req, &res); e != nil {
+		t.Fatal(e)
+	}
+	bs, e := res.MarshalJSON()
+	if e != nil {
+		t.Fatal(e)
+	}
+	logs := []DecodedLog{}
+	if e := json.Unmarshal(bs, &logs); e != nil {
+		t.Fatal(e)
+	}
+	if len(logs) != 2 {
+		t.Fatalf(`expected 2 logs, have %s`, bs)
+	}
+	for i, amount := range []string{`1.5`, `0.000000000000000001`} {
+		scaled := `["0x00000000000000000000000000000000000000aa","0x00000000000000000000000000000000000000bb","` + amount + `"]`
+		if !jsonEqualString(logs[i].Scaled, scaled) {
+			t.Fatalf(`expected scaled args %s, have %s`, scaled, logs[i].Scaled)
+		}
+	}
+	// the raw value is kept along the scaled one
+	if !jsonEqualString(logs[0].Args, `["0x00000000000000000000000000000000000000aa","0x00000000000000000000000000000000000000bb","0x14d1120d7b160000"]`) {
+		t.Fatalf(`unexpected args %s`, logs[0].Args)
+	}
+}
+
+func TestDispatchDecodesReceiptLogs(t *testing.T) {
+	token := abiContract(t, `Token.sol`, `Token`, `[
+		{"type": "function", "name": "transfer", "stateMutability": "nonpayable", "inputs": [{"name": "to", "type": "address"}, {"name": "value", "type": "uint256"}], "outputs": []},
+		{"type": "event", "name": "Transfer", "anonymous": false, "inputs": [
+			{"name": "from", "type": "address", "indexed": true},
+			{"name": "to", "type": "address", "indexed": true},
+			{"name": "value", "type": "uint256", "indexed": false}]}
+	]`)
+	truncated := transferLog(1, 7)
+	truncated.Data = truncated.Data[:40]
+	shortTopic := transferLog(1, 7)
+	shortTopic.Topics[1] = `0xaa`
+	unknown := transferLog(1, 7)
+	unknown.Topics[0] = `0x` + word(1)
+	logs := []TransactionReceiptLog{truncated, shortTopic, unknown, {Data: `0x`}, transferLog(1, 7)}
+
+	mock := newMockEthClient().
+		Handle(`eth_getTransactionCount`, func(...interface{}) (interface{}, error) { return `0x0`, nil }).
+		Handle(`eth_sendRawTransaction`, func(...interface{}) (interface{}, error) { return nil, nil }).
+		Handle(`eth_getTransactionReceipt`, func(...interface{}) (interface{}, error) {
+			return TransactionReceipt{Status: `0x1`, GasUsed: `0x5208`, Logs: logs}, nil
+		})
+	defer useEthClient(mock)()
+
+	req := DispatchFunctionCallRequest{Target: `0xcc`, GasPrice: `1`, GasLimit: `50000`, Mode: FunctionDispatchModeTransactionOnly, Auth: testAuth, DecodeLogs: true}
+	req.File, req.Contract, req.Signature, req.Arguments = `Token.sol`, `Token`, `transfer(address,uint256)`, json.RawMessage(`["0xbb", 7]`)
+	res := DispatchFunctionCallResponse{}
+	if e := testHandler(token).DispatchFunctionCall(req, &res); e != nil {
+		t.Fatal(e)
+	}
+	if len(res.DecodedLogs) != 1 {
+		t.Fatalf(`expected the one valid log decoded, have %+v`, res.DecodedLogs)
+	}
+	decoded := res.DecodedLogs[0]
+	if decoded.Event != `Transfer(address,address,uint256)` || !jsonEqualString(decoded.Args, `["0x00000000000000000000000000000000000000aa","0x00000000000000000000000000000000000000bb",7]`) {
+		t.Fatalf(`unexpected decoded log %+v`, decoded)
+	}
+	if len(res.Receipt.Logs) != len(logs) {
+		t.Fatalf(`expected all raw logs in the receipt, have %d`, len(res.Receipt.Logs))
+	}
+}
+
+func TestDecodeLogByEvent(t *testing.T) {
+	h := testHandler(abiContract(t, `Token.sol`, `Token`, erc20ABI), abiContract(t, `Logger.sol`, `Logger`, `[
+		{"type": "event", "name": "Logged", "anonymous": true, "inputs": [{"name": "tag", "type": "bytes32", "indexed": true}, {"name": "n", "type": "uint256", "indexed": false}]}
+	]`))
+	decode := func(contract, event string, log TransactionReceiptLog) (DecodedLog, error) {
+		req := DecodeLogByEventRequest{Event: event, Topics: log.Topics, Data: log.Data}
+		req.File, req.Contract = contract+`.sol`, contract
+		res := DecodedLog{}
+		return res, h.DecodeLogByEvent(req, &res)
+	}
+
+	res, e := decode(`Token`, `Transfer`, transferLog(1, 42))
+	if e != nil {
+		t.Fatal(e)
+	}
+	if expected := `["0x00000000000000000000000000000000000000aa", "0x00000000000000000000000000000000000000bb", 42]`; res.Event != `Transfer(address,address,uint256)` || !jsonEqualString(res.Args, expected) {
+		t.Fatalf(`expected Transfer args %s, have %s %s`, expected, res.Event, res.Args)
+	}
+	res, e = decode(`Logger`, `Logged`, TransactionReceiptLog{Topics: []string{`0x` + word(7)}, Data: `0x` + word(3)})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if expected := `["0x` + word(7) + `", 3]`; !jsonEqualString(res.Args, expected) {
+		t.Fatalf(`expected anonymous args %s, have %s`, expected, res.Args)
+	}
+
+	approval := transferLog(1, 42)
+	approval.Topics[0] = `0x` + hex.EncodeToString(keccak([]byte(`Approval(address,address,uint256)`)))
+	missing, short, truncated, odd, extra := transferLog(1, 42), transferLog(1, 42), transferLog(1, 42), transferLog(1, 42), transferLog(1, 42)
+	missing.Topics = missing.Topics[:2]
+	short.Topics[2] = `0xbb`
+	truncated.Data = truncated.Data[:40]
+	odd.Data += `0`
+	extra.Topics = append(extra.Topics, `0x`+word(1))
+	for name, log := range map[string]TransactionReceiptLog{`signature`: approval, `missing`: missing, `short`: short, `truncated`: truncated, `odd`: odd, `extra`: extra} {
+		if res, e := decode(`Token`, `Transfer`, log); e == nil {
+			t.Fatalf(`%s: expected error, decoded %s`, name, res.Args)
+		}
+	}
+	if _, e := decode(`Token`, `Minted`, transferLog(1, 42)); e == nil {
+		t.Fatalf(`expected error for unknown event`)
+	}
+}
+
+// erc721TransferABI declares the ERC-721 Transfer event, whose signature equals ERC-20's but which indexes all args.
+const erc721TransferABI = `[
+	{"type": "event", "name": "Transfer", "anonymous": false, "inputs": [
+		{"name": "from", "type": "address", "indexed": true},
+		{"name": "to", "type": "address", "indexed": true},
+		{"name": "tokenId", "type": "uint256", "indexed": true}]}
+]`
+
+func TestDecodeLogsSharedSignature(t *testing.T) {
+	// the ERC-721 contract sorts first, so ERC-20 logs only decode by trying the next layout
+	h := testHandler(abiContract(t, `A.sol`, `Collectible`, erc721TransferABI), abiContract(t, `B.sol`, `Token`, transferABI))
+	topic := [32]byte{}
+	copy(topic[:], mustDecodeHex(transferTopic))
+	if n := len(h.project.EventTopics[topic]); n != 2 {
+		t.Fatalf(`expected 2 layouts of Transfer, have %d`, n)
+	}
+
+	fungible := transferLog(1, 42)
+	collectible := TransactionReceiptLog{Topics: []string{transferTopic, `0x` + word(0xaa), `0x` + word(0xbb), `0x` + word(7)}, Data: `0x`}
+	decoded := decodeReceiptLogs(h.project.EventTopics, []TransactionReceiptLog{fungible, collectible, fungible})
+	if len(decoded) != 3 {
+		t.Fatalf(`expected 3 decoded logs, have %d`, len(decoded))
+	}
+	args := `["0x00000000000000000000000000000000000000aa", "0x00000000000000000000000000000000000000bb", %d]`
+	for i, value := range []int{42, 7, 42} {
+		if expected := fmt.Sprintf(args, value); !jsonEqualString(decoded[i].Args, expected) {
+			t.Fatalf(`log %d: expected %s, have %s`, i, expected, decoded[i].Args)
+		}
+	}
+	_, event, e := decodeLog(h.project.EventTopics, collectible)
+	if e != nil || !event.Indexed[2] {
+		t.Fatalf(`expected the ERC-721 layout, have %v (%v)`, event.Indexed, e)
+	}
+	if _, event, e = decodeLog(h.project.EventTopics, fungible); e != nil || event.Indexed[2] {
+		t.Fatalf(`expected the ERC-20 layout, have %v (%v)`, event.Indexed, e)
+	}
+}
+
+func BenchmarkDecodeLogs(b *testing.B) {
+	h := testHandler(abiContract(b, `Token.sol`, `Token`, erc20ABI), abiContract(b, `Collectible.sol`, `Collectible`, erc721TransferABI))
+	logs := make([]TransactionReceiptLog, 1000)
+	for i := range logs {
+		logs[i] = transferLog(uint64(i), uint64(i))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if decoded := decodeReceiptLogs(h.project.EventTopics, logs); len(decoded) != len(logs) {
+			b.Fatalf(`decoded %d of %d logs`, len(decoded), len(logs))
+		}
+	}
+}
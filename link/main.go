@@ -3,7 +3,10 @@
 package main // import "github.com/karmarun/karma.link/link"
 
 import (
+	"bufio"
+	"bytes"
 	"compress/gzip"
+	"context"
 	"encoding/hex"
 	"encoding/json"
 	"flag"
@@ -12,7 +15,6 @@ import (
 	ethtypes "github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto/sha3"
 	"github.com/ethereum/go-ethereum/rlp"
-	ethrpc "github.com/ethereum/go-ethereum/rpc"
 	"github.com/karmarun/karma.link/abi"
 	"github.com/karmarun/karma.link/ast"
 	"github.com/karmarun/karma.link/ast/extract"
@@ -20,14 +22,15 @@ import (
 	"github.com/karmarun/karma.link/auth/fs"
 	"github.com/karmarun/karma.link/config"
 	"github.com/karmarun/karma.link/types"
+	"golang.org/x/net/websocket"
 	"io"
 	"io/ioutil"
 	"log"
 	"math/big"
 	"net/http"
 	"net/rpc"
-	"net/rpc/jsonrpc"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -35,7 +38,7 @@ import (
 
 const defaultGasLimit = 90000
 
-var signer = ethtypes.HomesteadSigner{ethtypes.FrontierSigner{}}
+var errReadOnly = fmt.Errorf(`server is read-only, transactions are disabled`)
 
 type gzipResponseWriter struct {
 	http.ResponseWriter
@@ -46,16 +49,43 @@ func (w gzipResponseWriter) Write(bs []byte) (int, error) {
 	return w.gzip.Write(bs)
 }
 
+// maxDecompressedRequestSize limits gzipped request bodies, which may otherwise expand to arbitrary sizes.
+const maxDecompressedRequestSize = 64 << 20
+
+// limitedReader is like io.LimitedReader but fails instead of signaling EOF when the limit is exceeded.
+type limitedReader struct {
+	r io.Reader
+	n int64 // remaining bytes
+}
+
+func (l *limitedReader) Read(bs []byte) (int, error) {
+	if l.n <= 0 {
+		return 0, fmt.Errorf(`request body exceeds %d bytes`, maxDecompressedRequestSize)
+	}
+	if int64(len(bs)) > l.n {
+		bs = bs[:l.n]
+	}
+	n, e := l.r.Read(bs)
+	l.n -= int64(n)
+	return n, e
+}
+
 var (
-	EthClient *ethrpc.Client
+	EthClient      ethCaller
+	fsScryptParams fs.ScryptParams // of keystore files created in config.FSAuthDirectory
+	chainID        *big.Int        // of EIP-155 signatures, from config.ChainID; nil if not configured
 )
 
 func main() {
 
 	flag.Parse()
 
-	if config.CombinedJSONPath == "" {
-		log.Fatalln("Please specify --combined-json flag. See --help.")
+	if e := config.ConfigureLogging(); e != nil {
+		log.Fatalln(e)
+	}
+
+	if config.CombinedJSONPath == "" && config.ABIPaths == "" {
+		log.Fatalln("Please specify --combined-json or --abi flag. See --help.")
 	}
 
 	if config.GethRPCURL == "" {
@@ -64,10 +94,25 @@ func main() {
 
 	if config.FSAuthDirectory != "" {
 		auth.RegisterAuthenticator(`fs`, fs.Folder(config.FSAuthDirectory))
+		params, e := fs.ParseScryptParams(config.FSAuthScrypt)
+		if e != nil {
+			log.Fatalln(e)
+		}
+		fsScryptParams = params
+	}
+
+	if id, e := parseChainID(config.ChainID); e != nil {
+		log.Fatalln(e)
+	} else {
+		chainID = id
 	}
 
 	{
-		c, e := ethrpc.Dial(config.GethRPCURL)
+		httpClient, e := gethHTTPClient(config.GethHTTPTimeout, config.GethCAFile, config.GethHeaders)
+		if e != nil {
+			log.Fatalln(e)
+		}
+		c, e := dialFailover(config.GethRPCURL, httpClient)
 		if e != nil {
 			log.Fatalln(e)
 		}
@@ -75,64 +120,196 @@ func main() {
 		EthClient = c
 	}
 
-	file, e := os.Open(config.CombinedJSONPath)
-	if e != nil {
-		log.Fatalln(e)
+	project := types.Project{Files: make(map[string]map[string]*types.Contract, 4)}
+	if config.CombinedJSONPath != "" {
+		combined, e := loadCombined(config.CombinedJSONPath)
+		if e != nil {
+			log.Fatalln(e)
+		}
+		if project, e = extract.Projects(combined...); e != nil {
+			log.Fatalln("failed extracting type information from AST", e)
+		}
 	}
-	defer file.Close()
-	bs, e := ioutil.ReadAll(file)
-	if e != nil {
-		log.Fatalln(e)
+	if e := loadABIs(project, config.ABIPaths); e != nil {
+		log.Fatalln("failed loading ABI", e)
 	}
-	combined := ast.Combined{}
-	if e := json.Unmarshal(bs, &combined); e != nil {
-		log.Fatalln(e)
+	project.EventTopics = extract.ProjectEventTopics(project) // NOTE: includes contracts loaded from ABI files
+	if config.Validate {
+		if e := project.Validate(); e != nil {
+			log.Fatalln("inconsistent project", e)
+		}
+	}
+
+	deployments := (*deploymentChecker)(nil)
+	if config.DeploymentsPath != "" {
+		addresses, e := loadDeployments(config.DeploymentsPath, project)
+		if e != nil {
+			log.Fatalln(e)
+		}
+		deployments = &deploymentChecker{project: project, addresses: addresses}
+		go deployments.Run(deploymentCheckInterval)
 	}
-	project, e := extract.Project(combined)
+
+	timeouts, e := parseMethodTimeouts(config.RequestTimeout, config.MethodTimeouts)
 	if e != nil {
-		log.Fatalln("failed extracting type information from AST", e)
+		log.Fatalln(e)
 	}
 
 	rpcServer := rpc.NewServer()
 
-	if e := rpcServer.RegisterName("v1", RpcHandler{project}); e != nil {
+	if e := rpcServer.RegisterName("v1", RpcHandler{project, newEncodingCache()}); e != nil {
 		log.Fatalln(e)
 	}
 
 	httpServer := http.Server{
-		Addr: config.HttpBind,
-		Handler: http.HandlerFunc(func(rw http.ResponseWriter, rq *http.Request) {
-			if strings.Contains(rq.Header.Get(http.CanonicalHeaderKey(`accept-encoding`)), `gzip`) {
-				gz, _ := gzip.NewWriterLevel(rw, gzip.BestSpeed)
-				rw = gzipResponseWriter{rw, gz}
-				rw.Header().Set(http.CanonicalHeaderKey(`content-encoding`), `gzip`)
-				defer gz.Close()
-			}
-			rw.Header().Set(http.CanonicalHeaderKey(`content-type`), `application/json; charset=UTF-8`)
-			rpcServer.ServeCodec(
-				jsonrpc.NewServerCodec(struct {
-					io.Writer
-					io.ReadCloser
-				}{
-					rw,
-					rq.Body,
-				}),
-			)
-		}),
+		Addr:              config.HttpBind,
+		Handler:           rpcHTTPHandler(rpcServer, timeouts),
 		ReadHeaderTimeout: time.Second,
 		ReadTimeout:       time.Second * 2,
-		WriteTimeout:      time.Second * 3,
+		WriteTimeout:      timeouts.Max() + time.Second,
 		IdleTimeout:       time.Second * 5,
 	}
 
+	servers := []*http.Server{&httpServer}
+
+	if config.AdminBind != "" {
+		servers = append(servers, &http.Server{
+			Addr:              config.AdminBind,
+			Handler:           adminHandler(deployments),
+			ReadHeaderTimeout: time.Second,
+			ReadTimeout:       time.Second * 2,
+			WriteTimeout:      time.Second * 3,
+			IdleTimeout:       time.Second * 5,
+		})
+		log.Println(`admin server listening for HTTP traffic on ` + config.AdminBind)
+	}
+
+	if config.WSBind != "" {
+		servers = append(servers, &http.Server{
+			Addr:              config.WSBind,
+			Handler:           rpcWSHandler(rpcServer, timeouts),
+			ReadHeaderTimeout: time.Second, // NOTE: no read and write timeouts, connections are long-lived
+		})
+		log.Println(`JSON-RPC server listening for WebSocket traffic on ` + config.WSBind)
+	}
+
 	log.Println(`JSON-RPC server listening for HTTP traffic on ` + config.HttpBind)
-	log.Fatalln(httpServer.ListenAndServe())
+	if e := serve(servers...); e != nil {
+		log.Fatalln(e)
+	}
+
+}
+
+// rpcHTTPHandler serves JSON-RPC requests to rpcServer over HTTP, with gzip support in both directions.
+func rpcHTTPHandler(rpcServer *rpc.Server, timeouts methodTimeouts) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, rq *http.Request) {
+		if strings.Contains(rq.Header.Get(http.CanonicalHeaderKey(`accept-encoding`)), `gzip`) {
+			gz, _ := gzip.NewWriterLevel(rw, gzip.BestSpeed)
+			rw = gzipResponseWriter{rw, gz}
+			rw.Header().Set(http.CanonicalHeaderKey(`content-encoding`), `gzip`)
+			defer gz.Close()
+		}
+		rpcRequests.Add(1)
+		body := io.Reader(rq.Body)
+		if strings.EqualFold(rq.Header.Get(http.CanonicalHeaderKey(`content-encoding`)), `gzip`) {
+			gz, e := gzip.NewReader(rq.Body)
+			if e != nil {
+				http.Error(rw, `invalid gzip request body`, http.StatusBadRequest)
+				return
+			}
+			defer gz.Close()
+			body = &limitedReader{gz, maxDecompressedRequestSize}
+		}
+		rw.Header().Set(http.CanonicalHeaderKey(`content-type`), `application/json; charset=UTF-8`)
+		codec := newServerCodec(struct {
+			io.Writer
+			io.Reader
+			io.Closer
+		}{
+			rw,
+			body,
+			rq.Body,
+		}, timeouts)
+		go rpcServer.ServeCodec(codec)
+		<-codec.Done() // timed out calls may still be running
+	})
+}
+
+// rpcWSHandler serves JSON-RPC requests to rpcServer over WebSocket connections, one message per frame.
+// Unlike over HTTP, subscriptions can push notifications, see serverCodec.
+func rpcWSHandler(rpcServer *rpc.Server, timeouts methodTimeouts) http.Handler {
+	return websocket.Server{
+		Handshake: func(*websocket.Config, *http.Request) error {
+			return nil // any origin, like over HTTP
+		},
+		Handler: func(conn *websocket.Conn) {
+			codec := newServerCodec(conn, timeouts)
+			codec.push = true
+			rpcServer.ServeCodec(codec) // closes conn and codec's subscriptions once the client disconnects
+		},
+	}
+}
+
+// loadCombined decodes the combined.json files listed in paths ("path,...").
+func loadCombined(paths string) ([]ast.Combined, error) {
+	combined := make([]ast.Combined, 0, 2)
+	for _, path := range strings.Split(paths, `,`) {
+		file, e := os.Open(path)
+		if e != nil {
+			return nil, e
+		}
+		decoded, e := ast.DecodeCombined(bufio.NewReader(file))
+		file.Close()
+		if e != nil {
+			return nil, fmt.Errorf(`%s: %s`, path, e)
+		}
+		combined = append(combined, decoded)
+	}
+	return combined, nil
+}
 
+// loadABIs adds the contracts in the plain ABI JSON files listed in paths ("Name=path,...") to project.
+// Each contract is placed in a file named like its path.
+func loadABIs(project types.Project, paths string) error {
+	if paths == "" {
+		return nil
+	}
+	for _, pair := range strings.Split(paths, `,`) {
+		i := strings.IndexByte(pair, '=')
+		if i < 1 {
+			return fmt.Errorf(`expected Name=path, have %s`, pair)
+		}
+		name, path := pair[:i], pair[i+1:]
+		bs, e := ioutil.ReadFile(path)
+		if e != nil {
+			return e
+		}
+		contract, e := extract.ABI(path, name, bs)
+		if e != nil {
+			return e
+		}
+		if project.Files[path] == nil {
+			project.Files[path] = make(map[string]*types.Contract, 1)
+		}
+		if _, ok := project.Files[path][name]; ok {
+			return fmt.Errorf(`duplicate contract %s:%s`, path, name)
+		}
+		project.Files[path][name] = contract
+	}
+	return nil
 }
 
 // TODO: replace RPC subsystem with something better.
 type RpcHandler struct {
 	project types.Project
+	cache   *encodingCache
+}
+
+// encodeContract encodes a contract using the handler's cache.
+func (h RpcHandler) encodeContract(contract *types.Contract) (json.RawMessage, error) {
+	return h.cache.Get(`contract:`+types.Key{File: contract.File, Contract: contract.Name}.String(), func() ([]byte, error) {
+		return rpcEncoder.EncodeContract(contract)
+	})
 }
 
 var rpcEncoder = jsonEncoder{}
@@ -142,6 +319,7 @@ func (h RpcHandler) GetFiles(_ struct{}, res *[]string) error {
 	for path, _ := range h.project.Files {
 		out = append(out, path)
 	}
+	sort.Strings(out)
 	*res = out
 	return nil
 }
@@ -155,6 +333,7 @@ func (h RpcHandler) GetContracts(file string, res *[]string) error {
 	for name, _ := range _file {
 		out = append(out, name)
 	}
+	sort.Strings(out)
 	*res = out
 	return nil
 }
@@ -166,7 +345,7 @@ func (h RpcHandler) GetFile(req string, res *map[string]json.RawMessage) error {
 	}
 	out := make(map[string]json.RawMessage, len(file))
 	for name, contract := range file {
-		encoded, e := rpcEncoder.EncodeContract(contract)
+		encoded, e := h.encodeContract(contract)
 		if e != nil {
 			log.Panicln(e)
 		}
@@ -181,6 +360,19 @@ type GetContractRequest struct {
 	Contract string `json:"contract"`
 }
 
+// FindContract returns the locations of all contracts named name, sorted by file. The list is empty if there are none.
+func (h RpcHandler) FindContract(name string, res *[]GetContractRequest) error {
+	out := make([]GetContractRequest, 0, 1)
+	for path, file := range h.project.Files {
+		if _, ok := file[name]; ok {
+			out = append(out, GetContractRequest{File: path, Contract: name})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].File < out[j].File })
+	*res = out
+	return nil
+}
+
 func (h RpcHandler) GetContract(req GetContractRequest, res *json.RawMessage) error {
 
 	file, ok := h.project.Files[req.File]
@@ -193,12 +385,12 @@ func (h RpcHandler) GetContract(req GetContractRequest, res *json.RawMessage) er
 		return fmt.Errorf(`contract not found: %s`, req.Contract)
 	}
 
-	encoded, e := rpcEncoder.EncodeContract(contract)
+	encoded, e := h.encodeContract(contract)
 	if e != nil {
 		log.Panicln(e)
 	}
 
-	*res = json.RawMessage(encoded)
+	*res = encoded
 	return nil
 }
 
@@ -220,10 +412,11 @@ func (h RpcHandler) GetType(req GetTypeRequest, res *json.RawMessage) error {
 		return fmt.Errorf(`contract not found: %s`, req.Contract)
 	}
 
-	typ, ok := (types.Type)(nil), false
+	typ, ok, declaring := (types.Type)(nil), false, contract
 
 	for _, contract := range append([]*types.Contract{contract}, contract.Parents...) {
 		if typ, ok = contract.Types[req.Type]; ok {
+			declaring = contract
 			break
 		}
 	}
@@ -231,12 +424,195 @@ func (h RpcHandler) GetType(req GetTypeRequest, res *json.RawMessage) error {
 		return fmt.Errorf(`type not found: %s`, req.Type)
 	}
 
-	encoded, e := rpcEncoder.EncodeType(typ)
+	encoded, e := h.cache.Get(`type:`+types.Key{File: declaring.File, Contract: declaring.Name, Member: req.Type}.String(), func() ([]byte, error) {
+		return rpcEncoder.EncodeType(typ)
+	})
 	if e != nil {
 		log.Panicln(e)
 	}
 
-	*res = json.RawMessage(encoded)
+	*res = encoded
+	return nil
+}
+
+// EncodedStorageSlot is a types.StorageSlot with its type encoded like in GetType.
+type EncodedStorageSlot struct {
+	types.StorageSlot
+	Type json.RawMessage `json:"type"`
+}
+
+func (h RpcHandler) GetStorageLayout(req GetContractRequest, res *[]EncodedStorageSlot) error {
+
+	file, ok := h.project.Files[req.File]
+	if !ok {
+		return fmt.Errorf(`file not found: %s`, req.File)
+	}
+
+	contract, ok := file[req.Contract]
+	if !ok {
+		return fmt.Errorf(`contract not found: %s`, req.Contract)
+	}
+
+	layout := types.StorageLayout(contract)
+	out := make([]EncodedStorageSlot, len(layout), len(layout))
+
+	for i, slot := range layout {
+		encoded, e := rpcEncoder.EncodeType(slot.Type)
+		if e != nil {
+			log.Panicln(e)
+		}
+		out[i] = EncodedStorageSlot{StorageSlot: slot, Type: encoded}
+	}
+
+	*res = out
+	return nil
+}
+
+type GetMappingSlotRequest struct {
+	GetContractRequest
+	Variable string            `json:"variable"`
+	Keys     []json.RawMessage `json:"keys"` // one per level of nested mappings, outermost first
+}
+
+// GetMappingSlot returns the storage slot of an entry of a mapping state variable, e.g. of balances[holder],
+// for reading it with eth_getStorageAt. Nested mappings take one key per level. See abi.MappingSlot.
+func (h RpcHandler) GetMappingSlot(req GetMappingSlotRequest, res *BinaryJSON) error {
+
+	file, ok := h.project.Files[req.File]
+	if !ok {
+		return fmt.Errorf(`file not found: %s`, req.File)
+	}
+
+	contract, ok := file[req.Contract]
+	if !ok {
+		return fmt.Errorf(`contract not found: %s`, req.Contract)
+	}
+
+	variable := (*types.StorageSlot)(nil)
+	for _, slot := range types.StorageLayout(contract) {
+		if slot.Name == req.Variable {
+			slot := slot
+			variable = &slot // NOTE: last match, variables of derived contracts come last
+		}
+	}
+	if variable == nil {
+		return fmt.Errorf(`state variable not found: %s`, req.Variable)
+	}
+	if len(req.Keys) == 0 {
+		return fmt.Errorf(`missing keys`)
+	}
+
+	slot, typ := big.NewInt(int64(variable.Slot)), variable.Type
+	for i, key := range req.Keys {
+		if named, ok := typ.(types.Named); ok {
+			typ = named.Type
+		}
+		mapping, ok := typ.(types.Mapping)
+		if !ok {
+			return fmt.Errorf(`%s has %d mapping levels, have %d keys`, req.Variable, i, len(req.Keys))
+		}
+		next, e := abi.MappingSlot(slot, mapping.Key, key)
+		if e != nil {
+			return fmt.Errorf(`key %d: %s`, i, e)
+		}
+		slot, typ = new(big.Int).SetBytes(next[:]), mapping.Value
+	}
+
+	*res = BinaryJSON(common.LeftPadBytes(slot.Bytes(), 32))
+	return nil
+}
+
+// GetInterfaceID returns the EIP-165 interface identifier of a contract or interface.
+func (h RpcHandler) GetInterfaceID(req GetContractRequest, res *BinaryJSON) error {
+
+	file, ok := h.project.Files[req.File]
+	if !ok {
+		return fmt.Errorf(`file not found: %s`, req.File)
+	}
+
+	contract, ok := file[req.Contract]
+	if !ok {
+		return fmt.Errorf(`contract not found: %s`, req.Contract)
+	}
+
+	id := InterfaceID(contract)
+	*res = id[:]
+	return nil
+}
+
+// InterfaceID computes the EIP-165 interface identifier of a contract: the XOR of the selectors of
+// the external and public functions it declares itself. Inherited functions are not included,
+// so e.g. ERC-165's supportsInterface doesn't count towards the id of an interface extending it.
+func InterfaceID(contract *types.Contract) [4]byte {
+	id := [4]byte{}
+	for _, function := range contract.API {
+		if function.IsFallback() {
+			continue
+		}
+		if function.Visibility != ast.VisibilityPublic && function.Visibility != ast.VisibilityExternal {
+			continue
+		}
+		selector := keccak(function.SoliditySignature())
+		for i := range id {
+			id[i] ^= selector[i]
+		}
+	}
+	return id
+}
+
+type SelectorTable struct {
+	Functions map[string]string `json:"functions"` // "0x..." 4-byte selector -> signature
+	Events    map[string]string `json:"events"`    // "0x..." signature topic -> signature
+}
+
+// GetSelectorTable returns the selectors of a contract's callable functions and the signature topics
+// of its events, including inherited ones, e.g. for decoding calldata and logs client-side.
+func (h RpcHandler) GetSelectorTable(req GetContractRequest, res *SelectorTable) error {
+
+	file, ok := h.project.Files[req.File]
+	if !ok {
+		return fmt.Errorf(`file not found: %s`, req.File)
+	}
+
+	contract, ok := file[req.Contract]
+	if !ok {
+		return fmt.Errorf(`contract not found: %s`, req.Contract)
+	}
+
+	table := SelectorTable{Functions: make(map[string]string, len(contract.API)), Events: make(map[string]string, 8)}
+	for _, contract := range append([]*types.Contract{contract}, contract.Parents...) {
+		for signature, function := range contract.API {
+			if function.IsFallback() {
+				continue
+			}
+			if function.Visibility != ast.VisibilityPublic && function.Visibility != ast.VisibilityExternal {
+				continue
+			}
+			table.Functions[`0x`+hex.EncodeToString(keccak([]byte(signature))[:4])] = signature
+		}
+	}
+	for topic, event := range contractEvents(contract) {
+		table.Events[topic] = string(event.SoliditySignature())
+	}
+
+	*res = table
+	return nil
+}
+
+type CanonicalSignature struct {
+	Signature string     `json:"signature"` // as hashed by solc, e.g. "transfer(address,uint256)"
+	Selector  BinaryJSON `json:"selector"`  // first 4 bytes of the signature's hash
+}
+
+// CanonicalizeSignature returns the canonical form of a user-supplied signature like "transfer(address to, uint amount)"
+// and its selector. It doesn't need a contract and helps to debug signatures not matching any function.
+func (h RpcHandler) CanonicalizeSignature(signature string, res *CanonicalSignature) error {
+	name, inputs, e := types.ParseSignature(signature)
+	if e != nil {
+		return e
+	}
+	canonical := name + string(inputs.SoliditySignature())
+	*res = CanonicalSignature{Signature: canonical, Selector: BinaryJSON(keccak([]byte(canonical))[:4])}
 	return nil
 }
 
@@ -262,9 +638,8 @@ func (h RpcHandler) GetOverloads(req GetOverloadsRequest, res *[]json.RawMessage
 	out := make([]json.RawMessage, 0, len(contract.API))
 
 	for _, contract := range append([]*types.Contract{contract}, contract.Parents...) {
-		for _, function := range contract.API {
-			if function.Name == req.Function {
-				sig := string(function.SoliditySignature())
+		for _, sig := range sortedSignatures(contract) {
+			if function := contract.API[sig]; function.Name == req.Function {
 				if _, ok := sigs[sig]; ok {
 					continue
 				}
@@ -309,6 +684,20 @@ type AuthenticationRequest struct {
 	Credentials   json.RawMessage `json:"credentials"`
 }
 
+func (h RpcHandler) ListAuthenticators(_ struct{}, res *[]string) error {
+	*res = auth.RegisteredNames()
+	return nil
+}
+
+func (h RpcHandler) GetAuthenticatorSchema(name string, res *json.RawMessage) error {
+	schema, e := auth.Schema(name)
+	if e != nil {
+		return e
+	}
+	*res = schema
+	return nil
+}
+
 func (h RpcHandler) Authenticate(req AuthenticationRequest, res *json.RawMessage) error {
 	token, e := auth.Authenticate(req.Authenticator, req.Credentials)
 	if e != nil {
@@ -318,11 +707,73 @@ func (h RpcHandler) Authenticate(req AuthenticationRequest, res *json.RawMessage
 	return nil
 }
 
+type CreateAccountRequest struct {
+	Passphrase string `json:"passphrase"`
+}
+
+type CreatedAccount struct {
+	Address  string   `json:"address"`
+	FilePath []string `json:"filepath"` // as expected in the credentials of the fs authenticator
+}
+
+// CreateAccount generates a new key and stores it in the fs authenticator's directory, encrypted with the passphrase
+// using the configured scrypt parameters. The account can be used right away by authenticating with the fs authenticator.
+// Since it writes to the server's disk, it is disabled unless enabled with --create-accounts, and always in read-only mode.
+func (h RpcHandler) CreateAccount(req CreateAccountRequest, res *CreatedAccount) error {
+	if config.ReadOnly {
+		return errReadOnly
+	}
+	if !config.CreateAccounts {
+		return fmt.Errorf(`account creation is disabled, see --create-accounts`)
+	}
+	if config.FSAuthDirectory == "" {
+		return fmt.Errorf(`no fs authenticator directory configured`)
+	}
+	if req.Passphrase == "" {
+		return fmt.Errorf(`missing passphrase`)
+	}
+	address, path, e := fs.Folder(config.FSAuthDirectory).CreateAccount(req.Passphrase, fsScryptParams)
+	if e != nil {
+		log.Println("CreateAccount:", e)
+		return fmt.Errorf(`failed creating account`)
+	}
+	*res = CreatedAccount{Address: address.Hex(), FilePath: path}
+	return nil
+}
+
+// GetFunctionTemplate returns an argument array for a function holding the zero value of each input, see abi.ZeroValue.
+func (h RpcHandler) GetFunctionTemplate(req GetFunctionRequest, res *json.RawMessage) error {
+
+	function, e := h.functionBySignature(req.File, req.Contract, req.Signature)
+	if e != nil {
+		return e
+	}
+
+	*res = abi.ZeroValue(types.Tuple(function.Inputs))
+	return nil
+}
+
 type EncodeFunctionCallRequest struct {
 	File      string          `json:"file"`
 	Contract  string          `json:"contract"`
 	Signature string          `json:"signature"`
 	Arguments json.RawMessage `json:"arguments"`
+	Data      string          `json:"data"` // raw "0x..." calldata, used as-is instead of signature and arguments
+
+	// ArgumentPaths holds the arguments as flat key paths instead, e.g. {"amounts[0]": "1", "meta.name": "foo"}.
+	// See abi.AssembleKeyPaths.
+	ArgumentPaths map[string]string `json:"argumentPaths"`
+
+	// LenientBooleans accepts true and false for integer arguments, encoded as 1 and 0, see abi.EncodeOptions.
+	LenientBooleans bool `json:"lenientBooleans"`
+
+	// IntegerFormat determines how integers in decoded return values are rendered: "hex" (default), "string" or "number".
+	// See abi.IntegerFormat. Ignored where nothing is decoded.
+	IntegerFormat string `json:"integerFormat"`
+	PadHex        bool   `json:"padHex"` // renders integers as hex zero-padded to the width of their type, see abi.DecodeOptions
+
+	// RawUnsupported renders decoded values of unsupported types as raw words instead of failing, see abi.DecodeOptions.
+	RawUnsupported bool `json:"rawUnsupported"`
 }
 
 type BinaryJSON []byte
@@ -333,367 +784,1293 @@ func (j BinaryJSON) MarshalJSON() ([]byte, error) {
 }
 
 func (h RpcHandler) EncodeFunctionCall(req EncodeFunctionCallRequest, res *BinaryJSON) error {
+	_, calldata, e := h.encodeCall(req)
+	if e != nil {
+		return e
+	}
+	*res = calldata
+	return nil
+}
+
+// encodeCall returns the calldata for req along with the called function.
+// If req holds raw data, the function is unknown and nil is returned instead.
+func (h RpcHandler) encodeCall(req EncodeFunctionCallRequest) (*types.Function, []byte, error) {
+	if req.Data != "" {
+		if req.Signature != "" || len(req.Arguments) > 0 || req.ArgumentPaths != nil {
+			return nil, nil, fmt.Errorf(`data is mutually exclusive with signature and arguments`)
+		}
+		if !strings.HasPrefix(req.Data, `0x`) {
+			return nil, nil, fmt.Errorf(`data must be prefixed with 0x`)
+		}
+		calldata, e := hex.DecodeString(strip0xPrefix(req.Data))
+		if e != nil {
+			return nil, nil, fmt.Errorf(`invalid hex in data: %s`, e)
+		}
+		return nil, calldata, nil
+	}
 	function, e := h.functionBySignature(req.File, req.Contract, req.Signature)
+	if e != nil {
+		return nil, nil, e
+	}
+	arguments := req.Arguments
+	if req.ArgumentPaths != nil {
+		if len(arguments) > 0 {
+			return nil, nil, fmt.Errorf(`arguments and argumentPaths are mutually exclusive`)
+		}
+		if arguments, e = abi.AssembleKeyPaths(function.InputNames, function.Inputs, req.ArgumentPaths); e != nil {
+			return nil, nil, fmt.Errorf(`argument paths error: %s`, e)
+		}
+	}
+	if arguments, e = argumentList(function, arguments); e != nil {
+		return nil, nil, e
+	}
+	calldata, e := abi.EncodeWithOptions(types.Tuple(function.Inputs), arguments, abi.EncodeOptions{LenientBooleans: req.LenientBooleans})
+	if e != nil {
+		return nil, nil, fmt.Errorf(`argument encoding error: %s`, e)
+	}
+	if function.IsFallback() {
+		return &function, []byte{}, nil // called with empty calldata
+	}
+	return &function, append(keccak(function.SoliditySignature())[:4], calldata...), nil
+}
+
+// argumentList checks that arguments is a JSON array, as expected by abi.Encode for a function's inputs.
+// Functions without inputs also accept omitted arguments, null and {}. Functions with a single struct input
+// also accept the struct's members as a flat object, e.g. {"a": 1} for [{"a": 1}].
+func argumentList(function types.Function, arguments json.RawMessage) (json.RawMessage, error) {
+	trimmed := strings.TrimSpace(string(arguments))
+	if len(function.Inputs) == 0 && (trimmed == "" || trimmed == `null` || trimmed == `{}`) {
+		return json.RawMessage(`[]`), nil
+	}
+	if len(function.Inputs) == 1 && isStruct(function.Inputs[0]) && strings.HasPrefix(trimmed, `{`) {
+		return json.RawMessage(`[` + trimmed + `]`), nil
+	}
+	if !strings.HasPrefix(trimmed, `[`) {
+		return nil, fmt.Errorf(`arguments must be a JSON array of %d values for %s`, len(function.Inputs), function.SoliditySignature())
+	}
+	return arguments, nil
+}
+
+type ReadVariableRequest struct {
+	requestContext
+	File     string          `json:"file"`
+	Contract string          `json:"contract"`
+	Target   string          `json:"target"`
+	Variable string          `json:"variable"`
+	Keys     json.RawMessage `json:"keys"` // index/key arguments for mappings and arrays
+}
+
+// ReadVariable calls the generated getter of a public state variable and returns its decoded value.
+func (h RpcHandler) ReadVariable(req ReadVariableRequest, res *json.RawMessage) error {
+
+	ctx := req.Context()
+
+	file, ok := h.project.Files[req.File]
+	if !ok {
+		return fmt.Errorf(`file not found: %s`, req.File)
+	}
+
+	contract, ok := file[req.Contract]
+	if !ok {
+		return fmt.Errorf(`contract not found: %s`, req.Contract)
+	}
+
+	if req.Target == "" {
+		return fmt.Errorf(`missing target in request`)
+	}
+
+	getter, ok := types.Function{}, false
+
+	for _, contract := range append([]*types.Contract{contract}, contract.Parents...) {
+		for _, function := range contract.API {
+			if _, isVariable := function.Definition.(ast.VariableDeclaration); isVariable && function.Name == req.Variable {
+				getter, ok = function, true
+				break
+			}
+		}
+		if ok {
+			break
+		}
+	}
+	if !ok {
+		return fmt.Errorf(`variable not found: %s`, req.Variable)
+	}
+	if getter.Visibility != ast.VisibilityPublic {
+		return fmt.Errorf(`variable is not public: %s`, req.Variable)
+	}
+
+	keys := req.Keys
+	if len(keys) == 0 {
+		keys = json.RawMessage(`[]`)
+	}
+
+	calldata, e := abi.Encode(types.Tuple(getter.Inputs), keys)
+	if e != nil {
+		return fmt.Errorf(`key encoding error: %s`, e)
+	}
+	calldata = append(keccak(getter.SoliditySignature())[:4], calldata...)
+
+	call := struct {
+		To   string `json:"to"`
+		Data string `json:"data"`
+	}{
+		To:   ensure0xPrefix(common.HexToAddress(req.Target).String()),
+		Data: ensure0xPrefix(hex.EncodeToString(calldata)),
+	}
+
+	result := ""
+	if e := EthClient.CallContext(ctx, &result, `eth_call`, call, `latest`); e != nil {
+		return e // TODO: better error
+	}
+	if result == `0x` {
+		return fmt.Errorf(`getter call reverted -- wrong target or key out of range?`)
+	}
+	code, e := hex.DecodeString(strip0xPrefix(result))
+	if e != nil {
+		return e // TODO: better error
+	}
+	decoded, e := abi.Decode(types.Tuple(getter.Outputs), code)
+	if e != nil {
+		return e // TODO: context in error
+	}
+	if len(getter.Outputs) == 1 {
+		values := make([]json.RawMessage, 0, 1)
+		if e := json.Unmarshal(decoded, &values); e != nil {
+			log.Panicln(e)
+		}
+		decoded = values[0]
+	}
+
+	*res = decoded
+	return nil
+}
+
+type RequestAuth struct {
+	Provider string          `json:"authenticator"`
+	Token    json.RawMessage `json:"token"`
+}
+
+type TransactionReceipt struct {
+	Status            string                  `json:"status"`         // only set when tx done
+	Root              string                  `json:"root,omitempty"` // only set while tx pending
+	TransactionHash   string                  `json:"transactionHash"`
+	BlockHash         string                  `json:"blockHash"`
+	TransactionIndex  string                  `json:"transactionIndex"`
+	BlockNumber       string                  `json:"blockNumber"`
+	CumulativeGasUsed string                  `json:"cumulativeGasUsed"`
+	GasUsed           string                  `json:"gasUsed"`
+	EffectiveGasPrice string                  `json:"effectiveGasPrice,omitempty"` // only reported by some nodes
+	ContractAddress   string                  `json:"contractAddress"`
+	Logs              []TransactionReceiptLog `json:"logs"`
+}
+
+type TransactionReceiptLog struct {
+	Removed          bool     `json:"removed"`
+	LogIndex         string   `json:"logIndex"`
+	TransactionIndex string   `json:"transactionIndex"`
+	TransactionHash  string   `json:"transactionHash"`
+	BlockHash        string   `json:"blockHash"`
+	BlockNumber      string   `json:"blockNumber"`
+	Address          string   `json:"address"`
+	Data             string   `json:"data"`
+	Topics           []string `json:"topics"`
+}
+
+// callArguments is the transaction call object expected by eth_call.
+type callArguments struct {
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Gas      string `json:"gas"`
+	GasPrice string `json:"gasPrice"`
+	Value    string `json:"value"`
+	Data     string `json:"data"`
+}
+
+type FunctionDispatchMode string
+
+const (
+	FunctionDispatchModeDefault         FunctionDispatchMode = `default`
+	FunctionDispatchModeTransactionOnly FunctionDispatchMode = `transactionOnly`
+	FunctionDispatchModeCallOnly        FunctionDispatchMode = `callOnly`
+)
+
+type DispatchFunctionCallRequest struct {
+	requestContext
+	EncodeFunctionCallRequest
+	Target   string               `json:"target"`
+	Value    json.Number          `json:"value"`
+	GasPrice json.Number          `json:"gasPrice"`
+	GasLimit json.Number          `json:"gasLimit"`
+	Nonce    json.Number          `json:"nonce"` // pending nonce of the sender if empty
+	Mode     FunctionDispatchMode `json:"mode"`
+	Auth     RequestAuth          `json:"auth"`
+	TransactionUnits
+	TypedTransactionFields
+	SignatureType SignatureType `json:"signatureType"` // see transactionSigner
+
+	// StateOverrides is forwarded to eth_call as geth's state override set, e.g. {"0x...": {"balance": "0x..."}}.
+	// Only applicable to calls.
+	StateOverrides json.RawMessage `json:"stateOverrides"`
+
+	// Decimals, if set, adds the result with unsigned integers scaled by 10^-decimals to the response, see scaleDecimals.
+	Decimals json.Number `json:"decimals"`
+
+	// DecodeLogs adds the receipt's logs decoded against the events of the project to the response.
+	// Only applicable to transactions.
+	DecodeLogs bool `json:"decodeLogs"`
+
+	// CSV adds the result as CSV to the response, for functions returning an array of structs. See tabulateOutputs.
+	CSV bool `json:"csv"`
+}
+
+type DispatchFunctionCallResponse struct {
+	Result      json.RawMessage     `json:"result,omitempty"`
+	Scaled      json.RawMessage     `json:"scaled,omitempty"` // only if decimals were requested
+	Receipt     *TransactionReceipt `json:"receipt,omitempty"`
+	Cost        *TransactionCost    `json:"cost,omitempty"`        // set along with Receipt
+	DecodedLogs []DecodedLog        `json:"decodedLogs,omitempty"` // only if requested and any were decoded, see decodeReceiptLogs
+	CSV         string              `json:"csv,omitempty"`         // only if requested
+}
+
+// TransactionCost summarizes what a mined transaction cost its sender, in decimal strings.
+type TransactionCost struct {
+	GasUsed           string `json:"gasUsed"`
+	EffectiveGasPrice string `json:"effectiveGasPrice"` // in wei
+	Total             string `json:"total"`             // in wei, gasUsed * effectiveGasPrice
+}
+
+// transactionCost computes the cost of a transaction from its receipt.
+// gasPrice is the price the transaction was signed with, used if the node doesn't report an effective price.
+func transactionCost(receipt TransactionReceipt, gasPrice *big.Int) (*TransactionCost, error) {
+	gasUsed, ok := new(big.Int).SetString(strip0xPrefix(receipt.GasUsed), 16)
+	if !ok {
+		return nil, fmt.Errorf(`invalid gasUsed in receipt: %s`, receipt.GasUsed)
+	}
+	price := gasPrice
+	if receipt.EffectiveGasPrice != "" {
+		if price, ok = new(big.Int).SetString(strip0xPrefix(receipt.EffectiveGasPrice), 16); !ok {
+			return nil, fmt.Errorf(`invalid effectiveGasPrice in receipt: %s`, receipt.EffectiveGasPrice)
+		}
+	}
+	return &TransactionCost{
+		GasUsed:           gasUsed.Text(10),
+		EffectiveGasPrice: price.Text(10),
+		Total:             new(big.Int).Mul(gasUsed, price).Text(10),
+	}, nil
+}
+
+func (h RpcHandler) DispatchFunctionCall(req DispatchFunctionCallRequest, res *DispatchFunctionCallResponse) error {
+
+	ctx := req.Context()
+
+	if req.Mode == "" {
+		req.Mode = FunctionDispatchModeDefault
+	} else {
+		if req.Mode != FunctionDispatchModeDefault &&
+			req.Mode != FunctionDispatchModeTransactionOnly &&
+			req.Mode != FunctionDispatchModeCallOnly {
+			return fmt.Errorf(`invalid mode, available: default, transactionOnly, callOnly`)
+		}
+	}
+
+	if req.Target == "" {
+		return fmt.Errorf(`missing transaction target in request`)
+	}
+
+	value, gasPrice, gasLimit, e := transactionParameters(ctx, req.Value, req.GasPrice, req.GasLimit, req.TransactionUnits)
+	if e != nil {
+		return e
+	}
+
+	decimals, e := parseDecimals(req.Decimals)
+	if e != nil {
+		return e
+	}
+
+	integers, e := abi.ParseIntegerFormat(req.IntegerFormat)
+	if e != nil {
+		return e
+	}
+
+	function, calldata, e := h.encodeCall(req.EncodeFunctionCallRequest)
+	if e != nil {
+		return e
+	}
+
+	if req.CSV && (function == nil || tableKeys(function.Outputs) == nil) {
+		return fmt.Errorf(`csv output requires a function returning a single array of structs`)
+	}
+
+	callParams := []interface{}{`latest`}
+	if len(req.StateOverrides) > 0 && string(req.StateOverrides) != `null` {
+		if e := validateStateOverrides(req.StateOverrides); e != nil {
+			return e
+		}
+		callParams = append(callParams, req.StateOverrides)
+	}
+
+	key, e := auth.ExchangeToken(req.Auth.Provider, req.Auth.Token)
+	if e != nil {
+		return e // TODO: better errors
+	}
+	defer key.Destroy()
+
+	target := common.HexToAddress(req.Target)
+
+	call := callArguments{
+		From:     ensure0xPrefix(key.Address.String()),
+		To:       ensure0xPrefix(target.String()),
+		Gas:      ensure0xPrefix(strconv.FormatUint(gasLimit, 16)),
+		GasPrice: ensure0xPrefix(gasPrice.Text(16)),
+		Value:    ensure0xPrefix(value.Text(16)),
+		Data:     ensure0xPrefix(hex.EncodeToString(calldata)),
+	}
+
+	// pure and view functions can be called without transacting
+	if req.Mode == FunctionDispatchModeCallOnly ||
+		(req.Mode == FunctionDispatchModeDefault && function != nil &&
+			(function.StateMutability == ast.StateMutabilityPure ||
+				function.StateMutability == ast.StateMutabilityView)) {
+		result := ""
+		if e := EthClient.CallContext(ctx, &result, `eth_call`, append([]interface{}{call}, callParams...)...); e != nil {
+			return e // TODO: better error
+		}
+		if function == nil { // raw data: return data is opaque
+			encoded, _ := json.Marshal(result)
+			*res = DispatchFunctionCallResponse{Result: encoded}
+			return nil
+		}
+		if result == `0x` && len(function.Outputs) > 0 {
+			return fmt.Errorf(`function call reverted -- gasLimit (%d) too low?`, gasLimit)
+		}
+		if len(function.Outputs) == 0 {
+			*res = DispatchFunctionCallResponse{}
+			return nil
+		}
+		code, e := hex.DecodeString(strip0xPrefix(result))
+		if e != nil {
+			return e // TODO: better error
+		}
+		decoded, e := decodeOutputs(*function, code, abi.DecodeOptions{Integers: integers, PadHex: req.PadHex, RawUnsupported: req.RawUnsupported})
+		if e != nil {
+			return e // TODO: context in error
+		}
+		scaled, e := scaleOutputs(*function, decoded, decimals)
+		if e != nil {
+			return e
+		}
+		*res = DispatchFunctionCallResponse{Result: decoded, Scaled: scaled}
+		if req.CSV {
+			if res.CSV, e = tabulateOutputs(*function, decoded); e != nil {
+				return e
+			}
+		}
+		return nil
+	}
+
+	if config.ReadOnly {
+		return errReadOnly
+	}
+
+	if len(callParams) > 1 {
+		return fmt.Errorf(`stateOverrides only apply to calls, not transactions`)
+	}
+
+	nonce, e := transactionNonce(ctx, key.Address, req.Nonce)
+	if e != nil {
+		return e
+	}
+
+	transaction, data, e := signOutgoingTransaction(ctx, outgoingTransaction{
+		Nonce:    nonce,
+		To:       &target,
+		Value:    value,
+		GasLimit: gasLimit,
+		GasPrice: gasPrice,
+		Data:     calldata,
+	}, req.TypedTransactionFields, req.SignatureType, req.GasPriceUnit, key)
+	if e != nil {
+		return e
+	}
+
+	if e := sendRawTransaction(ctx, data); e != nil {
+		return e // TODO: better error
+	}
+	txJournal.Add(transaction, key.Address)
+
+	receipt, e := waitForReceipt(ctx, transaction.Hash())
+	if e != nil {
+		return e
+	}
+
+	if receipt.Status != `0x1` {
+		return fmt.Errorf(`transaction reverted -- gasLimit (%d) too low?`, gasLimit)
+	}
+
+	cost, e := transactionCost(receipt, gasPrice)
+	if e != nil {
+		return e
+	}
+
+	decodedLogs := []DecodedLog(nil)
+	if req.DecodeLogs {
+		decodedLogs = decodeReceiptLogs(h.project.EventTopics, receipt.Logs)
+	}
+
+	if req.Mode == FunctionDispatchModeTransactionOnly || function == nil {
+		*res = DispatchFunctionCallResponse{Receipt: &receipt, Cost: cost, DecodedLogs: decodedLogs}
+		return nil
+	}
+
+	decoded, e := replayCall(ctx, *function, call, receipt, abi.DecodeOptions{Integers: integers, PadHex: req.PadHex, RawUnsupported: req.RawUnsupported})
+	if e != nil {
+		return e
+	}
+	scaled, e := scaleOutputs(*function, decoded, decimals)
+	if e != nil {
+		return e
+	}
+	*res = DispatchFunctionCallResponse{Result: decoded, Scaled: scaled, Receipt: &receipt, Cost: cost, DecodedLogs: decodedLogs}
+	if req.CSV && decoded != nil {
+		if res.CSV, e = tabulateOutputs(*function, decoded); e != nil {
+			return e
+		}
+	}
+	return nil
+
+}
+
+var stateOverrideFields = map[string]bool{`balance`: true, `nonce`: true, `code`: true, `state`: true, `stateDiff`: true}
+
+// validateStateOverrides checks that overrides is an object mapping addresses to account overrides.
+func validateStateOverrides(overrides json.RawMessage) error {
+	accounts := make(map[string]map[string]json.RawMessage, 4)
+	if e := json.Unmarshal(overrides, &accounts); e != nil {
+		return fmt.Errorf(`stateOverrides expected to be object of objects`)
+	}
+	for address, fields := range accounts {
+		if !common.IsHexAddress(address) {
+			return fmt.Errorf(`invalid address in stateOverrides: %s`, address)
+		}
+		for field := range fields {
+			if !stateOverrideFields[field] {
+				return fmt.Errorf(`unexpected field in stateOverrides of %s: %s`, address, field)
+			}
+		}
+		if fields[`state`] != nil && fields[`stateDiff`] != nil {
+			return fmt.Errorf(`state and stateDiff are mutually exclusive in stateOverrides of %s`, address)
+		}
+	}
+	return nil
+}
+
+// replayCall re-executes a mined transaction as a call against the state of the preceding block
+// to obtain the function's return values. It returns nil if there are none.
+func replayCall(ctx context.Context, function types.Function, call callArguments, receipt TransactionReceipt, options abi.DecodeOptions) (json.RawMessage, error) {
+
+	// prevBlockNr := (receipt.BlockNumber - 1)
+	blockNr, _ := new(big.Int).SetString(strip0xPrefix(receipt.BlockNumber), 16)
+	prevBlockNr := new(big.Int).Sub(blockNr, big.NewInt(1))
+
+	result := ""
+	if e := EthClient.CallContext(ctx, &result, `eth_call`, call, ensure0xPrefix(prevBlockNr.Text(16))); e != nil {
+		return nil, e // TODO: better error
+	}
+	if result == `0x` && len(function.Outputs) > 0 {
+		// TODO: transaction succeeded but call didn't... better response?
+		return nil, nil
+	}
+	if len(function.Outputs) == 0 {
+		return nil, nil
+	}
+	code, e := hex.DecodeString(strip0xPrefix(result))
+	if e != nil {
+		return nil, e // TODO: better error
+	}
+	return decodeOutputs(function, code, options) // TODO: context in error
+}
+
+// decodeOutputs decodes a function's return data.
+// Functions returning a single struct yield the keyed struct object instead of a one-element array.
+func decodeOutputs(function types.Function, code []byte, options abi.DecodeOptions) (json.RawMessage, error) {
+	decoded, e := abi.DecodeWithOptions(types.Tuple(function.Outputs), code, options)
+	if e != nil {
+		return nil, e
+	}
+	if !returnsStruct(function) {
+		return decoded, nil
+	}
+	values := make([]json.RawMessage, 0, 1)
+	if e := json.Unmarshal(decoded, &values); e != nil {
+		log.Panicln(e)
+	}
+	return values[0], nil
+}
+
+func returnsStruct(function types.Function) bool {
+	return len(function.Outputs) == 1 && isStruct(function.Outputs[0])
+}
+
+// scaleOutputs applies scaleDecimals to outputs decoded by decodeOutputs. It returns nil if decimals is -1 or there are no outputs.
+func scaleOutputs(function types.Function, decoded json.RawMessage, decimals int) (json.RawMessage, error) {
+	if decimals == -1 || decoded == nil {
+		return nil, nil
+	}
+	if returnsStruct(function) {
+		return scaleDecimals(function.Outputs[0], decoded, decimals)
+	}
+	return scaleDecimals(types.Tuple(function.Outputs), decoded, decimals)
+}
+
+func isStruct(typ types.Type) bool {
+	if named, ok := typ.(types.Named); ok {
+		return isStruct(named.Type)
+	}
+	_, ok := typ.(types.Struct)
+	return ok
+}
+
+type DecodeConstructorArgsRequest struct {
+	GetContractRequest
+	Data string `json:"data"` // "0x..." input data of the deployment transaction
+}
+
+func (h RpcHandler) DecodeConstructorArgs(req DecodeConstructorArgsRequest, res *json.RawMessage) error {
+
+	file, ok := h.project.Files[req.File]
+	if !ok {
+		return fmt.Errorf(`file not found: %s`, req.File)
+	}
+
+	contract, ok := file[req.Contract]
+	if !ok {
+		return fmt.Errorf(`contract not found: %s`, req.Contract)
+	}
+
+	data, e := hex.DecodeString(strip0xPrefix(req.Data))
+	if e != nil {
+		return fmt.Errorf(`invalid hex in data: %s`, e)
+	}
+
+	decoded, e := DecodeConstructorArgs(contract, data)
+	if e != nil {
+		return e
+	}
+
+	*res = decoded
+	return nil
+}
+
+// DecodeConstructorArgs decodes the constructor arguments appended to contract's creation code in deploymentData.
+func DecodeConstructorArgs(contract *types.Contract, deploymentData []byte) (json.RawMessage, error) {
+	if len(contract.Binary) == 0 {
+		return nil, fmt.Errorf(`missing creation code of contract %s`, contract.Name)
+	}
+	if !bytes.HasPrefix(deploymentData, contract.Binary) {
+		return nil, fmt.Errorf(`deployment data doesn't start with the creation code of contract %s`, contract.Name)
+	}
+	inputs := []types.Type(nil)
+	if contract.Constructor != nil {
+		inputs = contract.Constructor.Inputs
+	}
+	args := deploymentData[len(contract.Binary):]
+	if len(args) == 0 && len(inputs) > 0 {
+		return nil, fmt.Errorf(`missing constructor arguments, expected %d`, len(inputs))
+	}
+	decoded, e := abi.Decode(types.Tuple(inputs), args)
+	if e != nil {
+		return nil, fmt.Errorf(`failed decoding constructor arguments: %s`, e)
+	}
+	return decoded, nil
+}
+
+// eip1967ImplementationSlot is the storage slot EIP-1967 proxies keep their implementation address in:
+// bytes32(uint256(keccak256("eip1967.proxy.implementation")) - 1)
+const eip1967ImplementationSlot = `0x360894a13ba1a3210667c828492db98dca3e2076cc3735a920a3ca505d382bbc`
+
+type ProxyImplementation struct {
+	IsProxy        bool   `json:"isProxy"`
+	Implementation string `json:"implementation,omitempty"` // only if isProxy
+}
+
+type GetProxyImplementationRequest struct {
+	requestContext
+	Address string `json:"address"`
+}
+
+// GetProxyImplementation resolves the implementation address of an EIP-1967 proxy, whose ABI is the one to use
+// when calling the proxy. Addresses with an empty implementation slot are reported as not being proxies.
+func (h RpcHandler) GetProxyImplementation(req GetProxyImplementationRequest, res *ProxyImplementation) error {
+	if !common.IsHexAddress(req.Address) {
+		return fmt.Errorf(`invalid address: %s`, req.Address)
+	}
+	slot := ""
+	if e := EthClient.CallContext(req.Context(), &slot, `eth_getStorageAt`, ensure0xPrefix(common.HexToAddress(req.Address).String()), eip1967ImplementationSlot, `latest`); e != nil {
+		return e // TODO: better error
+	}
+	word, e := hex.DecodeString(strip0xPrefix(slot))
+	if e != nil || len(word) > 32 {
+		return fmt.Errorf(`invalid storage value returned by node: %s`, slot)
+	}
+	implementation := common.BytesToAddress(word)
+	if implementation == (common.Address{}) {
+		*res = ProxyImplementation{}
+		return nil
+	}
+	*res = ProxyImplementation{IsProxy: true, Implementation: implementation.Hex()}
+	return nil
+}
+
+type PredictCreate2AddressRequest struct {
+	GetContractRequest
+	Deployer  string          `json:"deployer"`  // address of the contract executing CREATE2
+	Salt      string          `json:"salt"`      // "0x..." 32 bytes
+	Arguments json.RawMessage `json:"arguments"` // constructor arguments, if any
+}
+
+func (h RpcHandler) PredictCreate2Address(req PredictCreate2AddressRequest, res *string) error {
+
+	file, ok := h.project.Files[req.File]
+	if !ok {
+		return fmt.Errorf(`file not found: %s`, req.File)
+	}
+
+	contract, ok := file[req.Contract]
+	if !ok {
+		return fmt.Errorf(`contract not found: %s`, req.Contract)
+	}
+
+	if !common.IsHexAddress(req.Deployer) {
+		return fmt.Errorf(`invalid deployer address: %s`, req.Deployer)
+	}
+
+	saltBytes, e := hex.DecodeString(strip0xPrefix(req.Salt))
+	if e != nil || len(saltBytes) != 32 {
+		return fmt.Errorf(`salt expected to be 32 hex-encoded bytes`)
+	}
+	salt := [32]byte{}
+	copy(salt[:], saltBytes)
+
+	initCode, e := deploymentData(contract, req.Arguments)
+	if e != nil {
+		return e
+	}
+
+	*res = Create2Address(common.HexToAddress(req.Deployer), salt, initCode).Hex()
+	return nil
+}
+
+// deploymentData returns contract's creation code followed by the encoded constructor arguments.
+func deploymentData(contract *types.Contract, arguments json.RawMessage) ([]byte, error) {
+	if len(contract.Binary) == 0 {
+		return nil, fmt.Errorf(`missing creation code of contract %s`, contract.Name)
+	}
+	constructor := types.Function{}
+	if contract.Constructor != nil {
+		constructor = *contract.Constructor
+	}
+	arguments, e := argumentList(constructor, arguments)
+	if e != nil {
+		return nil, e
+	}
+	encoded, e := abi.Encode(types.Tuple(constructor.Inputs), arguments)
+	if e != nil {
+		return nil, fmt.Errorf(`argument encoding error: %s`, e)
+	}
+	return append(append(make([]byte, 0, len(contract.Binary)+len(encoded)), contract.Binary...), encoded...), nil
+}
+
+type PreviewDeployRequest struct {
+	requestContext
+	GetContractRequest
+	Arguments json.RawMessage `json:"arguments"` // constructor arguments
+	Sender    string          `json:"sender"`
+	Value     json.Number     `json:"value"`
+	Nonce     json.Number     `json:"nonce"` // pending nonce of the sender if empty
+	TransactionUnits
+}
+
+type DeployPreview struct {
+	GasEstimate         uint64 `json:"gasEstimate"`
+	PredictedAddress    string `json:"predictedAddress"`
+	FinalBytecodeLength int    `json:"finalBytecodeLength"` // creation code including constructor arguments, in bytes
+}
+
+// PreviewDeploy checks a deployment of a contract by sender without broadcasting anything: it encodes the
+// constructor arguments, has the node estimate the deployment's gas (failing if the constructor reverts)
+// and predicts the contract's address.
+func (h RpcHandler) PreviewDeploy(req PreviewDeployRequest, res *DeployPreview) error {
+
+	ctx := req.Context()
+
+	file, ok := h.project.Files[req.File]
+	if !ok {
+		return fmt.Errorf(`file not found: %s`, req.File)
+	}
+
+	contract, ok := file[req.Contract]
+	if !ok {
+		return fmt.Errorf(`contract not found: %s`, req.Contract)
+	}
+
+	if !common.IsHexAddress(req.Sender) {
+		return fmt.Errorf(`invalid sender address: %s`, req.Sender)
+	}
+	sender := common.HexToAddress(req.Sender)
+
+	if req.Value == "" {
+		req.Value = "0"
+	}
+	value, e := parseWei(req.Value, req.ValueUnit)
+	if e != nil {
+		return fmt.Errorf(`invalid value: %s`, e)
+	}
+
+	initCode, e := deploymentData(contract, req.Arguments)
+	if e != nil {
+		return e
+	}
+
+	nonce, e := transactionNonce(ctx, sender, req.Nonce)
+	if e != nil {
+		return e
+	}
+
+	estimate := ""
+	creation := struct {
+		From  string `json:"from"`
+		Value string `json:"value"`
+		Data  string `json:"data"`
+	}{
+		From:  sender.Hex(),
+		Value: ensure0xPrefix(value.Text(16)),
+		Data:  ensure0xPrefix(hex.EncodeToString(initCode)),
+	}
+	if e := EthClient.CallContext(ctx, &estimate, `eth_estimateGas`, creation); e != nil {
+		return fmt.Errorf(`gas estimation failed: %s`, e)
+	}
+	gas, e := strconv.ParseUint(strip0xPrefix(estimate), 16, 64)
+	if e != nil {
+		return fmt.Errorf(`invalid gas estimate returned by node: %s`, estimate)
+	}
+
+	*res = DeployPreview{
+		GasEstimate:         gas,
+		PredictedAddress:    CreateAddress(sender, nonce).Hex(),
+		FinalBytecodeLength: len(initCode),
+	}
+	return nil
+}
+
+type PredictCreateAddressRequest struct {
+	requestContext
+	Sender string      `json:"sender"`
+	Nonce  json.Number `json:"nonce"` // pending nonce of the sender if empty
+}
+
+// PredictCreateAddress returns the address of the contract a CREATE transaction of sender with nonce will create,
+// e.g. to reference contracts of a sequence of deployments before they are mined.
+func (h RpcHandler) PredictCreateAddress(req PredictCreateAddressRequest, res *string) error {
+
+	ctx := req.Context()
+
+	if !common.IsHexAddress(req.Sender) {
+		return fmt.Errorf(`invalid sender address: %s`, req.Sender)
+	}
+	sender := common.HexToAddress(req.Sender)
+
+	nonce, e := transactionNonce(ctx, sender, req.Nonce)
+	if e != nil {
+		return e
+	}
+
+	*res = CreateAddress(sender, nonce).Hex()
+	return nil
+}
+
+// CreateAddress computes the address of a contract created by sender with CREATE or a contract creation transaction:
+// keccak256(rlp([sender, nonce]))[12:]
+func CreateAddress(sender common.Address, nonce uint64) common.Address {
+	input, e := rlp.EncodeToBytes([]interface{}{sender, nonce})
+	if e != nil {
+		log.Panicln(e) // can't fail for these types
+	}
+	return common.BytesToAddress(keccak(input)[12:])
+}
+
+// Create2Address computes the address of a contract created by deployer with CREATE2:
+// keccak256(0xff ++ deployer ++ salt ++ keccak256(initCode))[12:]
+func Create2Address(deployer common.Address, salt [32]byte, initCode []byte) common.Address {
+	input := make([]byte, 0, 1+20+32+32)
+	input = append(input, 0xff)
+	input = append(input, deployer.Bytes()...)
+	input = append(input, salt[:]...)
+	input = append(input, keccak(initCode)...)
+	return common.BytesToAddress(keccak(input)[12:])
+}
+
+type CreateContractRequest struct {
+	requestContext
+	GetContractRequest
+	Value    json.Number `json:"value"`
+	GasPrice json.Number `json:"gasPrice"`
+	GasLimit json.Number `json:"gasLimit"`
+	Nonce    json.Number `json:"nonce"` // pending nonce of the sender if empty
+	Auth     RequestAuth `json:"auth"`
+	TransactionUnits
+	TypedTransactionFields
+	SignatureType SignatureType `json:"signatureType"` // see transactionSigner
+}
+
+func (h RpcHandler) CreateContract(req CreateContractRequest, res *TransactionReceipt) error {
+
+	ctx := req.Context()
+
+	if config.ReadOnly {
+		return errReadOnly
+	}
+
+	file, ok := h.project.Files[req.File]
+	if !ok {
+		return fmt.Errorf(`file not found: %s`, req.File)
+	}
+
+	contract, ok := file[req.Contract]
+	if !ok {
+		return fmt.Errorf(`contract not found: %s`, req.Contract)
+	}
+
+	value, gasPrice, gasLimit, e := transactionParameters(ctx, req.Value, req.GasPrice, req.GasLimit, req.TransactionUnits)
+	if e != nil {
+		return e
+	}
+
+	key, e := auth.ExchangeToken(req.Auth.Provider, req.Auth.Token)
+	if e != nil {
+		return e
+	}
+	defer key.Destroy()
+
+	nonce, e := transactionNonce(ctx, key.Address, req.Nonce)
+	if e != nil {
+		return e
+	}
+
+	transaction, data, e := signOutgoingTransaction(ctx, outgoingTransaction{
+		Nonce:    nonce,
+		Value:    value,
+		GasLimit: gasLimit,
+		GasPrice: gasPrice,
+		Data:     contract.Binary,
+	}, req.TypedTransactionFields, req.SignatureType, req.GasPriceUnit, key)
+	if e != nil {
+		return e
+	}
+
+	if e := sendRawTransaction(ctx, data); e != nil {
+		return e // TODO: better error
+	}
+	txJournal.Add(transaction, key.Address)
+
+	// TODO: cancel transactions pending for longer than a certain amount of time (gasPrice too low)
+	// NOTE: failed transaction creations still result in a contract address but with no code in it
+
+	receipt, e := waitForReceipt(ctx, transaction.Hash())
 	if e != nil {
 		return e
 	}
-	calldata, e := abi.Encode(types.Tuple(function.Inputs), req.Arguments)
-	if e != nil {
-		return e
+	if receipt.Status != `0x1` { // 0x1 = success
+		return fmt.Errorf(`contract creation reverted -- gasLimit (%d) too low?`, gasLimit)
 	}
-	*res = append(keccak(function.SoliditySignature())[:4], calldata...)
+
+	*res = receipt
 	return nil
 }
 
-type RequestAuth struct {
-	Provider string          `json:"authenticator"`
-	Token    json.RawMessage `json:"token"`
+// sendRawTransaction broadcasts a signed transaction unless ctx is done, e.g. because the call timed out
+// while resolving the nonce: the client has been answered with a timeout error and wouldn't learn about it.
+func sendRawTransaction(ctx context.Context, data []byte) error {
+	if e := ctx.Err(); e != nil {
+		return fmt.Errorf(`transaction not sent: %s`, e)
+	}
+	return EthClient.CallContext(ctx, nil, `eth_sendRawTransaction`, ensure0xPrefix(hex.EncodeToString(data)))
 }
 
-type TransactionReceipt struct {
-	Status            string                  `json:"status"`         // only set when tx done
-	Root              string                  `json:"root,omitempty"` // only set while tx pending
-	TransactionHash   string                  `json:"transactionHash"`
-	BlockHash         string                  `json:"blockHash"`
-	TransactionIndex  string                  `json:"transactionIndex"`
-	BlockNumber       string                  `json:"blockNumber"`
-	CumulativeGasUsed string                  `json:"cumulativeGasUsed"`
-	GasUsed           string                  `json:"gasUsed"`
-	ContractAddress   string                  `json:"contractAddress"`
-	Logs              []TransactionReceiptLog `json:"logs"`
-}
+// receiptPollInterval is the interval at which waitForReceipt polls the node.
+const receiptPollInterval = time.Second / 2
 
-type TransactionReceiptLog struct {
-	Removed          bool     `json:"removed"`
-	LogIndex         string   `json:"logIndex"`
-	TransactionIndex string   `json:"transactionIndex"`
-	TransactionHash  string   `json:"transactionHash"`
-	BlockHash        string   `json:"blockHash"`
-	BlockNumber      string   `json:"blockNumber"`
-	Address          string   `json:"address"`
-	Data             string   `json:"data"`
-	Topics           []string `json:"topics"`
+// waitForReceipt polls the node until the transaction identified by hash has been mined or ctx is done,
+// and records its outcome in the journal. If polling fails or is given up, the outcome is recorded as unknown.
+// Without a deadline in ctx, it gives up after the default timeout of the transacting methods.
+func waitForReceipt(ctx context.Context, hash common.Hash) (TransactionReceipt, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		cancel := context.CancelFunc(nil)
+		ctx, cancel = context.WithTimeout(ctx, defaultMethodTimeouts[`DispatchFunctionCall`])
+		defer cancel()
+	}
+	ticker := time.NewTicker(receiptPollInterval)
+	defer ticker.Stop()
+	for {
+		receipt := TransactionReceipt{Status: `pending`} // "pending" is placeholder
+		e := EthClient.CallContext(ctx, &receipt, `eth_getTransactionReceipt`, hash)
+		if e != nil && ctx.Err() == nil {
+			txJournal.Complete(hash, journalStatusUnknown)
+			return TransactionReceipt{}, e // TODO: better error
+		}
+		if e == nil && receipt.Status != `pending` {
+			txJournal.Complete(hash, receipt.Status)
+			return receipt, nil
+		}
+		select {
+		case <-ctx.Done():
+			txJournal.Complete(hash, journalStatusUnknown)
+			return TransactionReceipt{}, fmt.Errorf(`gave up waiting for receipt of transaction %s: %s`, hash.Hex(), ctx.Err())
+		case <-ticker.C:
+		}
+	}
 }
 
-type FunctionDispatchMode string
-
-const (
-	FunctionDispatchModeDefault         FunctionDispatchMode = `default`
-	FunctionDispatchModeTransactionOnly FunctionDispatchMode = `transactionOnly`
-	FunctionDispatchModeCallOnly        FunctionDispatchMode = `callOnly`
-)
-
-type DispatchFunctionCallRequest struct {
+type BuildUnsignedTransactionRequest struct {
+	requestContext
 	EncodeFunctionCallRequest
-	Target   string               `json:"target"`
-	Value    json.Number          `json:"value"`
-	GasPrice json.Number          `json:"gasPrice"`
-	GasLimit json.Number          `json:"gasLimit"`
-	Mode     FunctionDispatchMode `json:"mode"`
-	Auth     RequestAuth          `json:"auth"`
+	From     string      `json:"from"`
+	Target   string      `json:"target"`
+	Value    json.Number `json:"value"`
+	GasPrice json.Number `json:"gasPrice"`
+	GasLimit json.Number `json:"gasLimit"`
+	TransactionUnits
+	SignatureType SignatureType `json:"signatureType"` // of the signature to be made, see transactionSigner
 }
 
-type DispatchFunctionCallResponse struct {
-	Result  json.RawMessage     `json:"result,omitempty"`
-	Receipt *TransactionReceipt `json:"receipt,omitempty"`
+type UnsignedTransaction struct {
+	Transaction BinaryJSON `json:"transaction"` // RLP-encoded
+	Hash        BinaryJSON `json:"hash"`        // to be signed
+	From        string     `json:"from"`
+	To          string     `json:"to"`
+	Nonce       uint64     `json:"nonce"`
+	Value       string     `json:"value"`
+	GasPrice    string     `json:"gasPrice"`
+	GasLimit    uint64     `json:"gasLimit"`
+	Data        BinaryJSON `json:"data"`
 }
 
-func (h RpcHandler) DispatchFunctionCall(req DispatchFunctionCallRequest, res *DispatchFunctionCallResponse) error {
+// BuildUnsignedTransaction prepares a function call transaction for signing elsewhere, e.g. on a hardware wallet.
+// The nonce is resolved against the pending state of the supplied from address.
+func (h RpcHandler) BuildUnsignedTransaction(req BuildUnsignedTransactionRequest, res *UnsignedTransaction) error {
 
-	if req.Value == "" {
-		req.Value = "0"
-	}
+	ctx := req.Context()
 
-	if req.Mode == "" {
-		req.Mode = FunctionDispatchModeDefault
-	} else {
-		if req.Mode != FunctionDispatchModeDefault &&
-			req.Mode != FunctionDispatchModeTransactionOnly &&
-			req.Mode != FunctionDispatchModeCallOnly {
-			return fmt.Errorf(`invalid mode, available: default, transactionOnly, callOnly`)
-		}
+	if req.From == "" {
+		return fmt.Errorf(`missing from address in request`)
 	}
 
 	if req.Target == "" {
 		return fmt.Errorf(`missing transaction target in request`)
 	}
 
-	gasLimit, gasPrice := uint64(defaultGasLimit), (*big.Int)(nil)
-
-	if req.GasPrice == "" {
-		gp := ""
-		if e := EthClient.Call(&gp, `eth_gasPrice`); e != nil {
-			return e
-		}
-		gasPrice, _ = new(big.Int).SetString(strip0xPrefix(gp), 16)
-	} else {
-		gp, ok := new(big.Int).SetString(string(req.GasPrice), 10)
-		if !ok {
-			return fmt.Errorf(`invalid gasPrice`)
-		}
-		gasPrice = gp
+	value, gasPrice, gasLimit, e := transactionParameters(ctx, req.Value, req.GasPrice, req.GasLimit, req.TransactionUnits)
+	if e != nil {
+		return e
 	}
 
-	if req.GasLimit != "" {
-		gl, e := strconv.ParseUint(string(req.GasLimit), 10, 64)
-		if e != nil {
-			return fmt.Errorf(`invalid gasLimit`)
-		}
-		gasLimit = gl
+	_, calldata, e := h.encodeCall(req.EncodeFunctionCallRequest)
+	if e != nil {
+		return e
 	}
 
-	value, ok := new(big.Int).SetString(string(req.Value), 10)
-	if !ok {
-		return fmt.Errorf(`invalid value`)
-	}
+	from, target := common.HexToAddress(req.From), common.HexToAddress(req.Target)
 
-	function, e := h.functionBySignature(req.File, req.Contract, req.Signature)
+	nonce, e := pendingNonce(ctx, from)
 	if e != nil {
 		return e
 	}
 
-	calldata, e := abi.Encode(types.Tuple(function.Inputs), req.Arguments)
+	signer, e := transactionSigner(ctx, req.SignatureType)
 	if e != nil {
-		return fmt.Errorf(`argument encoding error: %s`, e)
+		return e
 	}
-	calldata = append(keccak(function.SoliditySignature())[:4], calldata...)
 
-	key, e := auth.ExchangeToken(req.Auth.Provider, req.Auth.Token)
+	transaction := ethtypes.NewTransaction(nonce, target, value, gasLimit, gasPrice, calldata)
+
+	encoded, e := rlp.EncodeToBytes(transaction)
 	if e != nil {
-		return e // TODO: better errors
+		return e
 	}
-	defer key.Destroy()
 
-	target := common.HexToAddress(req.Target)
+	*res = UnsignedTransaction{
+		Transaction: encoded,
+		Hash:        signer.Hash(transaction).Bytes(),
+		From:        ensure0xPrefix(from.String()),
+		To:          ensure0xPrefix(target.String()),
+		Nonce:       nonce,
+		Value:       value.String(),
+		GasPrice:    gasPrice.String(),
+		GasLimit:    gasLimit,
+		Data:        BinaryJSON(calldata),
+	}
+	return nil
+}
 
-	call := struct {
-		From     string `json:"from"`
-		To       string `json:"to"`
-		Gas      string `json:"gas"`
-		GasPrice string `json:"gasPrice"`
-		Value    string `json:"value"`
-		Data     string `json:"data"`
-	}{
-		From:     ensure0xPrefix(key.Address.String()),
-		To:       ensure0xPrefix(target.String()),
-		Gas:      ensure0xPrefix(strconv.FormatUint(gasLimit, 16)),
-		GasPrice: ensure0xPrefix(gasPrice.Text(16)),
-		Value:    ensure0xPrefix(value.Text(16)),
-		Data:     ensure0xPrefix(hex.EncodeToString(calldata)),
+type SubmitSignedTransactionRequest struct {
+	requestContext
+	EncodeFunctionCallRequest                      // optional, used to decode the function's return values
+	Transaction               string               `json:"transaction"` // hex, RLP-encoded or an access-list or dynamic-fee transaction (EIP-2718)
+	Mode                      FunctionDispatchMode `json:"mode"`
+}
+
+// SubmitSignedTransaction broadcasts a transaction signed by the client, e.g. one built by BuildUnsignedTransaction.
+// If a function signature is given and mode is not transactionOnly, the return values are decoded like in DispatchFunctionCall.
+func (h RpcHandler) SubmitSignedTransaction(req SubmitSignedTransactionRequest, res *DispatchFunctionCallResponse) error {
+
+	ctx := req.Context()
+
+	if config.ReadOnly {
+		return errReadOnly
 	}
 
-	// pure and view functions can be called without transacting
-	if req.Mode == FunctionDispatchModeCallOnly ||
-		(req.Mode == FunctionDispatchModeDefault &&
-			(function.StateMutability == ast.StateMutabilityPure ||
-				function.StateMutability == ast.StateMutabilityView)) {
-		result := ""
-		if e := EthClient.Call(&result, `eth_call`, call, `latest`); e != nil {
-			return e // TODO: better error
+	if req.Mode == "" {
+		req.Mode = FunctionDispatchModeDefault
+	} else {
+		switch req.Mode {
+		case FunctionDispatchModeDefault, FunctionDispatchModeTransactionOnly:
+		default:
+			return fmt.Errorf(`invalid mode: %s`, req.Mode)
 		}
-		if result == `0x` && len(function.Outputs) > 0 {
-			return fmt.Errorf(`function call reverted -- gasLimit (%d) too low?`, gasLimit)
+	}
+
+	data, e := hex.DecodeString(strip0xPrefix(req.Transaction))
+	if e != nil {
+		return fmt.Errorf(`invalid transaction encoding: %s`, e)
+	}
+
+	transaction, from := signedTransaction(nil), common.Address{}
+	if len(data) > 0 && (data[0] == accessListTxType || data[0] == dynamicFeeTxType) {
+		typed, e := decodeTypedTransaction(data)
+		if e != nil {
+			return fmt.Errorf(`invalid transaction: %s`, e)
 		}
-		if len(function.Outputs) == 0 {
-			*res = DispatchFunctionCallResponse{}
-			return nil
+		if from, e = typedTransactionSender(typed); e != nil {
+			return fmt.Errorf(`invalid transaction signature: %s`, e)
 		}
-		code, e := hex.DecodeString(strip0xPrefix(result))
-		if e != nil {
-			return e // TODO: better error
+		transaction = typed
+	} else {
+		legacy := new(ethtypes.Transaction)
+		if e := rlp.DecodeBytes(data, legacy); e != nil {
+			return fmt.Errorf(`invalid transaction: %s`, e)
 		}
-		decoded, e := abi.Decode(types.Tuple(function.Outputs), code)
-		if e != nil {
-			return e // TODO: context in error
+		if from, e = transactionSender(legacy); e != nil {
+			return fmt.Errorf(`invalid transaction signature: %s`, e)
 		}
-		*res = DispatchFunctionCallResponse{Result: decoded}
-		return nil
+		transaction = legacy
 	}
 
-	nonce := uint64(0)
-	{
-		nc := ""
-		if e := EthClient.Call(&nc, `eth_getTransactionCount`, key.Address, `pending`); e != nil {
-			return fmt.Errorf(`failed to get nonce: %s`, e.Error())
+	integers, e := abi.ParseIntegerFormat(req.IntegerFormat)
+	if e != nil {
+		return e
+	}
+
+	function, decode := types.Function{}, req.Signature != "" && req.Mode == FunctionDispatchModeDefault
+	if decode {
+		if transaction.To() == nil {
+			return fmt.Errorf(`contract creation has no return values to decode`)
+		}
+		if function, e = h.functionBySignature(req.File, req.Contract, req.Signature); e != nil {
+			return e
 		}
-		nonce, _ = strconv.ParseUint(strip0xPrefix(nc), 16, 64)
 	}
 
-	transaction, e := ethtypes.SignTx(ethtypes.NewTransaction(nonce, target, value, gasLimit, gasPrice, calldata), signer, key.PrivateKey)
-	if e != nil {
-		return fmt.Errorf(`error signing transaction: %s`, e.Error())
+	if e := sendRawTransaction(ctx, data); e != nil {
+		return e // TODO: better error
 	}
+	txJournal.Add(transaction, from)
 
-	data, e := rlp.EncodeToBytes(transaction)
+	receipt, e := waitForReceipt(ctx, transaction.Hash())
 	if e != nil {
-		return e // TODO: better error
+		return e
 	}
-	if e := EthClient.Call(nil, `eth_sendRawTransaction`, ensure0xPrefix(hex.EncodeToString(data))); e != nil {
-		return e // TODO: better error
+
+	if receipt.Status != `0x1` {
+		return fmt.Errorf(`transaction reverted -- gasLimit (%d) too low?`, transaction.Gas())
 	}
 
-	receipt := TransactionReceipt{Status: `pending`} // "pending" is placeholder
+	cost, e := transactionCost(receipt, transaction.GasPrice())
+	if e != nil {
+		return e
+	}
 
-	for {
-		if e := EthClient.Call(&receipt, `eth_getTransactionReceipt`, transaction.Hash()); e != nil {
-			return e // TODO: better error
-		}
-		if receipt.Status == `pending` {
-			time.Sleep(time.Second / 2)
-			continue
-		}
-		break
+	if !decode {
+		*res = DispatchFunctionCallResponse{Receipt: &receipt, Cost: cost}
+		return nil
 	}
 
-	if receipt.Status != `0x1` {
-		return fmt.Errorf(`transaction reverted -- gasLimit (%d) too low?`, gasLimit)
+	call := callArguments{
+		From:     ensure0xPrefix(from.String()),
+		To:       ensure0xPrefix(transaction.To().String()),
+		Gas:      ensure0xPrefix(strconv.FormatUint(transaction.Gas(), 16)),
+		GasPrice: ensure0xPrefix(transaction.GasPrice().Text(16)),
+		Value:    ensure0xPrefix(transaction.Value().Text(16)),
+		Data:     ensure0xPrefix(hex.EncodeToString(transaction.Data())),
 	}
 
-	if req.Mode == FunctionDispatchModeTransactionOnly {
-		*res = DispatchFunctionCallResponse{Receipt: &receipt}
-		return nil
+	decoded, e := replayCall(ctx, function, call, receipt, abi.DecodeOptions{Integers: integers, PadHex: req.PadHex, RawUnsupported: req.RawUnsupported})
+	if e != nil {
+		return e
 	}
+	*res = DispatchFunctionCallResponse{Result: decoded, Receipt: &receipt, Cost: cost}
+	return nil
+}
 
-	// prevBlockNr := (receipt.BlockNumber - 1)
-	blockNr, _ := new(big.Int).SetString(strip0xPrefix(receipt.BlockNumber), 16)
-	prevBlockNr := new(big.Int).Sub(blockNr, big.NewInt(1))
+type TransactionSender struct {
+	From         string `json:"from"`         // recovered from the transaction's signature
+	ReportedFrom string `json:"reportedFrom"` // as reported by the node
+	Match        bool   `json:"match"`
+}
 
-	result := ""
-	if e := EthClient.Call(&result, `eth_call`, call, ensure0xPrefix(prevBlockNr.Text(16))); e != nil {
+type RecoverTransactionSenderRequest struct {
+	requestContext
+	Hash string `json:"hash"`
+}
+
+// RecoverTransactionSender fetches a transaction by its hash and recovers its sender from the signature,
+// comparing it to the sender reported by the node. Legacy, access-list and dynamic-fee transactions are supported.
+func (h RpcHandler) RecoverTransactionSender(req RecoverTransactionSenderRequest, res *TransactionSender) error {
+	raw := json.RawMessage(nil)
+	if e := EthClient.CallContext(req.Context(), &raw, `eth_getTransactionByHash`, ensure0xPrefix(strip0xPrefix(req.Hash))); e != nil {
 		return e // TODO: better error
 	}
-	if result == `0x` && len(function.Outputs) > 0 {
-		// TODO: transaction succeeded but call didn't... better response?
-		*res = DispatchFunctionCallResponse{Receipt: &receipt}
-		return nil
+	if len(raw) == 0 || string(raw) == `null` {
+		return fmt.Errorf(`transaction not found: %s`, req.Hash)
 	}
-	if len(function.Outputs) == 0 {
-		*res = DispatchFunctionCallResponse{Receipt: &receipt}
-		return nil
+	reported := struct {
+		From string `json:"from"`
+	}{}
+	if e := json.Unmarshal(raw, &reported); e != nil {
+		return fmt.Errorf(`invalid transaction returned by node: %s`, e)
 	}
-	code, e := hex.DecodeString(strip0xPrefix(result))
+	typed, e := typedTransactionFromNode(raw)
 	if e != nil {
-		return e // TODO: better error
+		return fmt.Errorf(`invalid transaction returned by node: %s`, e)
+	}
+	from := common.Address{}
+	if typed != nil {
+		from, e = typedTransactionSender(typed)
+	} else {
+		transaction := new(ethtypes.Transaction)
+		if e := json.Unmarshal(raw, transaction); e != nil {
+			return fmt.Errorf(`invalid transaction returned by node: %s`, e)
+		}
+		from, e = transactionSender(transaction)
 	}
-	decoded, e := abi.Decode(types.Tuple(function.Outputs), code)
 	if e != nil {
-		return e // TODO: context in error
+		return fmt.Errorf(`failed recovering sender: %s`, e)
+	}
+	*res = TransactionSender{
+		From:         from.Hex(),
+		ReportedFrom: common.HexToAddress(reported.From).Hex(),
+		Match:        from == common.HexToAddress(reported.From),
 	}
-	*res = DispatchFunctionCallResponse{Result: decoded, Receipt: &receipt}
 	return nil
-
-}
-
-type CreateContractRequest struct {
-	GetContractRequest
-	Value    json.Number `json:"value"`
-	GasPrice json.Number `json:"gasPrice"`
-	GasLimit json.Number `json:"gasLimit"`
-	Auth     RequestAuth `json:"auth"`
 }
 
-func (h RpcHandler) CreateContract(req CreateContractRequest, res *TransactionReceipt) error {
-
-	file, ok := h.project.Files[req.File]
-	if !ok {
-		return fmt.Errorf(`file not found: %s`, req.File)
-	}
+// transactionParameters parses the value, gas price and gas limit of a transaction request.
+// Value and gas price are given in units, see parseWei.
+// Omitted values default to zero wei, the node's gas price and defaultGasLimit, respectively.
+func transactionParameters(ctx context.Context, value, gasPrice, gasLimit json.Number, units TransactionUnits) (*big.Int, *big.Int, uint64, error) {
 
-	contract, ok := file[req.Contract]
-	if !ok {
-		return fmt.Errorf(`contract not found: %s`, req.Contract)
+	if value == "" {
+		value = "0"
 	}
 
-	if req.Value == "" {
-		req.Value = "0"
+	val, e := parseWei(value, units.ValueUnit)
+	if e != nil {
+		return nil, nil, 0, fmt.Errorf(`invalid value: %s`, e)
 	}
 
-	gasLimit, gasPrice := uint64(defaultGasLimit), (*big.Int)(nil)
-
-	if req.GasPrice == "" {
-		gp := ""
-		if e := EthClient.Call(&gp, `eth_gasPrice`); e != nil {
-			return e
+	gp := (*big.Int)(nil)
+	if gasPrice == "" {
+		suggested := ""
+		if e := EthClient.CallContext(ctx, &suggested, `eth_gasPrice`); e != nil {
+			return nil, nil, 0, e
 		}
-		gasPrice, _ = new(big.Int).SetString(strip0xPrefix(gp), 16)
+		gp, _ = new(big.Int).SetString(strip0xPrefix(suggested), 16)
 	} else {
-		gp, ok := new(big.Int).SetString(string(req.GasPrice), 10)
-		if !ok {
-			return fmt.Errorf(`invalid gasPrice`)
+		if gp, e = parseWei(gasPrice, units.GasPriceUnit); e != nil {
+			return nil, nil, 0, fmt.Errorf(`invalid gasPrice: %s`, e)
 		}
-		gasPrice = gp
 	}
 
-	if req.GasLimit != "" {
-		gl, e := strconv.ParseUint(string(req.GasLimit), 10, 64)
+	gl := uint64(defaultGasLimit)
+	if gasLimit != "" {
+		parsed, e := strconv.ParseUint(string(gasLimit), 10, 64)
 		if e != nil {
-			return fmt.Errorf(`invalid gasLimit`)
+			return nil, nil, 0, fmt.Errorf(`invalid gasLimit`)
 		}
-		gasLimit = gl
+		gl = parsed
 	}
 
-	value, ok := new(big.Int).SetString(string(req.Value), 10)
-	if !ok {
-		return fmt.Errorf(`invalid value`)
-	}
+	return val, gp, gl, nil
+}
 
-	key, e := auth.ExchangeToken(req.Auth.Provider, req.Auth.Token)
+// transactionNonce returns the nonce for the next transaction of address: override if given, the pending nonce otherwise.
+// Overrides behind the pending nonce are allowed, e.g. to replace a pending transaction, but logged.
+func transactionNonce(ctx context.Context, address common.Address, override json.Number) (uint64, error) {
+	pending, e := pendingNonce(ctx, address)
 	if e != nil {
-		return e
+		return 0, e
 	}
-	defer key.Destroy()
-
-	nonce := uint64(0)
-	{
-		nc := ""
-		if e := EthClient.Call(&nc, `eth_getTransactionCount`, key.Address, `pending`); e != nil {
-			return fmt.Errorf(`failed to get nonce: %s`, e.Error())
-		}
-		nonce, _ = strconv.ParseUint(strip0xPrefix(nc), 16, 64)
+	if override == "" {
+		return pending, nil
 	}
-
-	transaction, e := ethtypes.SignTx(ethtypes.NewContractCreation(nonce, value, gasLimit, gasPrice, contract.Binary), signer, key.PrivateKey)
+	nonce, e := strconv.ParseUint(string(override), 10, 64)
 	if e != nil {
-		return fmt.Errorf(`error signing transaction: %s`, e.Error())
+		return 0, fmt.Errorf(`invalid nonce`)
 	}
-
-	{
-		bs, e := rlp.EncodeToBytes(transaction)
-		if e != nil {
-			return e // TODO: better error
-		}
-		if e := EthClient.Call(nil, `eth_sendRawTransaction`, ensure0xPrefix(hex.EncodeToString(bs))); e != nil {
-			return e // TODO: better error
-		}
+	if nonce < pending {
+		log.Println(`nonce override`, nonce, `for`, address.Hex(), `is behind pending nonce`, pending)
 	}
+	return nonce, nil
+}
 
-	// TODO: cancel transactions pending for longer than a certain amount of time (gasPrice too low)
-	// NOTE: failed transaction creations still result in a contract address but with no code in it
-
-	for {
-
-		receipt := TransactionReceipt{Status: `pending`}
-		if e := EthClient.Call(&receipt, `eth_getTransactionReceipt`, transaction.Hash()); e != nil {
-			return e // TODO: better error
-		}
-		if receipt.Status == `pending` {
-			time.Sleep(time.Second / 2)
-			continue
-		}
-		if receipt.Status != `0x1` { // 0x1 = success
-			return fmt.Errorf(`contract creation reverted -- gasLimit (%d) too low?`, gasLimit)
-		}
-		*res = receipt
-		break
+// pendingNonce fetches the next nonce of address, taking pending transactions into account.
+func pendingNonce(ctx context.Context, address common.Address) (uint64, error) {
+	nc := ""
+	if e := EthClient.CallContext(ctx, &nc, `eth_getTransactionCount`, address, `pending`); e != nil {
+		return 0, fmt.Errorf(`failed to get nonce: %s`, e.Error())
 	}
+	nonce, _ := strconv.ParseUint(strip0xPrefix(nc), 16, 64)
+	return nonce, nil
+}
 
-	return nil
+// signTransaction signs tx with key using signer and verifies that the signed transaction recovers to key.Address.
+// A mismatch indicates a key handling bug; the transaction must never be broadcast in that case.
+func signTransaction(tx *ethtypes.Transaction, signer ethtypes.Signer, key *auth.Key) (*ethtypes.Transaction, error) {
+	signed, e := ethtypes.SignTx(tx, signer, key.PrivateKey)
+	if e != nil {
+		return nil, fmt.Errorf(`error signing transaction: %s`, e.Error())
+	}
+	sender, e := ethtypes.Sender(signer, signed)
+	if e != nil {
+		log.Println("failed recovering sender of signed transaction", e)
+		return nil, fmt.Errorf(`internal error`)
+	}
+	if sender != key.Address {
+		log.Println("signed transaction recovers to", sender.Hex(), "instead of", key.Address.Hex())
+		return nil, fmt.Errorf(`internal error`)
+	}
+	return signed, nil
 }
 
+// fallbackSignatures select a contract's fallback function in functionBySignature.
+var fallbackSignatures = map[string]bool{``: true, `()`: true, `fallback`: true, `fallback()`: true, `receive`: true, `receive()`: true}
+
 func (h RpcHandler) functionBySignature(file, contract, signature string) (types.Function, error) {
 
 	function := types.Function{}
 
+	if fallbackSignatures[signature] {
+		signature = types.FallbackFunctionName + `()`
+	}
+
 	_file, ok := h.project.Files[file]
 	if !ok {
 		return function, fmt.Errorf(`file not found: %s`, file)
@@ -707,19 +2084,30 @@ func (h RpcHandler) functionBySignature(file, contract, signature string) (types
 	sigs := make([]string, 0, 16)
 
 	for _, contract := range append([]*types.Contract{_contract}, _contract.Parents...) {
-		for _, function := range contract.API {
-			sig := string(function.SoliditySignature())
-			if sig == signature {
-				return function, nil
-			}
-			sigs = append(sigs, sig)
+		if function, ok := contract.API[signature]; ok {
+			return function, nil
 		}
+		sigs = append(sigs, sortedSignatures(contract)...)
 	}
 
 	return function, fmt.Errorf(`function signature not found: %s. available are: %s`, signature, strings.Join(sigs, `, `))
 
 }
 
+// sortedSignatures returns the signatures of contract's functions in lexical order.
+func sortedSignatures(contract *types.Contract) []string {
+	sigs := make([]string, 0, len(contract.API))
+	for sig, _ := range contract.API {
+		sigs = append(sigs, sig)
+	}
+	sort.Strings(sigs)
+	return sigs
+}
+
+// jsonEncoder produces canonical output: equal values encode to identical bytes, so clients may content-address
+// or cache encodings. encoding/json sorts map keys and emits struct fields in declaration order, Keys and Types
+// of types.Struct keep their declaration order, and lists are never encoded as null, so an absent list and an
+// empty one are indistinguishable.
 type jsonEncoder struct{}
 
 func (codec jsonEncoder) EncodeProject(project types.Project) ([]byte, error) {
@@ -746,7 +2134,11 @@ func (codec jsonEncoder) EncodeProject(project types.Project) ([]byte, error) {
 func (codec jsonEncoder) EncodeContract(contract *types.Contract) ([]byte, error) {
 	parents := make([]string, len(contract.Parents), len(contract.Parents))
 	for i, parent := range contract.Parents {
-		parents[i] = (parent.File + ":" + parent.Name)
+		parents[i] = types.Key{File: parent.File, Contract: parent.Name}.String()
+	}
+	bases := make([]string, len(contract.Bases), len(contract.Bases))
+	for i, base := range contract.Bases {
+		bases[i] = types.Key{File: base.File, Contract: base.Name}.String()
 	}
 	api := make(map[string]json.RawMessage, len(contract.API))
 	for signature, function := range contract.API {
@@ -756,34 +2148,52 @@ func (codec jsonEncoder) EncodeContract(contract *types.Contract) ([]byte, error
 		}
 		api[signature] = encoded
 	}
-	types := make(map[string]json.RawMessage, len(contract.Types))
+	typs := make(map[string]json.RawMessage, len(contract.Types))
 	for name, typ := range contract.Types {
 		encoded, e := codec.EncodeType(typ)
 		if e != nil {
 			return nil, e
 		}
-		types[name] = encoded
+		typs[name] = encoded
+	}
+	constants := contract.Constants
+	if constants == nil {
+		constants = map[string]json.RawMessage{} // e.g. contracts loaded from ABI files
 	}
 	return json.Marshal(struct {
-		Kind         string                     `json:"kind"`
-		File         string                     `json:"file"`
-		Name         string                     `json:"name"`
-		Parents      []string                   `json:"parents"`
-		NatSpec      string                     `json:"natSpec"`
-		ContractKind ast.ContractKind           `json:"contractKind"`
-		API          map[string]json.RawMessage `json:"api"`
-		Types        map[string]json.RawMessage `json:"types"`
-		Binary       BinaryJSON                 `json:"binary"`
+		Kind            string                      `json:"kind"`
+		File            string                      `json:"file"`
+		Name            string                      `json:"name"`
+		Parents         []string                    `json:"parents"` // linearized, most derived first
+		Bases           []string                    `json:"bases"`   // direct, in declaration order
+		NatSpec         string                      `json:"natSpec"`
+		NatSpecTags     types.NatSpec               `json:"natSpecTags"`
+		ContractKind    ast.ContractKind            `json:"contractKind"`
+		API             map[string]json.RawMessage  `json:"api"`
+		Types           map[string]json.RawMessage  `json:"types"`
+		Source          *ast.SourceRange            `json:"source"` // null if unknown
+		TypeSources     map[string]*ast.SourceRange `json:"typeSources"`
+		Constants       map[string]json.RawMessage  `json:"constants"`
+		Binary          BinaryJSON                  `json:"binary"`
+		RuntimeBinary   BinaryJSON                  `json:"runtimeBinary"`
+		CompilerVersion string                      `json:"compilerVersion"`
 	}{
-		Kind:         `contract`,
-		File:         contract.File,
-		Name:         contract.Name,
-		Parents:      parents,
-		NatSpec:      contract.NatSpec,
-		ContractKind: contract.Kind,
-		API:          api,
-		Types:        types,
-		Binary:       BinaryJSON(contract.Binary),
+		Kind:            `contract`,
+		File:            contract.File,
+		Name:            contract.Name,
+		Parents:         parents,
+		Bases:           bases,
+		NatSpec:         contract.NatSpec,
+		NatSpecTags:     contract.NatSpecTags(),
+		ContractKind:    contract.Kind,
+		API:             api,
+		Types:           typs,
+		Source:          contract.Source(),
+		TypeSources:     contract.TypeSources(),
+		Constants:       constants,
+		Binary:          BinaryJSON(contract.Binary),
+		RuntimeBinary:   BinaryJSON(contract.RuntimeBinary),
+		CompilerVersion: contract.CompilerVersion,
 	})
 }
 
@@ -804,6 +2214,10 @@ func (codec jsonEncoder) EncodeFunction(function types.Function) ([]byte, error)
 		}
 		outputs[i] = encodedOutput
 	}
+	modifiers := function.Modifiers
+	if modifiers == nil {
+		modifiers = []string{} // e.g. variable getters
+	}
 	sig := function.SoliditySignature()
 	return json.Marshal(struct {
 		Kind        string            `json:"kind"`
@@ -811,21 +2225,39 @@ func (codec jsonEncoder) EncodeFunction(function types.Function) ([]byte, error)
 		Fingerprint string            `json:"fingerprint"`
 		Name        string            `json:"name"`
 		NatSpec     string            `json:"natSpec"`
+		NatSpecTags types.NatSpec     `json:"natSpecTags"`
 		Visibility  ast.Visibility    `json:"visibility"`
 		Inputs      []json.RawMessage `json:"inputs"`
 		Outputs     []json.RawMessage `json:"outputs"`
+		InputNames  []string          `json:"inputNames"`
+		OutputNames []string          `json:"outputNames"`
+		Modifiers   []string          `json:"modifiers"`
+		Source      *ast.SourceRange  `json:"source"` // null if unknown
 	}{
 		Kind:        `function`,
 		Signature:   string(sig),
 		Fingerprint: hex.EncodeToString(keccak(sig)[:4]),
 		Name:        function.Name,
 		NatSpec:     function.NatSpec,
+		NatSpecTags: function.NatSpecTags(),
 		Visibility:  function.Visibility,
 		Inputs:      inputs,
 		Outputs:     outputs,
+		InputNames:  parameterNames(function.InputNames, len(inputs)),
+		OutputNames: parameterNames(function.OutputNames, len(outputs)),
+		Modifiers:   modifiers,
+		Source:      function.Source(),
 	})
 }
 
+// parameterNames returns names, or n empty names if names is missing.
+func parameterNames(names []string, n int) []string {
+	if len(names) == n {
+		return names
+	}
+	return make([]string, n, n)
+}
+
 func (codec jsonEncoder) EncodeType(typ types.Type) ([]byte, error) {
 	definition, e := codec.encodeType(typ)
 	if e != nil {
@@ -852,14 +2284,28 @@ func (codec jsonEncoder) encodeType(typ types.Type) ([]byte, error) {
 			}
 			args[i] = arg
 		}
+		indexed := t.Indexed
+		if len(indexed) != len(args) {
+			indexed = make([]bool, len(args), len(args))
+		}
+		argNames := t.ArgNames
+		if len(argNames) != len(args) {
+			argNames = make([]string, len(args), len(args))
+		}
 		return json.Marshal(struct {
-			Kind string            `json:"kind"`
-			Name string            `json:"name"`
-			Args []json.RawMessage `json:"args"`
+			Kind      string            `json:"kind"`
+			Name      string            `json:"name"`
+			Args      []json.RawMessage `json:"args"`
+			Indexed   []bool            `json:"indexed"`
+			ArgNames  []string          `json:"argNames"`
+			Anonymous bool              `json:"anonymous"`
 		}{
-			Kind: `event`,
-			Name: string(t.Name),
-			Args: args,
+			Kind:      `event`,
+			Name:      string(t.Name),
+			Args:      args,
+			Indexed:   indexed,
+			ArgNames:  argNames,
+			Anonymous: t.Anonymous,
 		})
 
 	case types.Tuple:
@@ -896,13 +2342,17 @@ func (codec jsonEncoder) encodeType(typ types.Type) ([]byte, error) {
 			}
 			types[i] = encoded
 		}
+		keys := t.Keys
+		if keys == nil {
+			keys = []string{}
+		}
 		return json.Marshal(struct {
 			Kind  string            `json:"kind"`
 			Keys  []string          `json:"keys"`
 			Types []json.RawMessage `json:"types"`
 		}{
 			Kind:  `struct`,
-			Keys:  t.Keys,
+			Keys:  keys,
 			Types: types,
 		})
 	case types.Array:
@@ -938,12 +2388,16 @@ func (codec jsonEncoder) encodeType(typ types.Type) ([]byte, error) {
 			Value: value,
 		})
 	case types.Enum:
+		values := []string(t)
+		if values == nil {
+			values = []string{}
+		}
 		return json.Marshal(struct {
 			Kind   string   `json:"kind"`
 			Values []string `json:"values"`
 		}{
 			Kind:   `enum`,
-			Values: []string(t),
+			Values: values,
 		})
 	case types.Named:
 		encoded, e := codec.encodeType(t.Type)
@@ -1005,7 +2459,7 @@ func ensure0xPrefix(s string) string {
 }
 
 func strip0xPrefix(s string) string {
-	if len(s) > 2 && s[:2] == `0x` {
+	if len(s) >= 2 && s[:2] == `0x` {
 		return s[2:]
 	}
 	return s
@@ -0,0 +1,1324 @@
+// Copyright 2018 karma.run AG. All rights reserved.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/karmarun/karma.link/ast"
+	"github.com/karmarun/karma.link/ast/extract"
+	"github.com/karmarun/karma.link/auth"
+	"github.com/karmarun/karma.link/auth/fs"
+	"github.com/karmarun/karma.link/config"
+	"github.com/karmarun/karma.link/types"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// mockEthClient implements ethCaller by answering calls with the handler registered for their method.
+// Handler results are passed through JSON like responses of a real node.
+type mockEthClient struct {
+	mutex    sync.Mutex
+	handlers map[string]func(args ...interface{}) (interface{}, error)
+	calls    []string // methods called, in order
+}
+
+func newMockEthClient() *mockEthClient {
+	return &mockEthClient{handlers: make(map[string]func(args ...interface{}) (interface{}, error), 8)}
+}
+
+func (c *mockEthClient) Handle(method string, handler func(args ...interface{}) (interface{}, error)) *mockEthClient {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.handlers[method] = handler
+	return c
+}
+
+// CallContext fails with ctx.Err() once ctx is done, even if the handler hasn't returned yet, like *ethrpc.Client.
+func (c *mockEthClient) CallContext(ctx context.Context, result interface{}, method string, args ...interface{}) error {
+	if e := ctx.Err(); e != nil {
+		return e
+	}
+	c.mutex.Lock()
+	c.calls = append(c.calls, method)
+	handler, ok := c.handlers[method]
+	c.mutex.Unlock()
+	if !ok {
+		return fmt.Errorf(`unexpected call: %s`, method)
+	}
+	type answer struct {
+		value interface{}
+		err   error
+	}
+	answers := make(chan answer, 1)
+	go func() {
+		value, e := handler(args...)
+		answers <- answer{value, e}
+	}()
+	value := interface{}(nil)
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case a := <-answers:
+		if a.err != nil || result == nil {
+			return a.err
+		}
+		value = a.value
+	}
+	bs, e := json.Marshal(value)
+	if e != nil {
+		return e
+	}
+	return json.Unmarshal(bs, result)
+}
+
+func (c *mockEthClient) Called(method string) int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	n := 0
+	for _, called := range c.calls {
+		if called == method {
+			n++
+		}
+	}
+	return n
+}
+
+// testKeyHex is the private key handed out by testAuthenticator.
+const testKeyHex = `b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291`
+
+var testAddress = crypto.PubkeyToAddress(testKey().PrivateKey.PublicKey)
+
+func testKey() *auth.Key {
+	private, e := crypto.HexToECDSA(testKeyHex)
+	if e != nil {
+		panic(e)
+	}
+	return &auth.Key{Address: crypto.PubkeyToAddress(private.PublicKey), PrivateKey: private}
+}
+
+// testAuthenticator accepts any credentials and exchanges any token for a fresh copy of the test key.
+type testAuthenticator struct{}
+
+func (testAuthenticator) Authenticate(json.RawMessage) (json.RawMessage, error) {
+	return json.RawMessage(`"token"`), nil
+}
+
+func (testAuthenticator) RenewToken(token json.RawMessage) (json.RawMessage, error) {
+	return token, nil
+}
+
+func (testAuthenticator) ExchangeToken(json.RawMessage) (*auth.Key, error) {
+	return testKey(), nil
+}
+
+var testAuth = RequestAuth{Provider: `test`, Token: json.RawMessage(`"token"`)}
+
+func init() {
+	auth.RegisterAuthenticator(`test`, testAuthenticator{})
+}
+
+// minedReceipt answers eth_getTransactionReceipt with a successful receipt.
+func minedReceipt(args ...interface{}) (interface{}, error) {
+	return TransactionReceipt{Status: `0x1`, GasUsed: `0x5208`, Logs: []TransactionReceiptLog{}}, nil
+}
+
+// useEthClient replaces EthClient with client, call the returned function to restore it.
+func useEthClient(client ethCaller) func() {
+	previous := EthClient
+	EthClient = client
+	return func() { EthClient = previous }
+}
+
+// abiContract extracts a contract from ABI JSON, failing t on errors.
+func abiContract(t testing.TB, file, name, abiJSON string) *types.Contract {
+	contract, e := extract.ABI(file, name, []byte(abiJSON))
+	if e != nil {
+		t.Fatal(e)
+	}
+	return contract
+}
+
+// testHandler returns an RpcHandler serving contracts, each in its own file.
+func testHandler(contracts ...*types.Contract) RpcHandler {
+	project := types.Project{Files: make(map[string]map[string]*types.Contract, len(contracts))}
+	for _, contract := range contracts {
+		if project.Files[contract.File] == nil {
+			project.Files[contract.File] = make(map[string]*types.Contract, 1)
+		}
+		project.Files[contract.File][contract.Name] = contract
+	}
+	project.EventTopics = extract.ProjectEventTopics(project)
+	return RpcHandler{project, newEncodingCache()}
+}
+
+// mustDecodeHex decodes a "0x"-prefixed hex string, panicking on errors.
+func mustDecodeHex(s string) []byte {
+	bs, e := hex.DecodeString(strip0xPrefix(s))
+	if e != nil {
+		panic(e)
+	}
+	return bs
+}
+
+// word returns the hex of 32-byte big-endian words holding values.
+func word(values ...uint64) string {
+	s := ""
+	for _, value := range values {
+		s += fmt.Sprintf(`%064x`, value)
+	}
+	return s
+}
+
+func TestReadVariable(t *testing.T) {
+	token := abiContract(t, `Token.sol`, `Token`, `[
+		{"type": "function", "name": "balances", "stateMutability": "view",
+		 "inputs": [{"name": "", "type": "address"}], "outputs": [{"name": "", "type": "uint256"}]}
+	]`)
+	getter := token.API[`balances(address)`]
+	getter.Visibility, getter.Definition = ast.VisibilityPublic, ast.VariableDeclaration{Name: `balances`, StateVariable: true}
+	token.API[`balances(address)`] = getter
+
+	holder := `0x00000000000000000000000000000000000000aa`
+	mock := newMockEthClient().Handle(`eth_call`, func(args ...interface{}) (interface{}, error) {
+		data := ensure0xPrefix(hex.EncodeToString(keccak([]byte(`balances(address)`))[:4])) + word(0xaa)
+		if call := fmt.Sprint(args[0]); !strings.Contains(call, data) {
+			return nil, fmt.Errorf(`unexpected call: %s`, call)
+		}
+		return `0x` + word(1000), nil
+	})
+	defer useEthClient(mock)()
+
+	result := json.RawMessage(nil)
+	req := ReadVariableRequest{File: `Token.sol`, Contract: `Token`, Target: `0x01`, Variable: `balances`, Keys: json.RawMessage(`["` + holder + `"]`)}
+	if e := testHandler(token).ReadVariable(req, &result); e != nil {
+		t.Fatal(e)
+	}
+	if string(result) != `1000` {
+		t.Fatalf(`expected 1000, have %s`, result)
+	}
+
+	req.Variable = `owner`
+	if e := testHandler(token).ReadVariable(req, &result); e == nil || !strings.Contains(e.Error(), `variable not found`) {
+		t.Fatalf(`expected variable not found, have %v`, e)
+	}
+}
+
+// callContract dispatches a call of signature on contract, answering eth_call with result.
+func callContract(t *testing.T, contract *types.Contract, signature, arguments, result string) (DispatchFunctionCallResponse, error) {
+	mock := newMockEthClient().Handle(`eth_call`, func(...interface{}) (interface{}, error) { return `0x` + result, nil })
+	defer useEthClient(mock)()
+	req := DispatchFunctionCallRequest{Target: `0x01`, GasPrice: `1`, Auth: testAuth}
+	req.File, req.Contract, req.Signature, req.Arguments = contract.File, contract.Name, signature, json.RawMessage(arguments)
+	res := DispatchFunctionCallResponse{}
+	e := testHandler(contract).DispatchFunctionCall(req, &res)
+	return res, e
+}
+
+func TestDispatchNamedStructOutput(t *testing.T) {
+	registry := abiContract(t, `Registry.sol`, `Registry`, `[
+		{"type": "function", "name": "entry", "stateMutability": "view", "inputs": [],
+		 "outputs": [{"name": "", "type": "tuple", "components": [{"name": "owner", "type": "address"}, {"name": "size", "type": "uint256"}]}]},
+		{"type": "function", "name": "entryAndCount", "stateMutability": "view", "inputs": [],
+		 "outputs": [{"name": "", "type": "tuple", "components": [{"name": "owner", "type": "address"}, {"name": "size", "type": "uint256"}]},
+		             {"name": "count", "type": "uint256"}]}
+	]`)
+	for _, signature := range []string{`entry()`, `entryAndCount()`} {
+		function := registry.API[signature]
+		function.Outputs[0] = types.Named{Name: `Registry.sol:Registry.Entry`, Type: function.Outputs[0]}
+	}
+
+	res, e := callContract(t, registry, `entry()`, `[]`, word(0xaa, 3))
+	if e != nil {
+		t.Fatal(e)
+	}
+	if expected := `{"owner":"0x00000000000000000000000000000000000000aa","size":3}`; !jsonEqualString(res.Result, expected) {
+		t.Fatalf(`expected %s, have %s`, expected, res.Result)
+	}
+
+	res, e = callContract(t, registry, `entryAndCount()`, `[]`, word(0xaa, 3, 9))
+	if e != nil {
+		t.Fatal(e)
+	}
+	if expected := `[{"owner":"0x00000000000000000000000000000000000000aa","size":3},9]`; !jsonEqualString(res.Result, expected) {
+		t.Fatalf(`expected %s, have %s`, expected, res.Result)
+	}
+}
+
+// jsonEqualString reports whether bs and s hold equal JSON values.
+func jsonEqualString(bs []byte, s string) bool {
+	a, b := interface{}(nil), interface{}(nil)
+	if json.Unmarshal(bs, &a) != nil || json.Unmarshal([]byte(s), &b) != nil {
+		return false
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// mismatchedAuthenticator hands out the test key under a wrong address, like a key handling bug would.
+type mismatchedAuthenticator struct{ testAuthenticator }
+
+func (mismatchedAuthenticator) ExchangeToken(json.RawMessage) (*auth.Key, error) {
+	key := testKey()
+	key.Address[0] ^= 0xff
+	return key, nil
+}
+
+func init() {
+	auth.RegisterAuthenticator(`test-mismatched`, mismatchedAuthenticator{})
+}
+
+func TestSignerMismatchIsNotBroadcast(t *testing.T) {
+	store := abiContract(t, `Store.sol`, `Store`, `[
+		{"type": "function", "name": "set", "stateMutability": "nonpayable", "inputs": [{"name": "v", "type": "uint256"}], "outputs": []}
+	]`)
+	mock := newMockEthClient().
+		Handle(`eth_getTransactionCount`, func(...interface{}) (interface{}, error) { return `0x0`, nil }).
+		Handle(`eth_chainId`, func(...interface{}) (interface{}, error) { return `0x1`, nil }).
+		Handle(`eth_sendRawTransaction`, func(...interface{}) (interface{}, error) { return nil, nil })
+	defer useEthClient(mock)()
+	for _, typed := range []TypedTransactionFields{{}, {AccessList: []AccessTuple{}}} {
+		req := DispatchFunctionCallRequest{Target: `0x01`, GasPrice: `1`, Auth: RequestAuth{Provider: `test-mismatched`}, TypedTransactionFields: typed}
+		req.File, req.Contract, req.Signature, req.Arguments = `Store.sol`, `Store`, `set(uint256)`, json.RawMessage(`[1]`)
+		if e := testHandler(store).DispatchFunctionCall(req, &DispatchFunctionCallResponse{}); e == nil || e.Error() != `internal error` {
+			t.Fatalf(`expected internal error, have %v`, e)
+		}
+	}
+	if n := mock.Called(`eth_sendRawTransaction`); n != 0 {
+		t.Fatalf(`expected no broadcast, have %d`, n)
+	}
+}
+
+func TestBuildUnsignedTransaction(t *testing.T) {
+	store := abiContract(t, `Store.sol`, `Store`, `[
+		{"type": "function", "name": "set", "stateMutability": "nonpayable", "inputs": [{"name": "v", "type": "uint256"}], "outputs": []}
+	]`)
+	mock := newMockEthClient().Handle(`eth_getTransactionCount`, func(args ...interface{}) (interface{}, error) {
+		if args[0] != common.HexToAddress(`0xaa`) {
+			return nil, fmt.Errorf(`unexpected address %v`, args[0])
+		}
+		return `0x2a`, nil
+	})
+	defer useEthClient(mock)()
+
+	req := BuildUnsignedTransactionRequest{From: `0xaa`, Target: `0xcc`, Value: `3`, GasPrice: `5`, GasLimit: `21000`, SignatureType: SignatureTypeLegacy}
+	req.File, req.Contract, req.Signature, req.Arguments = `Store.sol`, `Store`, `set(uint256)`, json.RawMessage(`[9]`)
+	res := UnsignedTransaction{}
+	if e := testHandler(store).BuildUnsignedTransaction(req, &res); e != nil {
+		t.Fatal(e)
+	}
+
+	data := append(keccak([]byte(`set(uint256)`))[:4], mustDecodeHex(word(9))...)
+	if res.From != ensure0xPrefix(common.HexToAddress(`0xaa`).String()) || res.To != ensure0xPrefix(common.HexToAddress(`0xcc`).String()) ||
+		res.Nonce != 42 || res.Value != `3` || res.GasPrice != `5` || res.GasLimit != 21000 || !bytes.Equal(res.Data, data) {
+		t.Fatalf(`unexpected fields: %+v`, res)
+	}
+	tx := new(ethtypes.Transaction)
+	if e := rlp.DecodeBytes(res.Transaction, tx); e != nil {
+		t.Fatal(e)
+	}
+	if tx.Nonce() != 42 || *tx.To() != common.HexToAddress(`0xcc`) || tx.Value().Int64() != 3 || tx.GasPrice().Int64() != 5 ||
+		tx.Gas() != 21000 || !bytes.Equal(tx.Data(), data) {
+		t.Fatalf(`encoded transaction doesn't match fields: %+v`, tx)
+	}
+	if !bytes.Equal(res.Hash, legacySigner.Hash(tx).Bytes()) {
+		t.Fatalf(`unexpected hash %x`, []byte(res.Hash))
+	}
+}
+
+func TestSubmitSignedTransaction(t *testing.T) {
+	counter := abiContract(t, `Counter.sol`, `Counter`, `[
+		{"type": "function", "name": "increment", "stateMutability": "nonpayable", "inputs": [], "outputs": [{"name": "", "type": "uint256"}]}
+	]`)
+	signed, e := ethtypes.SignTx(ethtypes.NewTransaction(1, common.HexToAddress(`0xcc`), big.NewInt(0), 50000, big.NewInt(1), keccak([]byte(`increment()`))[:4]),
+		ethtypes.NewEIP155Signer(big.NewInt(5)), testKey().PrivateKey)
+	if e != nil {
+		t.Fatal(e)
+	}
+	raw, e := rlp.EncodeToBytes(signed)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	broadcast := ""
+	mock := newMockEthClient().
+		Handle(`eth_sendRawTransaction`, func(args ...interface{}) (interface{}, error) {
+			broadcast = args[0].(string)
+			return signed.Hash(), nil
+		}).
+		Handle(`eth_getTransactionReceipt`, func(...interface{}) (interface{}, error) {
+			return TransactionReceipt{Status: `0x1`, GasUsed: `0x5208`, BlockNumber: `0x10`, TransactionHash: signed.Hash().Hex()}, nil
+		}).
+		Handle(`eth_call`, func(args ...interface{}) (interface{}, error) {
+			if call := args[0].(callArguments); call.From != ensure0xPrefix(testAddress.String()) || args[1] != `0xf` {
+				return nil, fmt.Errorf(`unexpected replay %+v at %v`, call, args[1])
+			}
+			return `0x` + word(4), nil
+		})
+	defer useEthClient(mock)()
+
+	req := SubmitSignedTransactionRequest{Transaction: hex.EncodeToString(raw)}
+	req.File, req.Contract, req.Signature = `Counter.sol`, `Counter`, `increment()`
+	res := DispatchFunctionCallResponse{}
+	if e := testHandler(counter).SubmitSignedTransaction(req, &res); e != nil {
+		t.Fatal(e)
+	}
+	if broadcast != ensure0xPrefix(hex.EncodeToString(raw)) {
+		t.Fatalf(`expected raw transaction to be forwarded as is, have %s`, broadcast)
+	}
+	if res.Receipt == nil || res.Receipt.TransactionHash != signed.Hash().Hex() || string(res.Result) != `[4]` {
+		t.Fatalf(`unexpected response: %+v`, res)
+	}
+	if res.Cost == nil || res.Cost.Total != `21000` {
+		t.Fatalf(`unexpected cost: %+v`, res.Cost)
+	}
+}
+
+func TestInterfaceID(t *testing.T) {
+	erc721 := abiContract(t, `ERC721.sol`, `ERC721`, `[
+		{"type": "function", "name": "balanceOf", "inputs": [{"name": "owner", "type": "address"}], "outputs": [{"name": "", "type": "uint256"}]},
+		{"type": "function", "name": "ownerOf", "inputs": [{"name": "tokenId", "type": "uint256"}], "outputs": [{"name": "", "type": "address"}]},
+		{"type": "function", "name": "safeTransferFrom", "inputs": [{"name": "from", "type": "address"}, {"name": "to", "type": "address"}, {"name": "tokenId", "type": "uint256"}, {"name": "data", "type": "bytes"}], "outputs": []},
+		{"type": "function", "name": "safeTransferFrom", "inputs": [{"name": "from", "type": "address"}, {"name": "to", "type": "address"}, {"name": "tokenId", "type": "uint256"}], "outputs": []},
+		{"type": "function", "name": "transferFrom", "inputs": [{"name": "from", "type": "address"}, {"name": "to", "type": "address"}, {"name": "tokenId", "type": "uint256"}], "outputs": []},
+		{"type": "function", "name": "approve", "inputs": [{"name": "approved", "type": "address"}, {"name": "tokenId", "type": "uint256"}], "outputs": []},
+		{"type": "function", "name": "setApprovalForAll", "inputs": [{"name": "operator", "type": "address"}, {"name": "approved", "type": "bool"}], "outputs": []},
+		{"type": "function", "name": "getApproved", "inputs": [{"name": "tokenId", "type": "uint256"}], "outputs": [{"name": "", "type": "address"}]},
+		{"type": "function", "name": "isApprovedForAll", "inputs": [{"name": "owner", "type": "address"}, {"name": "operator", "type": "address"}], "outputs": [{"name": "", "type": "bool"}]},
+		{"type": "fallback", "stateMutability": "nonpayable"}
+	]`)
+	res := BinaryJSON(nil)
+	if e := testHandler(erc721).GetInterfaceID(GetContractRequest{File: `ERC721.sol`, Contract: `ERC721`}, &res); e != nil {
+		t.Fatal(e)
+	}
+	if id := hex.EncodeToString(res); id != `80ac58cd` {
+		t.Fatalf(`expected interface id 80ac58cd, have %s`, id)
+	}
+}
+
+func TestDispatchRawCalldata(t *testing.T) {
+	proxy := abiContract(t, `Proxy.sol`, `Proxy`, `[{"type": "fallback", "stateMutability": "nonpayable"}]`)
+	calldata := `0xa9059cbb` + word(0xaa, 5)
+	sent := new(ethtypes.Transaction)
+	mock := newMockEthClient().
+		Handle(`eth_getTransactionCount`, func(...interface{}) (interface{}, error) { return `0x0`, nil }).
+		Handle(`eth_getTransactionReceipt`, minedReceipt).
+		Handle(`eth_sendRawTransaction`, func(args ...interface{}) (interface{}, error) {
+			return nil, rlp.DecodeBytes(mustDecodeHex(args[0].(string)), sent)
+		})
+	defer useEthClient(mock)()
+
+	req := DispatchFunctionCallRequest{Target: `0xcc`, GasPrice: `1`, GasLimit: `50000`, Mode: FunctionDispatchModeTransactionOnly, Auth: testAuth}
+	req.File, req.Contract, req.Data = `Proxy.sol`, `Proxy`, calldata
+	if e := testHandler(proxy).DispatchFunctionCall(req, &DispatchFunctionCallResponse{}); e != nil {
+		t.Fatal(e)
+	}
+	if !bytes.Equal(sent.Data(), mustDecodeHex(calldata)) {
+		t.Fatalf(`expected calldata to be sent as is, have %x`, sent.Data())
+	}
+
+	for _, invalid := range []struct{ data, signature, message string }{
+		{calldata, `transfer(address,uint256)`, `mutually exclusive`},
+		{`a9059cbb`, ``, `prefixed with 0x`},
+		{`0xa9059cbz`, ``, `invalid hex`},
+	} {
+		req.Data, req.Signature = invalid.data, invalid.signature
+		if e := testHandler(proxy).DispatchFunctionCall(req, &DispatchFunctionCallResponse{}); e == nil || !strings.Contains(e.Error(), invalid.message) {
+			t.Errorf(`data %s: expected error containing %q, have %v`, invalid.data, invalid.message, e)
+		}
+	}
+	if n := mock.Called(`eth_sendRawTransaction`); n != 1 {
+		t.Fatalf(`expected one broadcast, have %d`, n)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	store := abiContract(t, `Store.sol`, `Store`, `[
+		{"type": "function", "name": "set", "stateMutability": "nonpayable", "inputs": [{"name": "v", "type": "uint256"}], "outputs": []},
+		{"type": "function", "name": "get", "stateMutability": "view", "inputs": [], "outputs": [{"name": "", "type": "uint256"}]}
+	]`)
+	mock := newMockEthClient().
+		Handle(`eth_call`, func(...interface{}) (interface{}, error) { return `0x` + word(7), nil }).
+		Handle(`eth_getTransactionCount`, func(...interface{}) (interface{}, error) { return `0x0`, nil }).
+		Handle(`eth_getTransactionReceipt`, minedReceipt).
+		Handle(`eth_sendRawTransaction`, func(...interface{}) (interface{}, error) { return nil, nil })
+	defer useEthClient(mock)()
+	defer func(readOnly bool) { config.ReadOnly = readOnly }(config.ReadOnly)
+
+	dispatch := func(signature, arguments string, mode FunctionDispatchMode) (DispatchFunctionCallResponse, error) {
+		req := DispatchFunctionCallRequest{Target: `0xcc`, GasPrice: `1`, GasLimit: `50000`, Mode: mode, Auth: testAuth}
+		req.File, req.Contract, req.Signature, req.Arguments = `Store.sol`, `Store`, signature, json.RawMessage(arguments)
+		res := DispatchFunctionCallResponse{}
+		e := testHandler(store).DispatchFunctionCall(req, &res)
+		return res, e
+	}
+
+	config.ReadOnly = true
+	for _, mode := range []FunctionDispatchMode{FunctionDispatchModeDefault, FunctionDispatchModeTransactionOnly} {
+		if _, e := dispatch(`set(uint256)`, `[1]`, mode); e != errReadOnly {
+			t.Fatalf(`mode %s: expected %v, have %v`, mode, errReadOnly, e)
+		}
+	}
+	create := CreateContractRequest{GetContractRequest: GetContractRequest{File: `Store.sol`, Contract: `Store`}, GasPrice: `1`, Auth: testAuth}
+	if e := testHandler(store).CreateContract(create, &TransactionReceipt{}); e != errReadOnly {
+		t.Fatalf(`CreateContract: expected %v, have %v`, errReadOnly, e)
+	}
+	if e := testHandler(store).SubmitSignedTransaction(SubmitSignedTransactionRequest{Transaction: `0x00`}, &DispatchFunctionCallResponse{}); e != errReadOnly {
+		t.Fatalf(`SubmitSignedTransaction: expected %v, have %v`, errReadOnly, e)
+	}
+	for _, mode := range []FunctionDispatchMode{FunctionDispatchModeDefault, FunctionDispatchModeCallOnly} {
+		if res, e := dispatch(`get()`, `[]`, mode); e != nil || string(res.Result) != `[7]` {
+			t.Fatalf(`mode %s: expected call to work, have %s, %v`, mode, res.Result, e)
+		}
+	}
+	if n := mock.Called(`eth_sendRawTransaction`); n != 0 {
+		t.Fatalf(`expected no broadcast, have %d`, n)
+	}
+
+	config.ReadOnly = false
+	if _, e := dispatch(`set(uint256)`, `[1]`, FunctionDispatchModeTransactionOnly); e != nil {
+		t.Fatal(e)
+	}
+	if n := mock.Called(`eth_sendRawTransaction`); n != 1 {
+		t.Fatalf(`expected one broadcast, have %d`, n)
+	}
+}
+
+func TestExplicitNonce(t *testing.T) {
+	store := abiContract(t, `Store.sol`, `Store`, `[
+		{"type": "function", "name": "set", "stateMutability": "nonpayable", "inputs": [{"name": "v", "type": "uint256"}], "outputs": []}
+	]`)
+	sent := new(ethtypes.Transaction)
+	mock := newMockEthClient().
+		Handle(`eth_getTransactionCount`, func(...interface{}) (interface{}, error) { return `0x7`, nil }).
+		Handle(`eth_getTransactionReceipt`, minedReceipt).
+		Handle(`eth_sendRawTransaction`, func(args ...interface{}) (interface{}, error) {
+			return nil, rlp.DecodeBytes(mustDecodeHex(args[0].(string)), sent)
+		})
+	defer useEthClient(mock)()
+
+	for _, nonce := range []json.Number{``, `42`, `3`} {
+		req := DispatchFunctionCallRequest{Target: `0xcc`, GasPrice: `1`, GasLimit: `50000`, Nonce: nonce, Mode: FunctionDispatchModeTransactionOnly, Auth: testAuth}
+		req.File, req.Contract, req.Signature, req.Arguments = `Store.sol`, `Store`, `set(uint256)`, json.RawMessage(`[1]`)
+		if e := testHandler(store).DispatchFunctionCall(req, &DispatchFunctionCallResponse{}); e != nil {
+			t.Fatal(e)
+		}
+		expected := uint64(7) // pending nonce
+		if nonce != `` {
+			n, _ := nonce.Int64()
+			expected = uint64(n) // also if behind the pending nonce
+		}
+		if sent.Nonce() != expected {
+			t.Fatalf(`nonce %q: expected transaction with nonce %d, have %d`, nonce, expected, sent.Nonce())
+		}
+	}
+
+	req := DispatchFunctionCallRequest{Target: `0xcc`, GasPrice: `1`, Nonce: `-1`, Mode: FunctionDispatchModeTransactionOnly, Auth: testAuth}
+	req.File, req.Contract, req.Signature, req.Arguments = `Store.sol`, `Store`, `set(uint256)`, json.RawMessage(`[1]`)
+	if e := testHandler(store).DispatchFunctionCall(req, &DispatchFunctionCallResponse{}); e == nil || e.Error() != `invalid nonce` {
+		t.Fatalf(`expected invalid nonce, have %v`, e)
+	}
+}
+
+func TestDeterministicContractEncoding(t *testing.T) {
+	functions := make([]string, 0, 16)
+	for i := 0; i < 16; i++ {
+		functions = append(functions, fmt.Sprintf(`{"type": "function", "name": "f%d", "inputs": [{"name": "x", "type": "uint%d"}], "outputs": []}`, i, 8*(i+1)))
+		functions = append(functions, fmt.Sprintf(`{"type": "event", "name": "E%d", "inputs": [{"name": "x", "type": "uint256", "indexed": false}]}`, i))
+	}
+	contract := abiContract(t, `Many.sol`, `Many`, `[`+strings.Join(functions, `,`)+`]`)
+
+	first, e := (jsonEncoder{}).EncodeContract(contract)
+	if e != nil {
+		t.Fatal(e)
+	}
+	for i := 0; i < 10; i++ {
+		encoded, e := (jsonEncoder{}).EncodeContract(contract)
+		if e != nil {
+			t.Fatal(e)
+		}
+		if !bytes.Equal(encoded, first) {
+			t.Fatalf("encodings differ:\n%s\n%s", first, encoded)
+		}
+	}
+}
+
+func TestDecodeConstructorArgs(t *testing.T) {
+	contract := abiContract(t, `Token.sol`, `Token`, `[{"type": "constructor", "inputs": [
+		{"name": "supply", "type": "uint256"}, {"name": "name", "type": "bytes"}]}]`)
+	contract.Binary = mustDecodeHex(`0x6080604052`)
+	handler := testHandler(contract)
+
+	args := word(7, 0x40, 2) + `6869` + strings.Repeat(`00`, 30) // 7, "hi"
+	req := DecodeConstructorArgsRequest{GetContractRequest{File: `Token.sol`, Contract: `Token`}, `0x6080604052` + args}
+	res := json.RawMessage{}
+	if e := handler.DecodeConstructorArgs(req, &res); e != nil {
+		t.Fatal(e)
+	}
+	if !jsonEqualString(res, `[7, "hi"]`) {
+		t.Fatalf(`unexpected arguments %s`, res)
+	}
+
+	for data, expected := range map[string]string{
+		`0x6080604052`:                        `missing constructor arguments, expected 2`,
+		`0x6080604052` + args[:64+62]:         `failed decoding constructor arguments`, // half of the offset word
+		`0x6080604052` + word(7, 0x1000):      `failed decoding constructor arguments`, // offset outside of the data
+		`0x6080604052` + word(7, 0x40, 0x100): `failed decoding constructor arguments`, // length exceeding the data
+	} {
+		req.Data = data
+		if e := handler.DecodeConstructorArgs(req, &res); e == nil || !strings.HasPrefix(e.Error(), expected) {
+			t.Errorf(`%s: expected %q, have %v`, data, expected, e)
+		}
+	}
+}
+
+func TestStateOverrides(t *testing.T) {
+	store := abiContract(t, `Store.sol`, `Store`, `[{"type": "function", "name": "get", "stateMutability": "view", "inputs": [],
+		"outputs": [{"name": "", "type": "uint256"}]}]`)
+	overrides := `{"0x00000000000000000000000000000000000000aa": {"balance": "0xde0b6b3a7640000"}}`
+
+	forwarded := []interface{}(nil)
+	mock := newMockEthClient().Handle(`eth_call`, func(args ...interface{}) (interface{}, error) {
+		forwarded = args
+		return `0x` + word(1), nil
+	})
+	defer useEthClient(mock)()
+
+	req := DispatchFunctionCallRequest{Target: `0x01`, GasPrice: `1`, Auth: testAuth, StateOverrides: json.RawMessage(overrides)}
+	req.File, req.Contract, req.Signature, req.Arguments = `Store.sol`, `Store`, `get()`, json.RawMessage(`[]`)
+	if e := testHandler(store).DispatchFunctionCall(req, &DispatchFunctionCallResponse{}); e != nil {
+		t.Fatal(e)
+	}
+	if len(forwarded) != 3 || forwarded[1] != `latest` {
+		t.Fatalf(`expected call arguments, block and overrides, have %v`, forwarded)
+	}
+	if bs, _ := json.Marshal(forwarded[2]); !jsonEqualString(bs, overrides) {
+		t.Fatalf(`unexpected overrides forwarded: %s`, bs)
+	}
+
+	req.StateOverrides = json.RawMessage(`{"0x00000000000000000000000000000000000000aa": {"owner": "0x01"}}`)
+	if e := testHandler(store).DispatchFunctionCall(req, &DispatchFunctionCallResponse{}); e == nil || !strings.Contains(e.Error(), `unexpected field`) {
+		t.Fatalf(`expected invalid overrides to be rejected, have %v`, e)
+	}
+}
+
+func TestTransactionCost(t *testing.T) {
+	store := abiContract(t, `Store.sol`, `Store`, `[
+		{"type": "function", "name": "set", "stateMutability": "nonpayable", "inputs": [{"name": "v", "type": "uint256"}], "outputs": []}
+	]`)
+	effectiveGasPrice := ``
+	mock := newMockEthClient().
+		Handle(`eth_getTransactionCount`, func(...interface{}) (interface{}, error) { return `0x0`, nil }).
+		Handle(`eth_sendRawTransaction`, func(...interface{}) (interface{}, error) { return nil, nil }).
+		Handle(`eth_getTransactionReceipt`, func(...interface{}) (interface{}, error) {
+			return TransactionReceipt{Status: `0x1`, GasUsed: `0xb1a9`, EffectiveGasPrice: effectiveGasPrice, Logs: []TransactionReceiptLog{}}, nil
+		})
+	defer useEthClient(mock)()
+
+	for price, expected := range map[string]TransactionCost{
+		``:           {GasUsed: `45481`, EffectiveGasPrice: `20000000000`, Total: `909620000000000`}, // signed gas price
+		`0x3b9aca00`: {GasUsed: `45481`, EffectiveGasPrice: `1000000000`, Total: `45481000000000`},
+	} {
+		effectiveGasPrice = price
+		req := DispatchFunctionCallRequest{Target: `0xcc`, GasPrice: `20000000000`, GasLimit: `50000`, Mode: FunctionDispatchModeTransactionOnly, Auth: testAuth}
+		req.File, req.Contract, req.Signature, req.Arguments = `Store.sol`, `Store`, `set(uint256)`, json.RawMessage(`[1]`)
+		res := DispatchFunctionCallResponse{}
+		if e := testHandler(store).DispatchFunctionCall(req, &res); e != nil {
+			t.Fatal(e)
+		}
+		if res.Cost == nil || *res.Cost != expected {
+			t.Fatalf(`effective gas price %q: expected cost %+v, have %+v`, price, expected, res.Cost)
+		}
+	}
+}
+
+func TestNoArgumentCall(t *testing.T) {
+	counter := abiContract(t, `Counter.sol`, `Counter`, `[
+		{"type": "function", "name": "increment", "stateMutability": "nonpayable", "inputs": [], "outputs": []},
+		{"type": "function", "name": "add", "stateMutability": "nonpayable", "inputs": [{"name": "n", "type": "uint256"}], "outputs": []}
+	]`)
+	handler := testHandler(counter)
+	req := EncodeFunctionCallRequest{File: `Counter.sol`, Contract: `Counter`}
+
+	req.Signature = `increment()`
+	for _, arguments := range []string{``, `null`, `[]`, `{}`} {
+		req.Arguments = json.RawMessage(arguments)
+		res := BinaryJSON{}
+		if e := handler.EncodeFunctionCall(req, &res); e != nil {
+			t.Fatalf(`arguments %q: %s`, arguments, e)
+		}
+		if hex.EncodeToString(res) != `d09de08a` { // increment()
+			t.Fatalf(`arguments %q: unexpected calldata %x`, arguments, []byte(res))
+		}
+	}
+
+	req.Signature = `add(uint256)`
+	for _, arguments := range []string{`null`, `{"n": 1}`, `1`} {
+		req.Arguments = json.RawMessage(arguments)
+		expected := `arguments must be a JSON array of 1 values for add(uint256)`
+		if e := handler.EncodeFunctionCall(req, &BinaryJSON{}); e == nil || e.Error() != expected {
+			t.Fatalf(`arguments %q: expected %q, have %v`, arguments, expected, e)
+		}
+	}
+}
+
+func TestCreateAddress(t *testing.T) {
+	sender := common.HexToAddress(`0x6ac7ea33f8831ea9dcc53393aaa88b25a785dbf0`)
+	// verified against go-ethereum's crypto.CreateAddress, nonces 127, 128 and 256 change the RLP encoding of the nonce
+	for nonce, expected := range map[uint64]string{
+		0:       `0xcd234A471b72ba2F1Ccf0A70FCABA648a5eeCD8d`,
+		1:       `0x343c43A37D37dfF08AE8C4A11544c718AbB4fCF8`,
+		2:       `0xf778B86FA74E846c4f0a1fBd1335FE81c00a0C91`,
+		3:       `0xffFd933A0bC612844eaF0C6Fe3E5b8E9B6C1d19c`,
+		127:     `0x06d9a77f5E4b311Bae8D559DB9CDB4dF94104aA0`,
+		128:     `0x08e190dcB7b73F5fcDAbb43e102215c83659A76D`,
+		256:     `0x3837C1Ae70354f670550C746580199Ac6a73Cb0a`,
+		1 << 32: `0xf4bf328880432064068338F915C49f817dC4Ce18`,
+	} {
+		if address := CreateAddress(sender, nonce).Hex(); address != expected {
+			t.Fatalf(`nonce %d: expected %s, have %s`, nonce, expected, address)
+		}
+	}
+
+	// the pending nonce unless given
+	defer useEthClient(newMockEthClient().
+		Handle(`eth_getTransactionCount`, func(...interface{}) (interface{}, error) { return `0x2`, nil }))()
+	for nonce, expected := range map[json.Number]string{``: `0xf778B86FA74E846c4f0a1fBd1335FE81c00a0C91`, `3`: `0xffFd933A0bC612844eaF0C6Fe3E5b8E9B6C1d19c`} {
+		res := ""
+		if e := testHandler().PredictCreateAddress(PredictCreateAddressRequest{Sender: sender.Hex(), Nonce: nonce}, &res); e != nil {
+			t.Fatal(e)
+		}
+		if res != expected {
+			t.Fatalf(`nonce %q: expected %s, have %s`, nonce, expected, res)
+		}
+	}
+}
+
+func TestCreate2Address(t *testing.T) {
+	// from EIP-1014
+	for _, vector := range []struct{ deployer, salt, initCode, address string }{
+		{`0x0000000000000000000000000000000000000000`, word(0), `0x00`, `0x4D1A2e2bB4F88F0250f26Ffff098B0b30B26BF38`},
+		{`0xdeadbeef00000000000000000000000000000000`, word(0), `0x00`, `0xB928f69Bb1D91Cd65274e3c79d8986362984fDA3`},
+		{`0x00000000000000000000000000000000deadbeef`, word(0xcafebabe), `0xdeadbeef`, `0x60f3f640a8508fC6a86d45DF051962668E1e8AC7`},
+		{`0x0000000000000000000000000000000000000000`, word(0), `0x`, `0xE33C0C7F7df4809055C3ebA6c09CFe4BaF1BD9e0`},
+	} {
+		salt := [32]byte{}
+		copy(salt[:], mustDecodeHex(vector.salt))
+		if address := Create2Address(common.HexToAddress(vector.deployer), salt, mustDecodeHex(vector.initCode)); address.Hex() != vector.address {
+			t.Fatalf(`expected %s, have %s`, vector.address, address.Hex())
+		}
+	}
+
+	contract := abiContract(t, `Beef.sol`, `Beef`, `[]`)
+	contract.Binary = mustDecodeHex(`0xdeadbeef`)
+	req := PredictCreate2AddressRequest{GetContractRequest{File: `Beef.sol`, Contract: `Beef`}, `0x00000000000000000000000000000000deadbeef`, `0x` + word(0xcafebabe), nil}
+	res := ""
+	if e := testHandler(contract).PredictCreate2Address(req, &res); e != nil {
+		t.Fatal(e)
+	}
+	if res != `0x60f3f640a8508fC6a86d45DF051962668E1e8AC7` {
+		t.Fatalf(`unexpected address %s`, res)
+	}
+}
+
+// erc20ABI is the ABI of an ERC-20 token as served by block explorers.
+const erc20ABI = `[{"constant":true,"inputs":[],"name":"name","outputs":[{"name":"","type":"string"}],"payable":false,"stateMutability":"view","type":"function"},{"constant":false,"inputs":[{"name":"_spender","type":"address"},{"name":"_value","type":"uint256"}],"name":"approve","outputs":[{"name":"","type":"bool"}],"payable":false,"stateMutability":"nonpayable","type":"function"},{"constant":true,"inputs":[],"name":"totalSupply","outputs":[{"name":"","type":"uint256"}],"payable":false,"stateMutability":"view","type":"function"},{"constant":false,"inputs":[{"name":"_from","type":"address"},{"name":"_to","type":"address"},{"name":"_value","type":"uint256"}],"name":"transferFrom","outputs":[{"name":"","type":"bool"}],"payable":false,"stateMutability":"nonpayable","type":"function"},{"constant":true,"inputs":[],"name":"decimals","outputs":[{"name":"","type":"uint8"}],"payable":false,"stateMutability":"view","type":"function"},{"constant":true,"inputs":[{"name":"_owner","type":"address"}],"name":"balanceOf","outputs":[{"name":"balance","type":"uint256"}],"payable":false,"stateMutability":"view","type":"function"},{"constant":true,"inputs":[],"name":"symbol","outputs":[{"name":"","type":"string"}],"payable":false,"stateMutability":"view","type":"function"},{"constant":false,"inputs":[{"name":"_to","type":"address"},{"name":"_value","type":"uint256"}],"name":"transfer","outputs":[{"name":"","type":"bool"}],"payable":false,"stateMutability":"nonpayable","type":"function"},{"constant":true,"inputs":[{"name":"_owner","type":"address"},{"name":"_spender","type":"address"}],"name":"allowance","outputs":[{"name":"","type":"uint256"}],"payable":false,"stateMutability":"view","type":"function"},{"payable":true,"stateMutability":"payable","type":"fallback"},{"anonymous":false,"inputs":[{"indexed":true,"name":"owner","type":"address"},{"indexed":true,"name":"spender","type":"address"},{"indexed":false,"name":"value","type":"uint256"}],"name":"Approval","type":"event"},{"anonymous":false,"inputs":[{"indexed":true,"name":"from","type":"address"},{"indexed":true,"name":"to","type":"address"},{"indexed":false,"name":"value","type":"uint256"}],"name":"Transfer","type":"event"}]`
+
+func TestLoadABIs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), `erc20.json`)
+	if e := ioutil.WriteFile(path, []byte(erc20ABI), 0644); e != nil {
+		t.Fatal(e)
+	}
+	project := types.Project{Files: map[string]map[string]*types.Contract{}}
+	if e := loadABIs(project, `ERC20=`+path); e != nil {
+		t.Fatal(e)
+	}
+	token := project.Files[path][`ERC20`]
+	if token == nil || len(token.API) != 10 { // 9 functions and the fallback
+		t.Fatalf(`expected ERC20 with 10 functions, have %+v`, token)
+	}
+
+	res, e := callContract(t, token, `balanceOf(address)`, `["0x52908400098527886e0f7030069857d2e4169ee7"]`, word(1000))
+	if e != nil {
+		t.Fatal(e)
+	}
+	if !jsonEqualString(res.Result, `[1000]`) {
+		t.Fatalf(`unexpected balance %s`, res.Result)
+	}
+
+	req := EncodeFunctionCallRequest{File: path, Contract: `ERC20`, Signature: `transfer(address,uint256)`, Arguments: json.RawMessage(`["0x52908400098527886e0f7030069857d2e4169ee7", 1]`)}
+	calldata := BinaryJSON{}
+	if e := testHandler(token).EncodeFunctionCall(req, &calldata); e != nil {
+		t.Fatal(e)
+	}
+	if expected := `a9059cbb` + `00000000000000000000000052908400098527886e0f7030069857d2e4169ee7` + word(1); hex.EncodeToString(calldata) != expected {
+		t.Fatalf(`unexpected calldata %x`, []byte(calldata))
+	}
+
+	if e := loadABIs(project, `ERC20=`+path); e == nil {
+		t.Fatal(`expected error loading a duplicate contract`)
+	}
+}
+
+func TestGetProxyImplementation(t *testing.T) {
+	slots := map[string]string{
+		`0x00000000000000000000000000000000000000aa`: `0x` + strings.Repeat(`00`, 12) + `52908400098527886e0f7030069857d2e4169ee7`,
+		`0x00000000000000000000000000000000000000bb`: `0x` + word(0),
+	}
+	mock := newMockEthClient().Handle(`eth_getStorageAt`, func(args ...interface{}) (interface{}, error) {
+		if args[1] != eip1967ImplementationSlot || args[2] != `latest` {
+			t.Fatalf(`unexpected eth_getStorageAt arguments %v`, args)
+		}
+		return slots[strings.ToLower(args[0].(string))], nil
+	})
+	defer useEthClient(mock)()
+
+	res := ProxyImplementation{}
+	if e := testHandler().GetProxyImplementation(GetProxyImplementationRequest{Address: `0x00000000000000000000000000000000000000aa`}, &res); e != nil {
+		t.Fatal(e)
+	}
+	if !res.IsProxy || res.Implementation != `0x52908400098527886E0F7030069857D2E4169EE7` {
+		t.Fatalf(`unexpected implementation %+v`, res)
+	}
+
+	res = ProxyImplementation{}
+	if e := testHandler().GetProxyImplementation(GetProxyImplementationRequest{Address: `0x00000000000000000000000000000000000000bb`}, &res); e != nil {
+		t.Fatal(e)
+	}
+	if res.IsProxy || res.Implementation != `` {
+		t.Fatalf(`expected no proxy, have %+v`, res)
+	}
+
+	if e := testHandler().GetProxyImplementation(GetProxyImplementationRequest{Address: `0xzz`}, &res); e == nil {
+		t.Fatal(`expected invalid address error`)
+	}
+}
+
+func TestDeterministicTypeEncoding(t *testing.T) {
+	status := types.Named{Name: `Shop.sol:Shop.Status`, Type: types.Enum{`Open`, `Paid`, `Shipped`, `Closed`}}
+	keys, members := []string{}, []types.Type{}
+	for i := 0; i < 16; i++ {
+		keys = append(keys, fmt.Sprintf(`m%02d`, 15-i))
+		members = append(members, types.Mapping{Key: types.Elementary(`address`), Value: types.Array{Length: i + 1, Type: status}})
+	}
+	order := types.Named{Name: `Shop.sol:Shop.Order`, Type: types.Struct{Keys: keys, Types: members}}
+	nested := types.Tuple{order, types.Array{Length: -1, Type: order}, types.Event{
+		Name: `Ordered`, Args: []types.Type{order, status}, Indexed: []bool{false, true}, ArgNames: []string{`order`, `status`},
+	}}
+
+	first, e := (jsonEncoder{}).EncodeType(nested)
+	if e != nil {
+		t.Fatal(e)
+	}
+	for i := 0; i < 10; i++ {
+		encoded, e := (jsonEncoder{}).EncodeType(nested)
+		if e != nil {
+			t.Fatal(e)
+		}
+		if !bytes.Equal(encoded, first) {
+			t.Fatalf("encodings differ:\n%s\n%s", first, encoded)
+		}
+	}
+	// struct members keep their declaration order rather than being sorted
+	if i, j := bytes.Index(first, []byte(`"m15"`)), bytes.Index(first, []byte(`"m00"`)); i == -1 || j == -1 || i > j {
+		t.Fatalf(`expected members in declaration order: %s`, first)
+	}
+}
+
+func TestRecoverTransactionSender(t *testing.T) {
+	transactions := map[string]json.RawMessage{}
+	serve := func(hash string, signer ethtypes.Signer, from common.Address) {
+		tx := ethtypes.NewTransaction(3, common.HexToAddress(`0xcc`), big.NewInt(1), 21000, big.NewInt(1), nil)
+		signed, e := ethtypes.SignTx(tx, signer, testKey().PrivateKey)
+		if e != nil {
+			t.Fatal(e)
+		}
+		bs, e := json.Marshal(signed)
+		if e != nil {
+			t.Fatal(e)
+		}
+		fields := map[string]json.RawMessage{}
+		json.Unmarshal(bs, &fields)
+		fields[`from`], _ = json.Marshal(from.Hex())
+		transactions[hash], _ = json.Marshal(fields)
+	}
+	serve(`0x01`, legacySigner, testAddress)
+	serve(`0x02`, ethtypes.NewEIP155Signer(big.NewInt(5)), testAddress)
+	serve(`0x03`, legacySigner, common.HexToAddress(`0xaa`))
+	serveTyped := func(hash string, tx *typedTransaction) {
+		if e := signTypedTransaction(tx, testKey()); e != nil {
+			t.Fatal(e)
+		}
+		fields := map[string]interface{}{
+			`hash`:    tx.Hash().Hex(),
+			`from`:    testAddress.Hex(),
+			`type`:    fmt.Sprintf(`0x%x`, tx.Type),
+			`chainId`: `0x` + tx.ChainID.Text(16),
+			`nonce`:   fmt.Sprintf(`0x%x`, tx.AccountNonce),
+			`gas`:     fmt.Sprintf(`0x%x`, tx.GasLimit),
+			`to`:      tx.Recipient.Hex(),
+			`value`:   `0x` + tx.Amount.Text(16),
+			`input`:   `0x` + hex.EncodeToString(tx.Payload),
+			`v`:       `0x` + tx.V.Text(16),
+			`r`:       `0x` + tx.R.Text(16),
+			`s`:       `0x` + tx.S.Text(16),
+			`accessList`: []AccessTuple{{
+				Address:     tx.AccessList[0].Address.Hex(),
+				StorageKeys: []string{tx.AccessList[0].StorageKeys[0].Hex()},
+			}},
+		}
+		if tx.Type == dynamicFeeTxType {
+			fields[`maxFeePerGas`], fields[`maxPriorityFeePerGas`] = `0x`+tx.Price.Text(16), `0x`+tx.GasTipCap.Text(16)
+			fields[`gasPrice`] = `0x1` // the effective gas price, once mined
+		} else {
+			fields[`gasPrice`] = `0x` + tx.Price.Text(16)
+		}
+		transactions[hash], _ = json.Marshal(fields)
+	}
+	to := common.HexToAddress(`0xcc`)
+	accessList := []accessTuple{{Address: to, StorageKeys: []common.Hash{common.HexToHash(`0x03`)}}}
+	serveTyped(`0x05`, &typedTransaction{Type: accessListTxType, ChainID: big.NewInt(5), AccountNonce: 4, Price: big.NewInt(2),
+		GasLimit: 30000, Recipient: &to, Amount: big.NewInt(1), Payload: []byte{1, 2}, AccessList: accessList})
+	serveTyped(`0x06`, &typedTransaction{Type: dynamicFeeTxType, ChainID: big.NewInt(5), AccountNonce: 5, GasTipCap: big.NewInt(1), Price: big.NewInt(3),
+		GasLimit: 30000, Recipient: &to, Amount: big.NewInt(0), AccessList: accessList})
+	mock := newMockEthClient().Handle(`eth_getTransactionByHash`, func(args ...interface{}) (interface{}, error) {
+		if tx, ok := transactions[args[0].(string)]; ok {
+			return tx, nil
+		}
+		return nil, nil
+	})
+	defer useEthClient(mock)()
+
+	for hash, match := range map[string]bool{`0x01`: true, `0x02`: true, `0x03`: false, `0x05`: true, `0x06`: true} {
+		res := TransactionSender{}
+		if e := testHandler().RecoverTransactionSender(RecoverTransactionSenderRequest{Hash: hash}, &res); e != nil {
+			t.Fatalf(`%s: %s`, hash, e)
+		}
+		if res.From != testAddress.Hex() || res.Match != match {
+			t.Fatalf(`%s: expected sender %s (match %t), have %+v`, hash, testAddress.Hex(), match, res)
+		}
+	}
+	if e := testHandler().RecoverTransactionSender(RecoverTransactionSenderRequest{Hash: `0x04`}, &TransactionSender{}); e == nil || e.Error() != `transaction not found: 0x04` {
+		t.Fatalf(`expected transaction not found, have %v`, e)
+	}
+}
+
+func TestFallbackSelection(t *testing.T) {
+	wallet := abiContract(t, `Wallet.sol`, `Wallet`, `[
+		{"type": "fallback", "payable": true, "stateMutability": "payable"},
+		{"type": "function", "name": "owner", "stateMutability": "view", "inputs": [], "outputs": [{"name": "", "type": "address"}]}
+	]`)
+	for _, signature := range []string{``, `()`, `fallback`, `fallback()`, `receive`, `receive()`} {
+		calldata := BinaryJSON{0xff}
+		req := EncodeFunctionCallRequest{File: `Wallet.sol`, Contract: `Wallet`, Signature: signature}
+		if e := testHandler(wallet).EncodeFunctionCall(req, &calldata); e != nil {
+			t.Fatalf(`%q: %s`, signature, e)
+		}
+		if len(calldata) != 0 {
+			t.Fatalf(`%q: expected empty calldata, have %x`, signature, []byte(calldata))
+		}
+	}
+
+	sent := new(ethtypes.Transaction)
+	mock := newMockEthClient().
+		Handle(`eth_getTransactionCount`, func(...interface{}) (interface{}, error) { return `0x0`, nil }).
+		Handle(`eth_getTransactionReceipt`, minedReceipt).
+		Handle(`eth_sendRawTransaction`, func(args ...interface{}) (interface{}, error) {
+			return nil, rlp.DecodeBytes(mustDecodeHex(args[0].(string)), sent)
+		})
+	defer useEthClient(mock)()
+	req := DispatchFunctionCallRequest{Target: `0xcc`, Value: `5`, GasPrice: `1`, GasLimit: `50000`, Mode: FunctionDispatchModeTransactionOnly, Auth: testAuth}
+	req.File, req.Contract, req.Signature = `Wallet.sol`, `Wallet`, `receive()`
+	if e := testHandler(wallet).DispatchFunctionCall(req, &DispatchFunctionCallResponse{}); e != nil {
+		t.Fatal(e)
+	}
+	if len(sent.Data()) != 0 || sent.Value().Int64() != 5 {
+		t.Fatalf(`expected transfer of 5 wei without data, have value %s and data %x`, sent.Value(), sent.Data())
+	}
+}
+
+func TestIntegerFormats(t *testing.T) {
+	stats := abiContract(t, `Stats.sol`, `Stats`, `[{"type": "function", "name": "stats", "stateMutability": "view", "inputs": [],
+		"outputs": [{"name": "count", "type": "uint8"}, {"name": "total", "type": "uint256"}, {"name": "huge", "type": "uint256"}, {"name": "delta", "type": "int256"}]}]`)
+	result := word(7, 1<<60) + strings.Repeat(`ff`, 32) + strings.Repeat(`ff`, 31) + `fb` // 7, 2^60, 2^256-1, -5
+	mock := newMockEthClient().Handle(`eth_call`, func(...interface{}) (interface{}, error) { return `0x` + result, nil })
+	defer useEthClient(mock)()
+
+	max := `115792089237316195423570985008687907853269984665640564039457584007913129639935`
+	for format, expected := range map[string]string{
+		``:       `[7, "0x1000000000000000", "0x` + strings.Repeat(`ff`, 32) + `", -5]`,
+		`hex`:    `[7, "0x1000000000000000", "0x` + strings.Repeat(`ff`, 32) + `", -5]`,
+		`string`: `["7", "1152921504606846976", "` + max + `", "-5"]`,
+		`number`: `[7, "1152921504606846976", "` + max + `", -5]`,
+	} {
+		req := DispatchFunctionCallRequest{Target: `0x01`, GasPrice: `1`, Auth: testAuth}
+		req.File, req.Contract, req.Signature, req.Arguments, req.IntegerFormat = `Stats.sol`, `Stats`, `stats()`, json.RawMessage(`[]`), format
+		res := DispatchFunctionCallResponse{}
+		if e := testHandler(stats).DispatchFunctionCall(req, &res); e != nil {
+			t.Fatal(e)
+		}
+		if !jsonEqualString(res.Result, expected) {
+			t.Fatalf(`format %q: expected %s, have %s`, format, expected, res.Result)
+		}
+	}
+
+	req := DispatchFunctionCallRequest{Target: `0x01`, GasPrice: `1`, Auth: testAuth}
+	req.File, req.Contract, req.Signature, req.IntegerFormat = `Stats.sol`, `Stats`, `stats()`, `decimal`
+	if e := testHandler(stats).DispatchFunctionCall(req, &DispatchFunctionCallResponse{}); e == nil || !strings.HasPrefix(e.Error(), `invalid integer format decimal`) {
+		t.Fatalf(`expected invalid integer format, have %v`, e)
+	}
+}
+
+func TestFindContract(t *testing.T) {
+	h := testHandler(
+		abiContract(t, `b/Token.sol`, `Token`, transferABI),
+		abiContract(t, `a/Token.sol`, `Token`, erc20ABI),
+		abiContract(t, `a/Token.sol`, `Other`, transferABI),
+	)
+	res := []GetContractRequest(nil)
+	if e := h.FindContract(`Token`, &res); e != nil {
+		t.Fatal(e)
+	}
+	if expected := []GetContractRequest{{File: `a/Token.sol`, Contract: `Token`}, {File: `b/Token.sol`, Contract: `Token`}}; !reflect.DeepEqual(res, expected) {
+		t.Fatalf(`expected %v, have %v`, expected, res)
+	}
+
+	// an empty list, not null
+	res = nil
+	if e := h.FindContract(`Missing`, &res); e != nil {
+		t.Fatal(e)
+	}
+	if bs, _ := json.Marshal(res); string(bs) != `[]` {
+		t.Fatalf(`expected [], have %s`, bs)
+	}
+}
+
+func TestGetContractConstants(t *testing.T) {
+	fees := abiContract(t, `Fees.sol`, `Fees`, `[{"type": "function", "name": "FEE", "stateMutability": "view", "inputs": [], "outputs": [{"name": "", "type": "uint256"}]}]`)
+	fees.Constants = map[string]json.RawMessage{`FEE`: json.RawMessage(`100`)}
+	res := json.RawMessage{}
+	if e := testHandler(fees).GetContract(GetContractRequest{File: `Fees.sol`, Contract: `Fees`}, &res); e != nil {
+		t.Fatal(e)
+	}
+	encoded := struct {
+		Constants map[string]json.RawMessage `json:"constants"`
+	}{}
+	if e := json.Unmarshal(res, &encoded); e != nil || string(encoded.Constants[`FEE`]) != `100` {
+		t.Fatalf(`expected constant FEE = 100, have %s`, res)
+	}
+}
+
+func TestGetContractCompilerVersion(t *testing.T) {
+	token := abiContract(t, `Token.sol`, `Token`, transferABI)
+	token.CompilerVersion = `0.4.24+commit.e67f0147.Linux.g++`
+	res := json.RawMessage{}
+	if e := testHandler(token).GetContract(GetContractRequest{File: `Token.sol`, Contract: `Token`}, &res); e != nil {
+		t.Fatal(e)
+	}
+	encoded := struct {
+		CompilerVersion string `json:"compilerVersion"`
+	}{}
+	if e := json.Unmarshal(res, &encoded); e != nil || encoded.CompilerVersion != token.CompilerVersion {
+		t.Fatalf(`expected compilerVersion %s, have %s`, token.CompilerVersion, res)
+	}
+}
+
+func TestGetContractCustomNatSpec(t *testing.T) {
+	vault := abiContract(t, `Vault.sol`, `Vault`, `[{"type": "function", "name": "withdraw", "stateMutability": "nonpayable", "inputs": [], "outputs": []}]`)
+	vault.NatSpec = "@title Vault\n@custom:security-contact security@example.com\n@custom:oz-upgrades-unsafe-allow constructor"
+	withdraw := vault.API[`withdraw()`]
+	withdraw.NatSpec = "@notice Withdraws everything.\n@custom:audit passed"
+	vault.API[`withdraw()`] = withdraw
+
+	res := json.RawMessage{}
+	if e := testHandler(vault).GetContract(GetContractRequest{File: `Vault.sol`, Contract: `Vault`}, &res); e != nil {
+		t.Fatal(e)
+	}
+	encoded := struct {
+		NatSpecTags types.NatSpec `json:"natSpecTags"`
+		API         map[string]struct {
+			NatSpecTags types.NatSpec `json:"natSpecTags"`
+		} `json:"api"`
+	}{}
+	if e := json.Unmarshal(res, &encoded); e != nil {
+		t.Fatal(e)
+	}
+	expected := map[string]string{`security-contact`: `security@example.com`, `oz-upgrades-unsafe-allow`: `constructor`}
+	if custom := encoded.NatSpecTags.Custom; !reflect.DeepEqual(custom, expected) {
+		t.Fatalf(`expected contract tags %v, have %v`, expected, custom)
+	}
+	if custom := encoded.API[`withdraw()`].NatSpecTags.Custom; !reflect.DeepEqual(custom, map[string]string{`audit`: `passed`}) {
+		t.Fatalf(`unexpected function tags %v`, custom)
+	}
+}
+
+func TestGetFunctionTemplate(t *testing.T) {
+	shop := abiContract(t, `Shop.sol`, `Shop`, `[{"type": "function", "name": "order", "stateMutability": "nonpayable", "outputs": [], "inputs": [
+		{"name": "id", "type": "uint256"}, {"name": "buyer", "type": "address"}, {"name": "paid", "type": "bool"},
+		{"name": "note", "type": "string"}, {"name": "data", "type": "bytes"}, {"name": "tag", "type": "bytes4"},
+		{"name": "deltas", "type": "int8[2]"}, {"name": "amounts", "type": "uint256[]"},
+		{"name": "item", "type": "tuple", "components": [{"name": "sku", "type": "bytes32"}, {"name": "sizes", "type": "uint8[]"}]}]}]`)
+	signature := `order(uint256,address,bool,string,bytes,bytes4,int8[2],uint256[],(bytes32,uint8[]))`
+
+	template := json.RawMessage{}
+	if e := testHandler(shop).GetFunctionTemplate(GetFunctionRequest{`Shop.sol`, `Shop`, signature}, &template); e != nil {
+		t.Fatal(e)
+	}
+	expected := `[0, "0x0000000000000000000000000000000000000000", false, "", "", "0x00000000", [0, 0], [],
+		{"sku": "0x0000000000000000000000000000000000000000000000000000000000000000", "sizes": []}]`
+	if !jsonEqualString(template, expected) {
+		t.Fatalf(`expected %s, have %s`, expected, template)
+	}
+
+	// the template is accepted as arguments
+	req := EncodeFunctionCallRequest{File: `Shop.sol`, Contract: `Shop`, Signature: signature, Arguments: template}
+	if e := testHandler(shop).EncodeFunctionCall(req, &BinaryJSON{}); e != nil {
+		t.Fatal(e)
+	}
+}
+
+func TestGetSelectorTable(t *testing.T) {
+	token := abiContract(t, `Token.sol`, `Token`, erc20ABI)
+	mintable := abiContract(t, `Token.sol`, `Mintable`, `[
+		{"type": "function", "name": "mint", "stateMutability": "nonpayable", "inputs": [{"name": "to", "type": "address"}, {"name": "amount", "type": "uint256"}], "outputs": []},
+		{"type": "event", "name": "Transfer", "anonymous": false, "inputs": [{"name": "from", "type": "address", "indexed": true}, {"name": "to", "type": "address", "indexed": true}, {"name": "value", "type": "uint256", "indexed": false}]}
+	]`)
+	mintable.Parents = []*types.Contract{token}
+	h := testHandler(mintable)
+
+	res := SelectorTable{}
+	if e := h.GetSelectorTable(GetContractRequest{File: `Token.sol`, Contract: `Mintable`}, &res); e != nil {
+		t.Fatal(e)
+	}
+	for selector, signature := range map[string]string{
+		`0x40c10f19`: `mint(address,uint256)`, // own
+		`0xa9059cbb`: `transfer(address,uint256)`,
+		`0x23b872dd`: `transferFrom(address,address,uint256)`,
+		`0x70a08231`: `balanceOf(address)`,
+		`0x095ea7b3`: `approve(address,uint256)`,
+		`0x18160ddd`: `totalSupply()`,
+	} {
+		if res.Functions[selector] != signature {
+			t.Fatalf(`expected %s for selector %s, have %q`, signature, selector, res.Functions[selector])
+		}
+	}
+	if len(res.Functions) != 10 {
+		t.Fatalf(`expected 10 functions (9 inherited, 1 own), have %d: %v`, len(res.Functions), res.Functions)
+	}
+	if topic := `0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef`; res.Events[topic] != `Transfer(address,address,uint256)` {
+		t.Fatalf(`expected Transfer topic, have %v`, res.Events)
+	}
+
+	if e := h.GetSelectorTable(GetContractRequest{File: `Token.sol`, Contract: `Missing`}, &res); e == nil {
+		t.Fatalf(`expected error for missing contract`)
+	}
+}
+
+func TestFlatStructArguments(t *testing.T) {
+	order := `{"type": "tuple", "name": "order", "components": [{"name": "buyer", "type": "address"}, {"name": "amount", "type": "uint256"}, {"name": "note", "type": "string"}]}`
+	h := testHandler(abiContract(t, `Shop.sol`, `Shop`, `[
+		{"type": "function", "name": "place", "stateMutability": "nonpayable", "inputs": [`+order+`], "outputs": []},
+		{"type": "function", "name": "placeFor", "stateMutability": "nonpayable", "inputs": [`+order+`, {"name": "id", "type": "uint256"}], "outputs": []}
+	]`))
+	fields := `{"buyer": "0x00000000000000000000000000000000000000aa", "amount": 42, "note": "gift"}`
+
+	encode := func(signature, arguments string) (BinaryJSON, error) {
+		calldata := BinaryJSON{}
+		req := EncodeFunctionCallRequest{File: `Shop.sol`, Contract: `Shop`, Signature: signature, Arguments: json.RawMessage(arguments)}
+		return calldata, h.EncodeFunctionCall(req, &calldata)
+	}
+	wrapped, e := encode(`place((address,uint256,string))`, `[`+fields+`]`)
+	if e != nil {
+		t.Fatal(e)
+	}
+	flat, e := encode(`place((address,uint256,string))`, fields)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if !bytes.Equal(wrapped, flat) {
+		t.Fatalf("flat and wrapped arguments encoded differently:\n%x\n%x", flat, wrapped)
+	}
+	if len(flat) != 4+32*6 { // offset, buyer, amount, note offset, note length, note
+		t.Fatalf(`unexpected calldata %x`, flat)
+	}
+
+	// functions with more inputs stay strict
+	if _, e := encode(`placeFor((address,uint256,string),uint256)`, fields); e == nil {
+		t.Fatalf(`expected flat struct members to be rejected for multiple inputs`)
+	}
+	if _, e := encode(`placeFor((address,uint256,string),uint256)`, `[`+fields+`, 1]`); e != nil {
+		t.Fatal(e)
+	}
+}
+
+func TestCanonicalizeSignature(t *testing.T) {
+	for canonical, synonyms := range map[string][]string{
+		`transfer(address,uint256)`:  {`transfer(address,uint256)`, `transfer(address to, uint amount)`, ` function transfer( address _to ,uint256 _value ) `},
+		`f(uint256[],int256,bytes1)`: {`f(uint[] memory xs, int y, byte b)`, `f(uint256[] calldata, int256, bytes1)`},
+		`g((uint256,address)[])`:     {`g((uint a, address b)[] calldata items)`, `g((uint256,address)[])`},
+	} {
+		selector := ``
+		for _, synonym := range synonyms {
+			res := CanonicalSignature{}
+			if e := (RpcHandler{}).CanonicalizeSignature(synonym, &res); e != nil {
+				t.Fatalf(`%s: %s`, synonym, e)
+			}
+			if res.Signature != canonical {
+				t.Fatalf(`%s: expected %s, have %s`, synonym, canonical, res.Signature)
+			}
+			if selector == `` {
+				selector = hex.EncodeToString(res.Selector)
+			} else if hex.EncodeToString(res.Selector) != selector {
+				t.Fatalf(`%s: expected selector %s, have %x`, synonym, selector, res.Selector)
+			}
+		}
+	}
+
+	res := CanonicalSignature{}
+	if e := (RpcHandler{}).CanonicalizeSignature(`transfer(address to, uint amount)`, &res); e != nil || hex.EncodeToString(res.Selector) != `a9059cbb` {
+		t.Fatalf(`expected selector a9059cbb, have %x (%v)`, res.Selector, e)
+	}
+	for _, invalid := range []string{`transfer`, `transfer(address`, `(address)`, `transfer(address) returns (bool)`} {
+		if e := (RpcHandler{}).CanonicalizeSignature(invalid, &res); e == nil {
+			t.Fatalf(`expected error for %s`, invalid)
+		}
+	}
+}
+
+func TestCreateAccount(t *testing.T) {
+	dir := t.TempDir()
+	authenticator := `fs-create-test:` + dir // registered for good, so unique across runs of the test
+	auth.RegisterAuthenticator(authenticator, fs.Folder(dir))
+	defer func(directory string, enabled, readOnly bool, params fs.ScryptParams) {
+		config.FSAuthDirectory, config.CreateAccounts, config.ReadOnly, fsScryptParams = directory, enabled, readOnly, params
+	}(config.FSAuthDirectory, config.CreateAccounts, config.ReadOnly, fsScryptParams)
+	config.FSAuthDirectory, fsScryptParams = dir, fs.LightScrypt
+
+	h, req := RpcHandler{}, CreateAccountRequest{Passphrase: `secret`}
+	if e := h.CreateAccount(req, &CreatedAccount{}); e == nil {
+		t.Fatalf(`expected account creation to be disabled by default`)
+	}
+	config.CreateAccounts, config.ReadOnly = true, true
+	if e := h.CreateAccount(req, &CreatedAccount{}); e != errReadOnly {
+		t.Fatalf(`expected %v, have %v`, errReadOnly, e)
+	}
+	config.ReadOnly = false
+
+	created := CreatedAccount{}
+	if e := h.CreateAccount(req, &created); e != nil {
+		t.Fatal(e)
+	}
+	credentials, _ := json.Marshal(fs.Credentials{FilePath: created.FilePath, Passphrase: `secret`})
+	token := json.RawMessage{}
+	if e := h.Authenticate(AuthenticationRequest{Authenticator: authenticator, Credentials: credentials}, &token); e != nil {
+		t.Fatal(e)
+	}
+	key, e := auth.ExchangeToken(authenticator, token)
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer key.Destroy()
+	if key.Address.Hex() != created.Address {
+		t.Fatalf(`authenticated as %s, created %s`, key.Address.Hex(), created.Address)
+	}
+
+	wrong, _ := json.Marshal(fs.Credentials{FilePath: created.FilePath, Passphrase: `wrong`})
+	if e := h.Authenticate(AuthenticationRequest{Authenticator: authenticator, Credentials: wrong}, &token); e == nil {
+		t.Fatalf(`expected wrong passphrase to fail`)
+	}
+}
+
+func TestGetMappingSlot(t *testing.T) {
+	address, uint256 := types.Elementary(`address`), types.Elementary(`uint256`)
+	token := abiContract(t, `Token.sol`, `Token`, erc20ABI)
+	token.Variables = []types.Variable{
+		{Name: `_balances`, Type: types.Mapping{Key: address, Value: uint256}},                                       // slot 0
+		{Name: `_allowances`, Type: types.Mapping{Key: address, Value: types.Mapping{Key: address, Value: uint256}}}, // slot 1
+		{Name: `_ids`, Type: types.Mapping{Key: types.Elementary(`string`), Value: uint256}},                         // slot 2
+	}
+	holder := json.RawMessage(`"0x6ac7ea33f8831ea9dcc53393aaa88b25a785dbf0"`)
+
+	// as computed by keccak256(abi.encode(holder, 0)) etc.
+	for _, vector := range []struct {
+		variable string
+		keys     []json.RawMessage
+		slot     string
+	}{
+		{`_balances`, []json.RawMessage{holder}, `a859db4e549bc2df3ed87a9dba7c57d0e9c83f41961b131c3c8f7a18f4fc303d`},
+		{`_allowances`, []json.RawMessage{holder, json.RawMessage(`"0x00000000000000000000000000000000000000bb"`)}, `ec912dfda1726b636a5955573ddecd805856bb79763f5a96704de282c5d48d63`},
+		{`_ids`, []json.RawMessage{json.RawMessage(`"alice"`)}, `596b06eb423d38a7c5a491741a6e3e9b743b0258c3e58cf6c6ff91ad2773ddc2`},
+	} {
+		res := BinaryJSON{}
+		req := GetMappingSlotRequest{GetContractRequest: GetContractRequest{File: `Token.sol`, Contract: `Token`}, Variable: vector.variable, Keys: vector.keys}
+		if e := testHandler(token).GetMappingSlot(req, &res); e != nil {
+			t.Fatalf(`%s: %s`, vector.variable, e)
+		}
+		if hex.EncodeToString(res) != vector.slot {
+			t.Fatalf(`%s: expected slot %s, have %x`, vector.variable, vector.slot, []byte(res))
+		}
+	}
+
+	req := GetMappingSlotRequest{GetContractRequest: GetContractRequest{File: `Token.sol`, Contract: `Token`}, Variable: `_balances`, Keys: []json.RawMessage{holder, holder}}
+	if e := testHandler(token).GetMappingSlot(req, &BinaryJSON{}); e == nil {
+		t.Fatalf(`expected error for too many keys`)
+	}
+}
+
+func TestPreviewDeploy(t *testing.T) {
+	token := abiContract(t, `Token.sol`, `Token`, `[{"type": "constructor", "stateMutability": "nonpayable", "inputs": [{"name": "name", "type": "string"}, {"name": "supply", "type": "uint256"}]}]`)
+	token.Binary = mustDecodeHex(`0x6080604052`)
+	sender := `0x6ac7ea33f8831ea9dcc53393aaa88b25a785dbf0`
+
+	estimated := make(chan map[string]string, 1)
+	mock := newMockEthClient().
+		Handle(`eth_getTransactionCount`, func(...interface{}) (interface{}, error) { return `0x2`, nil }).
+		Handle(`eth_estimateGas`, func(args ...interface{}) (interface{}, error) {
+			creation := map[string]string{}
+			bs, _ := json.Marshal(args[0])
+			json.Unmarshal(bs, &creation)
+			estimated <- creation
+			return `0x1e8480`, nil
+		})
+	defer useEthClient(mock)()
+
+	req := PreviewDeployRequest{GetContractRequest: GetContractRequest{File: `Token.sol`, Contract: `Token`}, Sender: sender, Value: `1`, TransactionUnits: TransactionUnits{ValueUnit: `gwei`}}
+	req.Arguments = json.RawMessage(`["Token", 1000]`)
+	res := DeployPreview{}
+	if e := testHandler(token).PreviewDeploy(req, &res); e != nil {
+		t.Fatal(e)
+	}
+	initCode := `0x6080604052` + word(0x40) + word(1000) + word(5) + hex.EncodeToString([]byte(`Token`)) + strings.Repeat(`0`, 64-10)
+	expected := DeployPreview{GasEstimate: 2000000, PredictedAddress: `0xf778B86FA74E846c4f0a1fBd1335FE81c00a0C91`, FinalBytecodeLength: 5 + 4*32}
+	if res != expected {
+		t.Fatalf(`expected %+v, have %+v`, expected, res)
+	}
+	creation := <-estimated
+	if creation[`data`] != initCode || creation[`value`] != `0x3b9aca00` || !strings.EqualFold(creation[`from`], sender) {
+		t.Fatalf(`unexpected estimation of %v`, creation)
+	}
+	if n := mock.Called(`eth_sendRawTransaction`); n != 0 {
+		t.Fatalf(`expected no broadcast, have %d`, n)
+	}
+
+	// an explicit nonce, invalid constructor arguments
+	req.Nonce = `3`
+	if e := testHandler(token).PreviewDeploy(req, &res); e != nil || res.PredictedAddress != `0xffFd933A0bC612844eaF0C6Fe3E5b8E9B6C1d19c` {
+		t.Fatalf(`expected address of nonce 3, have %s (%v)`, res.PredictedAddress, e)
+	}
+	<-estimated
+	req.Arguments = json.RawMessage(`["Token"]`)
+	if e := testHandler(token).PreviewDeploy(req, &res); e == nil {
+		t.Fatalf(`expected error for missing constructor argument`)
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2018 karma.run AG. All rights reserved.
+
+package main // import "github.com/karmarun/karma.link/link"
+
+import (
+	"context"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// SignatureType selects how transactions are signed, see transactionSigner.
+type SignatureType string
+
+const (
+	SignatureTypeDefault SignatureType = ""       // eip155 if a chain id is configured, legacy otherwise
+	SignatureTypeEIP155  SignatureType = `eip155` // replay-protected, for the configured chain or the node's
+	SignatureTypeLegacy  SignatureType = `legacy` // without replay protection, e.g. for old forks and testnets
+)
+
+// NOTE: these signers only sign legacy transactions, typed ones are signed by signTypedTransaction.
+var legacySigner = ethtypes.HomesteadSigner{FrontierSigner: ethtypes.FrontierSigner{}}
+
+// parseChainID parses a decimal or "0x"-prefixed hex chain id, nil if s is empty.
+func parseChainID(s string) (*big.Int, error) {
+	if s == "" {
+		return nil, nil
+	}
+	id, ok := (*big.Int)(nil), false
+	if strings.HasPrefix(s, `0x`) {
+		id, ok = new(big.Int).SetString(s[2:], 16)
+	} else {
+		id, ok = new(big.Int).SetString(s, 10)
+	}
+	if !ok || id.Sign() <= 0 {
+		return nil, fmt.Errorf(`invalid chain id: %s`, s)
+	}
+	return id, nil
+}
+
+// transactionSigner returns the signer for signatureType. EIP-155 signatures use the configured chain id,
+// or the one reported by the node if none is configured.
+func transactionSigner(ctx context.Context, signatureType SignatureType) (ethtypes.Signer, error) {
+	switch signatureType {
+	case SignatureTypeDefault:
+		if chainID == nil {
+			return legacySigner, nil
+		}
+		return ethtypes.NewEIP155Signer(chainID), nil
+	case SignatureTypeLegacy:
+		return legacySigner, nil
+	case SignatureTypeEIP155:
+		id, e := transactionChainID(ctx)
+		if e != nil {
+			return nil, e
+		}
+		return ethtypes.NewEIP155Signer(id), nil
+	}
+	return nil, fmt.Errorf(`invalid signatureType %s, available: eip155, legacy`, signatureType)
+}
+
+// transactionChainID returns the configured chain id, or the one reported by the node if none is configured.
+func transactionChainID(ctx context.Context) (*big.Int, error) {
+	if chainID != nil {
+		return chainID, nil
+	}
+	id := ""
+	if e := EthClient.CallContext(ctx, &id, `eth_chainId`); e != nil {
+		return nil, fmt.Errorf(`failed to get chain id: %s`, e.Error())
+	}
+	n, e := strconv.ParseUint(strip0xPrefix(id), 16, 64)
+	if e != nil || n == 0 {
+		return nil, fmt.Errorf(`invalid chain id reported by node: %s`, id)
+	}
+	return new(big.Int).SetUint64(n), nil
+}
+
+// transactionSender recovers the sender of a signed transaction, with or without replay protection.
+// Replay-protected transactions must be signed for the configured chain id, if any.
+func transactionSender(tx *ethtypes.Transaction) (common.Address, error) {
+	if tx.Protected() {
+		if chainID != nil && tx.ChainId().Cmp(chainID) != 0 {
+			return common.Address{}, fmt.Errorf(`signed for chain id %s, expected %s`, tx.ChainId(), chainID)
+		}
+		return ethtypes.Sender(ethtypes.NewEIP155Signer(tx.ChainId()), tx)
+	}
+	return ethtypes.Sender(legacySigner, tx)
+}
@@ -0,0 +1,66 @@
+// Copyright 2018 karma.run AG. All rights reserved.
+
+package main
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+	"math/big"
+	"testing"
+)
+
+func TestSignatureTypes(t *testing.T) {
+	// the node reports chain id 5, see dispatchTyped
+	for signatureType, vs := range map[SignatureType][2]int64{
+		SignatureTypeLegacy: {27, 28},
+		SignatureTypeEIP155: {5*2 + 35, 5*2 + 36},
+	} {
+		raw, e := dispatchTyped(t, TypedTransactionFields{}, signatureType)
+		if e != nil {
+			t.Fatalf(`%s: %s`, signatureType, e)
+		}
+		tx := new(ethtypes.Transaction)
+		if e := rlp.DecodeBytes(raw, tx); e != nil {
+			t.Fatalf(`%s: %s`, signatureType, e)
+		}
+		v, _, _ := tx.RawSignatureValues()
+		if v.Int64() != vs[0] && v.Int64() != vs[1] {
+			t.Fatalf(`%s: expected v %d or %d, have %s`, signatureType, vs[0], vs[1], v)
+		}
+		if tx.Protected() != (signatureType == SignatureTypeEIP155) {
+			t.Fatalf(`%s: unexpected replay protection`, signatureType)
+		}
+		from, e := transactionSender(tx)
+		if e != nil || from != testKey().Address {
+			t.Fatalf(`%s: expected sender %s, have %s (%v)`, signatureType, testKey().Address.Hex(), from.Hex(), e)
+		}
+	}
+}
+
+func TestTransactionSenderChainID(t *testing.T) {
+	defer func(id *big.Int) { chainID = id }(chainID)
+	sign := func(signer ethtypes.Signer) *ethtypes.Transaction {
+		tx, e := ethtypes.SignTx(ethtypes.NewTransaction(1, common.HexToAddress(`0xcc`), big.NewInt(0), 21000, big.NewInt(1), nil), signer, testKey().PrivateKey)
+		if e != nil {
+			t.Fatal(e)
+		}
+		return tx
+	}
+	goerli, mainnet, legacy := sign(ethtypes.NewEIP155Signer(big.NewInt(5))), sign(ethtypes.NewEIP155Signer(big.NewInt(1))), sign(legacySigner)
+
+	chainID = big.NewInt(5)
+	for _, tx := range []*ethtypes.Transaction{goerli, legacy} {
+		if from, e := transactionSender(tx); e != nil || from != testKey().Address {
+			t.Fatalf(`expected sender %s, have %s (%v)`, testKey().Address.Hex(), from.Hex(), e)
+		}
+	}
+	if _, e := transactionSender(mainnet); e == nil {
+		t.Fatal(`expected transaction signed for another chain to be rejected`)
+	}
+
+	chainID = nil // any chain
+	if from, e := transactionSender(mainnet); e != nil || from != testKey().Address {
+		t.Fatalf(`expected sender %s, have %s (%v)`, testKey().Address.Hex(), from.Hex(), e)
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright 2018 karma.run AG. All rights reserved.
+
+package main // import "github.com/karmarun/karma.link/link"
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// subscriptionNotificationMethod is the method of the JSON-RPC notifications pushed to subscribers.
+const subscriptionNotificationMethod = `v1.Subscription`
+
+// pushedResult is a result followed by notifications, e.g. of SubscribeLogs. serverCodec answers the call
+// with its ID and has it push notifications over connections that support it (see serverCodec.push).
+type pushedResult interface {
+	ID() string
+	// Push calls notify with the result of each notification until the subscription ends or notify fails.
+	Push(notify func(result interface{}) error)
+	// Cancel ends the subscription, it may be called repeatedly.
+	Cancel()
+}
+
+// subscriptions holds the active subscriptions by ID, for Unsubscribe.
+var subscriptions = struct {
+	sync.Mutex
+	byID map[string]pushedResult
+}{byID: make(map[string]pushedResult, 16)}
+
+func newSubscriptionID() string {
+	bs := make([]byte, 16, 16)
+	if _, e := rand.Read(bs); e != nil {
+		log.Panicln(e)
+	}
+	return `0x` + hex.EncodeToString(bs)
+}
+
+type SubscribeLogsRequest struct {
+	GetContractRequest
+	Event    string      `json:"event"`    // event name, all of the contract's events if empty
+	Address  string      `json:"address"`  // emitting contract, any if empty
+	Decimals json.Number `json:"decimals"` // adds args with unsigned integers scaled by 10^-decimals if set, see scaleDecimals
+}
+
+// LogSubscription is the result of SubscribeLogs. It's answered with its ID, followed by a notification
+// holding a DecodedLog for each log the node reports.
+type LogSubscription struct {
+	*logSubscription
+}
+
+type logSubscription struct {
+	id       string
+	query    *logQuery // for decoding only
+	logs     chan TransactionReceiptLog
+	upstream ethSubscription
+	quit     chan struct{}
+	once     sync.Once
+}
+
+// SubscribeLogs subscribes to new logs of a contract's events, decoded like in GetLogs.
+// It's only available over WebSocket (see --ws-bind) and needs a WebSocket or IPC geth endpoint to subscribe with.
+// The subscription lasts until it's cancelled with Unsubscribe or the connection is closed.
+func (h RpcHandler) SubscribeLogs(req SubscribeLogsRequest, res *LogSubscription) error {
+
+	events, topics, e := h.logEvents(req.GetContractRequest, req.Event)
+	if e != nil {
+		return e
+	}
+	if len(topics) == 0 {
+		if req.Event != "" {
+			return fmt.Errorf(`event not found: %s`, req.Event)
+		}
+		return fmt.Errorf(`contract has no events: %s`, req.Contract)
+	}
+
+	decimals, e := parseDecimals(req.Decimals)
+	if e != nil {
+		return e
+	}
+
+	subscriber, ok := EthClient.(ethSubscriber)
+	if !ok {
+		return fmt.Errorf(`geth client doesn't support subscriptions`)
+	}
+
+	filter := struct {
+		Address string     `json:"address,omitempty"`
+		Topics  [][]string `json:"topics"`
+	}{
+		Address: req.Address,
+		Topics:  [][]string{topics},
+	}
+	logs := make(chan TransactionReceiptLog, 64)
+	upstream, e := subscriber.EthSubscribe(context.Background(), logs, `logs`, filter)
+	if e != nil {
+		return e // TODO: better error
+	}
+
+	subscription := &logSubscription{
+		id:       newSubscriptionID(),
+		query:    &logQuery{events: events, decimals: decimals},
+		logs:     logs,
+		upstream: upstream,
+		quit:     make(chan struct{}),
+	}
+	subscriptions.Lock()
+	subscriptions.byID[subscription.id] = subscription
+	subscriptions.Unlock()
+
+	*res = LogSubscription{subscription}
+	return nil
+}
+
+// ID implements pushedResult.
+func (s *logSubscription) ID() string {
+	return s.id
+}
+
+// Push implements pushedResult. Logs failing to decode are skipped.
+func (s *logSubscription) Push(notify func(result interface{}) error) {
+	defer s.Cancel()
+	for {
+		select {
+		case <-s.quit:
+			return
+		case e := <-s.upstream.Err():
+			if e != nil {
+				log.Println(`log subscription`, s.id, `failed:`, e)
+			}
+			return
+		case raw := <-s.logs:
+			decoded, e := s.query.decode(raw)
+			if e != nil {
+				log.Println(`log subscription`, s.id, `skipping log:`, e)
+				continue
+			}
+			if e := notify(decoded); e != nil {
+				return
+			}
+		}
+	}
+}
+
+// Cancel implements pushedResult, cancelling the upstream subscription too.
+func (s *logSubscription) Cancel() {
+	s.once.Do(func() {
+		close(s.quit)
+		s.upstream.Unsubscribe()
+		subscriptions.Lock()
+		delete(subscriptions.byID, s.id)
+		subscriptions.Unlock()
+	})
+}
+
+// MarshalJSON renders the subscription as its ID.
+func (s *logSubscription) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.id)
+}
+
+type UnsubscribeRequest struct {
+	Subscription string `json:"subscription"` // ID returned by the subscribing method
+}
+
+// Unsubscribe cancels a subscription, reporting whether it was active.
+func (h RpcHandler) Unsubscribe(req UnsubscribeRequest, res *bool) error {
+	subscriptions.Lock()
+	subscription, ok := subscriptions.byID[req.Subscription]
+	subscriptions.Unlock()
+	if ok {
+		subscription.Cancel()
+	}
+	*res = ok
+	return nil
+}
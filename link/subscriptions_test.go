@@ -0,0 +1,165 @@
+// Copyright 2018 karma.run AG. All rights reserved.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"golang.org/x/net/websocket"
+	"net/http/httptest"
+	"net/rpc"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mockSubscriber is a mockEthClient which also accepts subscriptions, handing out their channels.
+type mockSubscriber struct {
+	*mockEthClient
+	subscribed chan mockSubscription
+}
+
+type mockSubscription struct {
+	logs         chan<- TransactionReceiptLog
+	args         []interface{}
+	err          chan error
+	unsubscribed chan struct{}
+}
+
+func (s mockSubscription) Unsubscribe()      { close(s.unsubscribed) }
+func (s mockSubscription) Err() <-chan error { return s.err }
+
+func (c mockSubscriber) EthSubscribe(ctx context.Context, channel interface{}, args ...interface{}) (ethSubscription, error) {
+	subscription := mockSubscription{channel.(chan TransactionReceiptLog), args, make(chan error), make(chan struct{})}
+	c.subscribed <- subscription
+	return subscription, nil
+}
+
+// rpcConn speaks JSON-RPC 2.0 over a WebSocket connection.
+type rpcConn struct {
+	t    *testing.T
+	conn *websocket.Conn
+}
+
+func (c rpcConn) send(id int, method string, params interface{}) {
+	request := map[string]interface{}{`jsonrpc`: `2.0`, `id`: id, `method`: method, `params`: params}
+	if e := websocket.JSON.Send(c.conn, request); e != nil {
+		c.t.Fatal(e)
+	}
+}
+
+// receive reads the next message, each of which must be framed on its own.
+func (c rpcConn) receive() map[string]json.RawMessage {
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	message := map[string]json.RawMessage{}
+	if e := websocket.JSON.Receive(c.conn, &message); e != nil {
+		c.t.Fatal(e)
+	}
+	return message
+}
+
+func dialWS(t *testing.T, server *httptest.Server) rpcConn {
+	conn, e := websocket.Dial(`ws`+strings.TrimPrefix(server.URL, `http`), ``, `http://localhost/`)
+	if e != nil {
+		t.Fatal(e)
+	}
+	return rpcConn{t, conn}
+}
+
+func TestSubscribeLogs(t *testing.T) {
+	token := abiContract(t, `Token.sol`, `Token`, transferABI)
+	mock := mockSubscriber{newMockEthClient(), make(chan mockSubscription, 1)}
+	defer useEthClient(mock)()
+
+	rpcServer := rpc.NewServer()
+	if e := rpcServer.RegisterName(`v1`, testHandler(token)); e != nil {
+		t.Fatal(e)
+	}
+	timeouts, e := parseMethodTimeouts(`30s`, ``)
+	if e != nil {
+		t.Fatal(e)
+	}
+	server := httptest.NewServer(rpcWSHandler(rpcServer, timeouts))
+	defer server.Close()
+
+	conn := dialWS(t, server)
+	defer conn.conn.Close()
+	params := map[string]interface{}{`file`: `Token.sol`, `contract`: `Token`, `event`: `Transfer`, `decimals`: 1}
+	conn.send(1, `v1.SubscribeLogs`, params)
+	upstream := <-mock.subscribed
+	if filter, _ := json.Marshal(upstream.args); string(filter) != `["logs",{"topics":[["`+transferTopic+`"]]}]` {
+		t.Fatalf(`unexpected upstream subscription %s`, filter)
+	}
+	response := conn.receive()
+	id := ""
+	if e := json.Unmarshal(response[`result`], &id); e != nil || string(response[`id`]) != `1` || !strings.HasPrefix(id, `0x`) {
+		t.Fatalf(`unexpected response %v`, response)
+	}
+
+	for _, value := range []uint64{15, 20} {
+		upstream.logs <- transferLog(1, value)
+	}
+	for _, scaled := range []string{`1.5`, `2`} {
+		notification := conn.receive()
+		params := struct {
+			Subscription string
+			Result       DecodedLog
+		}{}
+		if e := json.Unmarshal(notification[`params`], &params); e != nil {
+			t.Fatal(e)
+		}
+		if string(notification[`method`]) != `"v1.Subscription"` || params.Subscription != id || params.Result.Event != `Transfer(address,address,uint256)` {
+			t.Fatalf(`unexpected notification %v`, notification)
+		}
+		if !jsonEqualString(params.Result.Scaled, `["0x00000000000000000000000000000000000000aa","0x00000000000000000000000000000000000000bb","`+scaled+`"]`) {
+			t.Fatalf(`unexpected scaled args %s`, params.Result.Scaled)
+		}
+	}
+
+	conn.send(2, `v1.Unsubscribe`, map[string]string{`subscription`: id})
+	if response := conn.receive(); string(response[`result`]) != `true` {
+		t.Fatalf(`unexpected response %v`, response)
+	}
+	select {
+	case <-upstream.unsubscribed:
+	case <-time.After(5 * time.Second):
+		t.Fatal(`upstream subscription not cancelled by Unsubscribe`)
+	}
+
+	// closing the connection cancels its subscriptions
+	conn.send(3, `v1.SubscribeLogs`, params)
+	upstream = <-mock.subscribed
+	conn.receive()
+	conn.conn.Close()
+	select {
+	case <-upstream.unsubscribed:
+	case <-time.After(5 * time.Second):
+		t.Fatal(`upstream subscription not cancelled on teardown`)
+	}
+}
+
+func TestSubscribeLogsOverHTTP(t *testing.T) {
+	token := abiContract(t, `Token.sol`, `Token`, transferABI)
+	mock := mockSubscriber{newMockEthClient(), make(chan mockSubscription, 1)}
+	defer useEthClient(mock)()
+
+	rpcServer := rpc.NewServer()
+	if e := rpcServer.RegisterName(`v1`, testHandler(token)); e != nil {
+		t.Fatal(e)
+	}
+	timeouts, e := parseMethodTimeouts(`30s`, ``)
+	if e != nil {
+		t.Fatal(e)
+	}
+	rw := httptest.NewRecorder()
+	body := `{"jsonrpc":"2.0","id":1,"method":"v1.SubscribeLogs","params":{"file":"Token.sol","contract":"Token"}}`
+	rpcHTTPHandler(rpcServer, timeouts).ServeHTTP(rw, httptest.NewRequest(`POST`, `/`, strings.NewReader(body)))
+	if !strings.Contains(rw.Body.String(), `subscriptions require a WebSocket connection`) {
+		t.Fatalf(`unexpected response %s`, rw.Body.String())
+	}
+	select {
+	case <-(<-mock.subscribed).unsubscribed:
+	case <-time.After(5 * time.Second):
+		t.Fatal(`upstream subscription not cancelled`)
+	}
+}
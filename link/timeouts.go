@@ -0,0 +1,69 @@
+// Copyright 2018 karma.run AG. All rights reserved.
+
+package main // import "github.com/karmarun/karma.link/link"
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// defaultMethodTimeouts applies to methods waiting for transactions to be mined, unless overridden.
+var defaultMethodTimeouts = map[string]time.Duration{
+	`DispatchFunctionCall`:    5 * time.Minute,
+	`SubmitSignedTransaction`: 5 * time.Minute,
+	`CreateContract`:          5 * time.Minute,
+}
+
+// methodTimeouts determines how long RPC methods may take before their calls are answered with a timeout error.
+type methodTimeouts struct {
+	fallback time.Duration
+	methods  map[string]time.Duration // by method name without service, e.g. "GetLogs"
+}
+
+// parseMethodTimeouts parses the --request-timeout and --method-timeouts ("Method=duration,...") flags.
+func parseMethodTimeouts(fallback, methods string) (methodTimeouts, error) {
+	timeouts := methodTimeouts{methods: make(map[string]time.Duration, len(defaultMethodTimeouts))}
+	for method, timeout := range defaultMethodTimeouts {
+		timeouts.methods[method] = timeout
+	}
+	d, e := time.ParseDuration(fallback)
+	if e != nil || d <= 0 {
+		return methodTimeouts{}, fmt.Errorf(`invalid request timeout: %s`, fallback)
+	}
+	timeouts.fallback = d
+	if methods == "" {
+		return timeouts, nil
+	}
+	for _, pair := range strings.Split(methods, `,`) {
+		kv := strings.SplitN(pair, `=`, 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return methodTimeouts{}, fmt.Errorf(`invalid method timeout %s, expected Method=duration`, pair)
+		}
+		d, e := time.ParseDuration(kv[1])
+		if e != nil || d <= 0 {
+			return methodTimeouts{}, fmt.Errorf(`invalid timeout for method %s: %s`, kv[0], kv[1])
+		}
+		timeouts.methods[kv[0]] = d
+	}
+	return timeouts, nil
+}
+
+// Of returns the timeout of a method given as "service.Method".
+func (t methodTimeouts) Of(method string) time.Duration {
+	if d, ok := t.methods[method[strings.LastIndexByte(method, '.')+1:]]; ok {
+		return d
+	}
+	return t.fallback
+}
+
+// Max returns the longest timeout of any method.
+func (t methodTimeouts) Max() time.Duration {
+	max := t.fallback
+	for _, d := range t.methods {
+		if d > max {
+			max = d
+		}
+	}
+	return max
+}
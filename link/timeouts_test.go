@@ -0,0 +1,172 @@
+// Copyright 2018 karma.run AG. All rights reserved.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/karmarun/karma.link/ast"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseMethodTimeouts(t *testing.T) {
+	timeouts, e := parseMethodTimeouts(`30s`, `GetLogs=2m,ReadVariable=100ms`)
+	if e != nil {
+		t.Fatal(e)
+	}
+	for method, expected := range map[string]time.Duration{
+		`v1.GetLogs`:              2 * time.Minute,
+		`v1.ReadVariable`:         100 * time.Millisecond,
+		`v1.GetContract`:          30 * time.Second,
+		`v1.DispatchFunctionCall`: 5 * time.Minute, // default of transacting methods
+	} {
+		if d := timeouts.Of(method); d != expected {
+			t.Fatalf(`%s: expected %s, have %s`, method, expected, d)
+		}
+	}
+	if max := timeouts.Max(); max != 5*time.Minute {
+		t.Fatalf(`expected max 5m, have %s`, max)
+	}
+	for _, methods := range []string{`GetLogs`, `=1s`, `GetLogs=soon`, `GetLogs=-1s`} {
+		if _, e := parseMethodTimeouts(`30s`, methods); e == nil {
+			t.Fatalf(`expected an error parsing %q`, methods)
+		}
+	}
+	if _, e := parseMethodTimeouts(`0s`, ``); e == nil {
+		t.Fatal(`expected an error for a zero request timeout`)
+	}
+}
+
+func TestMethodTimeouts(t *testing.T) {
+	token := abiContract(t, `Token.sol`, `Token`, `[
+		{"type": "function", "name": "balances", "stateMutability": "view",
+		 "inputs": [{"name": "", "type": "address"}], "outputs": [{"name": "", "type": "uint256"}]}
+	]`)
+	getter := token.API[`balances(address)`]
+	getter.Visibility, getter.Definition = ast.VisibilityPublic, ast.VariableDeclaration{Name: `balances`, StateVariable: true}
+	token.API[`balances(address)`] = getter
+
+	// both methods make the same call taking 200ms, which only the slow method's budget allows for
+	defer useEthClient(newMockEthClient().
+		Handle(`eth_call`, func(...interface{}) (interface{}, error) {
+			time.Sleep(200 * time.Millisecond)
+			return `0x` + word(1000), nil
+		}))()
+	handler := testRPCHTTPHandler(t, `ReadVariable=50ms,DispatchFunctionCall=2s`, token)
+
+	params := `{"file": "Token.sol", "contract": "Token", "target": "0x01", "variable": "balances", "keys": ["0xaa"]}`
+	started := time.Now()
+	responses := postRequests(t, handler, `{"jsonrpc": "2.0", "id": 1, "method": "v1.ReadVariable", "params": `+params+`}`)
+	if elapsed := time.Since(started); elapsed >= 200*time.Millisecond {
+		t.Fatalf(`expected the fast method to be answered after its timeout, took %s`, elapsed)
+	}
+	err := jsonrpcError{}
+	if e := json.Unmarshal(responses[`1`][`error`], &err); e != nil || err.Message != `v1.ReadVariable timed out after 50ms` {
+		t.Fatalf(`expected a timeout error, have %v`, responses[`1`])
+	}
+
+	params = `{"file": "Token.sol", "contract": "Token", "signature": "balances(address)", "arguments": ["0xaa"], "target": "0x01", "gasPrice": "1", "mode": "callOnly", "auth": {"authenticator": "test", "token": "token"}}`
+	responses = postRequests(t, handler, `{"jsonrpc": "2.0", "id": 2, "method": "v1.DispatchFunctionCall", "params": `+params+`}`)
+	result := DispatchFunctionCallResponse{}
+	if e := json.Unmarshal(responses[`2`][`result`], &result); e != nil || string(result.Result) != `[1000]` {
+		t.Fatalf(`expected the slow method to succeed, have %v`, responses[`2`])
+	}
+}
+
+func TestTimedOutCallsDontTransact(t *testing.T) {
+	store := abiContract(t, `Store.sol`, `Store`, `[
+		{"type": "function", "name": "set", "stateMutability": "nonpayable", "inputs": [{"name": "v", "type": "uint256"}], "outputs": []}
+	]`)
+	mock := newMockEthClient().
+		Handle(`eth_getTransactionCount`, func(...interface{}) (interface{}, error) {
+			time.Sleep(100 * time.Millisecond) // outlasting the call's timeout
+			return `0x0`, nil
+		}).
+		Handle(`eth_sendRawTransaction`, func(...interface{}) (interface{}, error) { return nil, nil })
+	defer useEthClient(mock)()
+	handler := testRPCHTTPHandler(t, `DispatchFunctionCall=50ms`, store)
+
+	params := `{"file": "Store.sol", "contract": "Store", "signature": "set(uint256)", "arguments": [1], "target": "0x01", "gasPrice": "1", ` +
+		`"auth": {"authenticator": "test", "token": "token"}}`
+	responses := postRequests(t, handler, `{"jsonrpc": "2.0", "id": 1, "method": "v1.DispatchFunctionCall", "params": `+params+`}`)
+	err := jsonrpcError{}
+	if e := json.Unmarshal(responses[`1`][`error`], &err); e != nil || !strings.Contains(err.Message, `timed out`) {
+		t.Fatalf(`expected a timeout error, have %v`, responses[`1`])
+	}
+	time.Sleep(150 * time.Millisecond) // the call keeps running after being answered
+	if n := mock.Called(`eth_sendRawTransaction`); n != 0 {
+		t.Fatalf(`expected no broadcast after the timeout, have %d`, n)
+	}
+
+	// sendRawTransaction refuses to broadcast once the context is done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if e := sendRawTransaction(ctx, []byte{0xc0}); e == nil || !strings.Contains(e.Error(), `not sent`) {
+		t.Fatalf(`expected transaction not sent, have %v`, e)
+	}
+	if n := mock.Called(`eth_sendRawTransaction`); n != 0 {
+		t.Fatalf(`expected no broadcast, have %d`, n)
+	}
+}
+
+func TestWaitForReceiptGivesUp(t *testing.T) {
+	mock := newMockEthClient().
+		Handle(`eth_getTransactionReceipt`, func(...interface{}) (interface{}, error) { return nil, nil }) // never mined
+	defer useEthClient(mock)()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*receiptPollInterval/2)
+	defer cancel()
+	hash := common.HexToHash(`0x01`)
+	started := time.Now()
+	if _, e := waitForReceipt(ctx, hash); e == nil || !strings.Contains(e.Error(), `gave up waiting for receipt of transaction `+hash.Hex()) {
+		t.Fatalf(`expected to give up, have %v`, e)
+	}
+	if elapsed := time.Since(started); elapsed > 3*receiptPollInterval {
+		t.Fatalf(`expected to give up at the deadline, took %s`, elapsed)
+	}
+	if n := mock.Called(`eth_getTransactionReceipt`); n != 2 {
+		t.Fatalf(`expected 2 polls, have %d`, n)
+	}
+}
+
+// returningClient reports the errors calls of its mockEthClient returned with.
+type returningClient struct {
+	*mockEthClient
+	returned chan error
+}
+
+func (c returningClient) CallContext(ctx context.Context, result interface{}, method string, args ...interface{}) error {
+	e := c.mockEthClient.CallContext(ctx, result, method, args...)
+	c.returned <- e
+	return e
+}
+
+func TestGetProxyImplementationTimeout(t *testing.T) {
+	client := returningClient{
+		mockEthClient: newMockEthClient().Handle(`eth_getStorageAt`, func(...interface{}) (interface{}, error) {
+			time.Sleep(time.Second)
+			return `0x` + word(0), nil
+		}),
+		returned: make(chan error, 1),
+	}
+	defer useEthClient(client)()
+	handler := testRPCHTTPHandler(t, `GetProxyImplementation=50ms`)
+
+	params := `{"address": "0x00000000000000000000000000000000000000aa"}`
+	responses := postRequests(t, handler, `{"jsonrpc": "2.0", "id": 1, "method": "v1.GetProxyImplementation", "params": `+params+`}`)
+	err := jsonrpcError{}
+	if e := json.Unmarshal(responses[`1`][`error`], &err); e != nil || err.Message != `v1.GetProxyImplementation timed out after 50ms` {
+		t.Fatalf(`expected a timeout error, have %v`, responses[`1`])
+	}
+	select {
+	case e := <-client.returned:
+		if e != context.Canceled && e != context.DeadlineExceeded {
+			t.Fatalf(`expected eth_getStorageAt to be cancelled, have %v`, e)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal(`expected eth_getStorageAt to be cancelled by the timeout`)
+	}
+}
@@ -0,0 +1,413 @@
+// Copyright 2018 karma.run AG. All rights reserved.
+
+package main // import "github.com/karmarun/karma.link/link"
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/karmarun/karma.link/auth"
+	"log"
+	"math/big"
+	"strconv"
+)
+
+// EIP-2718 transaction types. The go-ethereum version we build against only knows legacy transactions,
+// so these are encoded and signed by typedTransaction.
+const (
+	accessListTxType = 0x01 // EIP-2930
+	dynamicFeeTxType = 0x02 // EIP-1559
+)
+
+// TypedTransactionFields holds the request fields selecting a typed transaction.
+// Legacy transactions are sent if neither is set.
+type TypedTransactionFields struct {
+
+	// AccessList, if not null, selects an access-list transaction (or a dynamic-fee one, see MaxPriorityFeePerGas).
+	// An empty list is allowed.
+	AccessList []AccessTuple `json:"accessList"`
+
+	// MaxPriorityFeePerGas, if set, selects a dynamic-fee transaction with the request's gas price as max fee per gas.
+	// It is given in the request's gas price unit.
+	MaxPriorityFeePerGas json.Number `json:"maxPriorityFeePerGas"`
+}
+
+// AccessTuple is an entry of an EIP-2930 access list: an address and the storage keys the transaction will access there.
+type AccessTuple struct {
+	Address     string   `json:"address"`
+	StorageKeys []string `json:"storageKeys"`
+}
+
+// accessTuple is a parsed AccessTuple, RLP-encoded as [address, [keys...]].
+type accessTuple struct {
+	Address     common.Address
+	StorageKeys []common.Hash
+}
+
+// journaledTransaction is implemented by *ethtypes.Transaction and *typedTransaction, see journal.Add.
+type journaledTransaction interface {
+	Hash() common.Hash
+	Nonce() uint64
+	To() *common.Address
+}
+
+// signedTransaction is implemented by *ethtypes.Transaction and *typedTransaction, see SubmitSignedTransaction.
+type signedTransaction interface {
+	journaledTransaction
+	Gas() uint64
+	GasPrice() *big.Int // max fee per gas of dynamic-fee transactions
+	Value() *big.Int
+	Data() []byte
+}
+
+// parseAccessList validates the addresses and storage keys of an access list.
+func parseAccessList(list []AccessTuple) ([]accessTuple, error) {
+	parsed := make([]accessTuple, len(list), len(list))
+	for i, tuple := range list {
+		address, e := hex.DecodeString(strip0xPrefix(tuple.Address))
+		if e != nil || len(address) != common.AddressLength {
+			return nil, fmt.Errorf(`invalid accessList[%d].address: %s`, i, tuple.Address)
+		}
+		parsed[i] = accessTuple{Address: common.BytesToAddress(address), StorageKeys: make([]common.Hash, len(tuple.StorageKeys))}
+		for k, key := range tuple.StorageKeys {
+			bs, e := hex.DecodeString(strip0xPrefix(key))
+			if e != nil || len(bs) != common.HashLength {
+				return nil, fmt.Errorf(`invalid accessList[%d].storageKeys[%d]: %s`, i, k, key)
+			}
+			parsed[i].StorageKeys[k] = common.BytesToHash(bs)
+		}
+	}
+	return parsed, nil
+}
+
+// typedTransaction is an access-list or dynamic-fee transaction.
+type typedTransaction struct {
+	Type         byte
+	ChainID      *big.Int
+	AccountNonce uint64
+	GasTipCap    *big.Int // max priority fee per gas, dynamic-fee transactions only
+	Price        *big.Int // gas price, max fee per gas of dynamic-fee transactions
+	GasLimit     uint64
+	Recipient    *common.Address // nil for contract creations
+	Amount       *big.Int
+	Payload      []byte
+	AccessList   []accessTuple
+	V, R, S      *big.Int // nil until signed
+}
+
+// fields returns the RLP list of the transaction's payload, with the signature values if signed.
+func (tx *typedTransaction) fields(signed bool) []interface{} {
+	to := []byte{}
+	if tx.Recipient != nil {
+		to = tx.Recipient.Bytes()
+	}
+	fields := []interface{}{tx.ChainID, tx.AccountNonce}
+	if tx.Type == dynamicFeeTxType {
+		fields = append(fields, tx.GasTipCap)
+	}
+	fields = append(fields, tx.Price, tx.GasLimit, to, tx.Amount, tx.Payload, tx.AccessList)
+	if signed {
+		fields = append(fields, tx.V, tx.R, tx.S)
+	}
+	return fields
+}
+
+func (tx *typedTransaction) encode(signed bool) []byte {
+	bs, e := rlp.EncodeToBytes(tx.fields(signed))
+	if e != nil {
+		log.Panicln(e)
+	}
+	return append([]byte{tx.Type}, bs...)
+}
+
+// MarshalBinary returns the signed transaction as accepted by eth_sendRawTransaction.
+func (tx *typedTransaction) MarshalBinary() ([]byte, error) {
+	if tx.V == nil {
+		return nil, fmt.Errorf(`transaction not signed`)
+	}
+	return tx.encode(true), nil
+}
+
+// SigningHash returns the hash signed by the sender.
+func (tx *typedTransaction) SigningHash() common.Hash {
+	return common.BytesToHash(keccak(tx.encode(false)))
+}
+
+func (tx *typedTransaction) Hash() common.Hash {
+	return common.BytesToHash(keccak(tx.encode(true)))
+}
+
+func (tx *typedTransaction) Nonce() uint64 {
+	return tx.AccountNonce
+}
+
+func (tx *typedTransaction) To() *common.Address {
+	return tx.Recipient
+}
+
+func (tx *typedTransaction) Gas() uint64 {
+	return tx.GasLimit
+}
+
+func (tx *typedTransaction) GasPrice() *big.Int {
+	return tx.Price
+}
+
+func (tx *typedTransaction) Value() *big.Int {
+	return tx.Amount
+}
+
+func (tx *typedTransaction) Data() []byte {
+	return tx.Payload
+}
+
+// decodeTypedTransaction decodes a signed access-list or dynamic-fee transaction as encoded by MarshalBinary.
+func decodeTypedTransaction(raw []byte) (*typedTransaction, error) {
+	if len(raw) == 0 || (raw[0] != accessListTxType && raw[0] != dynamicFeeTxType) {
+		return nil, fmt.Errorf(`not an access-list or dynamic-fee transaction`)
+	}
+	fields := []rlp.RawValue{}
+	if e := rlp.DecodeBytes(raw[1:], &fields); e != nil {
+		return nil, e
+	}
+	tx := &typedTransaction{Type: raw[0]}
+	targets := []interface{}{&tx.ChainID, &tx.AccountNonce}
+	if tx.Type == dynamicFeeTxType {
+		targets = append(targets, &tx.GasTipCap)
+	}
+	to := []byte{}
+	targets = append(targets, &tx.Price, &tx.GasLimit, &to, &tx.Amount, &tx.Payload, &tx.AccessList, &tx.V, &tx.R, &tx.S)
+	if len(fields) != len(targets) {
+		return nil, fmt.Errorf(`expected %d fields, have %d`, len(targets), len(fields))
+	}
+	for i, target := range targets {
+		if e := rlp.DecodeBytes(fields[i], target); e != nil {
+			return nil, fmt.Errorf(`field %d: %s`, i, e)
+		}
+	}
+	switch len(to) {
+	case 0:
+	case common.AddressLength:
+		address := common.BytesToAddress(to)
+		tx.Recipient = &address
+	default:
+		return nil, fmt.Errorf(`invalid recipient: %x`, to)
+	}
+	return tx, nil
+}
+
+// Sender recovers the address that signed tx.
+func (tx *typedTransaction) Sender() (common.Address, error) {
+	if tx.V == nil || !tx.V.IsUint64() || tx.V.Uint64() > 1 {
+		return common.Address{}, fmt.Errorf(`invalid signature values`)
+	}
+	if !crypto.ValidateSignatureValues(byte(tx.V.Uint64()), tx.R, tx.S, true) {
+		return common.Address{}, fmt.Errorf(`invalid signature values`)
+	}
+	sig := make([]byte, 65, 65)
+	copy(sig[32-len(tx.R.Bytes()):32], tx.R.Bytes())
+	copy(sig[64-len(tx.S.Bytes()):64], tx.S.Bytes())
+	sig[64] = byte(tx.V.Uint64())
+	pub, e := crypto.SigToPub(tx.SigningHash().Bytes(), sig)
+	if e != nil {
+		return common.Address{}, e
+	}
+	return crypto.PubkeyToAddress(*pub), nil
+}
+
+// typedTransactionSender recovers the sender of tx, which, like replay-protected legacy transactions,
+// must be signed for the configured chain id, if any. See transactionSender.
+func typedTransactionSender(tx *typedTransaction) (common.Address, error) {
+	if chainID != nil && tx.ChainID.Cmp(chainID) != 0 {
+		return common.Address{}, fmt.Errorf(`signed for chain id %s, expected %s`, tx.ChainID, chainID)
+	}
+	return tx.Sender()
+}
+
+// nodeTypedTransaction is a typed transaction as returned by eth_getTransactionByHash, with hex quantities.
+type nodeTypedTransaction struct {
+	Type                 string        `json:"type"`
+	ChainID              string        `json:"chainId"`
+	Nonce                string        `json:"nonce"`
+	MaxPriorityFeePerGas string        `json:"maxPriorityFeePerGas"`
+	MaxFeePerGas         string        `json:"maxFeePerGas"`
+	GasPrice             string        `json:"gasPrice"` // the effective gas price of mined dynamic-fee transactions
+	Gas                  string        `json:"gas"`
+	To                   *string       `json:"to"`
+	Value                string        `json:"value"`
+	Input                string        `json:"input"`
+	AccessList           []AccessTuple `json:"accessList"`
+	V                    string        `json:"v"`
+	R                    string        `json:"r"`
+	S                    string        `json:"s"`
+}
+
+// typedTransactionFromNode decodes a transaction returned by eth_getTransactionByHash if it is an access-list
+// or dynamic-fee one, returning nil for legacy transactions.
+func typedTransactionFromNode(raw json.RawMessage) (*typedTransaction, error) {
+	decoded := nodeTypedTransaction{}
+	if e := json.Unmarshal(raw, &decoded); e != nil {
+		return nil, e
+	}
+	typ, e := strconv.ParseUint(strip0xPrefix(decoded.Type), 16, 8)
+	if decoded.Type == "" || (e == nil && typ == 0) {
+		return nil, nil
+	}
+	if e != nil || (typ != accessListTxType && typ != dynamicFeeTxType) {
+		return nil, fmt.Errorf(`unsupported transaction type: %s`, decoded.Type)
+	}
+
+	tx := &typedTransaction{Type: byte(typ)}
+	price := decoded.GasPrice
+	if tx.Type == dynamicFeeTxType {
+		price = decoded.MaxFeePerGas
+	}
+	type quantity struct {
+		name, value string
+		target      **big.Int
+	}
+	quantities := []quantity{
+		{`chainId`, decoded.ChainID, &tx.ChainID},
+		{`gasPrice`, price, &tx.Price},
+		{`value`, decoded.Value, &tx.Amount},
+		{`v`, decoded.V, &tx.V},
+		{`r`, decoded.R, &tx.R},
+		{`s`, decoded.S, &tx.S},
+	}
+	if tx.Type == dynamicFeeTxType {
+		quantities = append(quantities, quantity{`maxPriorityFeePerGas`, decoded.MaxPriorityFeePerGas, &tx.GasTipCap})
+	}
+	for _, quantity := range quantities {
+		n, ok := new(big.Int).SetString(strip0xPrefix(quantity.value), 16)
+		if !ok {
+			return nil, fmt.Errorf(`invalid %s: %s`, quantity.name, quantity.value)
+		}
+		*quantity.target = n
+	}
+	if tx.AccountNonce, e = strconv.ParseUint(strip0xPrefix(decoded.Nonce), 16, 64); e != nil {
+		return nil, fmt.Errorf(`invalid nonce: %s`, decoded.Nonce)
+	}
+	if tx.GasLimit, e = strconv.ParseUint(strip0xPrefix(decoded.Gas), 16, 64); e != nil {
+		return nil, fmt.Errorf(`invalid gas: %s`, decoded.Gas)
+	}
+	if decoded.To != nil {
+		if !common.IsHexAddress(*decoded.To) {
+			return nil, fmt.Errorf(`invalid to: %s`, *decoded.To)
+		}
+		to := common.HexToAddress(*decoded.To)
+		tx.Recipient = &to
+	}
+	if tx.Payload, e = hex.DecodeString(strip0xPrefix(decoded.Input)); e != nil {
+		return nil, fmt.Errorf(`invalid input: %s`, e)
+	}
+	if tx.AccessList, e = parseAccessList(decoded.AccessList); e != nil {
+		return nil, e
+	}
+	return tx, nil
+}
+
+// signTypedTransaction signs tx with key and, like signTransaction, verifies that it recovers to key.Address.
+func signTypedTransaction(tx *typedTransaction, key *auth.Key) error {
+	sig, e := crypto.Sign(tx.SigningHash().Bytes(), key.PrivateKey)
+	if e != nil {
+		return fmt.Errorf(`error signing transaction: %s`, e.Error())
+	}
+	tx.R, tx.S, tx.V = new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:64]), new(big.Int).SetUint64(uint64(sig[64]))
+	sender, e := tx.Sender()
+	if e != nil {
+		log.Println("failed recovering sender of signed transaction", e)
+		return fmt.Errorf(`internal error`)
+	}
+	if sender != key.Address {
+		log.Println("signed transaction recovers to", sender.Hex(), "instead of", key.Address.Hex())
+		return fmt.Errorf(`internal error`)
+	}
+	return nil
+}
+
+// outgoingTransaction describes a transaction to be signed by signOutgoingTransaction.
+type outgoingTransaction struct {
+	Nonce    uint64
+	To       *common.Address // nil for contract creations
+	Value    *big.Int
+	GasLimit uint64
+	GasPrice *big.Int
+	Data     []byte
+}
+
+// signOutgoingTransaction signs tx with key as the kind of transaction selected by typed,
+// returning the signed transaction and its encoding for eth_sendRawTransaction.
+func signOutgoingTransaction(ctx context.Context, tx outgoingTransaction, typed TypedTransactionFields, signatureType SignatureType, gasPriceUnit string, key *auth.Key) (journaledTransaction, []byte, error) {
+
+	if typed.AccessList == nil && typed.MaxPriorityFeePerGas == "" {
+		signer, e := transactionSigner(ctx, signatureType)
+		if e != nil {
+			return nil, nil, e
+		}
+		unsigned := (*ethtypes.Transaction)(nil)
+		if tx.To == nil {
+			unsigned = ethtypes.NewContractCreation(tx.Nonce, tx.Value, tx.GasLimit, tx.GasPrice, tx.Data)
+		} else {
+			unsigned = ethtypes.NewTransaction(tx.Nonce, *tx.To, tx.Value, tx.GasLimit, tx.GasPrice, tx.Data)
+		}
+		signed, e := signTransaction(unsigned, signer, key)
+		if e != nil {
+			return nil, nil, e
+		}
+		data, e := rlp.EncodeToBytes(signed)
+		if e != nil {
+			return nil, nil, e // TODO: better error
+		}
+		return signed, data, nil
+	}
+
+	if signatureType == SignatureTypeLegacy {
+		return nil, nil, fmt.Errorf(`accessList and maxPriorityFeePerGas require typed transactions, which can't be signed with signatureType legacy`)
+	}
+
+	accessList, e := parseAccessList(typed.AccessList)
+	if e != nil {
+		return nil, nil, e
+	}
+
+	id, e := transactionChainID(ctx)
+	if e != nil {
+		return nil, nil, e
+	}
+
+	signed := &typedTransaction{
+		Type:         accessListTxType,
+		ChainID:      id,
+		AccountNonce: tx.Nonce,
+		Price:        tx.GasPrice,
+		GasLimit:     tx.GasLimit,
+		Recipient:    tx.To,
+		Amount:       tx.Value,
+		Payload:      tx.Data,
+		AccessList:   accessList,
+	}
+	if typed.MaxPriorityFeePerGas != "" {
+		tip, e := parseWei(typed.MaxPriorityFeePerGas, gasPriceUnit)
+		if e != nil {
+			return nil, nil, fmt.Errorf(`invalid maxPriorityFeePerGas: %s`, e)
+		}
+		if tip.Cmp(tx.GasPrice) > 0 {
+			return nil, nil, fmt.Errorf(`maxPriorityFeePerGas exceeds gasPrice (max fee per gas)`)
+		}
+		signed.Type, signed.GasTipCap = dynamicFeeTxType, tip
+	}
+
+	if e := signTypedTransaction(signed, key); e != nil {
+		return nil, nil, e
+	}
+	data, e := signed.MarshalBinary()
+	if e != nil {
+		return nil, nil, e
+	}
+	return signed, data, nil
+}
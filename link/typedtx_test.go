@@ -0,0 +1,191 @@
+// Copyright 2018 karma.run AG. All rights reserved.
+
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/ethereum/go-ethereum/common"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+// dispatchTyped dispatches a transaction calling set(uint256) with typed and returns the raw transaction sent.
+func dispatchTyped(t *testing.T, typed TypedTransactionFields, signatureType SignatureType) ([]byte, error) {
+	store := abiContract(t, `Store.sol`, `Store`, `[
+		{"type": "function", "name": "set", "stateMutability": "nonpayable", "inputs": [{"name": "v", "type": "uint256"}], "outputs": []}
+	]`)
+	raw := []byte(nil)
+	mock := newMockEthClient().
+		Handle(`eth_getTransactionCount`, func(...interface{}) (interface{}, error) { return `0x7`, nil }).
+		Handle(`eth_chainId`, func(...interface{}) (interface{}, error) { return `0x5`, nil }).
+		Handle(`eth_getTransactionReceipt`, minedReceipt).
+		Handle(`eth_sendRawTransaction`, func(args ...interface{}) (interface{}, error) {
+			bs, e := hex.DecodeString(strip0xPrefix(args[0].(string)))
+			raw = bs
+			return nil, e
+		})
+	defer useEthClient(mock)()
+
+	req := DispatchFunctionCallRequest{
+		Target:                 `0x00000000000000000000000000000000000000cc`,
+		GasPrice:               `20`,
+		TransactionUnits:       TransactionUnits{GasPriceUnit: `gwei`},
+		GasLimit:               `50000`,
+		Mode:                   FunctionDispatchModeTransactionOnly,
+		Auth:                   testAuth,
+		TypedTransactionFields: typed,
+		SignatureType:          signatureType,
+	}
+	req.File, req.Contract, req.Signature, req.Arguments = `Store.sol`, `Store`, `set(uint256)`, []byte(`[1]`)
+	e := testHandler(store).DispatchFunctionCall(req, &DispatchFunctionCallResponse{})
+	return raw, e
+}
+
+// decodeTyped decodes a raw access-list or dynamic-fee transaction.
+func decodeTyped(t *testing.T, raw []byte) *typedTransaction {
+	tx, e := decodeTypedTransaction(raw)
+	if e != nil {
+		t.Fatalf(`invalid typed transaction %x: %s`, raw, e)
+	}
+	return tx
+}
+
+// accessListTxVector is the transaction sent by TestAccessListTransaction, as signed by go-ethereum 1.17's LondonSigner.
+const accessListTxVector = `01f8c305078504a817c80082c3509400000000000000000000000000000000000000cc80a460fe47b1000000000000000000000000` +
+	`0000000000000000000000000000000000000001f838f79400000000000000000000000000000000000000cce1a0000000000000000000` +
+	`000000000000000000000000000000000000000000000301a0d5ed427abf473104ce80381ffef918ca20c3a393f992c0ff9d5f6c8195` +
+	`7714bea068d4c302b512332dc58e196bf8b06846676afef58dc7f1f3db5d8ca62f399a24`
+
+func TestAccessListTransaction(t *testing.T) {
+	raw, e := dispatchTyped(t, TypedTransactionFields{AccessList: []AccessTuple{{
+		Address:     `0x00000000000000000000000000000000000000cc`,
+		StorageKeys: []string{`0x0000000000000000000000000000000000000000000000000000000000000003`},
+	}}}, SignatureTypeDefault)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if hex.EncodeToString(raw) != accessListTxVector {
+		t.Fatalf(`expected %s, have %x`, accessListTxVector, raw)
+	}
+	tx := decodeTyped(t, raw)
+	if tx.Type != accessListTxType || tx.ChainID.Int64() != 5 || tx.AccountNonce != 7 || tx.GasLimit != 50000 {
+		t.Fatalf(`unexpected transaction: %+v`, tx)
+	}
+	if tx.Price.Cmp(big.NewInt(20000000000)) != 0 {
+		t.Fatalf(`expected gas price 20 gwei, have %s`, tx.Price)
+	}
+	if len(tx.AccessList) != 1 || tx.AccessList[0].Address != common.HexToAddress(`0xcc`) ||
+		len(tx.AccessList[0].StorageKeys) != 1 || tx.AccessList[0].StorageKeys[0] != common.BigToHash(big.NewInt(3)) {
+		t.Fatalf(`unexpected access list: %+v`, tx.AccessList)
+	}
+	sender, e := tx.Sender()
+	if e != nil {
+		t.Fatal(e)
+	}
+	if sender != testAddress {
+		t.Fatalf(`expected sender %s, have %s`, testAddress.Hex(), sender.Hex())
+	}
+	if tx.Hash() != common.BytesToHash(keccak(raw)) {
+		t.Fatalf(`hash mismatch`)
+	}
+}
+
+func TestDynamicFeeTransaction(t *testing.T) {
+	raw, e := dispatchTyped(t, TypedTransactionFields{MaxPriorityFeePerGas: `2`}, SignatureTypeEIP155)
+	if e != nil {
+		t.Fatal(e)
+	}
+	tx := decodeTyped(t, raw)
+	if tx.Type != dynamicFeeTxType || tx.GasTipCap.Cmp(big.NewInt(2000000000)) != 0 || len(tx.AccessList) != 0 {
+		t.Fatalf(`unexpected transaction: %+v`, tx)
+	}
+	if sender, e := tx.Sender(); e != nil || sender != testAddress {
+		t.Fatalf(`expected sender %s, have %s (%v)`, testAddress.Hex(), sender.Hex(), e)
+	}
+}
+
+func TestTypedTransactionValidation(t *testing.T) {
+	raw, e := dispatchTyped(t, TypedTransactionFields{AccessList: []AccessTuple{{Address: `0xcc`}}}, SignatureTypeDefault)
+	if e == nil || !strings.Contains(e.Error(), `accessList[0].address`) || raw != nil {
+		t.Fatalf(`expected invalid address error without broadcast, have %v`, e)
+	}
+	raw, e = dispatchTyped(t, TypedTransactionFields{AccessList: []AccessTuple{}}, SignatureTypeLegacy)
+	if e == nil || raw != nil {
+		t.Fatalf(`expected legacy signature type to be rejected without broadcast`)
+	}
+	raw, e = dispatchTyped(t, TypedTransactionFields{}, SignatureTypeLegacy)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if raw[0] < 0xc0 {
+		t.Fatalf(`expected legacy transaction, have %x`, raw)
+	}
+}
+
+func TestSubmitSignedTypedTransaction(t *testing.T) {
+	counter := abiContract(t, `Counter.sol`, `Counter`, `[
+		{"type": "function", "name": "increment", "stateMutability": "nonpayable", "inputs": [], "outputs": [{"name": "", "type": "uint256"}]}
+	]`)
+	to := common.HexToAddress(`0xcc`)
+	for _, tx := range []*typedTransaction{
+		{Type: accessListTxType, ChainID: big.NewInt(5), AccountNonce: 1, Price: big.NewInt(2), GasLimit: 50000, Recipient: &to,
+			Amount: big.NewInt(0), Payload: keccak([]byte(`increment()`))[:4], AccessList: []accessTuple{{Address: to, StorageKeys: []common.Hash{{}}}}},
+		{Type: dynamicFeeTxType, ChainID: big.NewInt(5), AccountNonce: 2, GasTipCap: big.NewInt(1), Price: big.NewInt(3), GasLimit: 50000, Recipient: &to,
+			Amount: big.NewInt(0), Payload: keccak([]byte(`increment()`))[:4], AccessList: []accessTuple{}},
+	} {
+		if e := signTypedTransaction(tx, testKey()); e != nil {
+			t.Fatal(e)
+		}
+		raw, e := tx.MarshalBinary()
+		if e != nil {
+			t.Fatal(e)
+		}
+
+		broadcast := ""
+		mock := newMockEthClient().
+			Handle(`eth_sendRawTransaction`, func(args ...interface{}) (interface{}, error) {
+				broadcast = args[0].(string)
+				return tx.Hash(), nil
+			}).
+			Handle(`eth_getTransactionReceipt`, func(...interface{}) (interface{}, error) {
+				return TransactionReceipt{Status: `0x1`, GasUsed: `0x5208`, BlockNumber: `0x10`, TransactionHash: tx.Hash().Hex(), EffectiveGasPrice: `0x2`}, nil
+			}).
+			Handle(`eth_call`, func(args ...interface{}) (interface{}, error) {
+				if call := args[0].(callArguments); call.From != ensure0xPrefix(testAddress.String()) || call.To != ensure0xPrefix(to.String()) {
+					return nil, fmt.Errorf(`unexpected replay %+v`, call)
+				}
+				return `0x` + word(4), nil
+			})
+		restore := useEthClient(mock)
+
+		req := SubmitSignedTransactionRequest{Transaction: hex.EncodeToString(raw)}
+		req.File, req.Contract, req.Signature = `Counter.sol`, `Counter`, `increment()`
+		res := DispatchFunctionCallResponse{}
+		e = testHandler(counter).SubmitSignedTransaction(req, &res)
+		restore()
+		if e != nil {
+			t.Fatalf(`type %d: %s`, tx.Type, e)
+		}
+		if broadcast != ensure0xPrefix(hex.EncodeToString(raw)) {
+			t.Fatalf(`type %d: expected raw transaction to be forwarded as is, have %s`, tx.Type, broadcast)
+		}
+		if res.Receipt == nil || res.Receipt.TransactionHash != tx.Hash().Hex() || string(res.Result) != `[4]` {
+			t.Fatalf(`type %d: unexpected response: %+v`, tx.Type, res)
+		}
+		if res.Cost == nil || res.Cost.Total != `42000` {
+			t.Fatalf(`type %d: unexpected cost: %+v`, tx.Type, res.Cost)
+		}
+	}
+
+	// a typed envelope with a forged signature is rejected before being broadcast
+	tx := &typedTransaction{Type: dynamicFeeTxType, ChainID: big.NewInt(5), GasTipCap: big.NewInt(1), Price: big.NewInt(1), Amount: big.NewInt(0),
+		V: big.NewInt(2), R: big.NewInt(1), S: big.NewInt(1)}
+	raw, _ := tx.MarshalBinary()
+	defer useEthClient(newMockEthClient())()
+	e := testHandler(counter).SubmitSignedTransaction(SubmitSignedTransactionRequest{Transaction: hex.EncodeToString(raw)}, &DispatchFunctionCallResponse{})
+	if e == nil || !strings.HasPrefix(e.Error(), `invalid transaction signature`) {
+		t.Fatalf(`expected invalid signature, have %v`, e)
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright 2018 karma.run AG. All rights reserved.
+
+package main // import "github.com/karmarun/karma.link/link"
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// TransactionUnits holds the units of a transaction request's value and gas price, wei if empty.
+type TransactionUnits struct {
+	ValueUnit    string `json:"valueUnit"`    // e.g. "ether"
+	GasPriceUnit string `json:"gasPriceUnit"` // e.g. "gwei"
+}
+
+// weiExponents maps ether units to the power of ten of their value in wei.
+var weiExponents = map[string]int{
+	`wei`:    0,
+	`kwei`:   3,
+	`mwei`:   6,
+	`gwei`:   9,
+	`szabo`:  12,
+	`finney`: 15,
+	`ether`:  18,
+}
+
+// parseWei parses a non-negative decimal amount of unit, e.g. "1.5" ether, into wei.
+// Amounts with fractional wei are rejected.
+func parseWei(amount json.Number, unit string) (*big.Int, error) {
+	if unit == "" {
+		unit = `wei`
+	}
+	exponent, ok := weiExponents[unit]
+	if !ok {
+		return nil, fmt.Errorf(`unknown unit %s, available: wei, kwei, mwei, gwei, szabo, finney, ether`, unit)
+	}
+	s := string(amount)
+	whole, fraction := s, ""
+	if dot := strings.IndexByte(s, '.'); dot != -1 {
+		whole, fraction = s[:dot], strings.TrimRight(s[dot+1:], `0`)
+	}
+	if whole == "" || strings.Trim(whole, `0123456789`) != "" || strings.Trim(fraction, `0123456789`) != "" {
+		return nil, fmt.Errorf(`invalid amount %s`, s)
+	}
+	if len(fraction) > exponent {
+		return nil, fmt.Errorf(`amount %s %s has fractional wei`, s, unit)
+	}
+	wei, _ := new(big.Int).SetString(whole+fraction+strings.Repeat(`0`, exponent-len(fraction)), 10)
+	return wei, nil
+}
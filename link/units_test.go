@@ -0,0 +1,55 @@
+// Copyright 2018 karma.run AG. All rights reserved.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func TestParseWei(t *testing.T) {
+	for _, vector := range []struct {
+		amount, unit, wei string
+	}{
+		{`1.5`, `ether`, `1500000000000000000`},
+		{`20`, `gwei`, `20000000000`},
+		{`0.000000001`, `gwei`, `1`},
+		{`1.500`, `ether`, `1500000000000000000`}, // trailing zeros aren't fractional wei
+		{`123456789012345678901234567890`, ``, `123456789012345678901234567890`},
+		{`0`, `finney`, `0`},
+	} {
+		wei, e := parseWei(json.Number(vector.amount), vector.unit)
+		if e != nil {
+			t.Fatalf(`%s %s: %s`, vector.amount, vector.unit, e)
+		}
+		if wei.String() != vector.wei {
+			t.Fatalf(`%s %s: expected %s wei, have %s`, vector.amount, vector.unit, vector.wei, wei)
+		}
+	}
+	for _, invalid := range []struct{ amount, unit string }{
+		{`1.5`, ``},              // fractional wei
+		{`0.0000000001`, `gwei`}, // idem
+		{`-1`, `ether`},
+		{`1e18`, ``},
+		{`.5`, `ether`},
+		{`1`, `lovelace`},
+	} {
+		if wei, e := parseWei(json.Number(invalid.amount), invalid.unit); e == nil {
+			t.Fatalf(`%s %s: expected error, have %s wei`, invalid.amount, invalid.unit, wei)
+		}
+	}
+}
+
+func TestTransactionParameterUnits(t *testing.T) {
+	value, gasPrice, _, e := transactionParameters(context.Background(), `1.5`, `20`, ``, TransactionUnits{ValueUnit: `ether`, GasPriceUnit: `gwei`})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if value.String() != `1500000000000000000` || gasPrice.String() != `20000000000` {
+		t.Fatalf(`expected 1.5 ether and 20 gwei in wei, have %s and %s`, value, gasPrice)
+	}
+	if _, _, _, e := transactionParameters(context.Background(), `1.5`, `20`, ``, TransactionUnits{}); e == nil {
+		t.Fatalf(`expected error for fractional wei by default`)
+	}
+}
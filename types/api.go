@@ -3,24 +3,34 @@
 package types // import "github.com/karmarun/karma.link/types"
 
 import (
+	"encoding/json"
 	"github.com/karmarun/karma.link/ast"
 )
 
 type Project struct {
-	Path  string
-	Files map[string]map[string]*Contract // "subdir/Example.sol" -> "Example" -> *Contract{...}
+	Path        string
+	Files       map[string]map[string]*Contract // "subdir/Example.sol" -> "Example" -> *Contract{...}
+	EventTopics map[[32]byte][]Event            // signature topic -> events of all contracts with that signature, see extract.ProjectEventTopics
 }
 
 type Contract struct {
-	File       string
-	Name       string
-	Parents    []*Contract
-	NatSpec    string
-	Kind       ast.ContractKind
-	API        map[string]Function // signature -> Function{...}
-	Types      map[string]Type
-	Definition ast.ContractDefinition
-	Binary     []byte
+	File            string
+	Name            string
+	Parents         []*Contract // linearized base contracts, most derived first, excluding the contract itself
+	Bases           []*Contract // direct base contracts in declaration order, a subset of Parents
+	NatSpec         string
+	Docs            *NatSpec // from solc's userdoc and devdoc, nil if not compiled with them
+	Kind            ast.ContractKind
+	API             map[string]Function // signature -> Function{...}
+	Constructor     *Function           // nil if not declared
+	Types           map[string]Type
+	Variables       []Variable                 // state variables in declaration order
+	Constants       map[string]json.RawMessage // name -> value of constant state variables initialized with literals
+	Definition      ast.ContractDefinition
+	Binary          []byte             // creation code
+	RuntimeBinary   []byte             // deployed code, if compiled with bin-runtime
+	CompilerVersion string             // solc version the contract was compiled with, as reported in combined.json
+	EventTopics     map[[32]byte]Event // signature topic -> event, including parents' events, see extract.EventTopics
 }
 
 func (c Contract) Overloads(name string) []Function {
@@ -36,18 +46,82 @@ func (c Contract) Overloads(name string) []Function {
 	return functions
 }
 
+// Source returns the location of the contract's definition, nil if unknown (e.g. for contracts loaded from ABI files).
+func (c Contract) Source() *ast.SourceRange {
+	return c.Definition.Header().Range()
+}
+
+// NatSpecTags returns the contract's structured NatSpec, preferring Docs over parsing NatSpec.
+func (c Contract) NatSpecTags() NatSpec {
+	if c.Docs != nil {
+		return *c.Docs
+	}
+	return ParseNatSpec(c.NatSpec)
+}
+
+// TypeSources maps the names of the structs, enums and events declared in the contract to the locations of their definitions.
+func (c Contract) TypeSources() map[string]*ast.SourceRange {
+	sources := make(map[string]*ast.SourceRange, 8)
+	for _, child := range c.Definition.Children() {
+		name := ""
+		switch definition := child.(type) {
+		case ast.StructDefinition:
+			name = definition.Name
+		case ast.EnumDefinition:
+			name = definition.Name
+		case ast.EventDefinition:
+			name = definition.Name
+		default:
+			continue
+		}
+		if source := child.Header().Range(); source != nil {
+			sources[name] = source
+		}
+	}
+	return sources
+}
+
+// Variable represents a contract's state variable.
+type Variable struct {
+	Name       string
+	Constant   bool
+	Visibility ast.Visibility
+	Type       Type
+	Definition ast.VariableDeclaration
+}
+
 const FallbackFunctionName = ""
 
 type Function struct {
 	Name            string
 	NatSpec         string
+	Docs            *NatSpec // idem Contract.Docs
 	Visibility      ast.Visibility
 	StateMutability ast.StateMutability
 	Inputs          []Type
 	Outputs         []Type
+	InputNames      []string // parameter names, same length as Inputs, empty for unnamed parameters
+	OutputNames     []string // idem for Outputs
+	Modifiers       []string // names of applied modifiers in invocation order, without arguments
 	Definition      ast.Node
 }
 
+// Source returns the location of the function's definition (or, for getters, of the variable's declaration), nil if unknown.
+func (f Function) Source() *ast.SourceRange {
+	if f.Definition == nil {
+		return nil
+	}
+	return f.Definition.Header().Range()
+}
+
+// NatSpecTags returns the function's structured NatSpec, preferring Docs over parsing NatSpec.
+func (f Function) NatSpecTags() NatSpec {
+	if f.Docs != nil {
+		return *f.Docs
+	}
+	return ParseNatSpec(f.NatSpec)
+}
+
 func (f Function) SoliditySignature() []byte {
 	bs := []byte(f.Name + `(`)
 	for i, input := range f.Inputs {
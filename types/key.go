@@ -0,0 +1,41 @@
+// Copyright 2018 karma.run AG. All rights reserved.
+
+package types // import "github.com/karmarun/karma.link/types"
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Key identifies a contract, or a type declared in one, by the path of its source file.
+// Its string form "path:Contract" or "path:Contract.Member" is used in names and identifiers throughout;
+// build and split those with Key instead of concatenating strings, since paths may contain colons and dots.
+type Key struct {
+	File     string
+	Contract string
+	Member   string // empty for contracts
+}
+
+func (k Key) String() string {
+	if k.Member == "" {
+		return k.File + ":" + k.Contract
+	}
+	return k.File + ":" + k.Contract + "." + k.Member
+}
+
+// ParseKey is the inverse of Key.String. Contract and member names are identifiers,
+// so the last colon separates the path from them, however many colons the path contains.
+func ParseKey(s string) (Key, error) {
+	i := strings.LastIndexByte(s, ':')
+	if i == -1 {
+		return Key{}, fmt.Errorf(`expected "file:name" identifier, have %s`, s)
+	}
+	key := Key{File: s[:i], Contract: s[i+1:]}
+	if j := strings.IndexByte(key.Contract, '.'); j != -1 {
+		key.Contract, key.Member = key.Contract[:j], key.Contract[j+1:]
+	}
+	if key.Contract == "" || strings.ContainsAny(key.Contract+key.Member, `:.`) {
+		return Key{}, fmt.Errorf(`invalid identifier %s`, s)
+	}
+	return key, nil
+}
@@ -0,0 +1,34 @@
+// Copyright 2018 karma.run AG. All rights reserved.
+
+package types
+
+import (
+	"testing"
+)
+
+func TestKey(t *testing.T) {
+	for s, expected := range map[string]Key{
+		`Token.sol:Token`:                     {File: `Token.sol`, Contract: `Token`},
+		`C:\contracts\Token.sol:Token`:        {File: `C:\contracts\Token.sol`, Contract: `Token`},
+		`C:/contracts/Token.sol:Token.Holder`: {File: `C:/contracts/Token.sol`, Contract: `Token`, Member: `Holder`},
+		`odd:name.v2.sol:Registry.Entry`:      {File: `odd:name.v2.sol`, Contract: `Registry`, Member: `Entry`},
+		`/abs/path:with:colons/A.sol:A`:       {File: `/abs/path:with:colons/A.sol`, Contract: `A`},
+		`contracts/v1.2/Store.sol:Store.Kind`: {File: `contracts/v1.2/Store.sol`, Contract: `Store`, Member: `Kind`},
+	} {
+		key, e := ParseKey(s)
+		if e != nil {
+			t.Fatalf(`%s: %s`, s, e)
+		}
+		if key != expected {
+			t.Fatalf(`%s: expected %+v, have %+v`, s, expected, key)
+		}
+		if key.String() != s {
+			t.Fatalf(`expected %s, have %s`, s, key.String())
+		}
+	}
+	for _, s := range []string{``, `Token.sol`, `Token.sol:`, `Token.sol:A.B.C`, `Token.sol:.Member`} {
+		if _, e := ParseKey(s); e == nil {
+			t.Fatalf(`expected an error parsing %q`, s)
+		}
+	}
+}
@@ -4,13 +4,18 @@ package types // import "github.com/karmarun/karma.link/types"
 
 type Map map[Reference]Type
 
+// Deref returns the type of ref, following references to references. It panics if ref is missing,
+// but returns a reference to a missing key in the chain as is, leaving it to validation to report.
 func (m Map) Deref(ref Reference) Type {
 	typ, ok := m[ref]
 	if !ok {
 		panic("missing typemap key")
 	}
-	if ref, ok := typ.(Reference); ok {
-		return m.Deref(ref)
+	if next, ok := typ.(Reference); ok {
+		if _, ok := m[next]; !ok {
+			return next
+		}
+		return m.Deref(next)
 	}
 	return typ
 }
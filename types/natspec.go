@@ -0,0 +1,78 @@
+// Copyright 2018 karma.run AG. All rights reserved.
+
+package types // import "github.com/karmarun/karma.link/types"
+
+import (
+	"strings"
+)
+
+// NatSpec is the structured form of a contract's or function's NatSpec documentation.
+type NatSpec struct {
+	Title   string            `json:"title,omitempty"`
+	Author  string            `json:"author,omitempty"`
+	Notice  string            `json:"notice,omitempty"`
+	Details string            `json:"details,omitempty"` // @dev
+	Params  map[string]string `json:"params"`            // parameter name -> description
+	Return  string            `json:"return,omitempty"`
+	Custom  map[string]string `json:"custom"` // @custom:<name> -> text, e.g. "security-contact" -> "security@example.com"
+}
+
+// ParseNatSpec parses raw NatSpec documentation as found in the AST, e.g. "@title Token\n@custom:audit none".
+// Text before the first tag is the notice. Lines not starting with a tag continue the previous one.
+// Repeated tags are joined by newlines, unknown tags are ignored.
+func ParseNatSpec(doc string) NatSpec {
+	spec := NatSpec{Params: map[string]string{}, Custom: map[string]string{}}
+	tag, text := `@notice`, make([]string, 0, 4)
+	flush := func() {
+		value := strings.TrimSpace(strings.Join(text, "\n"))
+		text = text[:0]
+		if value == "" && tag != `@param` && !strings.HasPrefix(tag, `@custom:`) {
+			return // custom tags may be flags without text, e.g. @custom:oz-upgrades
+		}
+		target := (*string)(nil)
+		switch {
+		case tag == `@title`:
+			target = &spec.Title
+		case tag == `@author`:
+			target = &spec.Author
+		case tag == `@notice`:
+			target = &spec.Notice
+		case tag == `@dev`:
+			target = &spec.Details
+		case tag == `@return`:
+			target = &spec.Return
+		case tag == `@param`:
+			fields := strings.SplitN(value, " ", 2)
+			if fields[0] == "" {
+				return
+			}
+			spec.Params[fields[0]] = appendLine(spec.Params[fields[0]], strings.TrimSpace(strings.Join(fields[1:], "")))
+			return
+		case strings.HasPrefix(tag, `@custom:`) && len(tag) > len(`@custom:`):
+			name := tag[len(`@custom:`):]
+			spec.Custom[name] = appendLine(spec.Custom[name], value)
+			return
+		default:
+			return
+		}
+		*target = appendLine(*target, value)
+	}
+	for _, line := range strings.Split(doc, "\n") {
+		line = strings.TrimLeft(strings.TrimSpace(line), `*/ `) // leftovers of /** */ or /// comments
+		if strings.HasPrefix(line, `@`) {
+			flush()
+			fields := strings.SplitN(line, " ", 2)
+			tag, line = fields[0], strings.Join(fields[1:], "")
+		}
+		text = append(text, line)
+	}
+	flush()
+	return spec
+}
+
+func appendLine(s, line string) string {
+	if s == "" {
+		return line
+	}
+	return s + "\n" + line
+}
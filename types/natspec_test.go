@@ -0,0 +1,37 @@
+// Copyright 2018 karma.run AG. All rights reserved.
+
+package types
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseNatSpec(t *testing.T) {
+	doc := "@title Vault\n@author karma\nHolds deposits.\n@custom:security-contact security@example.com\n" +
+		"@custom:oz-upgrades\n@dev Uses a pull pattern,\n  see withdraw.\n@custom:security-contact ops@example.com"
+	expected := NatSpec{
+		Title:   `Vault`,
+		Author:  "karma\nHolds deposits.",
+		Details: "Uses a pull pattern,\nsee withdraw.",
+		Params:  map[string]string{},
+		Custom: map[string]string{
+			`security-contact`: "security@example.com\nops@example.com",
+			`oz-upgrades`:      ``,
+		},
+	}
+	if spec := ParseNatSpec(doc); !reflect.DeepEqual(spec, expected) {
+		t.Fatalf(`expected %+v, have %+v`, expected, spec)
+	}
+
+	doc = "/// Sends tokens.\n/// @param to the recipient\n/// @param amount in wei\n/// @return success\n/// @custom:audit passed"
+	expected = NatSpec{
+		Notice: `Sends tokens.`,
+		Params: map[string]string{`to`: `the recipient`, `amount`: `in wei`},
+		Return: `success`,
+		Custom: map[string]string{`audit`: `passed`},
+	}
+	if spec := ParseNatSpec(doc); !reflect.DeepEqual(spec, expected) {
+		t.Fatalf(`expected %+v, have %+v`, expected, spec)
+	}
+}
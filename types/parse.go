@@ -0,0 +1,213 @@
+// Copyright 2018 karma.run AG. All rights reserved.
+
+package types // import "github.com/karmarun/karma.link/types"
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseType parses a canonical type string as used in function signatures and ABI JSON,
+// e.g. "uint256", "bytes32[]" or "(address,uint8[])[2]". Parenthesized tuples are returned as Tuple.
+// Aliases like "uint" are resolved to their canonical names.
+func ParseType(s string) (Type, error) {
+	typ, rest, e := parseType(s)
+	if e != nil {
+		return nil, fmt.Errorf(`invalid type %s: %s`, s, e)
+	}
+	if rest != "" {
+		return nil, fmt.Errorf(`invalid type %s: unexpected %s`, s, rest)
+	}
+	return typ, nil
+}
+
+// parseType parses a type at the start of s, returning it and the unparsed rest of s.
+func parseType(s string) (Type, string, error) {
+	typ, rest := Type(nil), s
+	if strings.HasPrefix(rest, `(`) {
+		tuple := make(Tuple, 0, 4)
+		rest = rest[1:]
+		for !strings.HasPrefix(rest, `)`) {
+			if rest == "" {
+				return nil, ``, fmt.Errorf(`missing )`)
+			}
+			if len(tuple) > 0 {
+				if !strings.HasPrefix(rest, `,`) {
+					return nil, ``, fmt.Errorf(`expected , or ) at %s`, rest)
+				}
+				rest = rest[1:]
+			}
+			component, r, e := parseType(rest)
+			if e != nil {
+				return nil, ``, e
+			}
+			tuple, rest = append(tuple, component), r
+		}
+		typ, rest = tuple, rest[1:]
+	} else {
+		end := strings.IndexAny(rest, `[],()`)
+		if end == -1 {
+			end = len(rest)
+		}
+		elementary, e := parseElementary(rest[:end])
+		if e != nil {
+			return nil, ``, e
+		}
+		typ, rest = elementary, rest[end:]
+	}
+	return parseArraySuffixes(typ, rest)
+}
+
+// parseArraySuffixes wraps typ in the array types given by suffixes like "[2][]" at the start of s.
+func parseArraySuffixes(typ Type, s string) (Type, string, error) {
+	rest := s
+	for strings.HasPrefix(rest, `[`) {
+		end := strings.IndexByte(rest, ']')
+		if end == -1 {
+			return nil, ``, fmt.Errorf(`missing ]`)
+		}
+		length := DynamicArrayLength
+		if end > 1 {
+			n, e := strconv.ParseUint(rest[1:end], 10, 31)
+			if e != nil {
+				return nil, ``, fmt.Errorf(`invalid array length %s`, rest[1:end])
+			}
+			length = int(n)
+		}
+		typ, rest = Array{Length: length, Type: typ}, rest[end+1:]
+	}
+	return typ, rest, nil
+}
+
+// ParseSignature parses a function signature as written by users, e.g. "transfer(address to, uint amount)",
+// returning the function's name and input types. Whitespace, parameter names and data locations are ignored,
+// aliases are resolved like in ParseType. The canonical signature is name + inputs.SoliditySignature().
+func ParseSignature(s string) (string, Tuple, error) {
+	open := strings.IndexByte(s, '(')
+	if open == -1 {
+		return "", nil, fmt.Errorf(`invalid signature %s: missing (`, s)
+	}
+	name := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(s[:open]), `function `))
+	if !isIdentifier(name) {
+		return "", nil, fmt.Errorf(`invalid signature %s: invalid function name %s`, s, name)
+	}
+	inputs, rest, e := parseParameterList(s[open:])
+	if e != nil {
+		return "", nil, fmt.Errorf(`invalid signature %s: %s`, s, e)
+	}
+	if rest = strings.TrimSpace(rest); rest != "" {
+		return "", nil, fmt.Errorf(`invalid signature %s: unexpected %s`, s, rest)
+	}
+	return name, inputs, nil
+}
+
+// parseParameterList parses a parenthesized parameter list at the start of s, returning it and the unparsed rest of s.
+// Nested lists are tuples. Words following a parameter's type, like its name or data location, are skipped.
+func parseParameterList(s string) (Tuple, string, error) {
+	tuple, rest := make(Tuple, 0, 4), strings.TrimSpace(s)[1:]
+	for {
+		rest = strings.TrimSpace(rest)
+		if rest == "" {
+			return nil, ``, fmt.Errorf(`missing )`)
+		}
+		if rest[0] == ')' {
+			return tuple, rest[1:], nil
+		}
+		if len(tuple) > 0 {
+			if rest[0] != ',' {
+				return nil, ``, fmt.Errorf(`expected , or ) at %s`, rest)
+			}
+			rest = strings.TrimSpace(rest[1:])
+		}
+		typ := Type(nil)
+		if strings.HasPrefix(rest, `(`) {
+			component, r, e := parseParameterList(rest)
+			if e != nil {
+				return nil, ``, e
+			}
+			if typ, rest, e = parseArraySuffixes(component, r); e != nil {
+				return nil, ``, e
+			}
+		} else {
+			end := strings.IndexAny(rest, ",() \t\n")
+			if end == -1 {
+				end = len(rest)
+			}
+			t, e := ParseType(rest[:end])
+			if e != nil {
+				return nil, ``, e
+			}
+			typ, rest = t, rest[end:]
+		}
+		for rest = strings.TrimSpace(rest); rest != "" && !strings.ContainsRune(`,()`, rune(rest[0])); rest = strings.TrimSpace(rest) {
+			end := strings.IndexAny(rest, ",() \t\n")
+			if end == -1 {
+				end = len(rest)
+			}
+			if !isIdentifier(rest[:end]) {
+				return nil, ``, fmt.Errorf(`unexpected %s`, rest[:end])
+			}
+			rest = rest[end:] // parameter name, data location or "indexed"
+		}
+		tuple = append(tuple, typ)
+	}
+}
+
+func isIdentifier(s string) bool {
+	for i, r := range s {
+		if !(r == '_' || r == '$' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || i > 0 && r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return s != ""
+}
+
+func parseElementary(name string) (Elementary, error) {
+	switch name { // aliases
+	case `int`:
+		return `int256`, nil
+	case `uint`:
+		return `uint256`, nil
+	case `byte`:
+		return `bytes1`, nil
+	case `fixed`:
+		return `fixed128x18`, nil
+	case `ufixed`:
+		return `ufixed128x18`, nil
+	case `bool`, `address`, `string`, `bytes`, `function`:
+		return Elementary(name), nil
+	}
+	for _, prefix := range []string{`uint`, `int`, `bytes`} {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		n, e := strconv.Atoi(name[len(prefix):])
+		if e != nil {
+			break
+		}
+		if prefix == `bytes` && n >= 1 && n <= 32 {
+			return Elementary(name), nil
+		}
+		if prefix != `bytes` && n >= 8 && n <= 256 && n%8 == 0 {
+			return Elementary(name), nil
+		}
+		break
+	}
+	for _, prefix := range []string{`ufixed`, `fixed`} {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		mn := strings.SplitN(name[len(prefix):], `x`, 2)
+		if len(mn) != 2 {
+			break
+		}
+		m, e1 := strconv.Atoi(mn[0])
+		n, e2 := strconv.Atoi(mn[1])
+		if e1 == nil && e2 == nil && m >= 8 && m <= 256 && m%8 == 0 && n >= 0 && n <= 80 {
+			return Elementary(name), nil
+		}
+		break
+	}
+	return ``, fmt.Errorf(`unknown elementary type %s`, name)
+}
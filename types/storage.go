@@ -0,0 +1,158 @@
+// Copyright 2018 karma.run AG. All rights reserved.
+
+package types // import "github.com/karmarun/karma.link/types"
+
+import (
+	"strconv"
+	"strings"
+)
+
+// StorageSlot describes where a state variable is located in contract storage.
+// Mappings and dynamic arrays occupy a single slot; their contents live at keccak-derived locations.
+type StorageSlot struct {
+	Contract string `json:"contract"` // "file:name" of the declaring contract
+	Name     string `json:"name"`
+	Type     Type   `json:"-"` // see link's GetStorageLayout for an encoding
+	Slot     int    `json:"slot"`
+	Offset   int    `json:"offset"` // in bytes, counting from the low-order end of the slot
+	Size     int    `json:"size"`   // in bytes
+}
+
+const slotSize = 32
+
+// StorageLayout computes the storage layout of a contract's state variables following Solidity's packing rules.
+// Inherited variables come first, in order of the linearized inheritance hierarchy. Constants take no storage.
+func StorageLayout(contract *Contract) []StorageSlot {
+	layout := make([]StorageSlot, 0, 16)
+	slot, offset := 0, 0
+	for i := len(contract.Parents); i >= 0; i-- {
+		c := contract
+		if i > 0 {
+			c = contract.Parents[i-1]
+		}
+		for _, variable := range c.Variables {
+			if variable.Constant {
+				continue
+			}
+			size, whole := storageSize(variable.Type)
+			if whole || offset+size > slotSize {
+				if offset > 0 {
+					slot, offset = slot+1, 0
+				}
+			}
+			layout = append(layout, StorageSlot{
+				Contract: Key{File: c.File, Contract: c.Name}.String(),
+				Name:     variable.Name,
+				Type:     variable.Type,
+				Slot:     slot,
+				Offset:   offset,
+				Size:     size,
+			})
+			if whole {
+				slot += (size + slotSize - 1) / slotSize
+				continue
+			}
+			offset += size
+		}
+	}
+	return layout
+}
+
+// storageSize returns the number of bytes typ takes in storage
+// and whether it occupies whole slots (i.e. can't be packed with neighbours).
+func storageSize(typ Type) (int, bool) {
+	switch t := typ.(type) {
+
+	case Named:
+		return storageSize(t.Type)
+
+	case ContractAddress, InterfaceAddress, LibraryAddress:
+		return 20, false
+
+	case Enum:
+		if len(t) <= 256 {
+			return 1, false
+		}
+		return 2, false
+
+	case Mapping:
+		return slotSize, true
+
+	case Struct:
+		slots, offset := 0, 0
+		for _, member := range t.Types {
+			size, whole := storageSize(member)
+			if whole || offset+size > slotSize {
+				if offset > 0 {
+					slots, offset = slots+1, 0
+				}
+			}
+			if whole {
+				slots += (size + slotSize - 1) / slotSize
+				continue
+			}
+			offset += size
+		}
+		if offset > 0 {
+			slots++
+		}
+		return slots * slotSize, true
+
+	case Array:
+		if t.IsDynamic() {
+			return slotSize, true
+		}
+		size, whole := storageSize(t.Type)
+		if whole {
+			return size * t.Length, true
+		}
+		perSlot := slotSize / size
+		return ((t.Length + perSlot - 1) / perSlot) * slotSize, true
+
+	case Elementary:
+		return elementaryStorageSize(t), false
+
+	}
+	logger.Panicf("unexpected type in types.storageSize: %T\n", typ)
+	return 0, false // shut up compiler
+}
+
+func elementaryStorageSize(t Elementary) int {
+	id := string(t)
+	if i := strings.IndexByte(id, ' '); i != -1 { // e.g. "address payable"
+		id = id[:i]
+	}
+	switch {
+	case id == `bool`, id == `byte`:
+		return 1
+	case id == `address`:
+		return 20
+	case id == `function`:
+		return 24 // external function: address + selector
+	case id == `int`, id == `uint`, id == `string`, id == `bytes`, id == `fixed`, id == `ufixed`:
+		return slotSize
+	case strings.HasPrefix(id, `uint`):
+		return bitsToBytes(id[len(`uint`):])
+	case strings.HasPrefix(id, `int`):
+		return bitsToBytes(id[len(`int`):])
+	case strings.HasPrefix(id, `ufixed`):
+		return bitsToBytes(id[len(`ufixed`):strings.IndexByte(id, 'x')])
+	case strings.HasPrefix(id, `fixed`):
+		return bitsToBytes(id[len(`fixed`):strings.IndexByte(id, 'x')])
+	case strings.HasPrefix(id, `bytes`):
+		n, e := strconv.Atoi(id[len(`bytes`):])
+		if e != nil {
+			logger.Panicln(e)
+		}
+		return n
+	}
+	return slotSize
+}
+
+func bitsToBytes(bits string) int {
+	n, e := strconv.Atoi(bits)
+	if e != nil {
+		logger.Panicln(e)
+	}
+	return n / 8
+}
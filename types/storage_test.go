@@ -0,0 +1,48 @@
+// Copyright 2018 karma.run AG. All rights reserved.
+
+package types
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStorageLayout(t *testing.T) {
+	base := &Contract{
+		File: `Base.sol`,
+		Name: `Base`,
+		Variables: []Variable{
+			{Name: `owner`, Type: Elementary(`address`)},
+		},
+	}
+	token := &Contract{
+		File:    `Token.sol`,
+		Name:    `Token`,
+		Parents: []*Contract{base},
+		Variables: []Variable{
+			{Name: `low`, Type: Elementary(`uint128`)},
+			{Name: `high`, Type: Elementary(`uint128`)},
+			{Name: `FEE`, Type: Elementary(`uint256`), Constant: true},
+			{Name: `balances`, Type: Mapping{Key: Elementary(`address`), Value: Elementary(`uint256`)}},
+			{Name: `flag`, Type: Elementary(`bool`)},
+		},
+	}
+	type slot struct {
+		Contract, Name     string
+		Slot, Offset, Size int
+	}
+	expected := []slot{
+		{`Base.sol:Base`, `owner`, 0, 0, 20},
+		{`Token.sol:Token`, `low`, 1, 0, 16},
+		{`Token.sol:Token`, `high`, 1, 16, 16},
+		{`Token.sol:Token`, `balances`, 2, 0, 32},
+		{`Token.sol:Token`, `flag`, 3, 0, 1},
+	}
+	actual := make([]slot, 0, len(expected))
+	for _, s := range StorageLayout(token) {
+		actual = append(actual, slot{s.Contract, s.Name, s.Slot, s.Offset, s.Size})
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("expected %v, have %v", expected, actual)
+	}
+}
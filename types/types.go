@@ -3,9 +3,12 @@
 package types // import "github.com/karmarun/karma.link/types"
 
 import (
+	"github.com/karmarun/karma.link/config"
 	"strconv"
 )
 
+var logger = config.NewLogger(`types`)
+
 type Type interface {
 	SoliditySignature() []byte
 	Map(func(Type) Type) Type
@@ -36,8 +39,11 @@ func (t Elementary) Map(f func(Type) Type) Type {
 }
 
 type Event struct {
-	Name string
-	Args []Type
+	Name      string
+	Args      []Type
+	Indexed   []bool   // Indexed[i] is true if Args[i] is stored in a log topic rather than in log data
+	ArgNames  []string // names of Args, "" for unnamed ones; nil if unknown
+	Anonymous bool     // anonymous events don't log their signature as first topic
 }
 
 func (t Event) SoliditySignature() []byte {
@@ -57,7 +63,7 @@ func (t Event) Map(f func(Type) Type) Type {
 	for i := 0; i < length; i++ {
 		args[i] = t.Args[i].Map(f)
 	}
-	return Event{Name: t.Name, Args: args} // NOTE: no f()
+	return Event{Name: t.Name, Args: args, Indexed: t.Indexed, ArgNames: t.ArgNames, Anonymous: t.Anonymous} // NOTE: no f()
 }
 
 type Tuple []Type
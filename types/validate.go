@@ -0,0 +1,178 @@
+// Copyright 2018 karma.run AG. All rights reserved.
+
+package types // import "github.com/karmarun/karma.link/types"
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Validate checks the invariants the rest of the system relies on: all type references are resolved,
+// parents are contracts of the project, and struct, event and parameter name lists match their types.
+// It returns the first violation found, visiting files, contracts and functions in lexical order.
+func (p Project) Validate() error {
+	contracts := make(map[*Contract]bool, 16)
+	for _, file := range p.Files {
+		for _, contract := range file {
+			contracts[contract] = true
+		}
+	}
+	for _, path := range sortedKeys(p.Files) {
+		file := p.Files[path]
+		names := make([]string, 0, len(file))
+		for name := range file {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			contract := file[name]
+			if e := validateContract(contract, contracts); e != nil {
+				return fmt.Errorf(`%s:%s: %s`, path, name, e)
+			}
+			if contract.File != path || contract.Name != name {
+				return fmt.Errorf(`%s:%s: contract is located at %s:%s`, path, name, contract.File, contract.Name)
+			}
+		}
+	}
+	return nil
+}
+
+func validateContract(contract *Contract, contracts map[*Contract]bool) error {
+	for i, parent := range contract.Parents {
+		if parent == nil || !contracts[parent] {
+			return fmt.Errorf(`parent %d is not a contract of the project`, i)
+		}
+		if parent == contract {
+			return fmt.Errorf(`contract is its own parent`)
+		}
+	}
+	for i, base := range contract.Bases {
+		isParent := false
+		for _, parent := range contract.Parents {
+			isParent = isParent || parent == base
+		}
+		if !isParent {
+			return fmt.Errorf(`base %d is not a parent`, i)
+		}
+	}
+	signatures := make([]string, 0, len(contract.API))
+	for signature := range contract.API {
+		signatures = append(signatures, signature)
+	}
+	sort.Strings(signatures)
+	for _, signature := range signatures {
+		if e := validateFunction(contract.API[signature]); e != nil {
+			return fmt.Errorf(`%s: %s`, signature, e)
+		}
+		if actual := string(contract.API[signature].SoliditySignature()); actual != signature {
+			return fmt.Errorf(`%s: function has signature %s`, signature, actual)
+		}
+	}
+	if contract.Constructor != nil {
+		if e := validateFunction(*contract.Constructor); e != nil {
+			return fmt.Errorf(`constructor: %s`, e)
+		}
+	}
+	for _, variable := range contract.Variables {
+		if e := validateType(variable.Type); e != nil {
+			return fmt.Errorf(`state variable %s: %s`, variable.Name, e)
+		}
+	}
+	names := make([]string, 0, len(contract.Types))
+	for name := range contract.Types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if e := validateType(contract.Types[name]); e != nil {
+			return fmt.Errorf(`type %s: %s`, name, e)
+		}
+	}
+	return nil
+}
+
+func validateFunction(function Function) error {
+	for i, input := range function.Inputs {
+		if e := validateType(input); e != nil {
+			return fmt.Errorf(`input %d: %s`, i, e)
+		}
+	}
+	for i, output := range function.Outputs {
+		if e := validateType(output); e != nil {
+			return fmt.Errorf(`output %d: %s`, i, e)
+		}
+	}
+	if function.InputNames != nil && len(function.InputNames) != len(function.Inputs) {
+		return fmt.Errorf(`%d input names for %d inputs`, len(function.InputNames), len(function.Inputs))
+	}
+	if function.OutputNames != nil && len(function.OutputNames) != len(function.Outputs) {
+		return fmt.Errorf(`%d output names for %d outputs`, len(function.OutputNames), len(function.Outputs))
+	}
+	return nil
+}
+
+func validateType(typ Type) error {
+	switch t := typ.(type) {
+	case nil:
+		return fmt.Errorf(`missing type`)
+	case Reference:
+		return fmt.Errorf(`unresolved type reference %d`, int(t))
+	case Named:
+		if e := validateType(t.Type); e != nil {
+			return fmt.Errorf(`%s: %s`, t.Name, e)
+		}
+	case Struct:
+		if len(t.Keys) != len(t.Types) {
+			return fmt.Errorf(`struct has %d keys but %d types`, len(t.Keys), len(t.Types))
+		}
+		for i, member := range t.Types {
+			if e := validateType(member); e != nil {
+				return fmt.Errorf(`member %s: %s`, t.Keys[i], e)
+			}
+		}
+	case Event:
+		if len(t.Indexed) != len(t.Args) {
+			return fmt.Errorf(`event has %d args but %d indexed flags`, len(t.Args), len(t.Indexed))
+		}
+		if t.ArgNames != nil && len(t.ArgNames) != len(t.Args) {
+			return fmt.Errorf(`event has %d args but %d arg names`, len(t.Args), len(t.ArgNames))
+		}
+		for i, arg := range t.Args {
+			if e := validateType(arg); e != nil {
+				return fmt.Errorf(`arg %d: %s`, i, e)
+			}
+		}
+	case Tuple:
+		for i, component := range t {
+			if e := validateType(component); e != nil {
+				return fmt.Errorf(`component %d: %s`, i, e)
+			}
+		}
+	case Array:
+		if t.Length < 0 && t.Length != DynamicArrayLength {
+			return fmt.Errorf(`invalid array length %d`, t.Length)
+		}
+		return validateType(t.Type)
+	case Mapping:
+		if e := validateType(t.Key); e != nil {
+			return fmt.Errorf(`mapping key: %s`, e)
+		}
+		if e := validateType(t.Value); e != nil {
+			return fmt.Errorf(`mapping value: %s`, e)
+		}
+	case Enum:
+		if len(t) == 0 {
+			return fmt.Errorf(`enum without members`)
+		}
+	}
+	return nil
+}
+
+func sortedKeys(files map[string]map[string]*Contract) []string {
+	keys := make([]string, 0, len(files))
+	for key := range files {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
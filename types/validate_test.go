@@ -0,0 +1,75 @@
+// Copyright 2018 karma.run AG. All rights reserved.
+
+package types
+
+import (
+	"testing"
+)
+
+// validProject returns a consistent project of a token inheriting from Base, for breaking in tests.
+func validProject() (Project, *Contract) {
+	base := &Contract{File: `Base.sol`, Name: `Base`, API: map[string]Function{}, Types: map[string]Type{}}
+	token := &Contract{
+		File:    `Token.sol`,
+		Name:    `Token`,
+		Parents: []*Contract{base},
+		Bases:   []*Contract{base},
+		API: map[string]Function{
+			`transfer(address,uint256)`: {
+				Name:        `transfer`,
+				Inputs:      []Type{Elementary(`address`), Elementary(`uint256`)},
+				Outputs:     []Type{Elementary(`bool`)},
+				InputNames:  []string{`to`, `value`},
+				OutputNames: []string{``},
+			},
+		},
+		Types: map[string]Type{
+			`Holder`: Named{Name: `Token.sol:Token.Holder`, Type: Struct{Keys: []string{`owner`, `amount`}, Types: []Type{Elementary(`address`), Elementary(`uint256`)}}},
+		},
+	}
+	return Project{Files: map[string]map[string]*Contract{`Base.sol`: {`Base`: base}, `Token.sol`: {`Token`: token}}}, token
+}
+
+func TestValidate(t *testing.T) {
+	if project, _ := validProject(); project.Validate() != nil {
+		t.Fatal(project.Validate())
+	}
+
+	for expected, breakProject := range map[string]func(Project, *Contract){
+		`Token.sol:Token: transfer(address,uint256): input 1: unresolved type reference 42`: func(p Project, token *Contract) {
+			token.API[`transfer(address,uint256)`].Inputs[1] = Reference(42)
+		},
+		`Token.sol:Token: transfer(address,uint256): output 0: missing type`: func(p Project, token *Contract) {
+			token.API[`transfer(address,uint256)`].Outputs[0] = nil
+		},
+		`Token.sol:Token: transfer(address,uint256): 1 input names for 2 inputs`: func(p Project, token *Contract) {
+			function := token.API[`transfer(address,uint256)`]
+			function.InputNames = []string{`to`}
+			token.API[`transfer(address,uint256)`] = function
+		},
+		`Token.sol:Token: transfer(uint256): function has signature transfer(address,uint256)`: func(p Project, token *Contract) {
+			token.API[`transfer(uint256)`] = token.API[`transfer(address,uint256)`]
+			delete(token.API, `transfer(address,uint256)`)
+		},
+		`Token.sol:Token: parent 0 is not a contract of the project`: func(p Project, token *Contract) {
+			delete(p.Files, `Base.sol`)
+		},
+		`Token.sol:Token: base 0 is not a parent`: func(p Project, token *Contract) {
+			token.Parents = nil
+		},
+		`Token.sol:Token: type Holder: Token.sol:Token.Holder: struct has 2 keys but 1 types`: func(p Project, token *Contract) {
+			holder := token.Types[`Holder`].(Named)
+			holder.Type = Struct{Keys: []string{`owner`, `amount`}, Types: []Type{Elementary(`address`)}}
+			token.Types[`Holder`] = holder
+		},
+		`Token.sol:Token: contract is located at Token.sol:Coin`: func(p Project, token *Contract) {
+			token.Name = `Coin`
+		},
+	} {
+		project, token := validProject()
+		breakProject(project, token)
+		if e := project.Validate(); e == nil || e.Error() != expected {
+			t.Errorf(`expected %q, have %v`, expected, e)
+		}
+	}
+}